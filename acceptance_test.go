@@ -1,17 +1,48 @@
 package tls_usr_sessions_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/golang/protobuf/ptypes/empty"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
 	"google.golang.org/grpc/status"
 
+	"github.com/KibaFox/tls-usr-sessions/gateway"
+	"github.com/KibaFox/tls-usr-sessions/otp"
 	"github.com/KibaFox/tls-usr-sessions/pb"
 	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/rest"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
 )
 
 var _ = Describe("Acceptance", func() {
@@ -64,8 +95,7 @@ var _ = Describe("Acceptance", func() {
 
 		cliKey, err := pki.GenerateKey()
 		Expect(err).ToNot(HaveOccurred())
-		csrPEM, err := pki.NewCSR(cliKey, "client")
-		Expect(err).ToNot(HaveOccurred())
+		csrPEM := challengeCSR(ctx, cli, cliKey, "demo")
 
 		resp, err := cli.Login(ctx, &pb.LoginRequest{
 			Username: "demo",
@@ -86,6 +116,270 @@ var _ = Describe("Acceptance", func() {
 		Expect(cert.Issuer).Should(Equal(anchor.Subject))
 	})
 
+	It("Requires a login challenge in the CSR", func() {
+		cli, conn := authCli()
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		csrPEM, err := pki.NewCSR(cliKey, "client")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = cli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      csrPEM,
+		})
+
+		Expect(err).To(HaveOccurred(), "server accepted the login request")
+		Expect(status.Code(err)).Should(Equal(codes.FailedPrecondition))
+	})
+
+	It("Should not allow a login request to be replayed", func() {
+		cli, conn := authCli()
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		req := &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, cli, cliKey, "demo"),
+		}
+
+		_, err = cli.Login(ctx, req)
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+		By("Replaying the same login request")
+		_, err = cli.Login(ctx, req)
+		Expect(err).To(HaveOccurred(), "server accepted a replayed login")
+		Expect(status.Code(err)).Should(Equal(codes.FailedPrecondition))
+	})
+
+	It("Should not accept a challenge issued for another user", func() {
+		cli, conn := authCli()
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = cli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, cli, cliKey, "someone-else"),
+		})
+		Expect(err).To(HaveOccurred(), "server accepted the login request")
+		Expect(status.Code(err)).Should(Equal(codes.FailedPrecondition))
+	})
+
+	It("Should lock out a user after too many failed logins", func() {
+		cli, conn := authCli()
+		defer conn.Close()
+		admCli, admConn := adminCli()
+		defer admConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		login := func() (trailer metadata.MD, err error) {
+			_, err = cli.Login(ctx, &pb.LoginRequest{
+				Username: "mallory",
+				Password: "guess",
+				Csr:      challengeCSR(ctx, cli, cliKey, "mallory"),
+			}, grpc.Trailer(&trailer))
+			return trailer, err
+		}
+
+		By("Failing to login up to the limit")
+		for i := 0; i < 5; i++ {
+			_, err = login()
+			Expect(status.Code(err)).Should(Equal(codes.InvalidArgument))
+		}
+
+		By("Being locked out")
+		trailer, err := login()
+		Expect(status.Code(err)).Should(Equal(codes.ResourceExhausted))
+		Expect(trailer.Get("retry-after")).Should(HaveLen(1))
+
+		resp, err := admCli.ListLockouts(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred())
+		var keys []string
+		for _, l := range resp.Lockouts {
+			keys = append(keys, l.Key)
+		}
+		Expect(keys).Should(ContainElement("user:mallory"))
+
+		By("Clearing the lockout")
+		cleared, err := admCli.ClearLockout(ctx,
+			&pb.ClearLockoutRequest{Key: "user:mallory"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cleared.Cleared).Should(BeTrue())
+		_, err = admCli.ClearLockout(ctx,
+			&pb.ClearLockoutRequest{Key: "ip:127.0.0.1"})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = login()
+		Expect(status.Code(err)).Should(Equal(codes.InvalidArgument))
+	})
+
+	It("Should require a TOTP code from enrolled users", func() {
+		By("Starting a separate server to enroll the demo user")
+		srv := startService()
+		defer srv.stop()
+
+		cli, conn := dialAuth(srv.auth)
+		defer conn.Close()
+		admCli, admConn := dialAdmin(srv.admin)
+		defer admConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		enr, err := admCli.EnrollTOTP(ctx,
+			&pb.EnrollTOTPRequest{Username: "demo"})
+		Expect(err).ToNot(HaveOccurred(), "problem enrolling")
+		Expect(enr.Uri).Should(HavePrefix("otpauth://totp/"))
+		Expect(enr.RecoveryCodes).ShouldNot(BeEmpty())
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		login := func(code string) error {
+			chal, err := cli.BeginLogin(ctx,
+				&pb.BeginLoginRequest{Username: "demo"})
+			Expect(err).ToNot(HaveOccurred())
+
+			csrPEM, err := pki.NewChallengeCSR(
+				cliKey, "client", chal.Challenge)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = cli.Login(ctx, &pb.LoginRequest{
+				Username: "demo",
+				Password: "test123",
+				Csr:      csrPEM,
+				Totp:     code,
+			})
+			return err
+		}
+
+		By("Logging in without a code")
+		err = login("")
+		Expect(status.Code(err)).Should(Equal(codes.Unauthenticated))
+		Expect(err.Error()).Should(ContainSubstring("TOTP code is required"))
+
+		By("Logging in with a code")
+		code, err := otp.Code(enr.Secret, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(login(code)).To(Succeed())
+
+		By("Reusing the code")
+		err = login(code)
+		Expect(status.Code(err)).Should(Equal(codes.InvalidArgument))
+
+		By("Using a recovery code")
+		Expect(login(enr.RecoveryCodes[0])).To(Succeed())
+	})
+
+	It("Should allow login over several rounds", func() {
+		cli, conn := authCli()
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		resp, asked, err := authenticate(ctx, cli, cliKey, "demo",
+			map[pb.Challenge_Type]string{pb.Challenge_PASSWORD: "test123"})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+		Expect(asked).Should(Equal([]pb.Challenge_Type{
+			pb.Challenge_PASSWORD, pb.Challenge_CSR,
+		}))
+
+		cert, err := pki.PEMtoCert(resp.Cert)
+		Expect(err).ToNot(HaveOccurred(), "problem loading cert")
+		anchor, err := pki.PEMtoCert(resp.Anchors)
+		Expect(err).ToNot(HaveOccurred(), "problem loading anchor")
+		Expect(cert.Issuer).Should(Equal(anchor.Subject))
+
+		By("Answering with the wrong password")
+		_, _, err = authenticate(ctx, cli, cliKey, "demo",
+			map[pb.Challenge_Type]string{pb.Challenge_PASSWORD: "nope"})
+		Expect(status.Code(err)).Should(Equal(codes.InvalidArgument))
+	})
+
+	It("Should challenge for a new password and the terms", func() {
+		terms, err := ioutil.TempFile("", "terms")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(terms.Name())
+		_, err = terms.WriteString("Be excellent to each other.")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(terms.Close()).To(Succeed())
+
+		srv := startService("-change-password", "-terms", terms.Name())
+		defer srv.stop()
+
+		cli, conn := dialAuth(srv.auth)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Using the single request login")
+		_, err = cli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, cli, cliKey, "demo"),
+		})
+		Expect(status.Code(err)).Should(Equal(codes.FailedPrecondition))
+
+		By("Declining the terms")
+		_, _, err = authenticate(ctx, cli, cliKey, "demo",
+			map[pb.Challenge_Type]string{
+				pb.Challenge_PASSWORD:     "test123",
+				pb.Challenge_NEW_PASSWORD: "hunter2hunter2",
+				pb.Challenge_TERMS:        "no",
+			})
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+
+		By("Accepting the terms")
+		_, asked, err := authenticate(ctx, cli, cliKey, "demo",
+			map[pb.Challenge_Type]string{
+				pb.Challenge_PASSWORD: "hunter2hunter2",
+				pb.Challenge_TERMS:    "yes",
+			})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+		Expect(asked).Should(Equal([]pb.Challenge_Type{
+			pb.Challenge_PASSWORD, pb.Challenge_TERMS, pb.Challenge_CSR,
+		}))
+
+		By("Logging in again")
+		_, asked, err = authenticate(ctx, cli, cliKey, "demo",
+			map[pb.Challenge_Type]string{
+				pb.Challenge_PASSWORD: "hunter2hunter2",
+			})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+		Expect(asked).Should(Equal([]pb.Challenge_Type{
+			pb.Challenge_PASSWORD, pb.Challenge_CSR,
+		}))
+	})
+
 	It("should allow retrieval of the MOTD", func() {
 		By("Logging in to get a cert")
 		authCli, authConn := authCli()
@@ -96,8 +390,7 @@ var _ = Describe("Acceptance", func() {
 
 		cliKey, err := pki.GenerateKey()
 		Expect(err).ToNot(HaveOccurred())
-		csrPEM, err := pki.NewCSR(cliKey, "client")
-		Expect(err).ToNot(HaveOccurred())
+		csrPEM := challengeCSR(ctx, authCli, cliKey, "demo")
 
 		authResp, err := authCli.Login(ctx, &pb.LoginRequest{
 			Username: "demo",
@@ -114,4 +407,1658 @@ var _ = Describe("Acceptance", func() {
 		Expect(err).ToNot(HaveOccurred(), "problem getting MOTD")
 		Expect(resp.Bulletin).Should(Equal("Hello and welcome!"))
 	})
+
+	It("Should keep an audit trail that can be verified", func() {
+		authCli, authConn := authCli()
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Failing a login")
+		_, err = authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "wrong",
+			Csr:      challengeCSR(ctx, authCli, cliKey, "demo"),
+		})
+		Expect(err).To(HaveOccurred())
+
+		By("Logging in and getting the MOTD")
+		authResp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, cliKey, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+		cli, conn := protectedCli(cliKey, authResp.Cert, authResp.Anchors)
+		defer conn.Close()
+		_, err = cli.MOTD(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred(), "problem getting MOTD")
+
+		By("Renewing the session")
+		renewResp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, cliKey, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem renewing")
+
+		By("Reading the audit log")
+		logPath := filepath.Join(service.dir, "certs", "audit.log")
+		byt, err := ioutil.ReadFile(logPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		cert, err := pki.PEMtoCert(authResp.Cert)
+		Expect(err).ToNot(HaveOccurred())
+		log := string(byt)
+		Expect(log).Should(ContainSubstring(`"type":"login.failed"`))
+		Expect(log).Should(ContainSubstring(`"type":"login.succeeded"`))
+		Expect(log).Should(ContainSubstring(`"type":"cert.issued"`))
+		Expect(log).Should(ContainSubstring(`"type":"access.granted"`))
+		Expect(log).Should(ContainSubstring(
+			`"serial":"` + cert.SerialNumber.Text(16) + `"`))
+		Expect(log).Should(ContainSubstring(
+			`"fingerprint":"` + pki.Fingerprint(cert) + `"`))
+		renewed, err := pki.PEMtoCert(renewResp.Cert)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(log).Should(ContainSubstring(`"type":"cert.renewed"`))
+		Expect(log).Should(ContainSubstring(
+			`"serial":"` + renewed.SerialNumber.Text(16) + `"`))
+		Expect(log).Should(ContainSubstring(
+			`"renews":"` + cert.SerialNumber.Text(16) + `"`))
+
+		By("Verifying the audit log")
+		verify := exec.Command(exe, "audit", "-log", logPath, "verify")
+		session, err := gexec.Start(verify, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say("Verified"))
+	})
+
+	It("Should export metrics", func() {
+		srv := startService("-metrics", "127.0.0.1:0")
+		defer srv.stop()
+
+		authCli, authConn := dialAuth(srv.auth)
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Failing a login")
+		_, err = authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "wrong",
+			Csr:      challengeCSR(ctx, authCli, cliKey, "demo"),
+		})
+		Expect(err).To(HaveOccurred())
+
+		By("Logging in")
+		authResp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, cliKey, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+		By("Presenting a certificate from an unknown CA")
+		otherCert, err := pki.SelfSign(cliKey, "other")
+		Expect(err).ToNot(HaveOccurred())
+		other, err := pki.PEMtoCert(otherCert)
+		Expect(err).ToNot(HaveOccurred())
+		pool := x509.NewCertPool()
+		Expect(pool.AppendCertsFromPEM([]byte(authResp.Anchors))).To(BeTrue())
+
+		// Clients only offer certificates issued by a CA the server accepts,
+		// so the certificate is given directly.
+		tlsConn, err := tls.Dial("tcp", srv.addr, &tls.Config{
+			ServerName: "tls-sess-demo",
+			RootCAs:    pool,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (
+				*tls.Certificate, error,
+			) {
+				return &tls.Certificate{
+					Certificate: [][]byte{other.Raw},
+					PrivateKey:  cliKey,
+				}, nil
+			},
+		})
+		if err == nil {
+			_, _ = tlsConn.Read(make([]byte, 1))
+			tlsConn.Close()
+		}
+
+		By("Scraping the metrics")
+		scrape := func() string {
+			resp, err := http.Get("http://" + srv.metrics + "/metrics")
+			Expect(err).ToNot(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).Should(Equal(http.StatusOK))
+
+			byt, err := ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			return string(byt)
+		}
+
+		Eventually(scrape).Should(ContainSubstring(
+			`tls_sess_handshake_failures_total{reason="unknown_ca"} 1`))
+		out := scrape()
+		Expect(out).Should(ContainSubstring(
+			`tls_sess_logins_total{outcome="success"} 1`))
+		Expect(out).Should(ContainSubstring(
+			`tls_sess_logins_total{outcome="failure"} 1`))
+		Expect(out).Should(ContainSubstring(
+			"tls_sess_certificates_issued_total 1"))
+		Expect(out).Should(ContainSubstring(
+			`tls_sess_rpc_duration_seconds_count{service="pb.Auth",` +
+				`method="Login",code="OK"} 1`))
+		Expect(out).Should(MatchRegexp(
+			`tls_sess_certificate_expiry_timestamp_seconds{cert="ca"} \d`))
+		Expect(out).Should(MatchRegexp(
+			`tls_sess_certificate_expiry_timestamp_seconds{cert="server"} \d`))
+	})
+
+	It("Should continue the client's trace on the server", func() {
+		dir, err := ioutil.TempDir("", "trace")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		spansPath := filepath.Join(dir, "spans.json")
+
+		srv := startService("-trace", spansPath)
+		defer srv.stop()
+
+		authCli, authConn := dialAuth(srv.auth)
+		defer authConn.Close()
+
+		const (
+			traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+			spanID  = "00f067aa0ba902b7"
+		)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			"traceparent", "00-"+traceID+"-"+spanID+"-01")
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, cliKey, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+		type span struct {
+			TraceID      string `json:"traceId"`
+			SpanID       string `json:"spanId"`
+			ParentSpanID string `json:"parentSpanId"`
+			Name         string `json:"name"`
+		}
+		spans := func() map[string]span {
+			byt, err := ioutil.ReadFile(spansPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			byName := make(map[string]span)
+			for _, line := range strings.Split(string(byt), "\n") {
+				if line == "" {
+					continue
+				}
+				var s span
+				Expect(json.Unmarshal([]byte(line), &s)).To(Succeed())
+				byName[s.Name] = s
+			}
+			return byName
+		}
+
+		Eventually(spans).Should(HaveKey("pb.Auth/Login"))
+		byName := spans()
+		login := byName["pb.Auth/Login"]
+		Expect(login.TraceID).Should(Equal(traceID))
+		Expect(login.ParentSpanID).Should(Equal(spanID))
+		for _, name := range []string{"users.check", "pki.SignCSR"} {
+			Expect(byName).Should(HaveKey(name))
+			Expect(byName[name].TraceID).Should(Equal(traceID))
+			Expect(byName[name].ParentSpanID).Should(Equal(login.SpanID))
+		}
+	})
+
+	It("Should report the health of both servers", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		By("Checking the Auth server")
+		authCli, authConn := authCli()
+		defer authConn.Close()
+		for _, svc := range []string{"", "pb.Auth"} {
+			resp, err := healthpb.NewHealthClient(authConn).Check(ctx,
+				&healthpb.HealthCheckRequest{Service: svc})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.Status).Should(
+				Equal(healthpb.HealthCheckResponse_SERVING))
+		}
+
+		session, err := gexec.Start(
+			exec.Command(exe, "health", "-connect", auth),
+			GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say("SERVING"))
+
+		By("Checking the Protected server with a client certificate")
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		authResp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, cliKey, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+		_, conn := protectedCli(cliKey, authResp.Cert, authResp.Anchors)
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx,
+			&healthpb.HealthCheckRequest{Service: "pb.Protected"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Status).Should(Equal(healthpb.HealthCheckResponse_SERVING))
+	})
+
+	It("Should not serve with an expired CA", func() {
+		dir, err := ioutil.TempDir("", "expired")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		By("Creating an expired CA")
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		keyPath := filepath.Join(dir, "ca_key.pem")
+		Expect(pki.SaveKey(key, keyPath)).To(Succeed())
+
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "tls-sess-demo"},
+			DNSNames:              []string{"tls-sess-demo"},
+			NotBefore:             time.Now().Add(-48 * time.Hour),
+			NotAfter:              time.Now().Add(-24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage: x509.KeyUsageDigitalSignature |
+				x509.KeyUsageCertSign,
+		}
+		der, err := x509.CreateCertificate(
+			rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		Expect(err).ToNot(HaveOccurred())
+		caPath := filepath.Join(dir, "ca_cert.pem")
+		Expect(pki.SaveCert(string(pem.EncodeToMemory(&pem.Block{
+			Type: "CERTIFICATE", Bytes: der,
+		})), caPath)).To(Succeed())
+
+		srv := startService("-key", keyPath, "-ca", caPath, "-reflection")
+		defer srv.stop()
+
+		_, conn := dialAuth(srv.auth)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		By("Checking the health")
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			resp, err := healthpb.NewHealthClient(conn).Check(ctx,
+				&healthpb.HealthCheckRequest{Service: "pb.Auth"})
+			Expect(err).ToNot(HaveOccurred())
+			return resp.Status
+		}).Should(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+
+		session, err := gexec.Start(
+			exec.Command(exe, "health", "-connect", srv.auth),
+			GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(1))
+		Expect(session.Out).Should(gbytes.Say("NOT_SERVING"))
+
+		By("Listing the services with reflection")
+		stream, err := rpb.NewServerReflectionClient(conn).
+			ServerReflectionInfo(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stream.Send(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+		})).To(Succeed())
+		refl, err := stream.Recv()
+		Expect(err).ToNot(HaveOccurred())
+
+		var names []string
+		for _, svc := range refl.GetListServicesResponse().Service {
+			names = append(names, svc.Name)
+		}
+		Expect(names).Should(ContainElement("pb.Auth"))
+		Expect(names).Should(ContainElement("grpc.health.v1.Health"))
+	})
+
+	It("Should let logins in flight finish when asked to stop", func() {
+		srv := startService()
+		defer srv.stop()
+
+		authCli, authConn := dialAuth(srv.auth)
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := authCli.Authenticate(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stream.Send(&pb.AuthenticateRequest{
+			Step: &pb.AuthenticateRequest_Start{
+				Start: &pb.AuthenticateStart{Username: "demo"},
+			},
+		})).To(Succeed())
+		step, err := stream.Recv()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(step.GetChallenge().GetType()).Should(
+			Equal(pb.Challenge_PASSWORD))
+
+		By("Asking the server to stop")
+		srv.session.Signal(syscall.SIGTERM)
+		Eventually(srv.session.Err).Should(gbytes.Say("Shutting down"))
+
+		By("Finishing the login")
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		answer := func(value string) *pb.AuthenticateResponse {
+			Expect(stream.Send(&pb.AuthenticateRequest{
+				Step: &pb.AuthenticateRequest_Answer{
+					Answer: &pb.ChallengeAnswer{Value: value},
+				},
+			})).To(Succeed())
+			step, err := stream.Recv()
+			Expect(err).ToNot(HaveOccurred())
+			return step
+		}
+		step = answer("test123")
+		Expect(step.GetChallenge().GetType()).Should(Equal(pb.Challenge_CSR))
+		csr, err := pki.NewChallengeCSR(cliKey, "client",
+			step.GetChallenge().Nonce)
+		Expect(err).ToNot(HaveOccurred())
+		step = answer(csr)
+		Expect(step.GetResult().GetCert()).ShouldNot(BeEmpty())
+
+		Eventually(srv.session, 5).Should(gexec.Exit(0))
+		Expect(srv.session.Err).Should(gbytes.Say("Stopped"))
+	})
+
+	It("Should stop every server when one fails", func() {
+		dir, err := ioutil.TempDir("", "tls-sess-demo")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		cmd := exec.Command(exe, "serv",
+			"-auth", "127.0.0.1:0",
+			"-listen", "127.0.0.1:0",
+			"-metrics", auth, // already in use
+		)
+		cmd.Dir = dir
+		session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(session, 5).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("metrics server failed to listen"))
+	})
+
+	It("Should rotate the CA without a restart", func() {
+		srv := startService()
+		defer srv.stop()
+
+		authCli, authConn := dialAuth(srv.auth)
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		login := func() (*ecdsa.PrivateKey, *pb.LoginResponse) {
+			key, err := pki.GenerateKey()
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := authCli.Login(ctx, &pb.LoginRequest{
+				Username: "demo",
+				Password: "test123",
+				Csr:      challengeCSR(ctx, authCli, key, "demo"),
+			})
+			Expect(err).ToNot(HaveOccurred(), "problem logging in")
+			return key, resp
+		}
+
+		By("Connecting with a certificate from the first CA")
+		oldKey, oldResp := login()
+		oldCli, oldConn := dialProtected(srv.addr, oldKey, oldResp.Cert,
+			oldResp.Anchors)
+		defer oldConn.Close()
+		_, err := oldCli.MOTD(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Replacing the CA and keeping the old one as an anchor")
+		caPath := filepath.Join(srv.dir, "certs", "ca_cert.pem")
+		oldCA, err := ioutil.ReadFile(caPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		caKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		newCA, err := pki.SelfSign(caKey, "tls-sess-demo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pki.SaveKey(caKey,
+			filepath.Join(srv.dir, "certs", "ca_key.pem"))).To(Succeed())
+		Expect(pki.SaveCert(newCA+string(oldCA), caPath)).To(Succeed())
+		Eventually(srv.session.Err).Should(gbytes.Say("Reloaded keys"))
+
+		By("Logging in to get a certificate from the new CA")
+		newKey, newResp := login()
+		Expect(newResp.Anchors).Should(HavePrefix(newCA))
+		Expect(newResp.Anchors).Should(ContainSubstring(string(oldCA)))
+
+		newCert, err := pki.PEMtoCert(newResp.Cert)
+		Expect(err).ToNot(HaveOccurred())
+		newCACert, err := pki.PEMtoCert(newCA)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(newCert.CheckSignatureFrom(newCACert)).To(Succeed())
+
+		By("Using the connection made before the rotation")
+		_, err = oldCli.MOTD(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Connecting with certificates from either CA")
+		for _, c := range []struct {
+			key  *ecdsa.PrivateKey
+			cert string
+		}{{newKey, newResp.Cert}, {oldKey, oldResp.Cert}} {
+			cli, conn := dialProtected(srv.addr, c.key, c.cert,
+				newResp.Anchors)
+			_, err = cli.MOTD(ctx, &empty.Empty{})
+			conn.Close()
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		By("Reloading on SIGHUP")
+		srv.session.Signal(syscall.SIGHUP)
+		Eventually(srv.session.Err).Should(gbytes.Say("Reloaded keys"))
+		Consistently(srv.session).ShouldNot(gexec.Exit())
+	})
+
+	It("Should check the configuration file", func() {
+		dir, err := ioutil.TempDir("", "config")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		check := func(yaml string, args ...string) *gexec.Session {
+			path := filepath.Join(dir, "serv.yaml")
+			Expect(ioutil.WriteFile(path, []byte(yaml), 0600)).To(Succeed())
+
+			args = append([]string{"serv", "-config", path, "-check-config"},
+				args...)
+			session, err := gexec.Start(exec.Command(exe, args...),
+				GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait()
+		}
+
+		By("Accepting a valid configuration")
+		session := check("ttl:\n  certificate: 24h\n")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say("Configuration OK"))
+
+		By("Listing every problem with its line")
+		session = check("listen:\n  auth: nowhere\nlog:\n  levl: debug\n")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Out).Should(gbytes.Say(`serv\.yaml:4: .*levl`))
+
+		session = check("listen:\n  auth: nowhere\nlog:\n  level: loud\n",
+			"-max-failures", "0")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Out).Should(gbytes.Say(`serv\.yaml:2: listen\.auth: `))
+		Expect(session.Out).Should(gbytes.Say(
+			`-max-failures: limits\.user\.max_failures: `))
+		Expect(session.Out).Should(gbytes.Say(`serv\.yaml:4: log\.level: `))
+	})
+
+	It("Should serve with a configuration file", func() {
+		dir, err := ioutil.TempDir("", "config")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "serv.yaml")
+		Expect(ioutil.WriteFile(path, []byte(`
+listen:
+  auth: 127.0.0.1:1
+ttl:
+  certificate: 2h
+tls:
+  min_version: "1.3"
+`), 0600)).To(Succeed())
+
+		By("Overriding the listen address with a flag")
+		srv := startService("-config", path)
+		defer srv.stop()
+
+		authCli, authConn := dialAuth(srv.auth)
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, key, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+		By("Issuing certificates with the configured lifetime")
+		cert, err := pki.PEMtoCert(resp.Cert)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.NotAfter).Should(BeTemporally("~",
+			time.Now().Add(2*time.Hour), time.Minute))
+
+		By("Refusing TLS 1.2 on the protected server")
+		_, err = tls.Dial("tcp", srv.addr, &tls.Config{
+			InsecureSkipVerify: true,
+			MaxVersion:         tls.VersionTLS12,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should keep a profile for each server", func() {
+		home, err := ioutil.TempDir("", "profiles")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		run := func(args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home)
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(5)
+		}
+
+		By("Logging in to get a cert")
+		authCli, authConn := authCli()
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, key, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+		ca, err := pki.PEMtoCert(resp.Anchors)
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Writing a profile for the server")
+		dir := filepath.Join(home, "tls-sess-demo")
+		profDir := filepath.Join(dir, "profiles", "work")
+		Expect(os.MkdirAll(profDir, 0700)).To(Succeed())
+		Expect(pki.SaveKey(key, filepath.Join(profDir, "key.pem"))).
+			To(Succeed())
+		Expect(pki.SaveCert(resp.Cert, filepath.Join(profDir, "cert.pem"))).
+			To(Succeed())
+		Expect(pki.SaveCert(resp.Anchors, filepath.Join(profDir, "root.pem"))).
+			To(Succeed())
+		writeProfiles := func(current, fingerprint string) {
+			Expect(ioutil.WriteFile(filepath.Join(dir, "profiles.yaml"),
+				[]byte("current: "+current+"\n"+
+					"profiles:\n"+
+					"  work:\n"+
+					"    auth: "+auth+"\n"+
+					"    protected: "+addr+"\n"+
+					"    fingerprint: "+fingerprint+"\n"),
+				0600)).To(Succeed())
+		}
+		writeProfiles("", pki.Fingerprint(ca))
+
+		By("Using the profile by name")
+		session := run("motd", "-profile", "work")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say("Hello and welcome!"))
+
+		By("Making the profile current")
+		Expect(run("profile", "use", "work")).Should(gexec.Exit(0))
+		session = run("profile", "list")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say(`\*\s+work\s+` + auth))
+
+		session = run("health", "-protected")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say("SERVING"))
+
+		By("Refusing a server that does not match the pinned CA")
+		writeProfiles("work", "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+		session = run("motd")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("pinned for profile work"))
+
+		By("Deleting the profile")
+		Expect(run("profile", "delete", "work")).Should(gexec.Exit(0))
+		Expect(profDir).ShouldNot(BeADirectory())
+		session = run("profile", "list")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).ShouldNot(gbytes.Say("work"))
+	})
+
+	It("Should show the status of the session", func() {
+		home, err := ioutil.TempDir("", "status")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		run := func(args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home)
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(5)
+		}
+
+		By("Refusing to show a session before login")
+		session := run("status")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("login first"))
+
+		By("Logging in to get a cert")
+		authCli, authConn := authCli()
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, key, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+		cert, err := pki.PEMtoCert(resp.Cert)
+		Expect(err).ToNot(HaveOccurred())
+
+		profDir := filepath.Join(home, "tls-sess-demo", "profiles", "default")
+		Expect(os.MkdirAll(profDir, 0700)).To(Succeed())
+		keyPath := filepath.Join(profDir, "key.pem")
+		certPath := filepath.Join(profDir, "cert.pem")
+		Expect(pki.SaveKey(key, keyPath)).To(Succeed())
+		Expect(pki.SaveCert(resp.Cert, certPath)).To(Succeed())
+		Expect(pki.SaveCert(resp.Anchors, filepath.Join(profDir, "root.pem"))).
+			To(Succeed())
+
+		By("Showing who the session is for")
+		session = run("status")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say(`User:\s+demo`))
+		Expect(session.Out).Should(gbytes.Say(`Roles:\s+user`))
+		Expect(session.Out).Should(gbytes.Say(`Device:\s+client`))
+		Expect(session.Out).Should(gbytes.Say(
+			`Serial:\s+` + cert.SerialNumber.Text(16)))
+		Expect(session.Out).Should(gbytes.Say(`Status:\s+valid`))
+
+		session = run("status", "-json")
+		Expect(session).Should(gexec.Exit(0))
+		var st struct {
+			User      string
+			Roles     []string
+			Expires   time.Time
+			Remaining float64
+			Valid     bool
+		}
+		Expect(json.Unmarshal(session.Out.Contents(), &st)).To(Succeed())
+		Expect(st.User).Should(Equal("demo"))
+		Expect(st.Roles).Should(Equal([]string{"user"}))
+		Expect(st.Expires).Should(BeTemporally("~", cert.NotAfter, time.Second))
+		Expect(st.Remaining).Should(BeNumerically("~",
+			(168 * time.Hour).Seconds(), 10))
+		Expect(st.Valid).Should(BeTrue())
+
+		By("Failing when the key does not match")
+		other, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pki.SaveKey(other, keyPath)).To(Succeed())
+		session = run("status")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Out).Should(gbytes.Say("does not match the key"))
+
+		By("Failing when the certificate expired")
+		Expect(pki.SaveKey(key, keyPath)).To(Succeed())
+		caKey, err := pki.LoadKey(
+			filepath.Join(service.dir, "certs", "ca_key.pem"))
+		Expect(err).ToNot(HaveOccurred())
+		ca, err := pki.PEMtoCert(resp.Anchors)
+		Expect(err).ToNot(HaveOccurred())
+		csr, err := pki.NewCSR(key, "client")
+		Expect(err).ToNot(HaveOccurred())
+		expired, err := pki.SignIdentity(caKey, ca, csr, -time.Minute,
+			"demo", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pki.SaveCert(expired, certPath)).To(Succeed())
+		session = run("status", "-json")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(json.Unmarshal(session.Out.Contents(), &st)).To(Succeed())
+		Expect(st.Valid).Should(BeFalse())
+		Expect(st.Remaining).Should(BeZero())
+
+		By("Refusing a key that other users can read")
+		Expect(os.Chmod(keyPath, 0644)).To(Succeed())
+		session = run("status")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("too open"))
+		session = run("motd")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("too open"))
+	})
+
+	It("Should login without a terminal", func() {
+		srv := startService()
+		defer srv.stop()
+
+		home, err := ioutil.TempDir("", "login")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		run := func(stdin string, env []string, args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home)
+			cmd.Env = append(cmd.Env, env...)
+			cmd.Stdin = strings.NewReader(stdin)
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(5)
+		}
+		loggedIn := func() {
+			session := run("", nil, "status")
+			Expect(session).Should(gexec.Exit(0))
+			Expect(session.Out).Should(gbytes.Say(`User:\s+demo`))
+			Expect(os.RemoveAll(home)).To(Succeed())
+		}
+		login := []string{"login", "-connect", srv.auth, "-protected", srv.addr}
+
+		By("Failing instead of prompting without credentials")
+		session := run("", nil, login...)
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("without a terminal"))
+
+		By("Taking the credentials from the environment")
+		session = run("", []string{
+			"TLS_SESS_USERNAME=demo", "TLS_SESS_PASSWORD=test123",
+		}, login...)
+		Expect(session).Should(gexec.Exit(0))
+		loggedIn()
+
+		By("Reading the password from standard input")
+		session = run("test123\n", nil,
+			append(login, "-username", "demo", "-password-stdin")...)
+		Expect(session).Should(gexec.Exit(0))
+		loggedIn()
+
+		By("Getting the credentials from a helper")
+		helperDir, err := ioutil.TempDir("", "helper")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(helperDir)
+		helper := filepath.Join(helperDir, "helper")
+		calls := filepath.Join(helperDir, "calls")
+		Expect(ioutil.WriteFile(helper, []byte(`#!/bin/sh
+echo "$1" >> "`+calls+`"
+cat >> "`+calls+`"
+if [ "$1" = get ]; then
+	echo username=demo
+	echo password=$HELPER_PASSWORD
+fi
+`), 0700)).To(Succeed())
+
+		session = run("", []string{"HELPER_PASSWORD=test123"},
+			append(login, "-credential-helper", helper)...)
+		Expect(session).Should(gexec.Exit(0))
+		loggedIn()
+		Expect(ioutil.ReadFile(calls)).Should(Equal([]byte(
+			"get\nprotocol=tls-sess-demo\nhost=" + srv.auth + "\n\n" +
+				"store\nprotocol=tls-sess-demo\nhost=" + srv.auth + "\n" +
+				"username=demo\npassword=test123\n\n")))
+
+		By("Telling the helper to erase rejected credentials")
+		Expect(os.Remove(calls)).To(Succeed())
+		session = run("", []string{"HELPER_PASSWORD=wrong"},
+			append(login, "-credential-helper", helper)...)
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("incorrect username or password"))
+		raw, err := ioutil.ReadFile(calls)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).Should(HavePrefix("get\n"))
+		Expect(string(raw)).Should(ContainSubstring("erase\n"))
+
+		By("Keeping the password when only the TOTP code is wrong")
+		admCli, admConn := dialAdmin(srv.admin)
+		defer admConn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		enr, err := admCli.EnrollTOTP(ctx,
+			&pb.EnrollTOTPRequest{Username: "demo"})
+		Expect(err).ToNot(HaveOccurred())
+		wrong, err := otp.Code(enr.Secret, time.Now().Add(time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.Remove(calls)).To(Succeed())
+		session = run("", []string{"HELPER_PASSWORD=test123",
+			"TLS_SESS_OTP=" + wrong},
+			append(login, "-credential-helper", helper)...)
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("incorrect TOTP code"))
+		raw, err = ioutil.ReadFile(calls)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).Should(Equal("get\nprotocol=tls-sess-demo\n" +
+			"host=" + srv.auth + "\n\n"))
+	})
+
+	It("Should keep the key encrypted with a passphrase", func() {
+		srv := startService()
+		defer srv.stop()
+
+		home, err := ioutil.TempDir("", "encrypted")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		run := func(pass string, args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home,
+				"TLS_SESS_USERNAME=demo", "TLS_SESS_PASSWORD=test123")
+			if pass != "" {
+				cmd.Env = append(cmd.Env, "TLS_SESS_KEY_PASSPHRASE="+pass)
+			}
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(15)
+		}
+		keyPath := filepath.Join(home, "tls-sess-demo", "profiles", "default",
+			"key.pem")
+
+		By("Logging in with the key in an encrypted file")
+		session := run("hunter2", "login", "-connect", srv.auth,
+			"-protected", srv.addr, "-keystore", "encrypted")
+		Expect(session).Should(gexec.Exit(0))
+		encrypted, err := ioutil.ReadFile(keyPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(encrypted)).ShouldNot(ContainSubstring("PRIVATE KEY"))
+		_, err = pki.LoadKey(keyPath)
+		Expect(err).To(HaveOccurred())
+		Expect(ioutil.ReadFile(filepath.Join(home, "tls-sess-demo",
+			"profiles.yaml"))).Should(ContainSubstring("keystore: encrypted"))
+
+		By("Using the key with its passphrase")
+		session = run("hunter2", "motd")
+		Expect(session).Should(gexec.Exit(0))
+		session = run("hunter2", "status")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say(`User:\s+demo`))
+
+		By("Failing without the passphrase")
+		session = run("", "motd")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("without a terminal"))
+		session = run("wrong", "motd")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("wrong passphrase"))
+
+		By("Keeping the key when logging in again")
+		session = run("hunter2", "login")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(ioutil.ReadFile(keyPath)).Should(Equal(encrypted))
+	})
+
+	It("Should issue SSH certificates for the session", func() {
+		srv := startService()
+		defer srv.stop()
+
+		home, err := ioutil.TempDir("", "ssh-cert")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		run := func(args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home,
+				"HOME="+home, "TLS_SESS_USERNAME=demo",
+				"TLS_SESS_PASSWORD=test123")
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(15)
+		}
+
+		By("Generating an SSH key")
+		sshDir := filepath.Join(home, ".ssh")
+		Expect(os.Mkdir(sshDir, 0700)).To(Succeed())
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		pub, err := ssh.NewPublicKey(&key.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.WriteFile(filepath.Join(sshDir, "id_ecdsa.pub"),
+			ssh.MarshalAuthorizedKey(pub), 0644)).To(Succeed())
+
+		By("Refusing to certify a key without a session")
+		session := run("ssh-cert", "-connect", srv.addr)
+		Expect(session).Should(gexec.Exit(1))
+
+		By("Certifying the key for the user of the session")
+		session = run("login", "-connect", srv.auth, "-protected", srv.addr)
+		Expect(session).Should(gexec.Exit(0))
+		caPath := filepath.Join(home, "ca.pub")
+		session = run("ssh-cert", "-ca-out", caPath)
+		Expect(session).Should(gexec.Exit(0))
+		certPath := filepath.Join(sshDir, "id_ecdsa-cert.pub")
+		Expect(session.Out).Should(gbytes.Say("SSH certificate: " +
+			regexp.QuoteMeta(certPath)))
+
+		raw, err := ioutil.ReadFile(certPath)
+		Expect(err).ToNot(HaveOccurred())
+		parsed, _, _, _, err := ssh.ParseAuthorizedKey(raw)
+		Expect(err).ToNot(HaveOccurred())
+		cert, ok := parsed.(*ssh.Certificate)
+		Expect(ok).Should(BeTrue())
+		Expect(cert.CertType).Should(Equal(uint32(ssh.UserCert)))
+		Expect(cert.ValidPrincipals).Should(Equal([]string{"demo"}))
+		Expect(cert.Key.Marshal()).Should(Equal(pub.Marshal()))
+		Expect(cert.Permissions.Extensions).Should(HaveKey("permit-pty"))
+
+		By("Expiring with the session")
+		sessionCert, err := pki.LoadCert(filepath.Join(home, "tls-sess-demo",
+			"profiles", "default", "cert.pem"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.ValidBefore).Should(Equal(
+			uint64(sessionCert.NotAfter.Unix())))
+		Expect(cert.KeyId).Should(ContainSubstring(
+			"session=" + sessionCert.SerialNumber.Text(16)))
+
+		By("Recording the certificate in the audit log")
+		raw, err = ioutil.ReadFile(filepath.Join(srv.dir, "certs",
+			"audit.log"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).Should(ContainSubstring(
+			`"type":"sshcert.issued","user":"demo"`))
+		Expect(string(raw)).Should(ContainSubstring(`"serial":"` +
+			strconv.FormatUint(cert.Serial, 10) + `","subject":"` +
+			cert.KeyId + `"`))
+
+		By("Being signed by the CA written for SSH servers")
+		raw, err = ioutil.ReadFile(caPath)
+		Expect(err).ToNot(HaveOccurred())
+		caPub, _, _, _, err := ssh.ParseAuthorizedKey(raw)
+		Expect(err).ToNot(HaveOccurred())
+		checker := &ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return bytes.Equal(auth.Marshal(), caPub.Marshal())
+			},
+		}
+		_, err = checker.Authenticate(sshConnMeta("demo"), cert)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = checker.Authenticate(sshConnMeta("root"), cert)
+		Expect(err).To(HaveOccurred())
+
+		By("Refusing what is not an SSH public key")
+		notKey := filepath.Join(home, "not-a-key.pub")
+		Expect(ioutil.WriteFile(notKey, []byte("hello"), 0644)).To(Succeed())
+		session = run("ssh-cert", "-ssh-key", notKey)
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("parsing SSH public key"))
+	})
+
+	It("Should write the configuration of VPN clients and servers", func() {
+		srv := startService("-crl", "certs/crl.pem")
+		defer srv.stop()
+
+		home, err := ioutil.TempDir("", "vpn-config")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		run := func(dir string, args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home,
+				"TLS_SESS_USERNAME=demo", "TLS_SESS_PASSWORD=test123")
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(15)
+		}
+		profileDir := filepath.Join(home, "tls-sess-demo", "profiles",
+			"default")
+
+		session := run(home, "login", "-connect", srv.auth,
+			"-protected", srv.addr)
+		Expect(session).Should(gexec.Exit(0))
+		certPEM, err := ioutil.ReadFile(filepath.Join(profileDir, "cert.pem"))
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Embedding the session in an OpenVPN client's configuration")
+		session = run(home, "vpn-config", "-type", "openvpn",
+			"-remote", "vpn.example.com")
+		Expect(session).Should(gexec.Exit(0))
+		out := string(session.Out.Contents())
+		Expect(out).Should(ContainSubstring("remote vpn.example.com 1194\n"))
+		Expect(out).Should(ContainSubstring(
+			"verify-x509-name vpn.example.com name\n"))
+		Expect(out).Should(ContainSubstring("<cert>\n" + string(certPEM)))
+		Expect(out).Should(ContainSubstring(
+			"key " + filepath.Join(profileDir, "key.pem") + "\n"))
+
+		By("Referring to the session in a strongSwan client's configuration")
+		confPath := filepath.Join(home, "swanctl.conf")
+		session = run(home, "vpn-config", "-type", "strongswan",
+			"-remote", "vpn.example.com", "-out", confPath)
+		Expect(session).Should(gexec.Exit(0))
+		Expect(ioutil.ReadFile(confPath)).Should(ContainSubstring(
+			"certs = " + filepath.Join(profileDir, "cert.pem") + "\n"))
+
+		By("Refusing without the address of the VPN server")
+		session = run(home, "vpn-config")
+		Expect(session).Should(gexec.Exit(1))
+
+		By("Keeping a CRL of the revoked sessions for the server")
+		crlPath := filepath.Join(srv.dir, "certs", "crl.pem")
+		ca, err := pki.LoadCert(filepath.Join(srv.dir, "certs",
+			"ca_cert.pem"))
+		Expect(err).ToNot(HaveOccurred())
+		readCRL := func() *x509.RevocationList {
+			raw, err := ioutil.ReadFile(crlPath)
+			Expect(err).ToNot(HaveOccurred())
+			blk, _ := pem.Decode(raw)
+			Expect(blk).ToNot(BeNil())
+			crl, err := x509.ParseRevocationList(blk.Bytes)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(crl.CheckSignatureFrom(ca)).To(Succeed())
+			return crl
+		}
+		first := readCRL()
+		Expect(first.RevokedCertificateEntries).Should(BeEmpty())
+
+		cert, err := pki.PEMtoCert(string(certPEM))
+		Expect(err).ToNot(HaveOccurred())
+		serial := cert.SerialNumber.Text(16)
+		session = run(home, "revoke", "-admin", srv.admin, serial)
+		Expect(session).Should(gexec.Exit(0))
+
+		var crl *x509.RevocationList
+		Eventually(func() []x509.RevocationListEntry {
+			crl = readCRL()
+			return crl.RevokedCertificateEntries
+		}, 5).Should(HaveLen(1))
+		Expect(crl.RevokedCertificateEntries[0].SerialNumber).Should(
+			Equal(cert.SerialNumber))
+		Expect(crl.Number.Cmp(first.Number)).Should(Equal(1))
+
+		By("Refusing the server's snippet without a server certificate")
+		session = run(srv.dir, "vpn-config", "-server", "-type", "openvpn")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("server-cert -name HOST"))
+
+		By("Presenting a certificate of the VPN server's own")
+		session = run(srv.dir, "server-cert", "-name", "vpn.example.com")
+		Expect(session).Should(gexec.Exit(0))
+		session = run(srv.dir, "vpn-config", "-server", "-type", "openvpn")
+		Expect(session).Should(gexec.Exit(0))
+		out = string(session.Out.Contents())
+		certsDir := filepath.Join(srv.dir, "certs")
+		Expect(out).Should(ContainSubstring(
+			"ca " + filepath.Join(certsDir, "ca_cert.pem") + "\n"))
+		Expect(out).Should(ContainSubstring(
+			"cert " + filepath.Join(certsDir, "server_cert.pem") + "\n"))
+		Expect(out).Should(ContainSubstring(
+			"key " + filepath.Join(certsDir, "server_key.pem") + "\n"))
+		Expect(out).Should(ContainSubstring("crl-verify " + crlPath + "\n"))
+		Expect(out).ShouldNot(ContainSubstring("ca_key.pem"))
+
+		By("Writing the CRL again with a greater number")
+		Expect(readCRL().Number.Cmp(crl.Number)).Should(Equal(1))
+	})
+
+	It("Should sign with the keys held by an agent", func() {
+		srv := startService()
+		defer srv.stop()
+
+		home, err := ioutil.TempDir("", "agent")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		env := func(pass string) []string {
+			env := append(os.Environ(), "XDG_CONFIG_HOME="+home,
+				"TLS_SESS_USERNAME=demo", "TLS_SESS_PASSWORD=test123")
+			if pass != "" {
+				env = append(env, "TLS_SESS_KEY_PASSPHRASE="+pass)
+			}
+			return env
+		}
+		run := func(pass, stdin string, args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Env = env(pass)
+			cmd.Stdin = strings.NewReader(stdin)
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(15)
+		}
+		certPath := filepath.Join(home, "tls-sess-demo", "profiles", "default",
+			"cert.pem")
+		serial := func() string {
+			raw, err := ioutil.ReadFile(certPath)
+			Expect(err).ToNot(HaveOccurred())
+			cert, err := pki.PEMtoCert(string(raw))
+			Expect(err).ToNot(HaveOccurred())
+			return cert.SerialNumber.Text(16)
+		}
+
+		By("Logging in with the key in an encrypted file")
+		session := run("hunter2", "", "login", "-connect", srv.auth,
+			"-protected", srv.addr, "-keystore", "encrypted")
+		Expect(session).Should(gexec.Exit(0))
+		session = run("", "", "agent", "add")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("no agent is running"))
+
+		By("Adding the key to the agent")
+		cmd := exec.Command(exe, "agent", "serve")
+		cmd.Env = env("")
+		agentSession, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		defer agentSession.Kill()
+		Eventually(agentSession.Err, 3).Should(gbytes.Say("Agent listening"))
+
+		session = run("hunter2", "", "agent", "add")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say("Added key of profile: default"))
+		session = run("", "", "agent", "list")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say(`default\s+demo\s+\S+\s+never`))
+
+		By("Signing without the passphrase")
+		session = run("", "", "motd")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say("Hello and welcome!"))
+
+		By("Renewing the agent's session when logging in again")
+		old := serial()
+		session = run("", "", "login")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(serial()).ShouldNot(Equal(old))
+		session = run("", "", "revoke", "-admin", srv.admin, old)
+		Expect(session).Should(gexec.Exit(0))
+		session = run("", "", "motd")
+		Expect(session).Should(gexec.Exit(0))
+
+		By("Refusing to sign while locked")
+		session = run("", "secret\n", "agent", "lock", "-passphrase-stdin")
+		Expect(session).Should(gexec.Exit(0))
+		session = run("", "", "motd")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("passphrase"))
+
+		By("Logging in with the profile's key while locked")
+		session = run("hunter2", "", "login")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Err).Should(gbytes.Say(
+			"Could not renew the agent's session"))
+
+		session = run("", "wrong\n", "agent", "unlock", "-passphrase-stdin")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("incorrect passphrase"))
+		session = run("", "secret\n", "agent", "unlock", "-passphrase-stdin")
+		Expect(session).Should(gexec.Exit(0))
+		session = run("", "", "motd")
+		Expect(session).Should(gexec.Exit(0))
+
+		By("Forgetting keys when asked or when their lifetime is up")
+		session = run("", "", "agent", "remove")
+		Expect(session).Should(gexec.Exit(0))
+		session = run("", "", "agent", "list")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).ShouldNot(gbytes.Say("default"))
+
+		session = run("hunter2", "", "agent", "add", "-lifetime", "1s")
+		Expect(session).Should(gexec.Exit(0))
+		Eventually(func() string {
+			session := run("", "", "agent", "list")
+			Expect(session).Should(gexec.Exit(0))
+			return string(session.Out.Contents())
+		}, 3).ShouldNot(ContainSubstring("default"))
+	})
+
+	It("Should forward plain connections with the session certificate", func() {
+		home, err := ioutil.TempDir("", "proxy")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		By("Starting the proxy before logging in")
+		cmd := exec.Command(exe, "proxy", "-listen", "127.0.0.1:0",
+			"-upstream", addr)
+		cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home)
+		session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		defer session.Kill()
+
+		listenRx := regexp.MustCompile(`Proxy listening addr=(\S+)`)
+		Eventually(func() [][]byte {
+			return listenRx.FindSubmatch(session.Err.Contents())
+		}, 3).ShouldNot(BeNil())
+		proxyAddr := string(listenRx.FindSubmatch(session.Err.Contents())[1])
+
+		motd := func() error {
+			conn, err := grpc.Dial(proxyAddr, grpc.WithInsecure())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(),
+				time.Second)
+			defer cancel()
+			resp, err := pb.NewProtectedClient(conn).MOTD(ctx, &empty.Empty{})
+			if err == nil {
+				Expect(resp.Bulletin).Should(Equal("Hello and welcome!"))
+			}
+			return err
+		}
+		Expect(motd()).Should(HaveOccurred())
+		Eventually(session.Err).Should(gbytes.Say("login first"))
+
+		profDir := filepath.Join(home, "tls-sess-demo", "profiles", "default")
+		Expect(os.MkdirAll(profDir, 0700)).To(Succeed())
+		saveSession := func() {
+			authCli, authConn := authCli()
+			defer authConn.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(),
+				time.Second)
+			defer cancel()
+
+			key, err := pki.GenerateKey()
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := authCli.Login(ctx, &pb.LoginRequest{
+				Username: "demo",
+				Password: "test123",
+				Csr:      challengeCSR(ctx, authCli, key, "demo"),
+			})
+			Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+			Expect(pki.SaveKey(key, filepath.Join(profDir, "key.pem"))).
+				To(Succeed())
+			Expect(pki.SaveCert(resp.Cert,
+				filepath.Join(profDir, "cert.pem"))).To(Succeed())
+			Expect(pki.SaveCert(resp.Anchors,
+				filepath.Join(profDir, "root.pem"))).To(Succeed())
+		}
+
+		By("Forwarding once logged in")
+		saveSession()
+		Expect(motd()).To(Succeed())
+
+		By("Picking up a renewed session")
+		time.Sleep(10 * time.Millisecond)
+		saveSession()
+		Expect(motd()).To(Succeed())
+		Eventually(session.Err).Should(
+			gbytes.Say("Reloaded session certificate"))
+	})
+
+	It("Should forward HTTPS requests with signed identity headers", func() {
+		secretFile, err := ioutil.TempFile("", "secret")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(secretFile.Name())
+		secret := []byte("0123456789abcdef0123456789abcdef")
+		_, err = secretFile.Write(secret)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secretFile.Close()).To(Succeed())
+
+		seen := make(chan pki.Identity, 1)
+		upstream := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				id, err := gateway.Verify(r, secret, gateway.DefaultMaxAge,
+					time.Now())
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				select {
+				case seen <- id:
+				default:
+				}
+			}))
+		defer upstream.Close()
+
+		By("Issuing the gateway a certificate of its own")
+		certDir, err := ioutil.TempDir("", "gateway")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(certDir)
+		gwCert := filepath.Join(certDir, "cert.pem")
+		gwKey := filepath.Join(certDir, "key.pem")
+		cmd := exec.Command(exe, "server-cert", "-name", "tls-sess-demo",
+			"-cert", gwCert, "-key", gwKey)
+		cmd.Dir = service.dir
+		session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(session).Should(gexec.Exit(0))
+
+		By("Starting the gateway without the CA's key")
+		cmd = exec.Command(exe, "gateway", "-listen", "127.0.0.1:0",
+			"-upstream", upstream.URL, "-secret", secretFile.Name(),
+			"-cert", gwCert, "-key", gwKey,
+			"-ca", filepath.Join(service.dir, "certs", "ca_cert.pem"),
+			"-revoked", filepath.Join(service.dir, "certs", "revoked.txt"))
+		session, err = gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		defer session.Kill()
+
+		listenRx := regexp.MustCompile(`Gateway listening addr=(\S+)`)
+		Eventually(func() [][]byte {
+			return listenRx.FindSubmatch(session.Err.Contents())
+		}, 3).ShouldNot(BeNil())
+		gatewayURL := "https://" +
+			string(listenRx.FindSubmatch(session.Err.Contents())[1]) + "/"
+
+		By("Logging in to get a cert")
+		authCli, authConn := authCli()
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, key, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+		cert, err := pki.PEMtoCert(resp.Cert)
+		Expect(err).ToNot(HaveOccurred())
+		serial := cert.SerialNumber.Text(16)
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		Expect(err).ToNot(HaveOccurred())
+		keyPEM := pem.EncodeToMemory(&pem.Block{
+			Type: "EC PRIVATE KEY", Bytes: keyDER,
+		})
+
+		get := func() (int, error) {
+			pair, err := tls.X509KeyPair([]byte(resp.Cert), keyPEM)
+			Expect(err).ToNot(HaveOccurred())
+			pool := x509.NewCertPool()
+			Expect(pool.AppendCertsFromPEM([]byte(resp.Anchors))).To(BeTrue())
+
+			cli := &http.Client{
+				Timeout: time.Second,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						ServerName:   "tls-sess-demo",
+						Certificates: []tls.Certificate{pair},
+						RootCAs:      pool,
+					},
+				},
+			}
+			res, err := cli.Post(gatewayURL, "text/plain",
+				strings.NewReader("hello"))
+			if err != nil {
+				return 0, err
+			}
+			res.Body.Close()
+			return res.StatusCode, nil
+		}
+
+		By("Forwarding the identity of the client")
+		Expect(get()).Should(Equal(http.StatusOK))
+		Eventually(seen).Should(Receive(Equal(pki.Identity{
+			User:   "demo",
+			Roles:  []string{"user"},
+			Device: "client",
+			Serial: serial,
+		})))
+
+		By("Revoking the certificate")
+		revokeCmd := exec.Command(exe, "revoke", "-admin", admin, serial)
+		revoked, err := gexec.Start(revokeCmd, GinkgoWriter, GinkgoWriter)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(revoked).Should(gexec.Exit(0))
+		Expect(revoked.Out).Should(gbytes.Say("Revoked: " + serial))
+
+		cli, conn := protectedCli(key, resp.Cert, resp.Anchors)
+		defer conn.Close()
+		_, err = cli.MOTD(ctx, &empty.Empty{})
+		Expect(err).Should(HaveOccurred(),
+			"the Protected server accepted a revoked certificate")
+
+		Eventually(func() error {
+			_, err := get()
+			return err
+		}, 2*revoke.RefreshInterval).Should(HaveOccurred(),
+			"the gateway accepted a revoked certificate")
+
+		raw, err := ioutil.ReadFile(
+			filepath.Join(service.dir, "certs", "audit.log"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).Should(ContainSubstring(
+			`"type":"cert.revoked"`))
+	})
+
+	It("Should serve the services as JSON over HTTPS", func() {
+		srv := startService("-rest", "127.0.0.1:0")
+		defer srv.stop()
+		base := "https://" + srv.rest
+
+		pool := x509.NewCertPool()
+		caPEM, err := ioutil.ReadFile(
+			filepath.Join(srv.dir, "certs", "ca_cert.pem"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool.AppendCertsFromPEM(caPEM)).To(BeTrue())
+
+		client := func(certs ...tls.Certificate) *http.Client {
+			return &http.Client{
+				Timeout: time.Second,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						ServerName:   "tls-sess-demo",
+						Certificates: certs,
+						RootCAs:      pool,
+					},
+				},
+			}
+		}
+		call := func(
+			cli *http.Client, method, path string, req, resp interface{},
+		) int {
+			var body io.Reader
+			if req != nil {
+				byt, err := json.Marshal(req)
+				Expect(err).ToNot(HaveOccurred())
+				body = bytes.NewReader(byt)
+			}
+			r, err := http.NewRequest(method, base+path, body)
+			Expect(err).ToNot(HaveOccurred())
+			res, err := cli.Do(r)
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			if resp != nil && res.StatusCode == http.StatusOK {
+				Expect(res.Header.Get("X-Request-Id")).ShouldNot(BeEmpty())
+				Expect(json.NewDecoder(res.Body).Decode(resp)).To(Succeed())
+			}
+			return res.StatusCode
+		}
+
+		By("Logging in without a certificate")
+		var chal struct {
+			Challenge string `json:"challenge"`
+		}
+		Expect(call(client(), "POST", rest.BeginLoginPath,
+			map[string]string{"username": "demo"}, &chal)).Should(
+			Equal(http.StatusOK))
+		Expect(chal.Challenge).ShouldNot(BeEmpty())
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		csr, err := pki.NewChallengeCSR(key, "client", chal.Challenge)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(call(client(), "POST", rest.LoginPath, map[string]string{
+			"username": "demo", "password": "wrong", "csr": csr,
+		}, nil)).Should(Equal(http.StatusBadRequest))
+
+		Expect(call(client(), "POST", rest.BeginLoginPath,
+			map[string]string{"username": "demo"}, &chal)).Should(
+			Equal(http.StatusOK))
+		csr, err = pki.NewChallengeCSR(key, "client", chal.Challenge)
+		Expect(err).ToNot(HaveOccurred())
+
+		var login struct {
+			Cert    string `json:"cert"`
+			Anchors string `json:"anchors"`
+		}
+		Expect(call(client(), "POST", rest.LoginPath, map[string]string{
+			"username": "demo", "password": "test123", "csr": csr,
+		}, &login)).Should(Equal(http.StatusOK))
+		Expect(login.Anchors).Should(Equal(string(caPEM)))
+
+		By("Calling protected routes with the certificate")
+		Expect(call(client(), "GET", rest.MOTDPath, nil, nil)).Should(
+			Equal(http.StatusUnauthorized))
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		Expect(err).ToNot(HaveOccurred())
+		pair, err := tls.X509KeyPair([]byte(login.Cert), pem.EncodeToMemory(
+			&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+		Expect(err).ToNot(HaveOccurred())
+
+		var motd struct {
+			Bulletin string `json:"bulletin"`
+		}
+		Expect(call(client(pair), "GET", rest.MOTDPath, nil, &motd)).Should(
+			Equal(http.StatusOK))
+		Expect(motd.Bulletin).Should(Equal("Hello and welcome!"))
+
+		raw, err := ioutil.ReadFile(
+			filepath.Join(srv.dir, "certs", "audit.log"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).Should(ContainSubstring(
+			`"method":"/pb.Protected/MOTD"`))
+	})
+
+	It("Should export the session to a PKCS#12 file and import it", func() {
+		home, err := ioutil.TempDir("", "export")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		run := func(stdin string, args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Dir = home
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home)
+			cmd.Stdin = strings.NewReader(stdin)
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session.Wait(5)
+		}
+
+		By("Logging in to get a cert")
+		authCli, authConn := authCli()
+		defer authConn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := authCli.Login(ctx, &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      challengeCSR(ctx, authCli, key, "demo"),
+		})
+		Expect(err).ToNot(HaveOccurred(), "problem logging in")
+
+		profDir := filepath.Join(home, "tls-sess-demo", "profiles", "default")
+		Expect(os.MkdirAll(profDir, 0700)).To(Succeed())
+		Expect(pki.SaveKey(key, filepath.Join(profDir, "key.pem"))).
+			To(Succeed())
+		Expect(pki.SaveCert(resp.Cert, filepath.Join(profDir, "cert.pem"))).
+			To(Succeed())
+		Expect(pki.SaveCert(resp.Anchors, filepath.Join(profDir, "root.pem"))).
+			To(Succeed())
+
+		for _, enc := range []string{"modern", "legacy"} {
+			By("Exporting with " + enc + " encryption")
+			out := filepath.Join(home, enc+".p12")
+			session := run("secret\n", "export", "-format", "p12",
+				"-encryption", enc, "-out", out, "-password-stdin")
+			Expect(session).Should(gexec.Exit(0))
+			Expect(session.Out).Should(gbytes.Say("Exported: " + out))
+
+			pfx, err := ioutil.ReadFile(out)
+			Expect(err).ToNot(HaveOccurred())
+			_, cert, chain, err := pki.DecodePKCS12(pfx, "secret")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(pki.CertToPEM(cert))).Should(Equal(resp.Cert))
+			Expect(chain).Should(HaveLen(1))
+
+			By("Importing into another profile")
+			session = run("wrong\n", "import", "-profile", enc,
+				"-password-stdin", out)
+			Expect(session).Should(gexec.Exit(1))
+
+			session = run("secret\n", "import", "-profile", enc,
+				"-password-stdin", out)
+			Expect(session).Should(gexec.Exit(0))
+			Expect(session.Out).Should(gbytes.Say(
+				"Imported into profile: " + enc))
+
+			session = run("", "status", "-profile", enc)
+			Expect(session).Should(gexec.Exit(0))
+			Expect(session.Out).Should(gbytes.Say(`User:\s+demo`))
+		}
+
+		By("Exporting to a default path named after the profile")
+		session := run("", "export")
+		Expect(session).Should(gexec.Exit(1))
+		Expect(session.Err).Should(gbytes.Say("without a terminal"))
+
+		session = run("secret\n", "export", "-profile", "default",
+			"-password-stdin")
+		Expect(session).Should(gexec.Exit(0))
+		Expect(filepath.Join(home, "default.p12")).Should(BeAnExistingFile())
+	})
+
+	It("Should not mix up logins run at the same time", func() {
+		home, err := ioutil.TempDir("", "concurrent")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(home)
+
+		start := func(args ...string) *gexec.Session {
+			cmd := exec.Command(exe, args...)
+			cmd.Env = append(os.Environ(), "XDG_CONFIG_HOME="+home,
+				"TLS_SESS_USERNAME=demo", "TLS_SESS_PASSWORD=test123")
+			session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			return session
+		}
+
+		var logins []*gexec.Session
+		for _, name := range []string{"one", "two", "one", "two"} {
+			logins = append(logins, start("login", "-profile", name,
+				"-connect", auth, "-protected", addr))
+		}
+		for _, session := range logins {
+			Eventually(session, 5).Should(gexec.Exit(0))
+		}
+
+		for _, name := range []string{"one", "two"} {
+			session := start("status", "-profile", name).Wait(5)
+			Expect(session).Should(gexec.Exit(0),
+				"the key and certificate of %s do not match", name)
+		}
+		session := start("profile", "list").Wait(5)
+		Expect(session).Should(gexec.Exit(0))
+		Expect(session.Out).Should(gbytes.Say(`one`))
+		Expect(session.Out).Should(gbytes.Say(`two`))
+	})
 })
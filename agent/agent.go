@@ -0,0 +1,314 @@
+// Package agent holds the keys of a client's sessions in memory and signs with
+// them on behalf of the client's commands, over a Unix socket, in the style of
+// ssh-agent.  A key is decrypted once when it is added, and never leaves the
+// agent again: the commands get a Signer that sends what they need signed to
+// the agent.
+//
+// Keys are forgotten when their lifetime is up, and the agent can be locked
+// with a passphrase, during which it refuses to list or use them.  Like
+// ssh-agent, it makes wrong passphrases wait before they can be tried again.
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/throttle"
+)
+
+// UnlockLimits are how many wrong passphrases Unlock takes before refusing to
+// try any for a while, so that the passphrase cannot be guessed quickly.
+var UnlockLimits = throttle.Config{
+	MaxFailures: 3,
+	Lockout:     time.Second,
+	MaxLockout:  time.Minute,
+	Window:      15 * time.Minute,
+}
+
+// unlockKey is the key of the failures to unlock in the limiter, which are
+// counted together whichever client makes them.
+const unlockKey = "unlock"
+
+// Agent is used to implement pb.AgentServer.
+type Agent struct {
+	// Lifetime is how long keys are held when they are added without one, or
+	// 0 to hold them until they are removed.
+	Lifetime time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*entry
+
+	// lock is the salt and the hash of the passphrase the agent was locked
+	// with, or nil if it is unlocked.
+	lock []byte
+
+	// unlocks limits the wrong passphrases given to Unlock.
+	unlocks *throttle.Limiter
+}
+
+// entry is a key held by the agent, with the certificate and trust anchors of
+// its session.
+type entry struct {
+	key     *ecdsa.PrivateKey
+	cert    string
+	anchors string
+	expires time.Time
+	timer   *time.Timer
+}
+
+// New creates an agent that holds keys for the given lifetime by default, or
+// until they are removed if it is 0.
+func New(lifetime time.Duration) *Agent {
+	return &Agent{
+		Lifetime: lifetime,
+		keys:     make(map[string]*entry),
+		unlocks:  throttle.New(UnlockLimits),
+	}
+}
+
+// AddKey holds the key of a profile, replacing any held before.
+func (a *Agent) AddKey(
+	ctx context.Context, req *pb.AddKeyRequest,
+) (*empty.Empty, error) {
+	if req.Profile == "" {
+		return nil, status.Error(codes.InvalidArgument, "a profile is required")
+	}
+	key, err := pki.PEMtoKey(req.Key)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	err = matchKey(key, req.Cert)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := a.Lifetime
+	if req.Lifetime > 0 {
+		lifetime = time.Duration(req.Lifetime) * time.Second
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.lock != nil {
+		return nil, errLocked
+	}
+	a.remove(req.Profile)
+	e := &entry{key: key, cert: req.Cert, anchors: req.Anchors}
+	if lifetime > 0 {
+		e.expires = time.Now().Add(lifetime)
+		e.timer = time.AfterFunc(lifetime, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if a.keys[req.Profile] == e {
+				a.remove(req.Profile)
+			}
+		})
+	}
+	a.keys[req.Profile] = e
+	return &empty.Empty{}, nil
+}
+
+// RemoveKey forgets the key of a profile, or all of them if no profile is
+// given.
+func (a *Agent) RemoveKey(
+	ctx context.Context, req *pb.RemoveKeyRequest,
+) (*empty.Empty, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.lock != nil {
+		return nil, errLocked
+	}
+	if req.Profile == "" {
+		for profile := range a.keys {
+			a.remove(profile)
+		}
+		return &empty.Empty{}, nil
+	}
+	if _, ok := a.keys[req.Profile]; !ok {
+		return nil, notHeld(req.Profile)
+	}
+	a.remove(req.Profile)
+	return &empty.Empty{}, nil
+}
+
+// remove forgets the key of the profile.  The lock must be held.
+func (a *Agent) remove(profile string) {
+	e, ok := a.keys[profile]
+	if !ok {
+		return
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	delete(a.keys, profile)
+}
+
+// ListKeys lists the keys held by profile, with the certificates and trust
+// anchors of their sessions.  None are listed while the agent is locked.
+func (a *Agent) ListKeys(
+	ctx context.Context, req *empty.Empty,
+) (*pb.AgentKeys, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	resp := &pb.AgentKeys{}
+	if a.lock != nil {
+		return resp, nil
+	}
+	for profile, e := range a.keys {
+		k := &pb.AgentKey{Profile: profile, Cert: e.cert, Anchors: e.anchors}
+		if !e.expires.IsZero() {
+			k.Expires = e.expires.Unix()
+		}
+		resp.Keys = append(resp.Keys, k)
+	}
+	sort.Slice(resp.Keys, func(i, j int) bool {
+		return resp.Keys[i].Profile < resp.Keys[j].Profile
+	})
+	return resp, nil
+}
+
+// Sign signs a digest with the key of a profile.
+func (a *Agent) Sign(
+	ctx context.Context, req *pb.SignRequest,
+) (*pb.SignResponse, error) {
+	a.mu.Lock()
+	if a.lock != nil {
+		a.mu.Unlock()
+		return nil, errLocked
+	}
+	e, ok := a.keys[req.Profile]
+	a.mu.Unlock()
+	if !ok {
+		return nil, notHeld(req.Profile)
+	}
+
+	hash := crypto.Hash(req.Hash)
+	if !hash.Available() || len(req.Digest) != hash.Size() {
+		return nil, status.Error(codes.InvalidArgument,
+			"the digest does not match the hash")
+	}
+	sig, err := e.key.Sign(rand.Reader, req.Digest, hash)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.SignResponse{Signature: sig}, nil
+}
+
+// Renew replaces the certificate and trust anchors of the key of a profile,
+// which must match the new certificate.
+func (a *Agent) Renew(
+	ctx context.Context, req *pb.RenewRequest,
+) (*empty.Empty, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.lock != nil {
+		return nil, errLocked
+	}
+	e, ok := a.keys[req.Profile]
+	if !ok {
+		return nil, notHeld(req.Profile)
+	}
+	err := matchKey(e.key, req.Cert)
+	if err != nil {
+		return nil, err
+	}
+	e.cert, e.anchors = req.Cert, req.Anchors
+	return &empty.Empty{}, nil
+}
+
+// Lock locks the agent with a passphrase.
+func (a *Agent) Lock(
+	ctx context.Context, req *pb.LockRequest,
+) (*empty.Empty, error) {
+	if req.Passphrase == "" {
+		return nil, status.Error(codes.InvalidArgument,
+			"a passphrase is required")
+	}
+	salt := make([]byte, sha256.Size)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.lock != nil {
+		return nil, errLocked
+	}
+	a.lock = append(salt, hashPassphrase(salt, req.Passphrase)...)
+	return &empty.Empty{}, nil
+}
+
+// Unlock unlocks the agent given the passphrase it was locked with.
+func (a *Agent) Unlock(
+	ctx context.Context, req *pb.LockRequest,
+) (*empty.Empty, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.lock == nil {
+		return nil, status.Error(codes.FailedPrecondition,
+			"the agent is not locked")
+	}
+	if retryAfter, ok := a.unlocks.Allow(unlockKey); !ok {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"too many incorrect passphrases, try again in %s",
+			retryAfter.Round(time.Second))
+	}
+	salt, hash := a.lock[:sha256.Size], a.lock[sha256.Size:]
+	if subtle.ConstantTimeCompare(hash,
+		hashPassphrase(salt, req.Passphrase)) != 1 {
+		a.unlocks.Fail(unlockKey)
+		return nil, status.Error(codes.PermissionDenied,
+			"incorrect passphrase")
+	}
+	a.unlocks.Succeed(unlockKey)
+	a.lock = nil
+	return &empty.Empty{}, nil
+}
+
+func hashPassphrase(salt []byte, passphrase string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(passphrase))
+	return h.Sum(nil)
+}
+
+// errLocked is returned for requests refused while the agent is locked.
+var errLocked = status.Error(codes.FailedPrecondition, "the agent is locked")
+
+func notHeld(profile string) error {
+	return status.Errorf(codes.NotFound, "no key held for profile %q", profile)
+}
+
+// matchKey checks that the certificate in PEM format is that of the key.
+func matchKey(key *ecdsa.PrivateKey, certPEM string) error {
+	cert, err := pki.PEMtoCert(certPEM)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return status.Error(codes.InvalidArgument,
+			"the certificate does not match the key")
+	}
+	return nil
+}
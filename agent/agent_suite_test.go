@@ -0,0 +1,26 @@
+package agent_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAgent(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Agent Suite")
+}
+
+func tmpDir() string {
+	dir, err := ioutil.TempDir("", "temp")
+	Expect(err).ToNot(HaveOccurred())
+	return dir
+}
+
+func rmDir(path string) {
+	err := os.RemoveAll(path)
+	Expect(err).ToNot(HaveOccurred())
+}
@@ -0,0 +1,211 @@
+package agent_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/agent"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+var _ = Describe("Agent", func() {
+	var (
+		dir  string
+		srv  *grpc.Server
+		conn *grpc.ClientConn
+		cli  pb.AgentClient
+		ctx  context.Context
+
+		key     *ecdsa.PrivateKey
+		keyPEM  []byte
+		certPEM string
+	)
+
+	BeforeEach(func() {
+		dir = tmpDir()
+		ctx = context.Background()
+
+		path := filepath.Join(dir, "agent.sock")
+		lis, err := net.Listen("unix", path)
+		Expect(err).ToNot(HaveOccurred())
+		srv = grpc.NewServer()
+		pb.RegisterAgentServer(srv, agent.New(0))
+		go srv.Serve(lis)
+
+		conn, err = agent.Dial(path)
+		Expect(err).ToNot(HaveOccurred())
+		cli = pb.NewAgentClient(conn)
+
+		key, err = pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		keyPEM, err = pki.KeyToPEM(key)
+		Expect(err).ToNot(HaveOccurred())
+		certPEM, err = pki.SelfSign(key, "client")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		srv.Stop()
+		rmDir(dir)
+	})
+
+	add := func(profile string, lifetime int64) error {
+		_, err := cli.AddKey(ctx, &pb.AddKeyRequest{
+			Profile:  profile,
+			Key:      keyPEM,
+			Cert:     certPEM,
+			Anchors:  certPEM,
+			Lifetime: lifetime,
+		})
+		return err
+	}
+
+	It("Should sign TLS handshakes with the keys it holds", func() {
+		_, _, err := agent.Session(ctx, cli, "default")
+		Expect(err).Should(Equal(agent.ErrNotHeld))
+
+		Expect(add("default", 0)).To(Succeed())
+		cert, anchors, err := agent.Session(ctx, cli, "default")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(anchors)).Should(Equal(certPEM))
+		Expect(cert.PrivateKey).ShouldNot(Equal(key))
+
+		serverKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		serverPEM, err := pki.SelfSign(serverKey, "server")
+		Expect(err).ToNot(HaveOccurred())
+		serverCert, err := pki.PEMtoCert(serverPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		pool := x509.NewCertPool()
+		Expect(pool.AppendCertsFromPEM(anchors)).To(BeTrue())
+		c, s := net.Pipe()
+		defer c.Close()
+		defer s.Close()
+		server := tls.Server(s, &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{serverCert.Raw},
+				PrivateKey:  serverKey,
+			}},
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  pool,
+		})
+		done := make(chan error, 1)
+		go func() { done <- server.Handshake() }()
+
+		client := tls.Client(c, &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		})
+		Expect(client.Handshake()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(server.ConnectionState().PeerCertificates[0].Raw).
+			Should(Equal(cert.Leaf.Raw))
+	})
+
+	It("Should only take certificates matching the key", func() {
+		other, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		otherPEM, err := pki.SelfSign(other, "client")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = cli.AddKey(ctx, &pb.AddKeyRequest{
+			Profile: "default", Key: keyPEM, Cert: otherPEM,
+		})
+		Expect(status.Code(err)).Should(Equal(codes.InvalidArgument))
+
+		Expect(add("default", 0)).To(Succeed())
+		_, err = cli.Renew(ctx, &pb.RenewRequest{
+			Profile: "default", Cert: otherPEM,
+		})
+		Expect(status.Code(err)).Should(Equal(codes.InvalidArgument))
+
+		renewed, err := pki.SelfSign(key, "renewed")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cli.Renew(ctx, &pb.RenewRequest{
+			Profile: "default", Cert: renewed, Anchors: otherPEM,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		keys, err := cli.ListKeys(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys.Keys).Should(HaveLen(1))
+		Expect(keys.Keys[0].Cert).Should(Equal(renewed))
+		Expect(keys.Keys[0].Anchors).Should(Equal(otherPEM))
+	})
+
+	It("Should refuse to list or use keys while locked", func() {
+		Expect(add("default", 0)).To(Succeed())
+
+		_, err := cli.Lock(ctx, &pb.LockRequest{Passphrase: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+		keys, err := cli.ListKeys(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys.Keys).Should(BeEmpty())
+		_, err = cli.Sign(ctx, &pb.SignRequest{Profile: "default"})
+		Expect(status.Code(err)).Should(Equal(codes.FailedPrecondition))
+		Expect(add("other", 0)).ShouldNot(Succeed())
+
+		_, err = cli.Unlock(ctx, &pb.LockRequest{Passphrase: "wrong"})
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+		_, err = cli.Unlock(ctx, &pb.LockRequest{Passphrase: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, _, err = agent.Session(ctx, cli, "default")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Should make wrong passphrases wait", func() {
+		_, err := cli.Lock(ctx, &pb.LockRequest{Passphrase: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < agent.UnlockLimits.MaxFailures; i++ {
+			_, err = cli.Unlock(ctx, &pb.LockRequest{Passphrase: "wrong"})
+			Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+		}
+		_, err = cli.Unlock(ctx, &pb.LockRequest{Passphrase: "secret"})
+		Expect(status.Code(err)).Should(Equal(codes.ResourceExhausted))
+
+		By("Taking the passphrase once the lockout is over")
+		time.Sleep(agent.UnlockLimits.Lockout)
+		_, err = cli.Unlock(ctx, &pb.LockRequest{Passphrase: "secret"})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Should forget keys when their lifetime is up", func() {
+		Expect(add("short", 1)).To(Succeed())
+		Expect(add("long", 0)).To(Succeed())
+
+		keys, err := cli.ListKeys(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys.Keys).Should(HaveLen(2))
+		Expect(keys.Keys[1].Profile).Should(Equal("short"))
+		Expect(time.Unix(keys.Keys[1].Expires, 0)).
+			Should(BeTemporally("~", time.Now().Add(time.Second), time.Second))
+
+		Eventually(func() []*pb.AgentKey {
+			keys, err := cli.ListKeys(ctx, &empty.Empty{})
+			Expect(err).ToNot(HaveOccurred())
+			return keys.Keys
+		}, 3*time.Second).Should(HaveLen(1))
+
+		_, err = cli.RemoveKey(ctx, &pb.RemoveKeyRequest{})
+		Expect(err).ToNot(HaveOccurred())
+		keys, err = cli.ListKeys(ctx, &empty.Empty{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys.Keys).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+// signTimeout bounds each signature asked of the agent.
+const signTimeout = 10 * time.Second
+
+// ErrNotHeld is returned by Session when the agent holds no key for the
+// profile, or is locked.
+var ErrNotHeld = errors.New("the agent holds no key for the profile")
+
+// Dial connects to the agent listening on the Unix socket at path.
+func Dial(path string) (conn *grpc.ClientConn, err error) {
+	dial := func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	}
+	conn, err = grpc.Dial(path, grpc.WithInsecure(), grpc.WithDialer(dial))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot connect to agent socket")
+	}
+	return conn, nil
+}
+
+// Signer signs with the key of a profile held by an agent, such as for the
+// TLS handshakes of the profile's session.
+type Signer struct {
+	cli     pb.AgentClient
+	profile string
+	pub     crypto.PublicKey
+}
+
+// NewSigner returns a Signer for the key of the profile, of which pub is the
+// public key.
+func NewSigner(
+	cli pb.AgentClient, profile string, pub crypto.PublicKey,
+) *Signer {
+	return &Signer{cli: cli, profile: profile, pub: pub}
+}
+
+// Public returns the public key.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign asks the agent to sign the digest, which must have been made with the
+// hash of opts.  The agent uses its own source of randomness.
+func (s *Signer) Sign(
+	_ io.Reader, digest []byte, opts crypto.SignerOpts,
+) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+
+	resp, err := s.cli.Sign(ctx, &pb.SignRequest{
+		Profile: s.profile,
+		Digest:  digest,
+		Hash:    uint32(opts.HashFunc()),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "the agent failed to sign")
+	}
+	return resp.Signature, nil
+}
+
+// Session returns the certificate of the profile's session for TLS, with a
+// Signer for the key held by the agent, along with the trust anchors of the
+// session.
+func Session(
+	ctx context.Context, cli pb.AgentClient, profile string,
+) (cert tls.Certificate, anchors []byte, err error) {
+	resp, err := cli.ListKeys(ctx, &empty.Empty{})
+	if err != nil {
+		return cert, nil, errors.Wrap(err, "failed to list the agent's keys")
+	}
+
+	for _, k := range resp.Keys {
+		if k.Profile != profile {
+			continue
+		}
+		leaf, err := pki.PEMtoCert(k.Cert)
+		if err != nil {
+			return cert, nil, err
+		}
+		return tls.Certificate{
+			Certificate: [][]byte{leaf.Raw},
+			PrivateKey:  NewSigner(cli, profile, leaf.PublicKey),
+			Leaf:        leaf,
+		}, []byte(k.Anchors), nil
+	}
+	return cert, nil, ErrNotHeld
+}
@@ -0,0 +1,294 @@
+// Package audit keeps a tamper-evident, append-only log of authentication and
+// certificate issuance events.
+//
+// The log is a file of JSON lines.  Each event carries the hash of the event
+// before it and its own hash, which covers the exact bytes of the line, so
+// editing, inserting or removing an event breaks the chain.  The sequence number
+// and hash of the last event are also kept in a head file next to the log, so
+// that cutting events off the end of the log is detected as well.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+)
+
+// Type is the kind of an audit event.
+type Type string
+
+// Types of audit events.
+const (
+	LoginSucceeded Type = "login.succeeded"
+	LoginFailed    Type = "login.failed"
+	CertIssued     Type = "cert.issued"
+	CertRenewed    Type = "cert.renewed"
+	CertRevoked    Type = "cert.revoked"
+	SSHCertIssued  Type = "sshcert.issued"
+	PolicyDenied   Type = "policy.denied"
+	AccessGranted  Type = "access.granted"
+	AccessDenied   Type = "access.denied"
+)
+
+// Event is an entry in the audit log.  Seq, Time, Prev and Hash are set when
+// the event is recorded.
+type Event struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Prev string    `json:"prev"`
+	Type Type      `json:"type"`
+
+	User      string `json:"user,omitempty"`
+	Addr      string `json:"addr,omitempty"`
+	Method    string `json:"method,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// Serial, Subject and Fingerprint describe the certificate that was
+	// issued or presented.  For SSH certificates, the serial is in decimal as
+	// in the logs of SSH servers, and the subject is the key ID.
+	Serial      string `json:"serial,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Renews is the serial of the session a renewal replaces.
+	Renews string `json:"renews,omitempty"`
+
+	// Reason explains a failure or denial.
+	Reason string `json:"reason,omitempty"`
+
+	Hash string `json:"hash,omitempty"`
+}
+
+// head is what the head file holds: the position of the last event.
+type head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// HeadPath returns the path of the head file kept for the log at path.
+func HeadPath(path string) string {
+	return path + ".head"
+}
+
+// Log appends events to an audit log file.  It is safe for concurrent use.  A
+// nil Log discards events.
+type Log struct {
+	path string
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	last string
+}
+
+// Open opens the audit log at path for appending, creating it if it does not
+// exist.  An existing log is verified first so that events are never chained
+// onto a log that was tampered with.
+func Open(path string) (l *Log, err error) {
+	l = &Log{path: path}
+
+	if _, err = os.Stat(path); err == nil {
+		l.seq, l.last, err = verify(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating directory for audit log")
+	}
+	l.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening audit log")
+	}
+
+	return l, nil
+}
+
+// Record appends the event to the log.  The event is on disk when Record
+// returns.
+func (l *Log) Record(e Event) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.seq + 1
+	e.Time = time.Now().UTC()
+	e.Prev = l.last
+	line, hash, err := encode(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.f.Write(line)
+	if err != nil {
+		return errors.Wrap(err, "writing audit event")
+	}
+	err = l.f.Sync()
+	if err != nil {
+		return errors.Wrap(err, "syncing audit log")
+	}
+	l.seq, l.last = e.Seq, hash
+
+	return writeHead(l.path, head{Seq: l.seq, Hash: l.last})
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Close()
+}
+
+// Verify checks the chain of events in the audit log at path against its head
+// file.  It returns the number of events in the log, or an error describing
+// the first problem found.
+func Verify(path string) (events int64, err error) {
+	events, _, err = verify(path)
+	return events, err
+}
+
+func verify(path string) (seq int64, last string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "opening audit log")
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		var line []byte
+		line, err = r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		if err != nil {
+			return 0, "", errors.Errorf(
+				"event %d: incomplete line at end of log", seq+1)
+		}
+
+		var e Event
+		e, err = decode(line)
+		if err != nil {
+			return 0, "", errors.Wrapf(err, "event %d", seq+1)
+		}
+		if e.Seq != seq+1 {
+			return 0, "", errors.Errorf(
+				"event %d: out of sequence, found event %d", seq+1, e.Seq)
+		}
+		if e.Prev != last {
+			return 0, "", errors.Errorf(
+				"event %d: does not follow the previous event", e.Seq)
+		}
+		seq, last = e.Seq, e.Hash
+	}
+
+	h, err := readHead(path)
+	if err != nil {
+		return 0, "", err
+	}
+	if h.Seq != seq || h.Hash != last {
+		return 0, "", errors.Errorf(
+			"log ends at event %d but the head file expects event %d: "+
+				"the log was truncated or the head file replaced", seq, h.Seq)
+	}
+
+	return seq, last, nil
+}
+
+// hashSuffix ends every line, following the JSON fields that the hash covers.
+const hashSuffix = `,"hash":"%s"}` + "\n"
+
+// encode returns the line for the event and its hash.  The hash is taken over
+// the previous hash and the JSON of the event without its hash, which is then
+// appended as the last field.
+func encode(e Event) (line []byte, hash string, err error) {
+	e.Hash = ""
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "encoding audit event")
+	}
+	hash = sum(e.Prev, body)
+
+	line = append(body[:len(body)-1], fmt.Sprintf(hashSuffix, hash)...)
+	return line, hash, nil
+}
+
+// decode parses a line and checks that its hash matches its bytes.
+func decode(line []byte) (e Event, err error) {
+	err = json.Unmarshal(line, &e)
+	if err != nil {
+		return Event{}, errors.Wrap(err, "parsing event")
+	}
+
+	suffix := []byte(fmt.Sprintf(hashSuffix, e.Hash))
+	if e.Hash == "" || !bytes.HasSuffix(line, suffix) {
+		return Event{}, errors.New("hash is missing or misplaced")
+	}
+	body := append(line[:len(line)-len(suffix):len(line)-len(suffix)], '}')
+	if sum(e.Prev, body) != e.Hash {
+		return Event{}, errors.New("hash does not match, the event was edited")
+	}
+
+	return e, nil
+}
+
+func sum(prev string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(prev))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func readHead(path string) (h head, err error) {
+	byt, err := ioutil.ReadFile(HeadPath(path))
+	if os.IsNotExist(err) {
+		return head{}, nil
+	}
+	if err != nil {
+		return head{}, errors.Wrap(err, "reading audit head file")
+	}
+
+	err = json.Unmarshal(byt, &h)
+	if err != nil {
+		return head{}, errors.Wrap(err, "parsing audit head file")
+	}
+	return h, nil
+}
+
+// writeHead replaces the head file in one step and syncs it, so a crash leaves
+// either the old or the new head, and never one behind the synced log.
+func writeHead(path string, h head) error {
+	byt, err := json.Marshal(h)
+	if err != nil {
+		return errors.Wrap(err, "encoding audit head")
+	}
+
+	err = keystore.WriteFile(HeadPath(path), byt)
+	if err != nil {
+		return errors.Wrap(err, "writing audit head file")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package audit_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/audit"
+)
+
+var _ = Describe("Audit", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		dir = tmpDir()
+		path = filepath.Join(dir, "audit.log")
+	})
+
+	AfterEach(func() {
+		rmDir(dir)
+	})
+
+	// record writes the events to a new log at path.
+	record := func(events ...audit.Event) {
+		l, err := audit.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		for _, e := range events {
+			Expect(l.Record(e)).To(Succeed())
+		}
+		Expect(l.Close()).To(Succeed())
+	}
+
+	lines := func() [][]byte {
+		byt, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		return bytes.SplitAfter(byt, []byte("\n"))
+	}
+
+	rewrite := func(lines [][]byte) {
+		err := ioutil.WriteFile(path, bytes.Join(lines, nil), 0600)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	events := []audit.Event{
+		{Type: audit.LoginSucceeded, User: "demo", Addr: "127.0.0.1"},
+		{Type: audit.CertIssued, User: "demo", Serial: "1f"},
+		{Type: audit.LoginFailed, User: "mallory", Reason: "bad password"},
+	}
+
+	It("Should verify an untouched log", func() {
+		record(events...)
+
+		n, err := audit.Verify(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).Should(BeEquivalentTo(3))
+	})
+
+	It("Should continue the chain when reopened", func() {
+		record(events[:2]...)
+		record(events[2:]...)
+
+		n, err := audit.Verify(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).Should(BeEquivalentTo(3))
+		Expect(string(lines()[2])).Should(ContainSubstring(`"seq":3`))
+	})
+
+	It("Should keep the log private", func() {
+		record(events...)
+
+		info, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).Should(Equal(os.FileMode(0600)))
+	})
+
+	It("Should detect an edited event", func() {
+		record(events...)
+
+		ls := lines()
+		ls[1] = bytes.Replace(ls[1], []byte(`"demo"`), []byte(`"root"`), 1)
+		rewrite(ls)
+
+		_, err := audit.Verify(path)
+		Expect(err).To(MatchError(ContainSubstring("event 2")))
+
+		_, err = audit.Open(path)
+		Expect(err).To(HaveOccurred(), "should not append to a bad log")
+	})
+
+	It("Should detect a removed event", func() {
+		record(events...)
+
+		ls := lines()
+		rewrite(append(ls[:1], ls[2:]...))
+
+		_, err := audit.Verify(path)
+		Expect(err).To(MatchError(ContainSubstring("event 2")))
+	})
+
+	It("Should detect a truncated log", func() {
+		record(events...)
+
+		ls := lines()
+		rewrite(ls[:2])
+
+		_, err := audit.Verify(path)
+		Expect(err).To(MatchError(ContainSubstring("truncated")))
+	})
+
+	It("Should detect a missing head file", func() {
+		record(events...)
+
+		Expect(os.Remove(audit.HeadPath(path))).To(Succeed())
+
+		_, err := audit.Verify(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should discard events when nil", func() {
+		var l *audit.Log
+		Expect(l.Record(events[0])).To(Succeed())
+		Expect(l.Close()).To(Succeed())
+	})
+})
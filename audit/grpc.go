@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+// UnaryServerInterceptor returns an interceptor that records every call to a
+// server requiring client certificates, along with the certificate presented.
+// Calls that succeed are recorded as granted and others as denied.  Calls to
+// the standard gRPC services, such as health checks, are not recorded.
+func UnaryServerInterceptor(l *Log) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		resp, err = handler(ctx, req)
+		record(ctx, l, info.FullMethod, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor for streaming calls.
+func StreamServerInterceptor(l *Log) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		err := handler(srv, ss)
+		record(ss.Context(), l, info.FullMethod, err)
+		return err
+	}
+}
+
+func record(ctx context.Context, l *Log, method string, err error) {
+	if l == nil || strings.HasPrefix(method, "/grpc.") {
+		return
+	}
+
+	e := Event{
+		Type:      AccessGranted,
+		Method:    method,
+		RequestID: logging.RequestID(ctx),
+	}
+	if err != nil {
+		e.Type = AccessDenied
+		e.Reason = status.Convert(err).Message()
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			e.Addr = p.Addr.String()
+			if host, _, err := net.SplitHostPort(e.Addr); err == nil {
+				e.Addr = host
+			}
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok &&
+			len(info.State.PeerCertificates) > 0 {
+			cert := info.State.PeerCertificates[0]
+			e.User = cert.Subject.CommonName
+			e.Serial = cert.SerialNumber.Text(16)
+			e.Subject = cert.Subject.String()
+			e.Fingerprint = pki.Fingerprint(cert)
+		}
+	}
+
+	if err := l.Record(e); err != nil {
+		logging.FromContext(ctx).Error("Could not record audit event",
+			"type", e.Type, "error", err)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/agent"
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+// envAgentSocket gives the path of the agent's socket.
+const envAgentSocket = config.EnvPrefix + "AGENT_SOCK"
+
+// agentTimeout bounds the requests to the agent, other than signatures.
+const agentTimeout = 10 * time.Second
+
+// agentSocket returns the path of the agent's socket, from the environment or
+// else in the client's configuration directory.
+func agentSocket() (string, error) {
+	if path := os.Getenv(envAgentSocket); path != "" {
+		return path, nil
+	}
+	dir, err := profile.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "agent.sock"), nil
+}
+
+// serveAgent serves the agent on a Unix socket that only the user running it
+// can connect to, until ctx is done.
+func serveAgent(ctx context.Context, path string, a *agent.Agent) error {
+	log := logging.Default()
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing stale agent socket")
+	}
+	err = os.MkdirAll(filepath.Dir(path), keystore.DirMode)
+	if err != nil {
+		return errors.Wrap(err, "creating directory for agent socket")
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return errors.Wrap(err, "agent failed to listen")
+	}
+	err = os.Chmod(path, keystore.FileMode)
+	if err != nil {
+		lis.Close()
+		return errors.Wrap(err, "restricting agent socket")
+	}
+	log.Info("Agent listening", "addr", lis.Addr())
+
+	s := grpc.NewServer(serverOpts(log)...)
+	pb.RegisterAgentServer(s, a)
+
+	err = serveUntil(ctx, s, lis)
+	if err != nil {
+		return errors.Wrap(err, "agent")
+	}
+	return nil
+}
+
+var (
+	agentOnce sync.Once
+	agentCli  pb.AgentClient
+	agentErr  error
+)
+
+// agentClient returns a client of the agent, which is nil if no agent is
+// listening on its socket.  The connection is kept for the signatures asked
+// of the agent during the TLS handshakes of the command.
+func agentClient() (pb.AgentClient, error) {
+	agentOnce.Do(func() {
+		var path string
+		path, agentErr = agentSocket()
+		if agentErr != nil {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			return
+		}
+
+		var conn *grpc.ClientConn
+		conn, agentErr = agent.Dial(path)
+		if agentErr == nil {
+			agentCli = pb.NewAgentClient(conn)
+		}
+	})
+	return agentCli, agentErr
+}
+
+// dialAgent returns a client of the agent, failing if none is listening.
+func dialAgent() (pb.AgentClient, error) {
+	cli, err := agentClient()
+	if err == nil && cli == nil {
+		err = errors.New("no agent is running, start one with: agent serve")
+	}
+	return cli, err
+}
+
+// agentSession returns the certificate of the profile's session for TLS, with
+// its key held by the agent, along with the trust anchors, or
+// agent.ErrNotHeld if no agent is running or it does not hold the key.
+func agentSession(p *profile.Profile) (tls.Certificate, []byte, error) {
+	cli, err := agentClient()
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	if cli == nil {
+		return tls.Certificate{}, nil, agent.ErrNotHeld
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+
+	cert, anchors, err := agent.Session(ctx, cli, p.Name())
+	if status.Code(errors.Cause(err)) == codes.Unavailable {
+		logging.Default().Debug("Agent unavailable", "error", err)
+		return tls.Certificate{}, nil, agent.ErrNotHeld
+	}
+	return cert, anchors, err
+}
+
+// renewAgent gives the certificate of a renewed session to the agent, if it
+// holds the profile's key.
+func renewAgent(p *profile.Profile) error {
+	cli, err := agentClient()
+	if err != nil || cli == nil {
+		return err
+	}
+
+	cert, err := ioutil.ReadFile(p.CertPath())
+	if err != nil {
+		return errors.Wrap(err, "reading client cert")
+	}
+	anchors, err := ioutil.ReadFile(p.RootPath())
+	if err != nil {
+		return errors.Wrap(err, "reading anchor certs")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+
+	_, err = cli.Renew(ctx, &pb.RenewRequest{
+		Profile: p.Name(),
+		Cert:    string(cert),
+		Anchors: string(anchors),
+	})
+	if code := status.Code(err); code == codes.NotFound ||
+		code == codes.Unavailable {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to renew the agent's session")
+	}
+	return nil
+}
+
+// addToAgent loads the profile's key and session and gives them to the agent,
+// which holds them for the lifetime given, or its default if 0.
+func addToAgent(p *profile.Profile, lifetime time.Duration) error {
+	cli, err := dialAgent()
+	if err != nil {
+		return err
+	}
+
+	key, err := readClientKey(p)
+	if err == keystore.ErrNotFound {
+		return errors.New("failed to read key, login first")
+	}
+	if err != nil {
+		return err
+	}
+	cert, err := ioutil.ReadFile(p.CertPath())
+	if err != nil {
+		return errors.New("failed to read certificate, login first")
+	}
+	anchors, err := ioutil.ReadFile(p.RootPath())
+	if err != nil {
+		return errors.Wrap(err, "reading anchor certs")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+
+	_, err = cli.AddKey(ctx, &pb.AddKeyRequest{
+		Profile:  p.Name(),
+		Key:      key,
+		Cert:     string(cert),
+		Anchors:  string(anchors),
+		Lifetime: int64(lifetime / time.Second),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to add key to agent")
+	}
+	return nil
+}
+
+// listAgent writes the keys held by the agent, with the user and expiry of
+// their sessions and when the agent forgets them.
+func listAgent(out io.Writer) error {
+	cli, err := dialAgent()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+
+	resp, err := cli.ListKeys(ctx, &empty.Empty{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list the agent's keys")
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROFILE\tUSER\tSESSION EXPIRES\tKEY EXPIRES")
+	for _, k := range resp.Keys {
+		user, expires := "-", "-"
+		if cert, err := pki.PEMtoCert(k.Cert); err == nil {
+			expires = cert.NotAfter.Format(time.RFC3339)
+			if id, err := pki.CertIdentity(cert); err == nil {
+				user = id.User
+			}
+		}
+		forget := "never"
+		if k.Expires != 0 {
+			forget = time.Unix(k.Expires, 0).Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", k.Profile, user, expires, forget)
+	}
+	return tw.Flush()
+}
+
+// removeFromAgent makes the agent forget the key of the named profile, or all
+// keys if the name is empty.
+func removeFromAgent(name string) error {
+	cli, err := dialAgent()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+
+	_, err = cli.RemoveKey(ctx, &pb.RemoveKeyRequest{Profile: name})
+	if err != nil {
+		return errors.Wrap(err, "failed to remove key from agent")
+	}
+	return nil
+}
+
+// lockAgent locks the agent with the passphrase, or unlocks it if lock is
+// false.
+func lockAgent(passphrase string, lock bool) error {
+	cli, err := dialAgent()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+
+	req := &pb.LockRequest{Passphrase: passphrase}
+	if lock {
+		_, err = cli.Lock(ctx, req)
+	} else {
+		_, err = cli.Unlock(ctx, req)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to lock or unlock the agent")
+	}
+	return nil
+}
+
+// agentPassphrase returns the passphrase to lock or unlock the agent with,
+// from the first line of standard input if fromStdin is set, or else by
+// prompting on the terminal.  A new passphrase is asked twice.
+func agentPassphrase(fromStdin, confirm bool) (string, error) {
+	if fromStdin {
+		return stdinSecret()
+	}
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errors.New("cannot prompt for the agent's passphrase " +
+			"without a terminal, give it with -passphrase-stdin")
+	}
+	return promptSecret("Agent Passphrase", confirm)
+}
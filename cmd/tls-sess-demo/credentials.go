@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+// Environment variables that give the credentials to login with.
+const (
+	envUsername = config.EnvPrefix + "USERNAME"
+	envPassword = config.EnvPrefix + "PASSWORD"
+	envOTP      = config.EnvPrefix + "OTP"
+)
+
+// helperTimeout bounds each run of a credential helper.
+const helperTimeout = 30 * time.Second
+
+// helperProtocol is given to credential helpers to tell the servers of this
+// program apart from others they keep credentials for.
+const helperProtocol = "tls-sess-demo"
+
+// loginFlags are the options of the login command that give credentials
+// without prompting for them.
+type loginFlags struct {
+	username      *string
+	passwordStdin *bool
+	helper        *string
+}
+
+func addLoginFlags(opts *flag.FlagSet) *loginFlags {
+	return &loginFlags{
+		username: opts.String("username", "",
+			"the user to login as, by default $"+envUsername),
+		passwordStdin: opts.Bool("password-stdin", false,
+			"read the password from the first line of standard input"),
+		helper: opts.String("credential-helper", "",
+			"an executable to get the username and password from, using the "+
+				"git credential helper protocol"),
+	}
+}
+
+// loginCredentials answers the challenges of a login, from what was given as
+// options, environment variables or by a credential helper, and else by
+// prompting on the terminal.
+type loginCredentials struct {
+	username string
+	password string
+	otp      string
+
+	// helper is the path of the credential helper, and host the address of
+	// the Auth server given to it.
+	helper string
+	host   string
+
+	in          *bufio.Reader
+	interactive bool
+
+	// answered is the type of the challenge answered last, which is the one
+	// a failed login was refused for.
+	answered pb.Challenge_Type
+}
+
+// credentials gathers the credentials to login to the profile's Auth server,
+// in order of precedence from the options, the environment and the helper.
+// Whatever is missing is prompted for if standard input is a terminal.
+func (f *loginFlags) credentials(
+	p *profile.Profile,
+) (*loginCredentials, error) {
+	c := &loginCredentials{
+		username:    *f.username,
+		helper:      *f.helper,
+		host:        p.Auth,
+		in:          bufio.NewReader(os.Stdin),
+		interactive: terminal.IsTerminal(int(os.Stdin.Fd())),
+	}
+	if c.username == "" {
+		c.username = os.Getenv(envUsername)
+	}
+	c.password = os.Getenv(envPassword)
+	c.otp = os.Getenv(envOTP)
+
+	if *f.passwordStdin {
+		line, err := c.in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "could not read password")
+		}
+		c.password = strings.TrimRight(line, "\r\n")
+		if c.password == "" {
+			return nil, errors.New("no password on standard input")
+		}
+		c.interactive = false
+	}
+
+	if c.helper != "" && c.password == "" {
+		got, err := c.runHelper("get")
+		if err != nil {
+			return nil, err
+		}
+		if c.username == "" {
+			c.username = got["username"]
+		}
+		if got["username"] == "" || got["username"] == c.username {
+			c.password = got["password"]
+		}
+	}
+
+	return c, nil
+}
+
+// user returns the username to login as.
+func (c *loginCredentials) user() (string, error) {
+	if c.username != "" {
+		return c.username, nil
+	}
+	user, err := c.readLine("Enter Username: ")
+	if err != nil {
+		return "", err
+	}
+	c.username = user
+	return user, nil
+}
+
+// pass returns the password of the user.
+func (c *loginCredentials) pass(prompt string) (string, error) {
+	if c.password != "" {
+		return c.password, nil
+	}
+	pass, err := c.readPassword(prompt)
+	if err != nil {
+		return "", err
+	}
+	c.password = pass
+	return pass, nil
+}
+
+// code returns the TOTP code of the user.
+func (c *loginCredentials) code(prompt string) (string, error) {
+	if c.otp != "" {
+		return c.otp, nil
+	}
+	return c.readLine(prompt)
+}
+
+// answering records the type of the challenge being answered, and the new
+// password if the challenge sets one, so that the helper keeps that one.
+func (c *loginCredentials) answering(t pb.Challenge_Type, answer string) {
+	c.answered = t
+	if t == pb.Challenge_NEW_PASSWORD {
+		c.password = answer
+	}
+}
+
+// approve tells the credential helper that the credentials were accepted, so
+// that it may keep them.
+func (c *loginCredentials) approve() error {
+	if c.helper == "" {
+		return nil
+	}
+	_, err := c.runHelper("store")
+	return err
+}
+
+// reject tells the credential helper to forget the credentials if the login
+// failed because the password was refused.  Refusals of later answers, such as
+// a mistyped TOTP code, leave the password kept.
+func (c *loginCredentials) reject(loginErr error) error {
+	if c.helper == "" || c.answered != pb.Challenge_PASSWORD {
+		return nil
+	}
+	switch status.Code(errors.Cause(loginErr)) {
+	case codes.InvalidArgument, codes.Unauthenticated:
+		_, err := c.runHelper("erase")
+		return err
+	}
+	return nil
+}
+
+// runHelper runs the credential helper for the action, giving it what is known
+// of the credentials, and returns the attributes it answered with.
+func (c *loginCredentials) runHelper(
+	action string,
+) (map[string]string, error) {
+	var in bytes.Buffer
+	fmt.Fprintf(&in, "protocol=%s\n", helperProtocol)
+	fmt.Fprintf(&in, "host=%s\n", c.host)
+	if c.username != "" {
+		fmt.Fprintf(&in, "username=%s\n", c.username)
+	}
+	if action != "get" && c.password != "" {
+		fmt.Fprintf(&in, "password=%s\n", c.password)
+	}
+	in.WriteString("\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), helperTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.helper, action)
+	cmd.Stdin = &in
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "credential helper %s failed", action)
+	}
+
+	attrs := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf(
+				"credential helper %s answered with invalid line %q",
+				action, line)
+		}
+		attrs[kv[0]] = kv[1]
+	}
+	return attrs, nil
+}
+
+// readLine prompts for and reads a line from standard input.
+func (c *loginCredentials) readLine(prompt string) (string, error) {
+	if !c.interactive {
+		return "", c.missing(prompt)
+	}
+
+	fmt.Print(prompt)
+	line, err := c.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", errors.Wrap(err, "could not read answer")
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// readPassword prompts for a password without echoing it.
+// origin: https://stackoverflow.com/a/32768479
+func (c *loginCredentials) readPassword(prompt string) (string, error) {
+	if !c.interactive {
+		return "", c.missing(prompt)
+	}
+
+	fmt.Print(prompt)
+	bytePassword, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", errors.Wrap(err, "could not read password")
+	}
+
+	return strings.TrimSpace(string(bytePassword)), nil
+}
+
+// missing returns the error for a prompt that cannot be answered because
+// standard input is not a terminal.
+func (c *loginCredentials) missing(prompt string) error {
+	return errors.Errorf("cannot prompt for %q without a terminal, give "+
+		"the credentials with -username, $%s, -password-stdin or "+
+		"-credential-helper", strings.TrimSpace(prompt), envPassword)
+}
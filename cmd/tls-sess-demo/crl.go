@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+// crlMode lets VPN servers read the CRL after they drop their privileges.
+const crlMode = 0644
+
+// writeCRL signs a CRL of the revoked serials with the CA of k, to be issued
+// again within validity, and writes it to path over the previous one, whose
+// number it increases.
+func writeCRL(
+	path string, k *keyring, serials []string, validity time.Duration,
+) error {
+	prev, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "reading CRL")
+	}
+
+	now := time.Now()
+	crl, err := pki.CreateCRL(k.ca, k.key, serials,
+		pki.NextCRLNumber(string(prev), now), now, now.Add(validity))
+	if err != nil {
+		return err
+	}
+	err = keystore.WriteFile(path, []byte(crl))
+	if err == nil {
+		err = os.Chmod(path, crlMode)
+	}
+	return errors.Wrap(err, "writing CRL")
+}
+
+// keepCRL writes the CRL at path until ctx is done: at first, then when a
+// certificate is revoked, when the CA is rotated and when half of its validity
+// has passed, so that VPN servers never see it expire.  Only the first write
+// is fatal.
+func keepCRL(
+	ctx context.Context, path string, keys *reloader, revoked *revoke.List,
+	validity time.Duration,
+) func() error {
+	return func() error {
+		log := logging.Default()
+
+		k, serials := keys.keyring(), revoked.Serials()
+		err := writeCRL(path, k, serials, validity)
+		if err != nil {
+			return err
+		}
+		written := time.Now()
+		log.Info("CRL written", "path", path, "revoked", len(serials))
+
+		ticker := time.NewTicker(revoke.RefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+
+			nextK, nextSerials := keys.keyring(), revoked.Serials()
+			if nextK.ca == k.ca &&
+				strings.Join(nextSerials, ",") == strings.Join(serials, ",") &&
+				time.Since(written) < validity/2 {
+				continue
+			}
+
+			err = writeCRL(path, nextK, nextSerials, validity)
+			if err != nil {
+				log.Error("Could not write the CRL", "path", path, "error", err)
+				continue
+			}
+			k, serials, written = nextK, nextSerials, time.Now()
+			log.Info("CRL written", "path", path, "revoked", len(serials))
+		}
+	}
+}
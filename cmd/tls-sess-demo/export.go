@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+// envBundlePassword gives the password of the PKCS#12 bundles exported and
+// imported.
+const envBundlePassword = config.EnvPrefix + "P12_PASSWORD"
+
+// exportP12 writes the key, certificate and trust anchors of the profile's
+// session to a PKCS#12 bundle at path, which browsers and the certificate
+// stores of operating systems can import.
+func exportP12(
+	p *profile.Profile, path, password string, enc pki.PKCS12Encryption,
+) error {
+	key, err := loadClientKey(p)
+	if err == keystore.ErrNotFound {
+		return errors.New("failed to read key, login first")
+	}
+	if err != nil {
+		return err
+	}
+	cert, err := pki.LoadCert(p.CertPath())
+	if err != nil {
+		return errors.Wrap(err, "failed to read certificate, login first")
+	}
+	raw, err := ioutil.ReadFile(p.RootPath())
+	if err != nil {
+		return errors.Wrap(err, "reading anchor certs")
+	}
+	anchors, err := pki.PEMtoCerts(string(raw))
+	if err != nil {
+		return errors.Wrap(err, "loading anchor certs")
+	}
+
+	pfx, err := pki.EncodePKCS12(key, cert, anchors, password, enc)
+	if err != nil {
+		return err
+	}
+	err = keystore.WriteFile(path, pfx)
+	if err != nil {
+		return errors.Wrap(err, "writing PKCS#12 bundle")
+	}
+	return nil
+}
+
+// importP12 saves the key, certificate and trust anchors of a PKCS#12 bundle
+// in the profile, as a login would.  The first certificate of the chain must
+// be the CA that signed the certificate, which must match the fingerprint
+// pinned for the profile, if any, and is pinned otherwise.
+func importP12(p *profile.Profile, path, password string) error {
+	pfx, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading PKCS#12 bundle")
+	}
+	key, cert, chain, err := pki.DecodePKCS12(pfx, password)
+	if err != nil {
+		return err
+	}
+
+	if len(chain) == 0 {
+		return errors.New("the PKCS#12 bundle has no CA certificate")
+	}
+	err = cert.CheckSignatureFrom(chain[0])
+	if err != nil {
+		return errors.Wrap(err, "the certificate was not signed by the CA")
+	}
+	err = p.Pin(chain[0])
+	if err != nil {
+		return err
+	}
+
+	var anchors []byte
+	for _, ca := range chain {
+		anchors = append(anchors, pki.CertToPEM(ca)...)
+	}
+
+	err = saveClientKey(p, key)
+	if err != nil {
+		return err
+	}
+	for _, f := range []struct{ pem, path, what string }{
+		{string(pki.CertToPEM(cert)), p.CertPath(), "client cert"},
+		{string(anchors), p.RootPath(), "anchor cert"},
+	} {
+		err = pki.SaveCert(f.pem, f.path)
+		if err != nil {
+			return errors.Wrapf(err, "error saving %s", f.what)
+		}
+	}
+	return nil
+}
+
+// bundlePassword returns the password of a PKCS#12 bundle, from the first
+// line of standard input if fromStdin is set, or else from the environment,
+// or else by prompting on the terminal.  A new password is asked twice.
+func bundlePassword(fromStdin, confirm bool) (string, error) {
+	if fromStdin {
+		return stdinSecret()
+	}
+	if pass := os.Getenv(envBundlePassword); pass != "" {
+		return pass, nil
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errors.Errorf("cannot prompt for the bundle's password "+
+			"without a terminal, give it with -password-stdin or $%s",
+			envBundlePassword)
+	}
+	return promptSecret("Bundle Password", confirm)
+}
+
+// stdinSecret reads a secret from the first line of standard input.
+func stdinSecret() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "could not read password")
+	}
+	pass := strings.TrimRight(line, "\r\n")
+	if pass == "" {
+		return "", errors.New("no password on standard input")
+	}
+	return pass, nil
+}
+
+// promptSecret prompts for a secret on the terminal, without echoing it.  A
+// new secret is asked twice.
+func promptSecret(what string, confirm bool) (string, error) {
+	prompts := []string{what + ": "}
+	if confirm {
+		prompts = append(prompts, "Confirm "+what+": ")
+	}
+	var answers []string
+	for _, prompt := range prompts {
+		fmt.Print(prompt)
+		pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return "", errors.Wrap(err, "could not read password")
+		}
+		answers = append(answers, string(pass))
+	}
+	if confirm && answers[0] != answers[1] {
+		return "", errors.New("the passwords do not match")
+	}
+	return answers[0], nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/gateway"
+	"github.com/KibaFox/tls-usr-sessions/httpauth"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+// minSecretLength is the shortest secret accepted to sign identity headers.
+const minSecretLength = 16
+
+// gatewayOpts are the options of the gateway command.
+type gatewayOpts struct {
+	listen   string
+	upstream string
+	certPath string
+	keyPath  string
+	caPath   string
+	revoked  string
+	secret   string
+	roles    []string
+}
+
+// runGateway serves the gateway until ctx is done, terminating TLS with a
+// certificate of its own, issued by server-cert, and its clients' session
+// certificates.  The gateway only needs the CA certificate to verify them,
+// never the CA's key.
+func runGateway(ctx context.Context, opts gatewayOpts) error {
+	upstream, err := url.Parse(opts.upstream)
+	if err != nil || upstream.Scheme == "" || upstream.Host == "" {
+		return errors.Errorf("invalid upstream URL %q", opts.upstream)
+	}
+
+	secret, err := ioutil.ReadFile(opts.secret)
+	if err != nil {
+		return errors.Wrap(err, "reading secret")
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < minSecretLength {
+		return errors.Errorf("the secret must be at least %d bytes",
+			minSecretLength)
+	}
+
+	var revoked *revoke.List
+	if opts.revoked != "" {
+		revoked, err = revoke.Open(opts.revoked)
+		if err != nil {
+			return err
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(opts.certPath, opts.keyPath)
+	if err != nil {
+		return errors.Wrap(err, "loading gateway certificate")
+	}
+	anchors, err := httpauth.LoadAnchors(opts.caPath)
+	if err != nil {
+		return err
+	}
+
+	lis, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return errors.Wrap(err, "gateway failed to listen")
+	}
+	logging.Default().Info("Gateway listening", "addr", lis.Addr(),
+		"upstream", upstream)
+
+	hs := &http.Server{
+		Handler: gateway.New(gateway.Config{
+			Upstream: upstream,
+			Secret:   secret,
+			Revoked:  revoked,
+			Roles:    opts.roles,
+		}),
+		TLSConfig: httpauth.TLSConfig(cert, anchors, revoked),
+	}
+	go func() {
+		<-ctx.Done()
+		shutCtx, cancel := context.WithTimeout(
+			context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = hs.Shutdown(shutCtx)
+	}()
+
+	err = hs.ServeTLS(lis, "", "")
+	if err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "gateway")
+	}
+	return nil
+}
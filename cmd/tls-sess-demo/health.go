@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/otp"
+)
+
+// healthInterval is how often the server checks whether it can serve.
+const healthInterval = 10 * time.Second
+
+// serverHealth returns why the server cannot serve logins, or nil if it can.
+func serverHealth(ca *x509.Certificate, totp *otp.Store) error {
+	now := time.Now()
+	if now.After(ca.NotAfter) {
+		return errors.New("the CA certificate expired")
+	}
+	if now.Before(ca.NotBefore) {
+		return errors.New("the CA certificate is not valid yet")
+	}
+	if totp != nil {
+		return totp.Check()
+	}
+	return nil
+}
+
+// watchHealth sets the status of the health servers, overall and for the
+// service given for each, from check now and every healthInterval until ctx is
+// done.  Then the servers report that they are not serving while they stop.
+func watchHealth(
+	ctx context.Context, check func() error,
+	servers map[*health.Server]string,
+) {
+	log := logging.Default()
+	status := healthpb.HealthCheckResponse_UNKNOWN
+
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	for {
+		next := healthpb.HealthCheckResponse_SERVING
+		if err := check(); err != nil {
+			next = healthpb.HealthCheckResponse_NOT_SERVING
+			if next != status {
+				log.Warn("Not serving", "reason", err)
+			}
+		} else if status == healthpb.HealthCheckResponse_NOT_SERVING {
+			log.Info("Serving again")
+		}
+		status = next
+
+		for hs, service := range servers {
+			hs.SetServingStatus("", status)
+			hs.SetServingStatus(service, status)
+		}
+
+		select {
+		case <-ctx.Done():
+			for hs := range servers {
+				hs.Shutdown()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth asks the server at addr for the health of the service, or the
+// whole server if it is empty, and writes the status to out.  If tlsCfg is
+// nil, the connection is not encrypted.
+func checkHealth(
+	addr, service string, tlsCfg *tls.Config, out io.Writer,
+) (serving bool, err error) {
+	opt := grpc.WithInsecure()
+	if tlsCfg != nil {
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg))
+	}
+
+	conn, err := grpc.Dial(addr, opt)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot connect")
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx,
+		&healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return false, errors.Wrap(err, "health check failed")
+	}
+
+	fmt.Fprintln(out, resp.Status)
+	return resp.Status == healthpb.HealthCheckResponse_SERVING, nil
+}
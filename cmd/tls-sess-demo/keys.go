@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+// Stores that the client's key can be kept in, as set for a profile.
+const (
+	keyStoreFile          = "file"
+	keyStoreEncrypted     = "encrypted"
+	keyStoreSecretService = "secret-service"
+)
+
+// envKeyPassphrase gives the passphrase of the keys in encrypted files.
+const envKeyPassphrase = config.EnvPrefix + "KEY_PASSPHRASE"
+
+// openKeyStore returns the store of the profile's key, which is kept in it
+// under the path of the key.  closeStore must be called when done with it.
+func openKeyStore(
+	p *profile.Profile,
+) (store keystore.Store, closeStore func() error, err error) {
+	noop := func() error { return nil }
+	switch p.KeyStore {
+	case "", keyStoreFile:
+		return keystore.Files{}, noop, nil
+	case keyStoreEncrypted:
+		return &keystore.Encrypted{Passphrase: keyPassphrase}, noop, nil
+	case keyStoreSecretService:
+		ss, err := keystore.OpenSecretService("", serverName)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ss, ss.Close, nil
+	}
+	return nil, nil, errors.Errorf("unknown key store %q, must be %s, %s "+
+		"or %s", p.KeyStore, keyStoreFile, keyStoreEncrypted,
+		keyStoreSecretService)
+}
+
+// keyOnDisk reports whether the profile's key is kept in a file at its path.
+func keyOnDisk(p *profile.Profile) bool {
+	return p.KeyStore != keyStoreSecretService
+}
+
+// readClientKey returns the profile's key in PEM format, or
+// keystore.ErrNotFound if there is none yet.
+func readClientKey(p *profile.Profile) ([]byte, error) {
+	store, closeStore, err := openKeyStore(p)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore()
+
+	return store.Get(p.KeyPath())
+}
+
+// loadClientKey returns the profile's key, or keystore.ErrNotFound if there is
+// none yet.
+func loadClientKey(p *profile.Profile) (*ecdsa.PrivateKey, error) {
+	keyPEM, err := readClientKey(p)
+	if err != nil {
+		return nil, err
+	}
+	return pki.PEMtoKey(keyPEM)
+}
+
+// saveClientKey keeps the key for the profile in its store.
+func saveClientKey(p *profile.Profile, key *ecdsa.PrivateKey) error {
+	keyPEM, err := pki.KeyToPEM(key)
+	if err != nil {
+		return err
+	}
+
+	store, closeStore, err := openKeyStore(p)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	err = store.Put(p.KeyPath(), keyPEM)
+	if err != nil {
+		return errors.Wrap(err, "saving key")
+	}
+	return nil
+}
+
+// deleteClientKey removes the profile's key from its store.
+func deleteClientKey(p *profile.Profile) error {
+	store, closeStore, err := openKeyStore(p)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	return store.Delete(p.KeyPath())
+}
+
+// keyPassphrase returns the passphrase of the keys in encrypted files, from
+// the environment, or else by prompting on the terminal.  A new passphrase is
+// asked twice.
+func keyPassphrase(confirm bool) ([]byte, error) {
+	if pass := os.Getenv(envKeyPassphrase); pass != "" {
+		return []byte(pass), nil
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.Errorf("cannot prompt for the key's passphrase "+
+			"without a terminal, give it with $%s", envKeyPassphrase)
+	}
+	pass, err := promptSecret("Key Passphrase", confirm)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(pass), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+)
+
+// dialAdmin connects to the admin service on the server's Unix socket.
+func dialAdmin(path string) (conn *grpc.ClientConn, err error) {
+	conn, err = grpc.Dial(path, grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot connect to admin socket")
+	}
+	return conn, nil
+}
+
+func listLockouts(adminPath string, out io.Writer) (err error) {
+	conn, err := dialAdmin(adminPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cli := pb.NewAdminClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := cli.ListLockouts(ctx, &empty.Empty{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list lockouts")
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tFAILURES\tUNTIL")
+	for _, l := range resp.Lockouts {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", l.Key, l.Failures,
+			time.Unix(l.Until, 0).Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func clearLockout(adminPath, key string) (cleared bool, err error) {
+	conn, err := dialAdmin(adminPath)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	cli := pb.NewAdminClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := cli.ClearLockout(ctx, &pb.ClearLockoutRequest{Key: key})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to clear lockout")
+	}
+
+	return resp.Cleared, nil
+}
@@ -1,101 +1,227 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"crypto/ecdsa"
+	"crypto"
 	"fmt"
-	"log"
+	"io"
 	"os"
-	"path/filepath"
-	"strings"
-	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/ssh/terminal"
 	"google.golang.org/grpc"
 
+	"github.com/KibaFox/tls-usr-sessions/agent"
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/logging"
 	"github.com/KibaFox/tls-usr-sessions/pb"
 	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+	"github.com/KibaFox/tls-usr-sessions/trace"
 )
 
-func login(addr, keyPath, certPath, anchorPath string) (err error) {
-	var key *ecdsa.PrivateKey
-	if _, err = os.Stat(keyPath); err != nil {
-		key, err = pki.GenerateKey()
-		if err != nil {
-			return err
-		}
-
-		err = os.MkdirAll(filepath.Dir(keyPath), 0777)
-		if err != nil {
-			return errors.Wrap(err, "creating directory for key")
-		}
-		err = pki.SaveKey(key, keyPath)
-		if err != nil {
-			return err
-		}
-	} else {
-		key, err = pki.LoadKey(keyPath)
-		if err != nil {
-			return err
-		}
+// loginTimeout bounds a whole login, including the time the user takes to
+// answer the challenges.
+const loginTimeout = 5 * time.Minute
+
+// login logs in to the Auth server of the profile and saves the certificate
+// issued and the trust anchors in the profile.  The CA that signed the
+// certificate must match the fingerprint pinned for the profile, if any, and
+// is pinned otherwise.  The challenges are answered with the credentials.
+func login(p *profile.Profile, creds *loginCredentials) (err error) {
+	ctx, span := trace.Start(context.Background(), "login", trace.Internal)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	key, err := loginKey(p)
+	if err != nil {
+		return err
 	}
-	csr, err := pki.NewCSR(key, "client")
+	usr, err := creds.user()
 	if err != nil {
 		return err
 	}
 
-	usr, pass := userCredentials()
-
 	// Set up a connection to the server.
-	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	conn, err := grpc.Dial(p.Auth,
+		append(clientOpts(), grpc.WithInsecure())...)
 	if err != nil {
 		return errors.Wrap(err, "cannot connect")
 	}
 	defer conn.Close()
 
 	c := pb.NewAuthClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, loginTimeout)
 	defer cancel()
 
-	resp, err := c.Login(ctx, &pb.LoginRequest{
-		Username: usr,
-		Password: pass,
-		Csr:      csr,
-	})
-
+	stream, err := c.Authenticate(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to login")
 	}
 
-	err = pki.SaveCert(resp.Cert, certPath)
+	err = stream.Send(&pb.AuthenticateRequest{
+		Step: &pb.AuthenticateRequest_Start{
+			Start: &pb.AuthenticateStart{Username: usr},
+		},
+	})
 	if err != nil {
-		return errors.Wrap(err, "error saving client cert")
+		return errors.Wrap(err, "failed to login")
 	}
 
-	err = pki.SaveCert(resp.Anchors, anchorPath)
+	var resp *pb.LoginResponse
+	for {
+		var step *pb.AuthenticateResponse
+		step, err = stream.Recv()
+		if err != nil {
+			return errors.Wrap(err, "failed to login")
+		}
+
+		if resp = step.GetResult(); resp != nil {
+			break
+		}
+
+		var answer string
+		answer, err = answerChallenge(step.GetChallenge(), key, creds)
+		if err != nil {
+			return err
+		}
+		creds.answering(step.GetChallenge().GetType(), answer)
+		err = stream.Send(&pb.AuthenticateRequest{
+			Step: &pb.AuthenticateRequest_Answer{
+				Answer: &pb.ChallengeAnswer{Value: answer},
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to login")
+		}
+	}
+	_ = stream.CloseSend()
+	_, err = stream.Recv()
+	if err == nil {
+		return errors.New("unexpected response from server")
+	}
+	if err != io.EOF {
+		return errors.Wrap(err, "failed to login")
+	}
+
+	err = pinCA(p, resp)
 	if err != nil {
-		return errors.Wrap(err, "error saving anchor cert")
+		return err
 	}
 
+	for _, f := range []struct{ pem, path, what string }{
+		{resp.Cert, p.CertPath(), "client cert"},
+		{resp.Anchors, p.RootPath(), "anchor cert"},
+	} {
+		err = pki.SaveCert(f.pem, f.path)
+		if err != nil {
+			return errors.Wrapf(err, "error saving %s", f.what)
+		}
+	}
+
+	// The certificates saved are used even if the agent could not take them.
+	if rerr := renewAgent(p); rerr != nil {
+		logging.Default().Warn("Could not renew the agent's session",
+			"error", rerr)
+	}
 	return nil
 }
 
-// origin: https://stackoverflow.com/a/32768479
-func userCredentials() (string, string) {
-	reader := bufio.NewReader(os.Stdin)
+// loginKey returns the key to login with: the one the agent holds for the
+// profile, if any, so that it renews the agent's session, or else the one in
+// the profile's key store, which is generated at the first login.  The key
+// store is also used when the agent fails, such as when it does not answer in
+// time, since logging in does not need the agent.
+func loginKey(p *profile.Profile) (crypto.Signer, error) {
+	cert, _, err := agentSession(p)
+	if err == nil {
+		return cert.PrivateKey.(crypto.Signer), nil
+	}
+	if err != agent.ErrNotHeld {
+		logging.Default().Warn("Could not use the agent's key, using the "+
+			"profile's", "error", err)
+	}
 
-	fmt.Print("Enter Username: ")
-	username, _ := reader.ReadString('\n')
+	key, err := loadClientKey(p)
+	if err == keystore.ErrNotFound {
+		key, err = pki.GenerateKey()
+		if err == nil {
+			err = saveClientKey(p, key)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
 
-	fmt.Print("Enter Password: ")
-	bytePassword, err := terminal.ReadPassword(syscall.Stdin)
+// pinCA checks that the certificate issued was signed by the first of the
+// anchors given with it, and that this CA is the one pinned for the profile.
+func pinCA(p *profile.Profile, resp *pb.LoginResponse) error {
+	cert, err := pki.PEMtoCert(resp.Cert)
+	if err != nil {
+		return errors.Wrap(err, "invalid certificate from server")
+	}
+	ca, err := pki.PEMtoCert(resp.Anchors)
 	if err != nil {
-		log.Fatalf("could not read password: %v", err)
+		return errors.Wrap(err, "invalid anchors from server")
 	}
-	password := string(bytePassword)
 
-	return strings.TrimSpace(username), strings.TrimSpace(password)
+	err = cert.CheckSignatureFrom(ca)
+	if err != nil {
+		return errors.Wrap(err, "the certificate was not signed by the CA")
+	}
+	return p.Pin(ca)
+}
+
+// answerChallenge answers a challenge from the server with the credentials.
+// CSR challenges are answered with a CSR for the key that carries the nonce.
+func answerChallenge(
+	ch *pb.Challenge, key crypto.Signer, creds *loginCredentials,
+) (answer string, err error) {
+	if ch == nil {
+		return "", errors.New("unexpected response from server")
+	}
+	if ch.Text != "" {
+		fmt.Println(ch.Text)
+	}
+
+	switch ch.Type {
+	case pb.Challenge_PASSWORD:
+		return creds.pass(ch.Prompt)
+	case pb.Challenge_OTP:
+		return creds.code(ch.Prompt)
+	case pb.Challenge_TERMS:
+		return creds.readLine(ch.Prompt)
+	case pb.Challenge_NEW_PASSWORD:
+		answer, err = creds.readPassword(ch.Prompt)
+		if err != nil {
+			return "", err
+		}
+		var confirm string
+		confirm, err = creds.readPassword("Confirm New Password: ")
+		if err != nil {
+			return "", err
+		}
+		if answer != confirm {
+			return "", errors.New("the new passwords do not match")
+		}
+		return answer, nil
+	case pb.Challenge_CSR:
+		return pki.NewChallengeCSR(key, deviceName(), ch.Nonce)
+	default:
+		return "", errors.Errorf("unsupported challenge: %s", ch.Type)
+	}
+}
+
+// deviceName returns the name of this device, which the server writes in the
+// certificate it issues.
+func deviceName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "client"
+	}
+	return name
 }
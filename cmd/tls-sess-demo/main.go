@@ -1,10 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/agent"
+	"github.com/KibaFox/tls-usr-sessions/audit"
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+	"github.com/KibaFox/tls-usr-sessions/trace"
+	"github.com/KibaFox/tls-usr-sessions/vpn"
 )
 
 const usage = `tls-sess-demo: A demo of using TLS for user sessions
@@ -13,9 +29,33 @@ USAGE: tls-sess-demo COMMAND [OPTIONS]
 
 Where COMMAND is one of:
 
-serv    to act as a server
-login   to login to a server
-motd    to get the message-of-the-day from the server
+serv        to act as a server
+login       to login to a server
+motd        to get the message-of-the-day from the server
+ssh-cert    to get an OpenSSH certificate for an SSH key, valid for the session
+gateway     to forward HTTPS requests with session certificates to an HTTP
+            service
+server-cert to issue a certificate signed by the CA for a gateway or a VPN
+            server (server-cert -name HOST)
+proxy       to forward local connections over TLS with the session certificate
+agent       to hold session keys in memory and sign with them for the other
+            commands (agent serve), and to add, list, remove, lock or unlock
+            its keys (agent add|list|remove|lock|unlock)
+status      to show who the profile is logged in as and when the session expires
+export      to export the session to a password protected PKCS#12 file
+            (export -format p12)
+import      to import a session from a PKCS#12 file into a profile (import FILE)
+vpn-config  to write the configuration of an OpenVPN or strongSwan client for
+            the session (vpn-config -type openvpn|strongswan -remote HOST), or
+            with -server the CRL and the snippet of a VPN server accepting them,
+            which presents a certificate issued by server-cert
+lockouts    to list (lockouts list) or clear (lockouts clear KEY) login lockouts
+revoke      to revoke a certificate before it expires (revoke SERIAL)
+totp        to enroll a user in TOTP as a second factor (totp enroll)
+audit       to check the audit log for tampering (audit verify)
+health      to check whether a server is serving
+profile     to list (profile list), switch (profile use NAME) or delete
+            (profile delete NAME) the profiles of the servers logged into
 `
 
 func main() { // nolint: gocyclo
@@ -27,66 +67,805 @@ func main() { // nolint: gocyclo
 	switch cmd {
 	case "serv":
 		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
-		authAddr := opts.String("auth", "127.0.0.1:4443",
-			"the address to listen on for login requests")
-		protectedAddr := opts.String("listen", "127.0.0.1:4444",
-			"the address to listen on for protected requests")
-		keyPath := opts.String("key", "certs/ca_key.pem",
-			"path to the CA key file in PEM format")
-		caPath := opts.String("ca", "certs/ca_cert.pem",
-			"path to the CA certificate file in PEM format")
+		cfgPath := opts.String("config", "",
+			"path to a YAML configuration file, by default $"+
+				config.EnvPrefix+"CONFIG")
+		check := opts.Bool("check-config", false,
+			"check the configuration and exit")
+		addServFlags(opts)
 		err := opts.Parse(os.Args[2:])
 		if err != nil {
-			log.Fatalf("could not parse options: %v", err)
+			fatalf("could not parse options: %v", err)
+		}
+
+		if *check {
+			if !checkConfig(configPath(*cfgPath), opts, os.Stdout) {
+				os.Exit(1)
+			}
+			return
+		}
+
+		cfg, err := servConfig(configPath(*cfgPath), opts)
+		if err != nil {
+			fatal(err)
+		}
+		err = setupLogging(cfg.Log.Level, cfg.Log.Format)
+		if err != nil {
+			fatal(err)
+		}
+		closeTrace, err := setupTracing(cfg.Trace.Output, serverName)
+		if err != nil {
+			fatal(err)
 		}
+		defer closeTrace()
+
+		ctx, stop := signalContext()
+		defer stop()
 
-		err = serve(*authAddr, *protectedAddr, *keyPath, *caPath)
+		err = serve(ctx, cfg)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 	case "login":
 		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
-		addr := opts.String("connect", "127.0.0.1:4443",
-			"the address to connect to the server")
-		keyPath := opts.String("key", "certs/cli_key.pem",
-			"path to the client key file in PEM format")
-		certPath := opts.String("cert", "certs/cli_cert.pem",
-			"path to the client certificate file in PEM format")
-		anchorPath := opts.String("root", "certs/root.pem",
-			"path to the root anchor certificate file in PEM format")
+		addr := opts.String("connect", "",
+			"the address of the Auth server, by default the profile's")
+		protectedAddr := opts.String("protected", "",
+			"the address of the Protected server to keep in the profile")
+		lf := addLoginFlags(opts)
+		pf := addProfileFlags(opts)
+		traceDest := opts.String("trace", "",
+			"write trace spans to stdout or to a file, empty to disable")
 		err := opts.Parse(os.Args[2:])
 		if err != nil {
-			log.Fatalf("could not parse options: %v", err)
+			fatalf("could not parse options: %v", err)
+		}
+
+		closeTrace, err := setupTracing(*traceDest, serverName+" "+cmd)
+		if err != nil {
+			fatal(err)
+		}
+		defer closeTrace()
+
+		unlock, err := lockProfiles()
+		if err != nil {
+			fatal(err)
+		}
+		defer unlock()
+
+		profiles, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+		if *addr != "" {
+			p.Auth = *addr
+		}
+		if *protectedAddr != "" {
+			p.Protected = *protectedAddr
 		}
 
-		err = login(*addr, *keyPath, *certPath, *anchorPath)
+		creds, err := lf.credentials(p)
+		if err != nil {
+			fatal(err)
+		}
+		err = login(p, creds)
+		if err != nil {
+			if herr := creds.reject(err); herr != nil {
+				logging.Default().Warn("Credential helper failed", "error", herr)
+			}
+			fatal(err)
+		}
+		err = creds.approve()
+		if err != nil {
+			logging.Default().Warn("Credential helper failed", "error", err)
+		}
+		profiles.Put(p)
+		err = profiles.Save()
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 
 	case "motd":
 		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
-		addr := opts.String("connect", "127.0.0.1:4444",
-			"the address to connect to the server")
-		keyPath := opts.String("key", "certs/cli_key.pem",
-			"path to the client key file in PEM format")
-		certPath := opts.String("cert", "certs/cli_cert.pem",
-			"path to the client certificate file in PEM format")
-		anchorPath := opts.String("root", "certs/root.pem",
-			"path to the root anchor certificate file in PEM format")
+		addr := opts.String("connect", "",
+			"the address of the Protected server, by default the profile's")
+		pf := addProfileFlags(opts)
+		traceDest := opts.String("trace", "",
+			"write trace spans to stdout or to a file, empty to disable")
 		err := opts.Parse(os.Args[2:])
 		if err != nil {
-			log.Fatalf("could not parse options: %v", err)
+			fatalf("could not parse options: %v", err)
+		}
+
+		closeTrace, err := setupTracing(*traceDest, serverName+" "+cmd)
+		if err != nil {
+			fatal(err)
+		}
+		defer closeTrace()
+
+		_, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+		if *addr != "" {
+			p.Protected = *addr
 		}
 
-		msg, err := motd(*addr, *keyPath, *certPath, *anchorPath)
+		msg, err := motd(p)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		fmt.Println(msg)
 
+	case "ssh-cert":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		key := opts.String("ssh-key", "",
+			"the SSH public key to certify, by default the first in ~/.ssh")
+		caOut := opts.String("ca-out", "",
+			"also write the public key of the CA, for TrustedUserCAKeys")
+		addr := opts.String("connect", "",
+			"the address of the Protected server, by default the profile's")
+		pf := addProfileFlags(opts)
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		_, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+		if *addr != "" {
+			p.Protected = *addr
+		}
+		if *key == "" {
+			*key, err = defaultSSHKey()
+			if err != nil {
+				fatal(err)
+			}
+		}
+
+		certPath, resp, err := sshCert(p, *key)
+		if err != nil {
+			fatal(err)
+		}
+		if *caOut != "" {
+			err = ioutil.WriteFile(*caOut, []byte(resp.CaPublicKey),
+				sshCertMode)
+			if err != nil {
+				fatal(errors.Wrap(err, "writing SSH CA key"))
+			}
+		}
+		fmt.Println("SSH certificate:", certPath)
+		fmt.Println("Expires:",
+			time.Unix(resp.Expires, 0).Format(time.RFC3339))
+
+	case "proxy":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		listen := opts.String("listen", "127.0.0.1:9000",
+			"the local address to accept plain connections on")
+		upstream := opts.String("upstream", "",
+			"the address to forward connections to over TLS, by default the "+
+				"profile's Protected server")
+		pf := addProfileFlags(opts)
+		logLevel := opts.String("log-level", "info",
+			"the least severe log entries to write: debug, info, warn, error")
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		err = setupLogging(*logLevel, "text")
+		if err != nil {
+			fatal(err)
+		}
+		_, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+		if *upstream == "" {
+			*upstream = p.Protected
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		err = proxy(ctx, *listen, *upstream, p)
+		if err != nil {
+			fatal(err)
+		}
+
+	case "agent":
+		var sub string
+		if len(os.Args) > 2 {
+			sub = os.Args[2]
+		}
+		opts := flag.NewFlagSet(cmd+" "+sub, flag.ExitOnError)
+		socket := opts.String("socket", "",
+			"path to the agent's Unix socket, by default $"+envAgentSocket+
+				" or agent.sock in the configuration directory")
+		lifetime := opts.Duration("lifetime", 0,
+			"how long keys are held, by default the agent's, or else until "+
+				"they are removed")
+		all := opts.Bool("all", false, "remove all the keys")
+		passphraseStdin := opts.Bool("passphrase-stdin", false,
+			"read the passphrase from the first line of standard input")
+		logLevel := opts.String("log-level", "info",
+			"the least severe log entries to write: debug, info, warn, error")
+		pf := addProfileFlags(opts)
+		var err error
+		if sub != "" {
+			err = opts.Parse(os.Args[3:])
+		}
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+		if *socket != "" {
+			os.Setenv(envAgentSocket, *socket)
+		}
+
+		switch sub {
+		case "serve":
+			err = setupLogging(*logLevel, "text")
+			if err != nil {
+				fatal(err)
+			}
+			var path string
+			path, err = agentSocket()
+			if err != nil {
+				fatal(err)
+			}
+
+			ctx, stop := signalContext()
+			defer stop()
+
+			err = serveAgent(ctx, path, agent.New(*lifetime))
+		case "add":
+			var p *profile.Profile
+			_, p, err = pf.load()
+			if err == nil {
+				err = addToAgent(p, *lifetime)
+			}
+			if err == nil {
+				fmt.Println("Added key of profile:", p.Name())
+			}
+		case "list":
+			err = listAgent(os.Stdout)
+		case "remove":
+			var name string
+			if !*all {
+				var p *profile.Profile
+				_, p, err = pf.load()
+				if err == nil {
+					name = p.Name()
+				}
+			}
+			if err == nil {
+				err = removeFromAgent(name)
+			}
+		case "lock", "unlock":
+			var pass string
+			pass, err = agentPassphrase(*passphraseStdin, sub == "lock")
+			if err == nil {
+				err = lockAgent(pass, sub == "lock")
+			}
+		case "":
+			fatalf("usage: agent serve|add|list|remove|lock|unlock")
+		default:
+			fatalf("unknown agent command: %s", sub)
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+	case "gateway":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		listen := opts.String("listen", "127.0.0.1:8443",
+			"the address to accept HTTPS requests with session certificates on")
+		upstream := opts.String("upstream", "",
+			"the URL of the HTTP service to forward requests to")
+		certPath := opts.String("cert", "certs/server_cert.pem",
+			"path to the gateway's certificate file in PEM format, as "+
+				"issued by server-cert")
+		keyPath := opts.String("key", "certs/server_key.pem",
+			"path to the gateway's key file in PEM format")
+		caPath := opts.String("ca", "certs/ca_cert.pem",
+			"path to the CA certificate file in PEM format")
+		revoked := opts.String("revoked", "certs/revoked.txt",
+			"path to the list of revoked certificates, empty to disable "+
+				"revocation")
+		secret := opts.String("secret", "",
+			"path to the secret shared with the upstream to sign the identity "+
+				"headers")
+		roles := opts.String("roles", "",
+			"comma separated roles of which users need one, empty to allow all")
+		logLevel := opts.String("log-level", "info",
+			"the least severe log entries to write: debug, info, warn, error")
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+		if *upstream == "" || *secret == "" {
+			fatalf("usage: gateway -upstream URL -secret FILE")
+		}
+
+		err = setupLogging(*logLevel, "text")
+		if err != nil {
+			fatal(err)
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		gw := gatewayOpts{
+			listen:   *listen,
+			upstream: *upstream,
+			certPath: *certPath,
+			keyPath:  *keyPath,
+			caPath:   *caPath,
+			revoked:  *revoked,
+			secret:   *secret,
+		}
+		if *roles != "" {
+			gw.roles = strings.Split(*roles, ",")
+		}
+		err = runGateway(ctx, gw)
+		if err != nil {
+			fatal(err)
+		}
+
+	case "server-cert":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		name := opts.String("name", "",
+			"the host name or IP address clients reach the server at")
+		certPath := opts.String("cert", "certs/server_cert.pem",
+			"path to write the certificate to")
+		keyPath := opts.String("key", "certs/server_key.pem",
+			"path to write the key to")
+		validity := opts.Duration("validity", 90*24*time.Hour,
+			"how long the certificate is valid for")
+		cfgPath := opts.String("config", "",
+			"path to the server's YAML configuration file, by default $"+
+				config.EnvPrefix+"CONFIG")
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+		if *name == "" {
+			fatalf("usage: server-cert -name HOST")
+		}
+
+		// The options of the command must not override those of the server
+		// that share their name.
+		cfg, err := servConfig(configPath(*cfgPath),
+			flag.NewFlagSet(cmd, flag.ExitOnError))
+		if err != nil {
+			fatal(err)
+		}
+		err = serverCert(cfg, *name, *certPath, *keyPath, *validity)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println("Certificate:", *certPath)
+		fmt.Println("Key:", *keyPath)
+
+	case "status":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		asJSON := opts.Bool("json", false, "print the status as JSON")
+		pf := addProfileFlags(opts)
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		_, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+
+		st, err := sessionState(p, time.Now())
+		if err != nil {
+			fatal(err)
+		}
+		err = printStatus(st, *asJSON, os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		if !st.Valid() {
+			os.Exit(1)
+		}
+
+	case "export":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		format := opts.String("format", "p12",
+			"the format of the export, only p12 is supported")
+		out := opts.String("out", "",
+			"path to write the bundle to, by default PROFILE.p12")
+		encryption := opts.String("encryption", "modern",
+			"modern, or legacy for systems that cannot read modern bundles")
+		passwordStdin := opts.Bool("password-stdin", false,
+			"read the bundle's password from the first line of standard input")
+		pf := addProfileFlags(opts)
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+		if *format != "p12" {
+			fatalf("unsupported export format: %s", *format)
+		}
+		enc, err := pki.ParsePKCS12Encryption(*encryption)
+		if err != nil {
+			fatal(err)
+		}
+
+		_, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+		if *out == "" {
+			*out = p.Name() + ".p12"
+		}
+
+		password, err := bundlePassword(*passwordStdin, true)
+		if err != nil {
+			fatal(err)
+		}
+		err = exportP12(p, *out, password, enc)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println("Exported:", *out)
+
+	case "import":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		passwordStdin := opts.Bool("password-stdin", false,
+			"read the bundle's password from the first line of standard input")
+		pf := addProfileFlags(opts)
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+		if opts.NArg() != 1 {
+			fatalf("usage: import FILE")
+		}
+
+		unlock, err := lockProfiles()
+		if err != nil {
+			fatal(err)
+		}
+		defer unlock()
+
+		profiles, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+
+		password, err := bundlePassword(*passwordStdin, false)
+		if err != nil {
+			fatal(err)
+		}
+		err = importP12(p, opts.Arg(0), password)
+		if err != nil {
+			fatal(err)
+		}
+		profiles.Put(p)
+		err = profiles.Save()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println("Imported into profile:", p.Name())
+
+	case "profile":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		dir, err := profile.Dir()
+		if err != nil {
+			fatal(err)
+		}
+		profiles, err := profile.Load(dir)
+		if err != nil {
+			fatal(err)
+		}
+
+		switch opts.Arg(0) {
+		case "", "list":
+			err = listProfiles(profiles, os.Stdout)
+		case "use", "delete":
+			if opts.NArg() != 2 {
+				fatalf("usage: profile %s NAME", opts.Arg(0))
+			}
+
+			// The profiles are loaded again under the lock, so that the
+			// changes of a login running meanwhile are not dropped.
+			var unlock func() error
+			unlock, err = lockProfiles()
+			if err != nil {
+				fatal(err)
+			}
+			defer unlock()
+			profiles, err = profile.Load(dir)
+			if err != nil {
+				fatal(err)
+			}
+
+			if opts.Arg(0) == "use" {
+				err = profiles.Use(opts.Arg(1))
+			} else {
+				err = deleteProfile(profiles, opts.Arg(1))
+			}
+			if err == nil {
+				err = profiles.Save()
+			}
+		default:
+			fatalf("unknown profile command: %s", opts.Arg(0))
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+	case "vpn-config":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		vpnType := opts.String("type", "openvpn", "openvpn or strongswan")
+		remote := opts.String("remote", "",
+			"the address of the VPN server, as host or host:port")
+		out := opts.String("out", "",
+			"path to write the configuration to, by default standard output")
+		server := opts.Bool("server", false,
+			"write the CRL and the snippet of a VPN server instead")
+		cfgPath := opts.String("config", "",
+			"path to the server's YAML configuration file, by default $"+
+				config.EnvPrefix+"CONFIG")
+		serverNameOpt := opts.String("server-name", "",
+			"the name the VPN server's certificate is issued for, by default "+
+				"the host of -remote")
+		serverCertPath := opts.String("server-cert", "certs/server_cert.pem",
+			"path to the VPN server's certificate, issued by server-cert")
+		serverKeyPath := opts.String("server-key", "certs/server_key.pem",
+			"path to the VPN server's key, issued by server-cert")
+		crlPath := opts.String("crl", "",
+			"path to write the CRL of the revoked certificates to, by default "+
+				"the server's storage.crl or certs/crl.pem")
+		crlValidity := opts.Duration("crl-validity", 0,
+			"how long the CRL is valid for before it must be written again, "+
+				"by default the server's ttl.crl")
+		pf := addProfileFlags(opts)
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+		t, err := vpn.ParseType(*vpnType)
+		if err != nil {
+			fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if *server {
+			// The options of the profile must not override those of the
+			// server that share their name.
+			var cfg *config.Config
+			cfg, err = servConfig(configPath(*cfgPath),
+				flag.NewFlagSet(cmd, flag.ExitOnError))
+			if err != nil {
+				fatal(err)
+			}
+			if *crlPath == "" {
+				*crlPath = cfg.Storage.CRL
+			}
+			if *crlPath == "" {
+				*crlPath = "certs/crl.pem"
+			}
+			if *crlValidity == 0 {
+				*crlValidity = cfg.TTL.CRL
+			}
+			err = vpnServerConfig(cfg, t, *serverCertPath, *serverKeyPath,
+				*crlPath, *crlValidity, &buf)
+		} else {
+			var p *profile.Profile
+			_, p, err = pf.load()
+			if err != nil {
+				fatal(err)
+			}
+			err = vpnClientConfig(p, t, *remote, *serverNameOpt, &buf)
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+		if *out == "" {
+			_, err = buf.WriteTo(os.Stdout)
+		} else {
+			err = keystore.WriteFile(*out, buf.Bytes())
+		}
+		if err != nil {
+			fatal(errors.Wrap(err, "writing VPN configuration"))
+		}
+
+	case "lockouts":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		adminPath := opts.String("admin", "certs/admin.sock",
+			"path to the server's Unix socket for admin requests")
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		switch opts.Arg(0) {
+		case "", "list":
+			err = listLockouts(*adminPath, os.Stdout)
+		case "clear":
+			if opts.NArg() != 2 {
+				fatalf("usage: lockouts clear KEY")
+			}
+			var cleared bool
+			cleared, err = clearLockout(*adminPath, opts.Arg(1))
+			if err == nil && !cleared {
+				fmt.Println("No failed logins recorded for:", opts.Arg(1))
+			}
+		default:
+			fatalf("unknown lockouts command: %s", opts.Arg(0))
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+	case "revoke":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		adminPath := opts.String("admin", "certs/admin.sock",
+			"path to the server's Unix socket for admin requests")
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+		if opts.NArg() != 1 {
+			fatalf("usage: revoke SERIAL")
+		}
+
+		revoked, err := revokeCert(*adminPath, opts.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		if revoked {
+			fmt.Println("Revoked:", opts.Arg(0))
+		} else {
+			fmt.Println("Already revoked:", opts.Arg(0))
+		}
+
+	case "totp":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		adminPath := opts.String("admin", "certs/admin.sock",
+			"path to the server's Unix socket for admin requests")
+		user := opts.String("user", "demo", "the user to enroll")
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		if opts.Arg(0) != "enroll" {
+			fatalf("unknown totp command: %s", opts.Arg(0))
+		}
+		err = enrollTOTP(*adminPath, *user, os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+
+	case "audit":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		logPath := opts.String("log", "certs/audit.log",
+			"path to the audit log to verify")
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		if opts.Arg(0) != "verify" {
+			fatalf("unknown audit command: %s", opts.Arg(0))
+		}
+		events, err := audit.Verify(*logPath)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Verified %d events in %s\n", events, *logPath)
+
+	case "health":
+		opts := flag.NewFlagSet(cmd, flag.ExitOnError)
+		addr := opts.String("connect", "",
+			"the address of the server, by default the profile's Auth server "+
+				"or with -protected its Protected server")
+		protected := opts.Bool("protected", false,
+			"check the Protected server, connecting with the client certificate")
+		service := opts.String("service", "",
+			"the service to check, empty for the whole server")
+		pf := addProfileFlags(opts)
+		err := opts.Parse(os.Args[2:])
+		if err != nil {
+			fatalf("could not parse options: %v", err)
+		}
+
+		_, p, err := pf.load()
+		if err != nil {
+			fatal(err)
+		}
+
+		var tlsCfg *tls.Config
+		switch {
+		case *addr != "":
+		case *protected:
+			*addr = p.Protected
+		default:
+			*addr = p.Auth
+		}
+		if *protected {
+			tlsCfg, err = setupClientTLS(p)
+			if err != nil {
+				fatal(err)
+			}
+		}
+
+		serving, err := checkHealth(*addr, *service, tlsCfg, os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		if !serving {
+			os.Exit(1)
+		}
+
 	default:
-		fmt.Println(usage)
+		fmt.Print(usage)
 		os.Exit(0)
 	}
 }
+
+// setupLogging replaces the default logger with one writing entries at the
+// named level and format to standard error.
+func setupLogging(level, format string) error {
+	lvl, err := logging.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	fmtr, err := logging.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+
+	logging.SetDefault(logging.New(os.Stderr, lvl, fmtr))
+	return nil
+}
+
+func fatal(err error) {
+	if errs, ok := err.(config.Errors); ok {
+		for _, err := range errs {
+			logging.Default().Error(err.Error())
+		}
+	} else {
+		logging.Default().Error(err.Error())
+	}
+	os.Exit(1)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fatal(fmt.Errorf(format, args...))
+}
+
+// setupTracing makes spans be written to dest, either "stdout" or the path of
+// a file to append to, and returns a function that closes the file.  Tracing
+// stays off if dest is empty.
+func setupTracing(dest, service string) (closeTrace func(), err error) {
+	switch dest {
+	case "":
+		return func() {}, nil
+	case "stdout":
+		trace.SetDefault(trace.New(os.Stdout, service))
+		return func() {}, nil
+	}
+
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening trace file")
+	}
+	trace.SetDefault(trace.New(f, service))
+	return func() { f.Close() }, nil
+}
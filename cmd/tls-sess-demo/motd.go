@@ -12,47 +12,73 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"github.com/KibaFox/tls-usr-sessions/agent"
+	"github.com/KibaFox/tls-usr-sessions/keystore"
 	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+	"github.com/KibaFox/tls-usr-sessions/trace"
 )
 
-func motd(addr, keyPath, certPath, anchorPath string) (msg string, err error) {
-	tlsCfg, err := setupClientTLS(anchorPath, keyPath, certPath)
-	if err != nil {
-		return "", err
-	}
+func motd(p *profile.Profile) (msg string, err error) {
+	ctx, span := trace.Start(context.Background(), "motd", trace.Internal)
+	defer span.End()
 
-	creds := credentials.NewTLS(tlsCfg)
-
-	// Set up a connection to the server.
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	conn, err := dialProtected(p)
 	if err != nil {
-		return "", errors.Wrap(err, "cannot connect")
+		return "", err
 	}
 	defer conn.Close()
 
 	cli := pb.NewProtectedClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	resp, err := cli.MOTD(ctx, &empty.Empty{})
 	if err != nil {
+		span.SetError(err)
 		return "", errors.Wrap(err, "failed to get MOTD")
 	}
 
 	return resp.Bulletin, nil
 }
 
-func setupClientTLS(
-	anchorPath, keyPath, certPath string,
-) (tlsCfg *tls.Config, err error) {
-	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+// dialProtected connects to the Protected server as the user of the profile.
+func dialProtected(p *profile.Profile) (*grpc.ClientConn, error) {
+	tlsCfg, err := setupClientTLS(p)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := credentials.NewTLS(tlsCfg)
+
+	// Set up a connection to the server.
+	conn, err := grpc.Dial(p.Protected,
+		append(clientOpts(), grpc.WithTransportCredentials(creds))...)
 	if err != nil {
-		return nil, errors.New("failed to load key pair")
+		return nil, errors.Wrap(err, "cannot connect")
 	}
+	return conn, nil
+}
+
+// clientOpts returns the options shared by the connections of the clients.
+func clientOpts() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(trace.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(trace.StreamClientInterceptor()),
+	}
+}
 
-	anchor, err := ioutil.ReadFile(anchorPath)
+// setupClientTLS returns the TLS configuration to connect to the Protected
+// server as the user of the profile, checking the server against the
+// fingerprint pinned for the profile.  The key and session are taken from the
+// agent if it holds them, or else from the profile's files.
+func setupClientTLS(p *profile.Profile) (tlsCfg *tls.Config, err error) {
+	certificate, anchor, err := agentSession(p)
+	if err == agent.ErrNotHeld {
+		certificate, anchor, err = storedSession(p)
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "error reading root anchor file")
+		return nil, err
 	}
 
 	certPool := x509.NewCertPool()
@@ -61,8 +87,35 @@ func setupClientTLS(
 	}
 
 	return &tls.Config{
-		ServerName:   serverName,
-		Certificates: []tls.Certificate{certificate},
-		RootCAs:      certPool,
+		ServerName:            serverName,
+		Certificates:          []tls.Certificate{certificate},
+		RootCAs:               certPool,
+		VerifyPeerCertificate: p.VerifyPeerCertificate,
 	}, nil
 }
+
+// storedSession returns the certificate of the profile's session for TLS,
+// with the key from the profile's key store, along with the trust anchors.
+func storedSession(
+	p *profile.Profile,
+) (certificate tls.Certificate, anchor []byte, err error) {
+	keyPEM, err := readClientKey(p)
+	if err != nil && err != keystore.ErrNotFound {
+		return certificate, nil, err
+	}
+	certPEM, cerr := ioutil.ReadFile(p.CertPath())
+	if err == nil && cerr == nil {
+		certificate, err = tls.X509KeyPair(certPEM, keyPEM)
+	}
+	if err != nil || cerr != nil {
+		return certificate, nil, errors.New(
+			"failed to load key pair, login first")
+	}
+
+	anchor, err = ioutil.ReadFile(p.RootPath())
+	if err != nil {
+		return certificate, nil, errors.Wrap(err,
+			"error reading root anchor file")
+	}
+	return certificate, anchor, nil
+}
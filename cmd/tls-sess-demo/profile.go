@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+// profileFlags are the options of the commands that connect to a server as
+// the user of a profile.
+type profileFlags struct {
+	name *string
+	key  *string
+	cert *string
+	root *string
+
+	keyStore *string
+}
+
+func addProfileFlags(opts *flag.FlagSet) *profileFlags {
+	return &profileFlags{
+		name: opts.String("profile", "",
+			"the profile to use, by default $TLS_SESS_PROFILE or the current "+
+				"profile"),
+		key: opts.String("key", "",
+			"path to the client key file in PEM format, by default the "+
+				"profile's"),
+		cert: opts.String("cert", "",
+			"path to the client certificate file in PEM format, by default "+
+				"the profile's"),
+		root: opts.String("root", "",
+			"path to the root anchor certificate file in PEM format, by "+
+				"default the profile's"),
+		keyStore: opts.String("keystore", "",
+			"where to keep the client key: file, encrypted for a file "+
+				"encrypted with a passphrase from $"+envKeyPassphrase+
+				" or a prompt, or secret-service for the keyring of the "+
+				"desktop, by default the profile's"),
+	}
+}
+
+// load loads the profiles and selects one, with the paths and key store given
+// as options in place of the profile's.
+func (f *profileFlags) load() (*profile.Config, *profile.Profile, error) {
+	dir, err := profile.Dir()
+	if err != nil {
+		return nil, nil, err
+	}
+	profiles, err := profile.Load(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := *f.name
+	if name == "" {
+		name = os.Getenv("TLS_SESS_PROFILE")
+	}
+	p, err := profiles.Select(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *f.key != "" {
+		p.Key = *f.key
+	}
+	if *f.cert != "" {
+		p.Cert = *f.cert
+	}
+	if *f.root != "" {
+		p.Root = *f.root
+	}
+	if *f.keyStore != "" {
+		p.KeyStore = *f.keyStore
+	}
+	return profiles, p, nil
+}
+
+// lockProfiles keeps other commands from changing the profiles until unlock is
+// called, so that logins run at the same time do not mix up their keys and
+// certificates nor drop each other's profiles.
+func lockProfiles() (unlock func() error, err error) {
+	dir, err := profile.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return keystore.Lock(dir, func() {
+		logging.Default().Info("Waiting for another login to finish")
+	})
+}
+
+// deleteProfile deletes the named profile, along with its key if it is not
+// kept in the profile's directory.
+func deleteProfile(profiles *profile.Config, name string) error {
+	if p, ok := profiles.Profiles[name]; ok && !keyOnDisk(p) {
+		err := deleteClientKey(p)
+		if err != nil {
+			return err
+		}
+	}
+	return profiles.Delete(name)
+}
+
+// listProfiles writes the profiles, marking the current one.
+func listProfiles(profiles *profile.Config, out io.Writer) error {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CURRENT\tNAME\tAUTH\tPROTECTED\tFINGERPRINT")
+	for _, name := range profiles.Names() {
+		p := profiles.Profiles[name]
+		current := ""
+		if name == profiles.Current {
+			current = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", current, name, p.Auth,
+			p.Protected, p.Fingerprint)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+// proxyDialTimeout bounds the connection and handshake with the upstream.
+const proxyDialTimeout = 10 * time.Second
+
+// sessionTLS gives the TLS configuration to connect as the user of a profile,
+// loading the key and certificates again whenever their files change, so that
+// a session renewed by logging in again is used from the next connection on.
+type sessionTLS struct {
+	p *profile.Profile
+
+	mu      sync.Mutex
+	cfg     *tls.Config
+	modTime []time.Time
+}
+
+func newSessionTLS(p *profile.Profile) *sessionTLS {
+	return &sessionTLS{p: p}
+}
+
+// config returns the current TLS configuration.
+func (s *sessionTLS) config() (*tls.Config, error) {
+	paths := []string{s.p.CertPath(), s.p.RootPath()}
+	if keyOnDisk(s.p) {
+		paths = append(paths, s.p.KeyPath())
+	}
+	modTime := make([]time.Time, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.New("failed to load key pair, login first")
+		}
+		modTime[i] = info.ModTime()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cfg != nil && sameTimes(modTime, s.modTime) {
+		return s.cfg, nil
+	}
+
+	cfg, err := setupClientTLS(s.p)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg != nil {
+		logging.Default().Info("Reloaded session certificate",
+			"profile", s.p.Name())
+	}
+	s.cfg, s.modTime = cfg, modTime
+	return cfg, nil
+}
+
+func sameTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// proxy accepts plain connections on the listen address and forwards each
+// over TLS to the upstream, presenting the session certificate of the profile,
+// until ctx is done.
+func proxy(
+	ctx context.Context, listen, upstream string, p *profile.Profile,
+) error {
+	log := logging.Default()
+	session := newSessionTLS(p)
+
+	lis, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	log.Info("Proxy listening", "addr", lis.Addr(), "upstream", upstream)
+
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, "failed to accept")
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			forward(ctx, conn, upstream, session)
+		}()
+	}
+}
+
+// forward connects to the upstream over TLS and copies between it and the
+// local connection until both sides are done.
+func forward(
+	ctx context.Context, local net.Conn, upstream string, session *sessionTLS,
+) {
+	log := logging.Default().With("client", local.RemoteAddr())
+	defer local.Close()
+
+	cfg, err := session.config()
+	if err != nil {
+		log.Warn("Cannot forward connection", "error", err)
+		return
+	}
+
+	dialer := &net.Dialer{Timeout: proxyDialTimeout}
+	remote, err := tls.DialWithDialer(dialer, "tcp", upstream, cfg)
+	if err != nil {
+		log.Warn("Cannot connect to upstream", "error", err)
+		return
+	}
+	defer remote.Close()
+	log.Debug("Forwarding connection", "upstream", upstream)
+
+	// Unblock the copies if the proxy is stopped.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			local.Close()
+			remote.Close()
+		case <-stop:
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(remote, local)
+		_ = remote.CloseWrite()
+	}()
+	_, _ = io.Copy(local, remote)
+	if tcp, ok := local.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+	<-done
+	log.Debug("Connection closed")
+}
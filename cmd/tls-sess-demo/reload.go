@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+// reloadDelay is how long the files must be left alone after a change before
+// they are reloaded, so that a rotation writing several files is seen whole.
+const reloadDelay = 250 * time.Millisecond
+
+// keyring is the CA with its key and the trust anchors, which are loaded
+// together so that they always match.
+type keyring struct {
+	// anchors are the certificates clients are told to trust.  The first is
+	// the CA and the others are kept during a rotation so that certificates
+	// signed by a previous CA are still accepted.
+	anchors string
+	ca      *x509.Certificate
+	key     *ecdsa.PrivateKey
+
+	// tls is the configuration for handshakes with the Protected server,
+	// which presents the CA certificate and accepts clients signed by any of
+	// the anchors.
+	tls *tls.Config
+}
+
+// loadKeyring loads the key and the trust anchors from their files.  The
+// first certificate in the anchor file must be the CA for the key.
+func loadKeyring(
+	keyPath, caPath string, settings config.TLS,
+) (*keyring, error) {
+	key, err := pki.LoadKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading CA file")
+	}
+	certs, err := pki.PEMtoCerts(string(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "loading CA")
+	}
+
+	ca := certs[0]
+	pub, ok := ca.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return nil, errors.New("the CA certificate does not match the key")
+	}
+
+	var anchors []byte
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		anchors = append(anchors, pki.CertToPEM(cert)...)
+		pool.AddCert(cert)
+	}
+
+	return &keyring{
+		anchors: string(anchors),
+		ca:      ca,
+		key:     key,
+		tls: &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			Certificates: []tls.Certificate{{
+				Certificate: [][]byte{ca.Raw},
+				PrivateKey:  key,
+				Leaf:        ca,
+			}},
+			ClientCAs:    pool,
+			NextProtos:   []string{"h2"},
+			MinVersion:   settings.MinVersionID(),
+			CipherSuites: settings.CipherSuiteIDs(),
+		},
+	}, nil
+}
+
+// serverCert returns the certificate the Protected server presents.
+func (k *keyring) serverCert() *x509.Certificate {
+	return k.tls.Certificates[0].Leaf
+}
+
+// reloader holds the keyring last loaded and loads it again when its files
+// change or the process receives SIGHUP.
+type reloader struct {
+	keyPath  string
+	caPath   string
+	settings config.TLS
+	current  atomic.Value // *keyring
+
+	// onReload is called with each keyring loaded after the first.
+	onReload func(k *keyring)
+}
+
+func newReloader(
+	keyPath, caPath string, settings config.TLS, onReload func(k *keyring),
+) (*reloader, error) {
+	logging.Default().Info("Loading keys", "key", keyPath, "ca", caPath)
+	k, err := loadKeyring(keyPath, caPath, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reloader{
+		keyPath:  filepath.Clean(keyPath),
+		caPath:   filepath.Clean(caPath),
+		settings: settings,
+		onReload: onReload,
+	}
+	r.current.Store(k)
+	return r, nil
+}
+
+// keyring returns the keyring last loaded.
+func (r *reloader) keyring() *keyring {
+	return r.current.Load().(*keyring)
+}
+
+// tlsConfig returns a TLS configuration that uses the keyring current at
+// each handshake, so that connections already established are not affected
+// by a reload.  Client certificates are also checked by verify if it is not
+// nil, and the application protocols are those given, or else only h2.
+func (r *reloader) tlsConfig(
+	verify func([][]byte, [][]*x509.Certificate) error, protos ...string,
+) *tls.Config {
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := r.keyring().tls
+			if verify == nil && len(protos) == 0 {
+				return cfg, nil
+			}
+
+			cfg = cfg.Clone()
+			cfg.VerifyPeerCertificate = verify
+			if len(protos) > 0 {
+				cfg.NextProtos = protos
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// optionalTLSConfig is like tlsConfig for servers that clients may connect to
+// without a certificate, leaving it to the handlers to refuse them where one
+// is required.
+func (r *reloader) optionalTLSConfig(
+	verify func([][]byte, [][]*x509.Certificate) error, protos ...string,
+) *tls.Config {
+	cfg := r.tlsConfig(verify, protos...)
+	get := cfg.GetConfigForClient
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	cfg.GetConfigForClient = func(
+		hello *tls.ClientHelloInfo,
+	) (*tls.Config, error) {
+		c, err := get(hello)
+		if err != nil {
+			return nil, err
+		}
+		c = c.Clone()
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		return c, nil
+	}
+	return cfg
+}
+
+// reload loads the keyring again.  The keyring in use is kept if the files
+// cannot be loaded.
+func (r *reloader) reload() error {
+	k, err := loadKeyring(r.keyPath, r.caPath, r.settings)
+	if err != nil {
+		return err
+	}
+
+	r.current.Store(k)
+	if r.onReload != nil {
+		r.onReload(k)
+	}
+	logging.Default().Info("Reloaded keys", "ca", pki.Fingerprint(k.ca))
+	return nil
+}
+
+// watch reloads the keyring in the background when its files change or on
+// SIGHUP until ctx is done.  The directories of the files are watched, rather
+// than the files, so that files replaced by a rename are noticed.
+func (r *reloader) watch(ctx context.Context) {
+	log := logging.Default()
+
+	// SIGHUP is caught before returning, since it would otherwise kill the
+	// process.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := r.watcher()
+	if err != nil {
+		log.Warn("Not watching keys for changes, reload with SIGHUP",
+			"error", err)
+	} else {
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	go func() {
+		defer signal.Stop(hup)
+		if watcher != nil {
+			defer watcher.Close()
+		}
+
+		delay := time.NewTimer(reloadDelay)
+		delay.Stop()
+		defer delay.Stop()
+
+		reload := func() {
+			if err := r.reload(); err != nil {
+				log.Error("Could not reload keys", "error", err)
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload()
+			case ev := <-events:
+				name := filepath.Clean(ev.Name)
+				if name == r.keyPath || name == r.caPath {
+					delay.Reset(reloadDelay)
+				}
+			case err := <-errs:
+				log.Warn("Error watching keys", "error", err)
+			case <-delay.C:
+				reload()
+			}
+		}
+	}()
+}
+
+func (r *reloader) watcher() (*fsnotify.Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating watcher")
+	}
+
+	for _, dir := range []string{
+		filepath.Dir(r.keyPath), filepath.Dir(r.caPath),
+	} {
+		err = w.Add(dir)
+		if err != nil {
+			w.Close()
+			return nil, errors.Wrapf(err, "watching %s", dir)
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+)
+
+func revokeCert(adminPath, serial string) (revoked bool, err error) {
+	conn, err := dialAdmin(adminPath)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	cli := pb.NewAdminClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := cli.RevokeCert(ctx, &pb.RevokeCertRequest{Serial: serial})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to revoke certificate")
+	}
+
+	return resp.Revoked, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+)
+
+// servFlags are the options of the serv command that override settings of the
+// configuration, with the paths of the settings they override.
+var servFlags = []struct {
+	name  string
+	paths []string
+	usage string
+}{
+	{"auth", []string{"listen.auth"},
+		"the address to listen on for login requests"},
+	{"listen", []string{"listen.protected"},
+		"the address to listen on for protected requests"},
+	{"rest", []string{"listen.rest"},
+		"the address to serve JSON over HTTPS on, empty to disable"},
+	{"key", []string{"ca.key"},
+		"path to the CA key file in PEM format"},
+	{"ca", []string{"ca.cert"},
+		"path to the CA certificate file in PEM format"},
+	{"admin", []string{"listen.admin"},
+		"path to the Unix socket for admin requests, empty to disable"},
+	{"totp", []string{"storage.totp"},
+		"path to the store of TOTP enrollments, empty to disable TOTP"},
+	{"audit", []string{"storage.audit"},
+		"path to the audit log, empty to disable auditing"},
+	{"revoked", []string{"storage.revoked"},
+		"path to the list of revoked certificates, empty to disable revocation"},
+	{"crl", []string{"storage.crl"},
+		"path to keep a CRL of the revoked certificates at, empty to disable"},
+	{"metrics", []string{"metrics.listen"},
+		"the address to serve metrics on at /metrics, empty to disable"},
+	{"terms", []string{"auth.terms"},
+		"path to terms of use that users must accept at login"},
+	{"change-password", []string{"auth.change_password"},
+		"require users to change their password at their first login"},
+	{"cert-ttl", []string{"ttl.certificate"},
+		"how long the certificates issued to users are valid"},
+	{"crl-ttl", []string{"ttl.crl"},
+		"how long each CRL written to the -crl path is valid"},
+	{"max-failures", []string{"limits.user.max_failures"},
+		"failed logins per username before it is locked out"},
+	{"max-ip-failures", []string{"limits.ip.max_failures"},
+		"failed logins per source address before it is locked out"},
+	{"lockout", []string{"limits.user.lockout", "limits.ip.lockout"},
+		"how long the first lockout lasts, doubling with each failure"},
+	{"max-lockout", []string{"limits.user.max_lockout", "limits.ip.max_lockout"},
+		"the longest a lockout can last"},
+	{"trace", []string{"trace.output"},
+		"write trace spans to stdout or to a file, empty to disable"},
+	{"reflection", []string{"listen.reflection"},
+		"register the gRPC reflection service on both listeners"},
+	{"log-level", []string{"log.level"},
+		"the least severe log entries to write: debug, info, warn, error"},
+	{"log-format", []string{"log.format"},
+		"the format of log entries: text or json"},
+}
+
+// addServFlags adds the options that override settings to opts, with the
+// defaults of the settings.
+func addServFlags(opts *flag.FlagSet) {
+	defaults := config.Default()
+	for _, sf := range servFlags {
+		def, err := defaults.Value(sf.paths[0])
+		if err != nil {
+			panic(err)
+		}
+
+		switch def := def.(type) {
+		case string:
+			opts.String(sf.name, def, sf.usage)
+		case bool:
+			opts.Bool(sf.name, def, sf.usage)
+		case int:
+			opts.Int(sf.name, def, sf.usage)
+		case time.Duration:
+			opts.Duration(sf.name, def, sf.usage)
+		default:
+			panic("unsupported option type for " + sf.name)
+		}
+	}
+}
+
+// servConfig loads the configuration file at path, or only the defaults if
+// path is empty, then applies the environment variables and the options set
+// in opts, in that order of precedence.  The result is validated.
+func servConfig(path string, opts *flag.FlagSet) (*config.Config, error) {
+	c, err := config.FromEnvironment(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs config.Errors
+	paths := make(map[string][]string)
+	for _, sf := range servFlags {
+		paths[sf.name] = sf.paths
+	}
+	opts.Visit(func(f *flag.Flag) {
+		for _, path := range paths[f.Name] {
+			if err := c.Set(path, f.Value.String(), "-"+f.Name); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// checkConfig reports whether the configuration is valid, printing the
+// problems found if it is not.
+func checkConfig(path string, opts *flag.FlagSet, out io.Writer) bool {
+	_, err := servConfig(path, opts)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return false
+	}
+
+	if path == "" {
+		path = "the defaults"
+	}
+	fmt.Fprintf(out, "Configuration OK: %s\n", path)
+	return true
+}
+
+// configPath returns the path of the configuration file given by the option,
+// or by the environment if the option is empty.
+func configPath(option string) string {
+	if option != "" {
+		return option
+	}
+	return os.Getenv(config.EnvPrefix + "CONFIG")
+}
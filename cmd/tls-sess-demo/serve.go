@@ -1,64 +1,274 @@
 package main
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/tls"
-	"crypto/x509"
-	"log"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 
+	"github.com/KibaFox/tls-usr-sessions/audit"
+	"github.com/KibaFox/tls-usr-sessions/config"
 	srv "github.com/KibaFox/tls-usr-sessions/grpc"
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/metrics"
+	"github.com/KibaFox/tls-usr-sessions/otp"
 	"github.com/KibaFox/tls-usr-sessions/pb"
 	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/rest"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+	"github.com/KibaFox/tls-usr-sessions/throttle"
+	"github.com/KibaFox/tls-usr-sessions/trace"
 )
 
 const serverName = "tls-sess-demo"
 
-func serve(authAddr, protectedAddr, keyPath, caPath string) error {
-	anchor, ca, key, err := setupCA(keyPath, caPath)
+// shutdownTimeout bounds how long in-flight calls may take to finish once the
+// server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+// serve runs the servers until ctx is done or one of them fails, then stops
+// them all gracefully.
+func serve(ctx context.Context, cfg *config.Config) error {
+	err := setupCA(cfg.CA.Key, cfg.CA.Cert)
 	if err != nil {
 		return err
 	}
 
-	tlsCfg, err := setupServerTLS(anchor, keyPath, caPath)
+	// The Auth server and metrics are given the keys after a reload, while
+	// the Protected server and health checks take them from the reloader.
+	var auth *srv.Auth
+	var m *metrics.Server
+	reloaded := func(k *keyring) {
+		authCfg := *auth.Config()
+		authCfg.AnchorsPEM, authCfg.CA, authCfg.Key = k.anchors, k.ca, k.key
+		auth.SetConfig(&authCfg)
+		m.CertExpiry("ca", k.ca)
+		m.CertExpiry("server", k.serverCert())
+	}
+	keys, err := newReloader(cfg.CA.Key, cfg.CA.Cert, cfg.TLS, reloaded)
 	if err != nil {
 		return err
 	}
+	k := keys.keyring()
+
+	var totp *otp.Store
+	if cfg.Storage.TOTP != "" {
+		totp, err = otp.OpenStore(cfg.Storage.TOTP)
+		if err != nil {
+			return err
+		}
+	}
+
+	var terms []byte
+	if cfg.Auth.Terms != "" {
+		terms, err = ioutil.ReadFile(cfg.Auth.Terms)
+		if err != nil {
+			return errors.Wrap(err, "reading terms of use")
+		}
+	}
+
+	var auditLog *audit.Log
+	if cfg.Storage.Audit != "" {
+		auditLog, err = audit.Open(cfg.Storage.Audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	var revoked *revoke.List
+	if cfg.Storage.Revoked != "" {
+		revoked, err = revoke.Open(cfg.Storage.Revoked)
+		if err != nil {
+			return err
+		}
+	}
 
-	authCfg := &srv.AuthConfig{
-		AnchorsPEM: anchor,
-		CA:         ca,
-		Key:        key,
-		UserTTL:    7 * 24 * time.Hour,
+	if cfg.Metrics.Listen != "" {
+		m = metrics.NewServer()
+		m.CertExpiry("ca", k.ca)
+		m.CertExpiry("server", k.serverCert())
 	}
 
-	var eg errgroup.Group
-	eg.Go(serveAuth(authAddr, authCfg))
-	eg.Go(serveProtected(protectedAddr, tlsCfg))
-	return eg.Wait()
+	auth = srv.NewAuth(&srv.AuthConfig{
+		AnchorsPEM:   k.anchors,
+		CA:           k.ca,
+		Key:          k.key,
+		UserTTL:      cfg.TTL.Certificate,
+		ChallengeTTL: cfg.TTL.Challenge,
+		UserLimiter:  throttle.New(cfg.Limits.User.Throttle()),
+		IPLimiter:    throttle.New(cfg.Limits.IP.Throttle()),
+		TOTP:         totp,
+
+		ChangePassword: cfg.Auth.ChangePassword,
+		Terms:          string(terms),
+		Audit:          auditLog,
+		Metrics:        m,
+	})
+
+	reflect := cfg.Listen.Reflection
+	authExtras := extras{health: health.NewServer(), reflection: reflect}
+	protExtras := extras{health: health.NewServer(), reflection: reflect}
+	eg, ctx := errgroup.WithContext(ctx)
+	keys.watch(ctx)
+	go watchHealth(ctx, func() error {
+		return serverHealth(keys.keyring().ca, totp)
+	}, map[*health.Server]string{
+		authExtras.health: "pb.Auth",
+		protExtras.health: "pb.Protected",
+	})
+
+	eg.Go(serveAuth(ctx, cfg.Listen.Auth, auth, m, authExtras))
+	protTLS := keys.tlsConfig(revoked.VerifyPeerCertificate)
+	protected := srv.NewProtected(func() *ecdsa.PrivateKey {
+		return keys.keyring().key
+	})
+	protected.Audit = auditLog
+	eg.Go(serveProtected(ctx, cfg.Listen.Protected, protTLS, protected,
+		revoked, auditLog, m, protExtras))
+	if cfg.Listen.Admin != "" {
+		authCfg := auth.Config()
+		admin := srv.NewAdmin(totp, authCfg.UserLimiter, authCfg.IPLimiter)
+		admin.Revoked, admin.Audit, admin.Metrics = revoked, auditLog, m
+		eg.Go(serveAdmin(ctx, cfg.Listen.Admin, admin))
+	}
+	if cfg.Listen.REST != "" {
+		log := logging.Default()
+		h := rest.New(rest.Config{
+			Auth:                 auth,
+			Protected:            protected,
+			AuthInterceptor:      authUnary(log, m),
+			ProtectedInterceptor: protectedUnary(log, m, auditLog, revoked),
+			Revoked:              revoked,
+		})
+		restTLS := keys.optionalTLSConfig(nil, "h2", "http/1.1")
+		eg.Go(serveREST(ctx, cfg.Listen.REST, restTLS, h))
+	}
+	if m != nil {
+		eg.Go(serveMetrics(ctx, cfg.Metrics.Listen, m))
+	}
+	if cfg.Storage.CRL != "" {
+		eg.Go(keepCRL(ctx, cfg.Storage.CRL, keys, revoked, cfg.TTL.CRL))
+	}
+
+	err = eg.Wait()
+
+	// The TOTP store is saved on every change and audit events are synced as
+	// they are recorded, so only the audit log is left to close.
+	if cerr := auditLog.Close(); cerr != nil && err == nil {
+		err = errors.Wrap(cerr, "closing audit log")
+	}
+	logging.Default().Info("Stopped")
+	return err
 }
 
-func serveAuth(addr string, config *srv.AuthConfig) func() error {
+// signalContext returns a context that is cancelled when the process is asked
+// to stop with SIGINT or SIGTERM.  A second signal kills the process.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			logging.Default().Info("Shutting down", "signal", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+
+	return ctx, cancel
+}
+
+// serveUntil serves s on lis until ctx is done.  Then it stops accepting
+// calls and waits up to shutdownTimeout for the calls in flight to finish
+// before cancelling them.
+func serveUntil(ctx context.Context, s *grpc.Server, lis net.Listener) error {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+
+		graceful := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(graceful)
+		}()
+
+		select {
+		case <-graceful:
+		case <-time.After(shutdownTimeout):
+			logging.Default().Warn("Cancelling calls still in flight")
+			s.Stop()
+		}
+	}()
+
+	err := s.Serve(lis)
+	if err != nil {
+		return err
+	}
+
+	<-stopped
+	return nil
+}
+
+// extras are the services registered on the Auth and Protected listeners
+// alongside their own.
+type extras struct {
+	health     *health.Server
+	reflection bool
+}
+
+func (e extras) register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, e.health)
+	if e.reflection {
+		reflection.Register(s)
+	}
+}
+
+func serveAuth(
+	ctx context.Context, addr string, auth *srv.Auth, m *metrics.Server,
+	ext extras,
+) func() error {
 	return func() (err error) {
+		log := logging.Default()
+
 		var lis net.Listener
 		lis, err = net.Listen("tcp", addr)
 		if err != nil {
 			return errors.Wrap(err, "auth server failed to listen")
 		}
-		log.Println("Auth server listening at:", lis.Addr())
+		log.Info("Auth server listening", "addr", lis.Addr())
 
-		s := grpc.NewServer()
-		pb.RegisterAuthServer(s, srv.NewAuth(config))
+		s := grpc.NewServer(
+			grpc.UnaryInterceptor(authUnary(log, m)),
+			grpc.StreamInterceptor(chainStream(
+				trace.StreamServerInterceptor(),
+				logging.StreamServerInterceptor(log),
+				metrics.StreamServerInterceptor(m),
+			)),
+		)
+		pb.RegisterAuthServer(s, auth)
+		ext.register(s)
 
-		err = s.Serve(lis)
+		err = serveUntil(ctx, s, lis)
 		if err != nil {
 			return errors.Wrap(err, "auth server")
 		}
@@ -67,20 +277,38 @@ func serveAuth(addr string, config *srv.AuthConfig) func() error {
 	}
 }
 
-func serveProtected(addr string, tlsCfg *tls.Config) func() error {
+func serveProtected(
+	ctx context.Context, addr string, tlsCfg *tls.Config,
+	protected *srv.Protected, revoked *revoke.List, auditLog *audit.Log,
+	m *metrics.Server, ext extras,
+) func() error {
 	return func() (err error) {
+		log := logging.Default()
+
 		var lis net.Listener
 		lis, err = net.Listen("tcp", addr)
 		if err != nil {
 			return errors.Wrap(err, "protected server failed to listen")
 		}
-		log.Println("Protected server listening at:", lis.Addr())
+		log.Info("Protected server listening", "addr", lis.Addr())
 
-		creds := credentials.NewTLS(tlsCfg)
-		s := grpc.NewServer(grpc.Creds(creds))
-		pb.RegisterProtectedServer(s, srv.NewProtected())
+		creds := metrics.ServerCredentials(credentials.NewTLS(tlsCfg), m)
+		s := grpc.NewServer(
+			grpc.Creds(creds),
+			grpc.UnaryInterceptor(
+				protectedUnary(log, m, auditLog, revoked)),
+			grpc.StreamInterceptor(chainStream(
+				trace.StreamServerInterceptor(),
+				logging.StreamServerInterceptor(log),
+				metrics.StreamServerInterceptor(m),
+				audit.StreamServerInterceptor(auditLog),
+				srv.StreamIdentityInterceptor(revoked),
+			)),
+		)
+		pb.RegisterProtectedServer(s, protected)
+		ext.register(s)
 
-		err = s.Serve(lis)
+		err = serveUntil(ctx, s, lis)
 		if err != nil {
 			return errors.Wrap(err, "protected server")
 		}
@@ -89,82 +317,233 @@ func serveProtected(addr string, tlsCfg *tls.Config) func() error {
 	}
 }
 
-func setupCA(keyPath, caPath string) (
-	anchor string, ca *x509.Certificate, key *ecdsa.PrivateKey, err error,
-) {
-	if _, err = os.Stat(keyPath); err != nil {
-		log.Println("Key not found. Generating key.")
-		key, err = pki.GenerateKey()
-		if err != nil {
-			return "", nil, nil, err
-		}
+// authUnary returns the interceptors of unary calls to the Auth service.
+func authUnary(
+	log *logging.Logger, m *metrics.Server,
+) grpc.UnaryServerInterceptor {
+	return chainUnary(
+		trace.UnaryServerInterceptor(),
+		logging.UnaryServerInterceptor(log),
+		metrics.UnaryServerInterceptor(m),
+	)
+}
 
-		log.Println("Saving key to:", keyPath)
-		err = os.MkdirAll(filepath.Dir(keyPath), 0777)
-		if err != nil {
-			return "", nil, nil, err
-		}
-		err = pki.SaveKey(key, keyPath)
+// protectedUnary returns the interceptors of unary calls to the Protected
+// service.
+func protectedUnary(
+	log *logging.Logger, m *metrics.Server, auditLog *audit.Log,
+	revoked *revoke.List,
+) grpc.UnaryServerInterceptor {
+	return chainUnary(
+		trace.UnaryServerInterceptor(),
+		logging.UnaryServerInterceptor(log),
+		metrics.UnaryServerInterceptor(m),
+		audit.UnaryServerInterceptor(auditLog),
+		srv.UnaryIdentityInterceptor(revoked),
+	)
+}
+
+// serveREST serves the Auth and Protected services as JSON over HTTPS.
+func serveREST(
+	ctx context.Context, addr string, tlsCfg *tls.Config, h http.Handler,
+) func() error {
+	return func() (err error) {
+		var lis net.Listener
+		lis, err = net.Listen("tcp", addr)
 		if err != nil {
-			return "", nil, nil, err
+			return errors.Wrap(err, "REST server failed to listen")
 		}
-	} else {
-		log.Println("Loading key from:", keyPath)
-		key, err = pki.LoadKey(keyPath)
-		if err != nil {
-			return "", nil, nil, err
+		logging.Default().Info("REST server listening", "addr", lis.Addr())
+
+		hs := &http.Server{Handler: h, TLSConfig: tlsCfg}
+		go func() {
+			<-ctx.Done()
+			shutCtx, cancel := context.WithTimeout(
+				context.Background(), shutdownTimeout)
+			defer cancel()
+			_ = hs.Shutdown(shutCtx)
+		}()
+
+		err = hs.ServeTLS(lis, "", "")
+		if err != nil && err != http.ErrServerClosed {
+			return errors.Wrap(err, "REST server")
 		}
+
+		return nil
 	}
+}
+
+// serveAdmin serves the admin service on a Unix socket that only the user
+// running the server can connect to.
+func serveAdmin(
+	ctx context.Context, path string, admin *srv.Admin,
+) func() error {
+	return func() (err error) {
+		log := logging.Default()
 
-	if _, err = os.Stat(caPath); err != nil {
-		log.Println("CA not found.  Self-signing a new CA cert.")
-		anchor, err = pki.SelfSign(key, serverName)
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "removing stale admin socket")
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0700)
 		if err != nil {
-			return "", nil, nil, err
+			return errors.Wrap(err, "creating directory for admin socket")
 		}
 
-		log.Println("Saving CA to:", caPath)
-		err = os.MkdirAll(filepath.Dir(caPath), 0777)
+		var lis net.Listener
+		lis, err = net.Listen("unix", path)
 		if err != nil {
-			return "", nil, nil, err
+			return errors.Wrap(err, "admin server failed to listen")
 		}
-		err = pki.SaveCert(anchor, caPath)
+		err = os.Chmod(path, 0600)
 		if err != nil {
-			return "", nil, nil, err
+			lis.Close()
+			return errors.Wrap(err, "restricting admin socket")
 		}
+		log.Info("Admin server listening", "addr", lis.Addr())
+
+		s := grpc.NewServer(serverOpts(log)...)
+		pb.RegisterAdminServer(s, admin)
 
-		ca, err = pki.PEMtoCert(anchor)
+		err = serveUntil(ctx, s, lis)
 		if err != nil {
-			return "", nil, nil, err
+			return errors.Wrap(err, "admin server")
 		}
-	} else {
-		log.Println("Loading CA from:", caPath)
-		ca, err = pki.LoadCert(caPath)
+
+		return nil
+	}
+}
+
+// serveMetrics serves the metrics over HTTP at /metrics.
+func serveMetrics(
+	ctx context.Context, addr string, m *metrics.Server,
+) func() error {
+	return func() (err error) {
+		var lis net.Listener
+		lis, err = net.Listen("tcp", addr)
 		if err != nil {
-			return "", nil, nil, err
+			return errors.Wrap(err, "metrics server failed to listen")
+		}
+		logging.Default().Info("Metrics server listening", "addr", lis.Addr())
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+
+		hs := &http.Server{Handler: mux}
+		go func() {
+			<-ctx.Done()
+			shutCtx, cancel := context.WithTimeout(
+				context.Background(), shutdownTimeout)
+			defer cancel()
+			_ = hs.Shutdown(shutCtx)
+		}()
+
+		err = hs.Serve(lis)
+		if err != nil && err != http.ErrServerClosed {
+			return errors.Wrap(err, "metrics server")
+		}
+
+		return nil
+	}
+}
+
+// serverOpts returns the options shared by all of the gRPC servers.
+func serverOpts(log *logging.Logger) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(logging.UnaryServerInterceptor(log)),
+		grpc.StreamInterceptor(logging.StreamServerInterceptor(log)),
+	}
+}
+
+// chainUnary combines interceptors into one that calls them in order, the first
+// being the outermost.
+func chainUnary(
+	interceptors ...grpc.UnaryServerInterceptor,
+) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next, interceptor := handler, interceptors[i]
+			handler = func(ctx context.Context, req interface{}) (
+				interface{}, error,
+			) {
+				return interceptor(ctx, req, info, next)
+			}
 		}
-		anchor = string(pki.CertToPEM(ca))
+		return handler(ctx, req)
 	}
+}
 
-	return anchor, ca, key, nil
+// chainStream is like chainUnary for streaming calls.
+func chainStream(
+	interceptors ...grpc.StreamServerInterceptor,
+) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			next, interceptor := handler, interceptors[i]
+			handler = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
 }
 
-func setupServerTLS(
-	anchor, keyPath, caPath string,
-) (tlsCfg *tls.Config, err error) {
-	certificate, err := tls.LoadX509KeyPair(caPath, keyPath)
+// setupCA generates the key and self-signs a CA certificate for it if they do
+// not exist yet.  The directory of the key is locked meanwhile, so that
+// servers started together do not generate different keys.
+func setupCA(keyPath, caPath string) error {
+	log := logging.Default()
+
+	unlock, err := keystore.Lock(filepath.Dir(keyPath), func() {
+		log.Info("Waiting for another server to set up the CA")
+	})
 	if err != nil {
-		return nil, errors.New("failed to load key pair")
+		return err
 	}
+	defer unlock()
 
-	certPool := x509.NewCertPool()
-	if ok := certPool.AppendCertsFromPEM([]byte(anchor)); !ok {
-		return nil, errors.New("failed to append anchor certs")
+	var key *ecdsa.PrivateKey
+	if _, err := os.Stat(keyPath); err != nil {
+		log.Info("Key not found. Generating key.")
+		key, err = pki.GenerateKey()
+		if err != nil {
+			return err
+		}
+
+		log.Info("Saving key", "path", keyPath)
+		err = pki.SaveKey(key, keyPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(caPath); err != nil {
+		if key == nil {
+			log.Info("Loading key", "path", keyPath)
+			key, err = pki.LoadKey(keyPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Info("CA not found. Self-signing a new CA cert.")
+		anchor, err := pki.SelfSign(key, serverName)
+		if err != nil {
+			return err
+		}
+
+		log.Info("Saving CA", "path", caPath)
+		err = pki.SaveCert(anchor, caPath)
+		if err != nil {
+			return err
+		}
 	}
 
-	return &tls.Config{
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		Certificates: []tls.Certificate{certificate},
-		ClientCAs:    certPool,
-	}, nil
+	return nil
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+// serverCertMode lets the server certificates be read by anyone, unlike their
+// keys.
+const serverCertMode = 0644
+
+// serverCert issues a certificate for a server named name, such as a gateway
+// or a VPN server, signed by the CA of cfg and valid for validity.  It saves
+// the certificate and a new key to certPath and keyPath, for the server to
+// present instead of the CA's key.
+func serverCert(
+	cfg *config.Config, name, certPath, keyPath string,
+	validity time.Duration,
+) error {
+	k, err := loadKeyring(cfg.CA.Key, cfg.CA.Cert, cfg.TLS)
+	if err != nil {
+		return err
+	}
+
+	key, err := pki.GenerateKey()
+	if err != nil {
+		return err
+	}
+	cert, err := pki.SignServer(k.key, k.ca, &key.PublicKey, name, validity)
+	if err != nil {
+		return err
+	}
+
+	err = pki.SaveKey(key, keyPath)
+	if err != nil {
+		return err
+	}
+	err = pki.SaveCert(cert, certPath)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(os.Chmod(certPath, serverCertMode),
+		"saving certificate")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+	"github.com/KibaFox/tls-usr-sessions/trace"
+)
+
+// sshKeyNames are the public keys of the user looked for in ~/.ssh, in order
+// of preference, as ssh tries their private keys.
+var sshKeyNames = []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"}
+
+// sshCertMode lets the SSH certificates be read by anyone, like the public
+// keys they certify.
+const sshCertMode = 0644
+
+// defaultSSHKey returns the path of the first of the user's public keys found
+// in ~/.ssh.
+func defaultSSHKey() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "finding home directory")
+	}
+	for _, name := range sshKeyNames {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("no SSH public key found in ~/.ssh, " +
+		"generate one with ssh-keygen or give it with -ssh-key")
+}
+
+// sshCertPath returns where ssh looks for the certificate of a public key,
+// which is next to its private key with -cert.pub appended.
+func sshCertPath(pubPath string) string {
+	return strings.TrimSuffix(pubPath, ".pub") + "-cert.pub"
+}
+
+// sshCert asks the server of the profile to certify the SSH public key at
+// pubPath, and writes the certificate where ssh looks for it.  It returns the
+// path of the certificate along with the response of the server.
+func sshCert(
+	p *profile.Profile, pubPath string,
+) (certPath string, resp *pb.SSHCert, err error) {
+	ctx, span := trace.Start(context.Background(), "ssh-cert", trace.Internal)
+	defer span.End()
+
+	pub, err := ioutil.ReadFile(pubPath)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "reading SSH public key")
+	}
+
+	conn, err := dialProtected(p)
+	if err != nil {
+		return "", nil, err
+	}
+	defer conn.Close()
+
+	cli := pb.NewProtectedClient(conn)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err = cli.IssueSSHCert(ctx,
+		&pb.SSHCertRequest{PublicKey: string(pub)})
+	if err != nil {
+		span.SetError(err)
+		return "", nil, errors.Wrap(err, "failed to get SSH certificate")
+	}
+
+	certPath = sshCertPath(pubPath)
+	err = ioutil.WriteFile(certPath, []byte(resp.Certificate), sshCertMode)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "writing SSH certificate")
+	}
+	return certPath, resp, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+// sessionStatus describes the certificate a profile holds and whether it can
+// still be used.
+type sessionStatus struct {
+	Profile   string        `json:"profile"`
+	User      string        `json:"user"`
+	Roles     []string      `json:"roles"`
+	Device    string        `json:"device"`
+	Serial    string        `json:"serial"`
+	Issuer    string        `json:"issuer"`
+	Expires   time.Time     `json:"expires"`
+	Remaining time.Duration `json:"remaining"`
+
+	// Problem is why the certificate cannot be used, empty if it can.
+	Problem string `json:"problem,omitempty"`
+}
+
+// Valid reports whether the certificate can be used.
+func (s *sessionStatus) Valid() bool {
+	return s.Problem == ""
+}
+
+// sessionState reads the certificate of the profile and checks it against the
+// profile's key and trust anchors at the time given.  An error is returned only
+// if the files or the key cannot be read; problems with the certificate itself
+// are given in the status.
+func sessionState(p *profile.Profile, now time.Time) (*sessionStatus, error) {
+	certPEM, err := ioutil.ReadFile(p.CertPath())
+	if err != nil {
+		return nil, errors.New("failed to read certificate, login first")
+	}
+	cert, err := pki.PEMtoCert(string(certPEM))
+	if err != nil {
+		return nil, err
+	}
+	id, err := pki.CertIdentity(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &sessionStatus{
+		Profile:   p.Name(),
+		User:      id.User,
+		Roles:     id.Roles,
+		Device:    id.Device,
+		Serial:    id.Serial,
+		Issuer:    cert.Issuer.CommonName,
+		Expires:   cert.NotAfter,
+		Remaining: cert.NotAfter.Sub(now).Truncate(time.Second),
+	}
+	if st.Remaining < 0 {
+		st.Remaining = 0
+	}
+
+	keyPEM, err := readClientKey(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading key")
+	}
+	anchors, err := ioutil.ReadFile(p.RootPath())
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading root anchor file")
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(anchors); !ok {
+		return nil, errors.New("failed to append anchor certs")
+	}
+
+	switch {
+	case now.After(cert.NotAfter):
+		st.Problem = "the certificate expired"
+	case now.Before(cert.NotBefore):
+		st.Problem = "the certificate is not valid yet"
+	}
+	if st.Problem != "" {
+		return st, nil
+	}
+
+	if _, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		st.Problem = "the certificate does not match the key"
+		return st, nil
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       pool,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		st.Problem = "the certificate is not trusted: " + err.Error()
+	}
+	return st, nil
+}
+
+// printStatus writes the status as text, or as JSON if asJSON is set.
+func printStatus(st *sessionStatus, asJSON bool, out io.Writer) error {
+	if asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			*sessionStatus
+			Remaining float64 `json:"remaining"`
+			Valid     bool    `json:"valid"`
+		}{st, st.Remaining.Seconds(), st.Valid()})
+	}
+
+	state := "valid"
+	if !st.Valid() {
+		state = st.Problem
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Profile:\t%s\n", st.Profile)
+	fmt.Fprintf(tw, "User:\t%s\n", st.User)
+	fmt.Fprintf(tw, "Roles:\t%s\n", strings.Join(st.Roles, ", "))
+	fmt.Fprintf(tw, "Device:\t%s\n", st.Device)
+	fmt.Fprintf(tw, "Serial:\t%s\n", st.Serial)
+	fmt.Fprintf(tw, "Issuer:\t%s\n", st.Issuer)
+	fmt.Fprintf(tw, "Expires:\t%s\n", st.Expires.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Remaining:\t%s\n", st.Remaining)
+	fmt.Fprintf(tw, "Status:\t%s\n", state)
+	return tw.Flush()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"rsc.io/qr"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+)
+
+// qrQuietZone is the number of blank modules around a QR code.
+const qrQuietZone = 2
+
+func enrollTOTP(adminPath, user string, out io.Writer) (err error) {
+	conn, err := dialAdmin(adminPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cli := pb.NewAdminClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := cli.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{Username: user})
+	if err != nil {
+		return errors.Wrap(err, "failed to enroll in TOTP")
+	}
+
+	fmt.Fprintln(out, "Scan this QR code with an authenticator app:")
+	fmt.Fprintln(out)
+	err = printQR(resp.Uri, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Or add this URI to it manually:")
+	fmt.Fprintln(out, "   ", resp.Uri)
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Recovery codes, each can be used once in place of a code:")
+	for _, code := range resp.RecoveryCodes {
+		fmt.Fprintln(out, "   ", code)
+	}
+
+	return nil
+}
+
+// printQR renders text as a QR code on a terminal.  Each character covers two
+// modules using half blocks, with light modules drawn so that the code reads
+// correctly on a dark background.
+func printQR(text string, out io.Writer) error {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return errors.Wrap(err, "encoding QR code")
+	}
+
+	light := func(x, y int) bool { return !code.Black(x, y) }
+	first, last := -qrQuietZone, code.Size+qrQuietZone
+	for y := first; y < last; y += 2 {
+		var line strings.Builder
+		for x := first; x < last; x++ {
+			top, bottom := light(x, y), y+1 < last && light(x, y+1)
+			switch {
+			case top && bottom:
+				line.WriteString("█")
+			case top:
+				line.WriteString("▀")
+			case bottom:
+				line.WriteString("▄")
+			default:
+				line.WriteString(" ")
+			}
+		}
+		fmt.Fprintln(out, line.String())
+	}
+
+	return nil
+}
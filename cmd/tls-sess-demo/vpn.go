@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+	"github.com/KibaFox/tls-usr-sessions/vpn"
+)
+
+// vpnClientConfig writes the configuration of a VPN client of the given type
+// for the profile's session, connecting to the VPN server at remote whose
+// certificate is issued for serverName, by default the host of remote.
+func vpnClientConfig(
+	p *profile.Profile, t vpn.Type, remote, serverName string, out io.Writer,
+) error {
+	if p.KeyStore != "" && p.KeyStore != keyStoreFile {
+		return errors.Errorf("VPN clients cannot use a key kept in the %s "+
+			"store, login with -keystore %s", p.KeyStore, keyStoreFile)
+	}
+
+	cert, err := ioutil.ReadFile(p.CertPath())
+	if err != nil {
+		return errors.New("failed to read certificate, login first")
+	}
+	anchors, err := ioutil.ReadFile(p.RootPath())
+	if err != nil {
+		return errors.Wrap(err, "reading anchor certs")
+	}
+	parsed, err := pki.PEMtoCert(string(cert))
+	if err != nil {
+		return err
+	}
+
+	c := vpn.Client{
+		Name:       p.Name(),
+		Remote:     remote,
+		ServerName: serverName,
+		Cert:       string(cert),
+		Anchors:    string(anchors),
+		Expires:    parsed.NotAfter,
+
+		CertPath:    p.CertPath(),
+		AnchorsPath: p.RootPath(),
+		KeyPath:     p.KeyPath(),
+	}
+	err = absPaths(&c.CertPath, &c.AnchorsPath, &c.KeyPath)
+	if err != nil {
+		return err
+	}
+	return vpn.WriteClient(out, t, c)
+}
+
+// vpnServerConfig writes a CRL of the revoked certificates to crlPath, signed
+// by the server's CA and to be issued again within validity, then the snippet
+// of the configuration of a VPN server of the given type that accepts the
+// sessions.  The VPN server presents the certificate at certPath with the key
+// at keyPath, which the CA must have issued to a server.
+func vpnServerConfig(
+	cfg *config.Config, t vpn.Type, certPath, keyPath, crlPath string,
+	validity time.Duration, out io.Writer,
+) error {
+	k, err := loadKeyring(cfg.CA.Key, cfg.CA.Cert, cfg.TLS)
+	if err != nil {
+		return err
+	}
+	name, err := vpnServerName(k, certPath, keyPath)
+	if err != nil {
+		return err
+	}
+	revoked, err := revoke.Open(cfg.Storage.Revoked)
+	if err != nil {
+		return err
+	}
+
+	err = writeCRL(crlPath, k, revoked.Serials(), validity)
+	if err != nil {
+		return err
+	}
+
+	s := vpn.Server{
+		Name:       serverName,
+		ServerName: name,
+		CertPath:   certPath,
+		KeyPath:    keyPath,
+		CAPath:     cfg.CA.Cert,
+		CRLPath:    crlPath,
+	}
+	err = absPaths(&s.CertPath, &s.KeyPath, &s.CAPath, &s.CRLPath)
+	if err != nil {
+		return err
+	}
+	return vpn.WriteServer(out, t, s)
+}
+
+// vpnServerName checks that the certificate at certPath goes with the key at
+// keyPath and was issued to a server by the CA of k, and returns the name it
+// was issued for.
+func vpnServerName(k *keyring, certPath, keyPath string) (string, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return "", errors.Wrap(err,
+			"loading the VPN server's certificate, issue one with "+
+				"server-cert -name HOST")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return "", errors.Wrap(err, "parsing the VPN server's certificate")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(k.ca)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "verifying the VPN server's certificate")
+	}
+
+	switch {
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0], nil
+	case len(cert.IPAddresses) > 0:
+		return cert.IPAddresses[0].String(), nil
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// absPaths makes each of the paths absolute, in place, since the VPN software
+// does not run from the same directory.
+func absPaths(paths ...*string) error {
+	for _, path := range paths {
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return errors.Wrap(err, "resolving path")
+		}
+		*path = abs
+	}
+	return nil
+}
@@ -0,0 +1,465 @@
+// Package config holds the configuration of the demo server, which is read
+// from a YAML file and can be overridden by environment variables and flags.
+// Every problem found is reported with the file and line, the variable or the
+// flag that set the offending value.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/KibaFox/tls-usr-sessions/throttle"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// settings.  The rest of the name is the path of the setting in upper case
+// with dots replaced by underscores, such as TLS_SESS_LOG_LEVEL for
+// log.level.
+const EnvPrefix = "TLS_SESS_"
+
+// Config is the configuration of the demo server.
+type Config struct {
+	Listen  Listen  `yaml:"listen"`
+	TLS     TLS     `yaml:"tls"`
+	CA      CA      `yaml:"ca"`
+	TTL     TTL     `yaml:"ttl"`
+	Auth    Auth    `yaml:"auth"`
+	Storage Storage `yaml:"storage"`
+	Limits  Limits  `yaml:"limits"`
+	Log     Log     `yaml:"log"`
+	Metrics Metrics `yaml:"metrics"`
+	Trace   Trace   `yaml:"trace"`
+
+	// sources are where each setting that is not a default came from, by
+	// path.
+	sources map[string]string
+}
+
+// Listen holds the addresses the servers listen on.
+type Listen struct {
+	Auth      string `yaml:"auth"`
+	Protected string `yaml:"protected"`
+
+	// REST is the address to serve the Auth and Protected services on as
+	// JSON over HTTPS.  The REST server is disabled if it is empty.
+	REST string `yaml:"rest"`
+
+	// Admin is the path of the Unix socket for admin requests.  The admin
+	// server is disabled if it is empty.
+	Admin string `yaml:"admin"`
+
+	// Reflection registers the gRPC reflection service on the Auth and
+	// Protected servers.
+	Reflection bool `yaml:"reflection"`
+}
+
+// TLS holds the settings of the Protected server's TLS handshakes.
+type TLS struct {
+	// MinVersion is the oldest version of TLS accepted, either "1.2" or
+	// "1.3".
+	MinVersion string `yaml:"min_version"`
+
+	// CipherSuites are the names of the TLS 1.2 cipher suites accepted, as
+	// given by crypto/tls.  Go's defaults are used if it is empty.
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+// CA holds the paths of the CA files, which are created if they do not
+// exist.
+type CA struct {
+	Key  string `yaml:"key"`
+	Cert string `yaml:"cert"`
+}
+
+// TTL holds how long what the server hands out is valid.
+type TTL struct {
+	Certificate time.Duration `yaml:"certificate"`
+	Challenge   time.Duration `yaml:"challenge"`
+
+	// CRL is how long each CRL written to storage.crl is valid.
+	CRL time.Duration `yaml:"crl"`
+}
+
+// Auth holds how users are authenticated.
+type Auth struct {
+	// Backend is where users are looked up.  Only "static", the built in
+	// demo user, is supported.
+	Backend string `yaml:"backend"`
+
+	// ChangePassword requires users to change their password at their first
+	// login.
+	ChangePassword bool `yaml:"change_password"`
+
+	// Terms is the path of terms of use users must accept at login.  Users
+	// are not asked to accept any if it is empty.
+	Terms string `yaml:"terms"`
+}
+
+// Storage holds the paths of the files the server keeps.  Each feature is
+// disabled if its path is empty.
+type Storage struct {
+	TOTP  string `yaml:"totp"`
+	Audit string `yaml:"audit"`
+
+	// Revoked is the list of revoked certificates, which is shared with the
+	// gateway.
+	Revoked string `yaml:"revoked"`
+
+	// CRL is where a CRL of the revoked certificates, signed by the CA, is
+	// kept up to date for VPN servers.
+	CRL string `yaml:"crl"`
+}
+
+// Limits holds the thresholds for failed logins.
+type Limits struct {
+	User Limit `yaml:"user"`
+	IP   Limit `yaml:"ip"`
+}
+
+// Limit holds the thresholds for failed logins per username or per source
+// address.
+type Limit struct {
+	MaxFailures int           `yaml:"max_failures"`
+	Lockout     time.Duration `yaml:"lockout"`
+	MaxLockout  time.Duration `yaml:"max_lockout"`
+	Window      time.Duration `yaml:"window"`
+}
+
+// Throttle returns the limit as the configuration of a throttle.Limiter.
+func (l Limit) Throttle() throttle.Config {
+	return throttle.Config{
+		MaxFailures: l.MaxFailures,
+		Lockout:     l.Lockout,
+		MaxLockout:  l.MaxLockout,
+		Window:      l.Window,
+	}
+}
+
+func limit(cfg throttle.Config) Limit {
+	return Limit{
+		MaxFailures: cfg.MaxFailures,
+		Lockout:     cfg.Lockout,
+		MaxLockout:  cfg.MaxLockout,
+		Window:      cfg.Window,
+	}
+}
+
+// Log holds how the server logs.
+type Log struct {
+	// Level is the least severe entries written: debug, info, warn or error.
+	Level string `yaml:"level"`
+
+	// Format is text or json.
+	Format string `yaml:"format"`
+}
+
+// Metrics holds where metrics are served.
+type Metrics struct {
+	// Listen is the address to serve metrics on at /metrics.  Metrics are
+	// disabled if it is empty.
+	Listen string `yaml:"listen"`
+}
+
+// Trace holds where trace spans are written.
+type Trace struct {
+	// Output is "stdout" or the path of a file.  Tracing is disabled if it is
+	// empty.
+	Output string `yaml:"output"`
+}
+
+// Default returns the configuration used when nothing else is given.
+func Default() *Config {
+	ipLimit := limit(throttle.DefaultConfig)
+	ipLimit.MaxFailures = 20
+
+	return &Config{
+		Listen: Listen{
+			Auth:      "127.0.0.1:4443",
+			Protected: "127.0.0.1:4444",
+			Admin:     "certs/admin.sock",
+		},
+		TLS: TLS{MinVersion: "1.2"},
+		CA: CA{
+			Key:  "certs/ca_key.pem",
+			Cert: "certs/ca_cert.pem",
+		},
+		TTL: TTL{
+			Certificate: 7 * 24 * time.Hour,
+			Challenge:   time.Minute,
+			CRL:         7 * 24 * time.Hour,
+		},
+		Auth: Auth{Backend: "static"},
+		Storage: Storage{
+			TOTP:    "certs/totp.json",
+			Audit:   "certs/audit.log",
+			Revoked: "certs/revoked.txt",
+		},
+		Limits: Limits{
+			User: limit(throttle.DefaultConfig),
+			IP:   ipLimit,
+		},
+		Log: Log{Level: "info", Format: "text"},
+	}
+}
+
+// Load reads the configuration file at path over the defaults.  Settings the
+// configuration does not have are refused.
+func Load(path string) (*Config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading configuration")
+	}
+
+	return Parse(path, raw)
+}
+
+// Parse reads a configuration over the defaults.  The name is the file it
+// came from, which is given in errors.
+func Parse(name string, raw []byte) (*Config, error) {
+	c := Default()
+
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	err := dec.Decode(c)
+	if err != nil && err != io.EOF {
+		return nil, yamlErrors(name, err)
+	}
+
+	var root yaml.Node
+	err = yaml.Unmarshal(raw, &root)
+	if err != nil {
+		return nil, yamlErrors(name, err)
+	}
+	c.sources = make(map[string]string)
+	if len(root.Content) > 0 {
+		c.addSources(name, "", 0, root.Content[0])
+	}
+
+	return c, nil
+}
+
+var yamlLineRx = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors reformats the errors of the YAML decoder as "name:line: error".
+func yamlErrors(name string, err error) error {
+	msgs := []string{err.Error()}
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = te.Errors
+	}
+
+	var errs Errors
+	for _, msg := range msgs {
+		if m := yamlLineRx.FindStringSubmatch(msg); m != nil {
+			errs = append(errs, &Error{Source: name + ":" + m[1], Msg: m[2]})
+		} else {
+			errs = append(errs, &Error{Source: name,
+				Msg: strings.TrimPrefix(msg, "yaml: ")})
+		}
+	}
+	return errs
+}
+
+// addSources records the line of each setting in the node, which is the line
+// of its key for the settings of a section.
+func (c *Config) addSources(name, path string, line int, n *yaml.Node) {
+	if path != "" {
+		c.sources[path] = fmt.Sprintf("%s:%d", name, line)
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			c.addSources(name, join(path, key.Value), key.Line, n.Content[i+1])
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			c.addSources(name, join(path, strconv.Itoa(i)), item.Line, item)
+		}
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// Source returns where the setting at path, or the section or list holding
+// it, was set, such as "serv.yaml:12".  It is empty for default values.
+func (c *Config) Source(path string) string {
+	for {
+		if src, ok := c.sources[path]; ok {
+			return src
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return ""
+		}
+		path = path[:i]
+	}
+}
+
+// Paths returns the paths of every setting, such as "log.level".
+func Paths() []string {
+	var paths []string
+	var walk func(prefix string, t reflect.Type)
+	walk = func(prefix string, t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("yaml")
+			if tag == "" {
+				continue
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(join(prefix, tag), f.Type)
+			} else {
+				paths = append(paths, join(prefix, tag))
+			}
+		}
+	}
+	walk("", reflect.TypeOf(Config{}))
+	return paths
+}
+
+// EnvName returns the environment variable that overrides the setting at
+// path.
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(path, ".", "_", -1))
+}
+
+// ApplyEnv overrides settings with the environment variables that are set.
+// lookup is usually os.LookupEnv.
+func (c *Config) ApplyEnv(lookup func(key string) (string, bool)) error {
+	var errs Errors
+	for _, path := range Paths() {
+		name := EnvName(path)
+		if value, ok := lookup(name); ok {
+			err := c.Set(path, value, "$"+name)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Value returns the setting at path, which is a string, bool, int,
+// time.Duration or []string.
+func (c *Config) Value(path string) (interface{}, error) {
+	v, err := c.field(path)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
+
+// Set sets the setting at path from a string, as given in an environment
+// variable or a flag.  Lists are separated by commas and durations are
+// formatted as for time.ParseDuration.  The source is given in errors.
+func (c *Config) Set(path, value, source string) error {
+	v, err := c.field(path)
+	if err != nil {
+		return &Error{Source: source, Msg: err.Error()}
+	}
+
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(value)
+	case bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		v.SetBool(b)
+	case int:
+		var i int
+		i, err = strconv.Atoi(value)
+		v.SetInt(int64(i))
+	case time.Duration:
+		var d time.Duration
+		d, err = time.ParseDuration(value)
+		v.SetInt(int64(d))
+	case []string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	}
+	if err != nil {
+		return &Error{Source: source, Path: path,
+			Msg: fmt.Sprintf("invalid value %q", value)}
+	}
+
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	for p := range c.sources {
+		if strings.HasPrefix(p, path+".") {
+			delete(c.sources, p)
+		}
+	}
+	c.sources[path] = source
+	return nil
+}
+
+// field returns the settable value of the setting at path.
+func (c *Config) field(path string) (reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	for _, key := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, errors.Errorf("unknown setting %s", path)
+		}
+
+		found := false
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("yaml") == key {
+				v, found = v.Field(i), true
+				break
+			}
+		}
+		if !found {
+			return reflect.Value{}, errors.Errorf("unknown setting %s", path)
+		}
+	}
+
+	if v.Kind() == reflect.Struct {
+		return reflect.Value{}, errors.Errorf("%s is not a setting", path)
+	}
+	return v, nil
+}
+
+// FromEnvironment reads the configuration file at path, or only the defaults
+// if path is empty, then applies the environment variables.
+func FromEnvironment(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		var err error
+		c, err = Load(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := c.ApplyEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
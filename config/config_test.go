@@ -0,0 +1,139 @@
+package config_test
+
+import (
+	"crypto/tls"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/config"
+)
+
+var _ = Describe("Config", func() {
+	It("has valid defaults", func() {
+		c := config.Default()
+		Expect(c.Validate()).To(Succeed())
+		Expect(c.TTL.Certificate).Should(Equal(7 * 24 * time.Hour))
+		Expect(c.Limits.IP.MaxFailures).Should(Equal(20))
+	})
+
+	It("reads a file over the defaults", func() {
+		c, err := config.Parse("serv.yaml", []byte(`
+listen:
+  auth: 0.0.0.0:5443
+tls:
+  min_version: 1.3
+ttl:
+  certificate: 12h
+limits:
+  user:
+    max_failures: 3
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Validate()).To(Succeed())
+
+		Expect(c.Listen.Auth).Should(Equal("0.0.0.0:5443"))
+		Expect(c.Listen.Protected).Should(Equal("127.0.0.1:4444"))
+		Expect(c.TLS.MinVersionID()).Should(Equal(uint16(tls.VersionTLS13)))
+		Expect(c.TTL.Certificate).Should(Equal(12 * time.Hour))
+		Expect(c.Limits.User.MaxFailures).Should(Equal(3))
+		Expect(c.Limits.User.Lockout).Should(Equal(time.Minute))
+		Expect(c.Source("ttl.certificate")).Should(Equal("serv.yaml:7"))
+		Expect(c.Source("listen.protected")).Should(Equal("serv.yaml:2"))
+		Expect(c.Source("log.level")).Should(BeEmpty())
+	})
+
+	It("refuses unknown settings with their line", func() {
+		_, err := config.Parse("serv.yaml", []byte(`
+listen:
+  auth: 0.0.0.0:5443
+  protcted: 0.0.0.0:5444
+`))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).Should(HavePrefix("serv.yaml:4: "))
+		Expect(err.Error()).Should(ContainSubstring("protcted"))
+	})
+
+	It("reports invalid YAML with its line", func() {
+		_, err := config.Parse("serv.yaml", []byte(`
+ttl:
+  certificate: [
+`))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).Should(MatchRegexp(`^serv\.yaml:\d+: `))
+	})
+
+	It("lists every invalid setting with where it was set", func() {
+		c, err := config.Parse("serv.yaml", []byte(`
+listen:
+  auth: nowhere
+tls:
+  cipher_suites:
+    - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+    - TLS_RSA_WITH_RC4_128_SHA
+log:
+  level: loud
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Set("limits.ip.lockout", "2h", "-lockout")).To(Succeed())
+
+		err = c.Validate()
+		Expect(err).To(HaveOccurred())
+		errs, ok := err.(config.Errors)
+		Expect(ok).To(BeTrue())
+		Expect(errs).Should(HaveLen(4))
+		Expect(errs[0].Error()).Should(HavePrefix("serv.yaml:3: listen.auth: "))
+		Expect(errs[1].Error()).Should(HavePrefix(
+			"serv.yaml:7: tls.cipher_suites.1: "))
+		Expect(errs[2].Error()).Should(Equal(
+			"limits.ip.max_lockout: must not be less than the lockout"))
+		Expect(errs[3].Error()).Should(HavePrefix("serv.yaml:9: log.level: "))
+	})
+
+	It("is overridden by environment variables", func() {
+		env := map[string]string{
+			"TLS_SESS_LOG_LEVEL":         "debug",
+			"TLS_SESS_LISTEN_REFLECTION": "true",
+			"TLS_SESS_TTL_CHALLENGE":     "30s",
+			"TLS_SESS_TLS_CIPHER_SUITES": "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, " +
+				"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+			"TLS_SESS_LIMITS_USER_MAX_FAILURES": "7",
+		}
+		c := config.Default()
+		Expect(c.ApplyEnv(func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		})).To(Succeed())
+
+		Expect(c.Log.Level).Should(Equal("debug"))
+		Expect(c.Listen.Reflection).Should(BeTrue())
+		Expect(c.TTL.Challenge).Should(Equal(30 * time.Second))
+		Expect(c.TLS.CipherSuites).Should(Equal([]string{
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+		}))
+		Expect(c.Limits.User.MaxFailures).Should(Equal(7))
+		Expect(c.Source("log.level")).Should(Equal("$TLS_SESS_LOG_LEVEL"))
+	})
+
+	It("refuses values that cannot be parsed", func() {
+		c := config.Default()
+		err := c.Set("ttl.certificate", "a week", "-cert-ttl")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).Should(Equal(
+			`-cert-ttl: ttl.certificate: invalid value "a week"`))
+
+		Expect(c.Set("ttl.forever", "1h", "-x")).ToNot(Succeed())
+		Expect(c.Set("ttl", "1h", "-x")).ToNot(Succeed())
+	})
+
+	It("gives the value of each setting", func() {
+		c := config.Default()
+		Expect(c.Value("ttl.certificate")).Should(Equal(7 * 24 * time.Hour))
+		Expect(c.Value("listen.reflection")).Should(Equal(false))
+		Expect(config.Paths()).Should(ContainElement("limits.ip.window"))
+		Expect(config.EnvName("limits.ip.window")).Should(
+			Equal("TLS_SESS_LIMITS_IP_WINDOW"))
+	})
+})
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/KibaFox/tls-usr-sessions/logging"
+)
+
+// Error is a problem with a setting.
+type Error struct {
+	// Source is where the setting was set, if not by default.
+	Source string
+
+	// Path is the path of the setting, if the problem is with one.
+	Path string
+
+	Msg string
+}
+
+func (e *Error) Error() string {
+	var parts []string
+	if e.Source != "" {
+		parts = append(parts, e.Source)
+	}
+	if e.Path != "" {
+		parts = append(parts, e.Path)
+	}
+	return strings.Join(append(parts, e.Msg), ": ")
+}
+
+// Errors lists every problem found with a configuration.
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// MinVersionID returns the oldest version of TLS accepted.
+func (t TLS) MinVersionID() uint16 {
+	return tlsVersions[t.MinVersion]
+}
+
+// CipherSuiteIDs returns the IDs of the cipher suites accepted, or nil for
+// Go's defaults.
+func (t TLS) CipherSuiteIDs() []uint16 {
+	var ids []uint16
+	for _, name := range t.CipherSuites {
+		if s := cipherSuite(name); s != nil {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids
+}
+
+func cipherSuite(name string) *tls.CipherSuite {
+	for _, s := range tls.CipherSuites() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Validate checks every setting and returns Errors listing all of the
+// problems found, or nil if there are none.
+func (c *Config) Validate() error {
+	v := &validator{c: c}
+
+	v.address("listen.auth", c.Listen.Auth, true)
+	v.address("listen.protected", c.Listen.Protected, true)
+	v.address("listen.rest", c.Listen.REST, false)
+	v.address("metrics.listen", c.Metrics.Listen, false)
+
+	if _, ok := tlsVersions[c.TLS.MinVersion]; !ok {
+		v.fail("tls.min_version", "must be 1.2 or 1.3")
+	}
+	for i, name := range c.TLS.CipherSuites {
+		if cipherSuite(name) == nil {
+			v.fail("tls.cipher_suites."+strconv.Itoa(i),
+				fmt.Sprintf("unknown or insecure cipher suite %q", name))
+		}
+	}
+
+	v.required("ca.key", c.CA.Key)
+	v.required("ca.cert", c.CA.Cert)
+
+	v.positive("ttl.certificate", int64(c.TTL.Certificate))
+	v.positive("ttl.challenge", int64(c.TTL.Challenge))
+	v.positive("ttl.crl", int64(c.TTL.CRL))
+
+	if c.Auth.Backend != "static" {
+		v.fail("auth.backend", "must be static")
+	}
+
+	v.limit("limits.user", c.Limits.User)
+	v.limit("limits.ip", c.Limits.IP)
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		v.fail("log.level", "must be debug, info, warn or error")
+	}
+	if _, err := logging.ParseFormat(c.Log.Format); err != nil {
+		v.fail("log.format", "must be text or json")
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type validator struct {
+	c    *Config
+	errs Errors
+}
+
+func (v *validator) fail(path, msg string) {
+	v.errs = append(v.errs, &Error{
+		Source: v.c.Source(path), Path: path, Msg: msg,
+	})
+}
+
+func (v *validator) required(path, value string) {
+	if value == "" {
+		v.fail(path, "is required")
+	}
+}
+
+func (v *validator) positive(path string, value int64) {
+	if value <= 0 {
+		v.fail(path, "must be greater than zero")
+	}
+}
+
+func (v *validator) address(path, addr string, required bool) {
+	if addr == "" {
+		if required {
+			v.fail(path, "is required")
+		}
+		return
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		v.fail(path, "must be an address such as 127.0.0.1:4443")
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		v.fail(path, "must have a port from 0 to 65535")
+	}
+}
+
+func (v *validator) limit(path string, l Limit) {
+	if l.MaxFailures < 1 {
+		v.fail(path+".max_failures", "must be at least 1")
+	}
+	v.positive(path+".lockout", int64(l.Lockout))
+	if l.MaxLockout < l.Lockout {
+		v.fail(path+".max_lockout", "must not be less than the lockout")
+	}
+	v.positive(path+".window", int64(l.Window))
+}
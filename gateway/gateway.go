@@ -0,0 +1,265 @@
+// Package gateway forwards HTTP requests from clients authenticated with their
+// session certificates to an upstream service that does not speak mutual TLS.
+//
+// The gateway refuses revoked certificates and users without the roles it
+// requires, then tells the upstream who the client is with identity headers.
+// The headers are signed with a secret shared with the upstream, which checks
+// them with Verify, so that they cannot be forged by whoever else can reach
+// the upstream.
+//
+// The signature covers the method, host and URI of the request and a digest
+// of its body, so that the headers cannot be moved to another request.  The
+// same request can still be replayed as is until the headers are older than
+// the maxAge given to Verify, DefaultMaxAge by default, so upstreams whose
+// requests must not be repeated have to remember the signatures they saw for
+// that long.
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/httpauth"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+// Headers that carry the identity of the client to the upstream.  Roles are
+// separated by commas.  The SHA-256 of the body is in unpadded base64url.
+const (
+	HeaderUser          = "X-Tls-Sess-User"
+	HeaderRoles         = "X-Tls-Sess-Roles"
+	HeaderDevice        = "X-Tls-Sess-Device"
+	HeaderSerial        = "X-Tls-Sess-Serial"
+	HeaderTime          = "X-Tls-Sess-Time"
+	HeaderContentSHA256 = "X-Tls-Sess-Content-Sha256"
+	HeaderSignature     = "X-Tls-Sess-Signature"
+)
+
+// headerPrefix is shared by the identity headers, which are removed from the
+// requests of clients so that they cannot set them.
+const headerPrefix = "X-Tls-Sess-"
+
+// DefaultMaxAge is how old signed headers may be for Verify when the upstream
+// does not say otherwise.  Requests can be replayed within it.
+const DefaultMaxAge = time.Minute
+
+// DefaultMaxBody is how large the bodies of the requests the gateway forwards
+// may be when not configured otherwise, since they are read in memory to be
+// digested.
+const DefaultMaxBody = 10 << 20
+
+// Sign sets the identity headers on a request to the upstream.  The signature
+// covers the identity, the time, and the method, host, URI and body of the
+// request, so that the headers cannot be replayed on another request.  The
+// body is read in memory and replaced by a copy.
+func Sign(
+	r *http.Request, secret []byte, id pki.Identity, now time.Time,
+) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	sign(r, secret, id, now, digest(body))
+	return nil
+}
+
+func sign(
+	r *http.Request, secret []byte, id pki.Identity, now time.Time,
+	bodyDigest string,
+) {
+	for name := range r.Header {
+		if strings.HasPrefix(name, headerPrefix) {
+			r.Header.Del(name)
+		}
+	}
+
+	r.Header.Set(HeaderUser, id.User)
+	r.Header.Set(HeaderRoles, strings.Join(id.Roles, ","))
+	r.Header.Set(HeaderDevice, id.Device)
+	r.Header.Set(HeaderSerial, id.Serial)
+	r.Header.Set(HeaderTime, strconv.FormatInt(now.Unix(), 10))
+	r.Header.Set(HeaderContentSHA256, bodyDigest)
+	r.Header.Set(HeaderSignature, signature(r, secret))
+}
+
+// Verify checks the signature of the identity headers of a request from the
+// gateway and returns the identity of the client.  The headers are refused if
+// they were signed more than maxAge before now.  The body is read in memory
+// and replaced by a copy, so it should be limited first, such as with
+// http.MaxBytesReader.
+func Verify(
+	r *http.Request, secret []byte, maxAge time.Duration, now time.Time,
+) (id pki.Identity, err error) {
+	got, err := base64.RawURLEncoding.DecodeString(
+		r.Header.Get(HeaderSignature))
+	if err != nil || len(got) == 0 {
+		return id, errors.New("missing or invalid identity signature")
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(signature(r, secret))
+	if !hmac.Equal(got, want) {
+		return id, errors.New("the identity signature does not match")
+	}
+
+	unix, err := strconv.ParseInt(r.Header.Get(HeaderTime), 10, 64)
+	if err != nil {
+		return id, errors.New("invalid identity time")
+	}
+	signed := time.Unix(unix, 0)
+	if now.Sub(signed) > maxAge || signed.Sub(now) > maxAge {
+		return id, errors.New("the identity headers expired")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return id, err
+	}
+	if digest(body) != r.Header.Get(HeaderContentSHA256) {
+		return id, errors.New("the body does not match the signed digest")
+	}
+
+	id.User = r.Header.Get(HeaderUser)
+	if roles := r.Header.Get(HeaderRoles); roles != "" {
+		id.Roles = strings.Split(roles, ",")
+	}
+	id.Device = r.Header.Get(HeaderDevice)
+	id.Serial = r.Header.Get(HeaderSerial)
+	return id, nil
+}
+
+// signature returns the HMAC-SHA256 of the identity headers and request line
+// with the secret.
+func signature(r *http.Request, secret []byte) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	for _, v := range []string{
+		"tls-sess-v2",
+		r.Method,
+		host,
+		r.URL.RequestURI(),
+		r.Header.Get(HeaderUser),
+		r.Header.Get(HeaderRoles),
+		r.Header.Get(HeaderDevice),
+		r.Header.Get(HeaderSerial),
+		r.Header.Get(HeaderTime),
+		r.Header.Get(HeaderContentSHA256),
+	} {
+		mac.Write([]byte(v))
+		mac.Write([]byte{'\n'})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// readBody reads the body of the request and replaces it by a copy.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading request body")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// digest returns the SHA-256 of a body, as carried by HeaderContentSHA256.
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// digestKey carries the digest of the body of a request, read before it is
+// forwarded, to the director that signs it.
+type digestKey struct{}
+
+// Config is the configuration of the handler returned by New.
+type Config struct {
+	// Upstream is the URL of the service to forward requests to.
+	Upstream *url.URL
+
+	// Secret signs the identity headers.
+	Secret []byte
+
+	// Revoked lists the certificates to refuse.  None are if it is nil.
+	Revoked *revoke.List
+
+	// Roles are those of which users need at least one.  All users are
+	// allowed if it is empty.
+	Roles []string
+
+	// MaxBody is how large the bodies of requests may be, DefaultMaxBody if
+	// zero.  Larger ones are answered with 413 Request Entity Too Large.
+	MaxBody int64
+}
+
+// New returns an http.Handler that forwards the requests of clients with a
+// valid session certificate to the upstream.  It must be served over TLS with
+// client certificates verified against the session CA, as with
+// httpauth.TLSConfig.
+func New(cfg Config) http.Handler {
+	maxBody := cfg.MaxBody
+	if maxBody == 0 {
+		maxBody = DefaultMaxBody
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(cfg.Upstream)
+	direct := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		direct(r)
+		id, _ := pki.FromContext(r.Context())
+		bodyDigest, _ := r.Context().Value(digestKey{}).(string)
+		sign(r, cfg.Secret, id, time.Now(), bodyDigest)
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logging.Default().Warn("Upstream request failed", "upstream",
+			cfg.Upstream, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	// The body is read before forwarding the request, so that its digest can
+	// be signed in the headers that precede it.
+	forward := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBody+1))
+		if err != nil {
+			http.Error(w, "failed to read the request body",
+				http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > maxBody {
+			http.Error(w, "the request body is too large",
+				http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+
+		ctx := context.WithValue(r.Context(), digestKey{}, digest(body))
+		proxy.ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	auth := httpauth.Middleware(httpauth.Options{
+		Revoked: cfg.Revoked,
+		Roles:   cfg.Roles,
+	})
+	return auth(forward)
+}
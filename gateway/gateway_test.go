@@ -0,0 +1,192 @@
+package gateway_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/gateway"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+var _ = Describe("Gateway", func() {
+	secret := []byte("shared secret")
+	id := pki.Identity{
+		User:   "demo",
+		Roles:  []string{"user", "ops"},
+		Device: "laptop",
+		Serial: "2a",
+	}
+
+	It("Should verify signed identity headers", func() {
+		now := time.Now()
+		r := httptest.NewRequest("POST", "/reports?year=2019",
+			strings.NewReader("total=1"))
+		r.Header.Set(gateway.HeaderUser, "forged")
+		Expect(gateway.Sign(r, secret, id, now)).To(Succeed())
+
+		got, err := gateway.Verify(r, secret, time.Minute, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).Should(Equal(id))
+
+		By("Leaving the body to read")
+		body, err := ioutil.ReadAll(r.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).Should(Equal("total=1"))
+
+		By("Refusing another secret")
+		_, err = gateway.Verify(r, []byte("other"), time.Minute, now)
+		Expect(err).Should(MatchError(ContainSubstring("does not match")))
+
+		By("Refusing old headers")
+		_, err = gateway.Verify(r, secret, time.Minute,
+			now.Add(2*time.Minute))
+		Expect(err).Should(MatchError(ContainSubstring("expired")))
+
+		By("Refusing headers replayed on another request")
+		other := httptest.NewRequest("DELETE", "/reports?year=2019", nil)
+		other.Header = r.Header
+		_, err = gateway.Verify(other, secret, time.Minute, now)
+		Expect(err).Should(HaveOccurred())
+
+		By("Refusing headers replayed to another host")
+		other = httptest.NewRequest("POST",
+			"https://other.example/reports?year=2019",
+			strings.NewReader("total=1"))
+		other.Header = r.Header
+		_, err = gateway.Verify(other, secret, time.Minute, now)
+		Expect(err).Should(MatchError(ContainSubstring("does not match")))
+
+		By("Refusing headers replayed with another body")
+		other = httptest.NewRequest("POST", "/reports?year=2019",
+			strings.NewReader("total=1000"))
+		other.Header = r.Header
+		_, err = gateway.Verify(other, secret, time.Minute, now)
+		Expect(err).Should(MatchError(ContainSubstring("body")))
+
+		By("Refusing changed headers")
+		r.Header.Set(gateway.HeaderRoles, "admin")
+		_, err = gateway.Verify(r, secret, time.Minute, now)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	Describe("Forwarding requests", func() {
+		var (
+			dir      string
+			upstream *httptest.Server
+			seen     chan pki.Identity
+			cert     *x509.Certificate
+			revoked  *revoke.List
+		)
+
+		BeforeEach(func() {
+			dir = tmpDir()
+
+			seen = make(chan pki.Identity, 1)
+			upstream = httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					id, err := gateway.Verify(r, secret, gateway.DefaultMaxAge,
+						time.Now())
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusUnauthorized)
+						return
+					}
+					seen <- id
+				}))
+
+			caKey, err := pki.GenerateKey()
+			Expect(err).ToNot(HaveOccurred())
+			caPEM, err := pki.SelfSign(caKey, "ca")
+			Expect(err).ToNot(HaveOccurred())
+			ca, err := pki.PEMtoCert(caPEM)
+			Expect(err).ToNot(HaveOccurred())
+
+			key, err := pki.GenerateKey()
+			Expect(err).ToNot(HaveOccurred())
+			csr, err := pki.NewCSR(key, "laptop")
+			Expect(err).ToNot(HaveOccurred())
+			certPEM, err := pki.SignIdentity(caKey, ca, csr, time.Hour,
+				"demo", []string{"user"})
+			Expect(err).ToNot(HaveOccurred())
+			cert, err = pki.PEMtoCert(certPEM)
+			Expect(err).ToNot(HaveOccurred())
+
+			revoked, err = revoke.Open(filepath.Join(dir, "revoked.txt"))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			upstream.Close()
+			rmDir(dir)
+		})
+
+		post := func(
+			cfg gateway.Config, body string,
+		) *httptest.ResponseRecorder {
+			u, err := url.Parse(upstream.URL)
+			Expect(err).ToNot(HaveOccurred())
+			cfg.Upstream, cfg.Secret, cfg.Revoked = u, secret, revoked
+
+			r := httptest.NewRequest("POST", "https://gateway/hello",
+				strings.NewReader(body))
+			r.Header.Set(gateway.HeaderUser, "forged")
+			r.TLS = &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{cert}},
+			}
+			w := httptest.NewRecorder()
+			gateway.New(cfg).ServeHTTP(w, r)
+			return w
+		}
+
+		serve := func(cfg gateway.Config) *httptest.ResponseRecorder {
+			return post(cfg, "")
+		}
+
+		It("Should forward the identity of the client", func() {
+			w := serve(gateway.Config{})
+			Expect(w.Code).Should(Equal(http.StatusOK))
+			Expect(<-seen).Should(Equal(pki.Identity{
+				User:   "demo",
+				Roles:  []string{"user"},
+				Device: "laptop",
+				Serial: cert.SerialNumber.Text(16),
+			}))
+		})
+
+		It("Should forward the body signed", func() {
+			Expect(post(gateway.Config{}, "total=1").Code).
+				Should(Equal(http.StatusOK))
+			Expect(seen).Should(Receive())
+
+			By("Refusing bodies over the limit")
+			Expect(post(gateway.Config{MaxBody: 4}, "total=1").Code).
+				Should(Equal(http.StatusRequestEntityTooLarge))
+			Expect(seen).ShouldNot(Receive())
+		})
+
+		It("Should require one of the roles", func() {
+			Expect(serve(gateway.Config{Roles: []string{"admin"}}).Code).
+				Should(Equal(http.StatusForbidden))
+			Expect(serve(gateway.Config{Roles: []string{"admin", "user"}}).
+				Code).Should(Equal(http.StatusOK))
+		})
+
+		It("Should refuse revoked certificates", func() {
+			_, err := revoked.Revoke(cert.SerialNumber.Text(16))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serve(gateway.Config{}).Code).
+				Should(Equal(http.StatusForbidden))
+			Expect(seen).ShouldNot(Receive())
+		})
+	})
+})
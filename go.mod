@@ -1,13 +1,30 @@
 module github.com/KibaFox/tls-usr-sessions
 
-go 1.12
+go 1.21
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/golang/protobuf v1.3.1
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
 	github.com/pkg/errors v0.8.1
-	golang.org/x/crypto v0.0.0-20190513172903-22d7a77e9e5f
-	golang.org/x/sync v0.0.0-20190423024810-112230192c58
+	golang.org/x/crypto v0.11.0
+	golang.org/x/sync v0.1.0
+	golang.org/x/sys v0.10.0
 	google.golang.org/grpc v1.20.1
+	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/qr v0.2.0
+	software.sslmate.com/src/go-pkcs12 v0.4.0
+)
+
+require (
+	github.com/hpcloud/tail v1.0.0 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/term v0.10.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
+	google.golang.org/genproto v0.0.0-20180817151627-c66870c02cf8 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.1 // indirect
 )
@@ -0,0 +1,142 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/audit"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/metrics"
+	"github.com/KibaFox/tls-usr-sessions/otp"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+	"github.com/KibaFox/tls-usr-sessions/throttle"
+)
+
+// totpIssuer names the server in the otpauth:// URIs shown to users.
+const totpIssuer = "tls-sess-demo"
+
+// Admin is used to implement pb.AdminServer
+type Admin struct {
+	Limiters []*throttle.Limiter
+	TOTP     *otp.Store
+
+	// Revoked is where certificates are revoked.  Revocation is disabled if
+	// it is nil.
+	Revoked *revoke.List
+
+	// Audit records the revocations and Metrics counts them.  Either may be
+	// nil.
+	Audit   *audit.Log
+	Metrics *metrics.Server
+}
+
+// NewAdmin creates a new gRPC server that administers the given TOTP store
+// and limiters.
+func NewAdmin(totp *otp.Store, limiters ...*throttle.Limiter) *Admin {
+	return &Admin{Limiters: limiters, TOTP: totp}
+}
+
+// ListLockouts lists the usernames and addresses that are locked out.
+func (s *Admin) ListLockouts(
+	ctx context.Context, req *empty.Empty,
+) (resp *pb.Lockouts, err error) {
+	resp = &pb.Lockouts{}
+	for _, lim := range s.Limiters {
+		if lim == nil {
+			continue
+		}
+		for _, l := range lim.Lockouts() {
+			resp.Lockouts = append(resp.Lockouts, &pb.Lockout{
+				Key:      l.Key,
+				Failures: int32(l.Failures),
+				Until:    l.Until.Unix(),
+			})
+		}
+	}
+	return resp, nil
+}
+
+// ClearLockout forgets the failed logins for a username or address.
+func (s *Admin) ClearLockout(
+	ctx context.Context, req *pb.ClearLockoutRequest,
+) (resp *pb.ClearLockoutResponse, err error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "a key is required")
+	}
+
+	resp = &pb.ClearLockoutResponse{}
+	for _, lim := range s.Limiters {
+		if lim != nil && lim.Clear(req.Key) {
+			resp.Cleared = true
+		}
+	}
+	return resp, nil
+}
+
+// EnrollTOTP generates a new TOTP secret and recovery codes for a user, who
+// must then provide a code at each login.
+func (s *Admin) EnrollTOTP(
+	ctx context.Context, req *pb.EnrollTOTPRequest,
+) (resp *pb.TOTPEnrollment, err error) {
+	if s.TOTP == nil {
+		return nil, status.Error(codes.FailedPrecondition,
+			"TOTP is not enabled on the server")
+	}
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+
+	secret, recovery, err := s.TOTP.Enroll(req.Username)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.TOTPEnrollment{
+		Secret:        secret,
+		Uri:           otp.URI(totpIssuer, req.Username, secret),
+		RecoveryCodes: recovery,
+	}, nil
+}
+
+// RevokeCert revokes a certificate before it expires, so that it is refused
+// from then on.
+func (s *Admin) RevokeCert(
+	ctx context.Context, req *pb.RevokeCertRequest,
+) (resp *pb.RevokeCertResponse, err error) {
+	if s.Revoked == nil {
+		return nil, status.Error(codes.FailedPrecondition,
+			"revocation is not enabled on the server")
+	}
+	serial, err := revoke.Normalize(req.Serial)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	added, err := s.Revoked.Revoke(serial)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !added {
+		return &pb.RevokeCertResponse{}, nil
+	}
+	s.Metrics.Revoked()
+
+	err = s.Audit.Record(audit.Event{
+		Type:      audit.CertRevoked,
+		Method:    "/pb.Admin/RevokeCert",
+		RequestID: logging.RequestID(ctx),
+		Serial:    serial,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("Could not record audit event",
+			"type", audit.CertRevoked, "error", err)
+		return nil, status.Error(codes.Internal,
+			"the certificate was revoked, but could not be recorded")
+	}
+
+	return &pb.RevokeCertResponse{Revoked: true}, nil
+}
@@ -4,37 +4,125 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"crypto/x509"
-	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
+	"github.com/KibaFox/tls-usr-sessions/audit"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/metrics"
+	"github.com/KibaFox/tls-usr-sessions/otp"
 	"github.com/KibaFox/tls-usr-sessions/pb"
 	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/throttle"
+	"github.com/KibaFox/tls-usr-sessions/trace"
 )
 
-// Static username and password for demonstration.
-const (
-	username = "demo"
-	password = "test123" // in prod, store passwords with a password hash + salt
-)
+// DefaultChallengeTTL is how long a login challenge is valid when the
+// configuration does not say otherwise.
+const DefaultChallengeTTL = time.Minute
 
 type AuthConfig struct {
-	AnchorsPEM string
-	CA         *x509.Certificate
-	Key        *ecdsa.PrivateKey
-	UserTTL    time.Duration
+	AnchorsPEM   string
+	CA           *x509.Certificate
+	Key          *ecdsa.PrivateKey
+	UserTTL      time.Duration
+	ChallengeTTL time.Duration
+
+	// UserLimiter and IPLimiter throttle failed logins per username and per
+	// source address.  Logins are not throttled if they are nil.
+	UserLimiter *throttle.Limiter
+	IPLimiter   *throttle.Limiter
+
+	// TOTP holds the users who enrolled in TOTP as a second factor.  No user
+	// is asked for a TOTP code if it is nil.
+	TOTP *otp.Store
+
+	// ChangePassword requires users to choose a new password the first time
+	// they login.
+	ChangePassword bool
+
+	// Terms are the terms of use that users must accept before they are
+	// issued a certificate.  Users are not asked to accept any if empty.
+	Terms string
+
+	// Audit records logins, issued certificates and denials.  No events are
+	// recorded if it is nil.
+	Audit *audit.Log
+
+	// Metrics counts logins and issued certificates.  Nothing is counted if
+	// it is nil.
+	Metrics *metrics.Server
 }
 
 // Auth is used to implement pb.AuthServer
 type Auth struct {
-	Config *AuthConfig
+	config atomic.Value // *AuthConfig
+
+	challenges *challenges
+	users      *users
+	sessions   *sessions
 }
 
 // NewAuth creates a new gRPC server.
 func NewAuth(config *AuthConfig) *Auth {
-	return &Auth{Config: config}
+	s := &Auth{
+		challenges: newChallenges(),
+		users:      newUsers(config.ChangePassword),
+		sessions:   newSessions(),
+	}
+	s.config.Store(config)
+	return s
+}
+
+// Config returns the configuration in use.  It must not be modified.
+func (s *Auth) Config() *AuthConfig {
+	return s.config.Load().(*AuthConfig)
+}
+
+// SetConfig replaces the configuration, such as to rotate the CA, without
+// stopping the server.  Calls in flight keep the configuration they started
+// with.  ChangePassword only applies to users who have not logged in yet.
+func (s *Auth) SetConfig(config *AuthConfig) {
+	s.config.Store(config)
+}
+
+// BeginLogin issues a single-use challenge that the client must embed in the
+// CSR it presents to Login.  This proves the client holds the key for the CSR
+// and prevents a captured login request from being replayed.
+func (s *Auth) BeginLogin(
+	ctx context.Context, req *pb.BeginLoginRequest,
+) (resp *pb.LoginChallenge, err error) {
+	if req.Username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+
+	cfg := s.Config()
+	ttl := cfg.ChallengeTTL
+	if ttl <= 0 {
+		ttl = DefaultChallengeTTL
+	}
+
+	nonce, expires, err := s.challenges.issue(req.Username, peerIP(ctx), ttl)
+	if err == errTooManyChallenges {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.LoginChallenge{
+		Challenge: nonce,
+		Expires:   expires.Unix(),
+	}, nil
 }
 
 // Login allows a user to start a session.  If the login succeeds, then the
@@ -49,18 +137,262 @@ func (s *Auth) Login(
 		return nil, status.Error(codes.InvalidArgument,
 			"username and password are required")
 	}
-	log.Printf("Received: %v", req)
 
-	if req.Username != username || req.Password != password {
-		return nil, status.Error(codes.InvalidArgument,
-			"incorrect username or password")
+	a, err := s.begin(ctx, req.Username)
+	if err != nil {
+		return nil, err
 	}
 
-	cert, err := pki.SignCSR(
-		s.Config.Key, s.Config.CA, req.Csr, s.Config.UserTTL)
+	nonce, err := pki.CSRChallenge(req.Csr)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid CSR")
+	}
+	if nonce == "" || !s.challenges.take(nonce, req.Username) {
+		return nil, s.deny(a, status.Error(codes.FailedPrecondition,
+			"a valid login challenge is required"))
+	}
+
+	err = s.checkPassword(ctx, a, req.Password)
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.LoginResponse{Cert: cert, Anchors: s.Config.AnchorsPEM}, nil
+	if a.cfg.totpRequired(req.Username) {
+		if req.Totp == "" {
+			return nil, status.Error(codes.Unauthenticated,
+				"a TOTP code is required")
+		}
+		err = s.checkTOTP(ctx, a, req.Totp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.users.mustChangePassword(req.Username) ||
+		a.cfg.Terms != "" && !s.users.acceptedTerms(req.Username) {
+		return nil, s.deny(a, status.Error(codes.FailedPrecondition,
+			"further steps are required, use Authenticate to login"))
+	}
+
+	s.succeed(a)
+	return s.issue(ctx, a, req.Csr)
+}
+
+// attempt holds what is known about a login attempt for the limiters, logs
+// and audit events, along with the configuration it started with.
+type attempt struct {
+	cfg       *AuthConfig
+	user      string
+	addr      string
+	method    string
+	requestID string
+	userKey   string
+	ipKey     string
+	log       *logging.Logger
+}
+
+// begin starts a login attempt for the user unless the user or the address
+// the call came from is locked out.
+func (s *Auth) begin(ctx context.Context, user string) (*attempt, error) {
+	method, _ := grpc.Method(ctx)
+	a := &attempt{
+		cfg:       s.Config(),
+		user:      user,
+		addr:      peerIP(ctx),
+		method:    method,
+		requestID: logging.RequestID(ctx),
+		log:       logging.FromContext(ctx).With("user", user),
+	}
+	a.userKey, a.ipKey = "user:"+a.user, "ip:"+a.addr
+
+	err := allow(ctx, a.cfg.UserLimiter, a.userKey)
+	if err != nil {
+		return nil, s.deny(a, err)
+	}
+	err = allow(ctx, a.cfg.IPLimiter, a.ipKey)
+	if err != nil {
+		return nil, s.deny(a, err)
+	}
+
+	return a, nil
+}
+
+func (s *Auth) checkPassword(
+	ctx context.Context, a *attempt, pass string,
+) error {
+	_, span := trace.Start(ctx, "users.check", trace.Internal)
+	span.SetAttribute("enduser.id", a.user)
+	ok := s.users.check(a.user, pass)
+	span.SetAttribute("auth.ok", ok)
+	span.End()
+
+	if !ok {
+		s.fail(a, "incorrect password")
+		return status.Error(codes.InvalidArgument,
+			"incorrect username or password")
+	}
+	return nil
+}
+
+func (s *Auth) checkTOTP(ctx context.Context, a *attempt, code string) error {
+	_, span := trace.Start(ctx, "otp.Verify", trace.Internal)
+	span.SetAttribute("enduser.id", a.user)
+	ok, err := a.cfg.TOTP.Verify(a.user, code)
+	span.SetAttribute("auth.ok", ok)
+	span.SetError(err)
+	span.End()
+
+	if err != nil {
+		a.log.Error("Could not verify TOTP code", "error", err)
+		return status.Error(codes.Internal, "could not verify code")
+	}
+	if !ok {
+		s.fail(a, "incorrect TOTP code")
+		return status.Error(codes.InvalidArgument, "incorrect TOTP code")
+	}
+	return nil
+}
+
+func (s *Auth) fail(a *attempt, reason string) {
+	a.log.Info("Failed login", "reason", reason)
+	_ = s.record(a, audit.Event{Type: audit.LoginFailed, Reason: reason})
+	a.cfg.Metrics.Login(metrics.LoginFailed)
+	fail(a.log, a.cfg.UserLimiter, a.userKey)
+	fail(a.log, a.cfg.IPLimiter, a.ipKey)
+}
+
+func (s *Auth) succeed(a *attempt) {
+	a.log.Info("Successful login")
+	_ = s.record(a, audit.Event{Type: audit.LoginSucceeded})
+	a.cfg.Metrics.Login(metrics.LoginSucceeded)
+	if a.cfg.UserLimiter != nil {
+		a.cfg.UserLimiter.Succeed(a.userKey)
+	}
+}
+
+// deny records that a policy denied the attempt and returns the error.
+func (s *Auth) deny(a *attempt, err error) error {
+	_ = s.record(a, audit.Event{
+		Type:   audit.PolicyDenied,
+		Reason: status.Convert(err).Message(),
+	})
+	if status.Code(err) == codes.ResourceExhausted {
+		a.cfg.Metrics.Login(metrics.LoginLocked)
+	} else {
+		a.cfg.Metrics.Login(metrics.LoginDenied)
+	}
+	return err
+}
+
+// record adds the details of the attempt to the event and records it in the
+// audit log.
+func (s *Auth) record(a *attempt, e audit.Event) error {
+	e.User, e.Addr = a.user, a.addr
+	e.Method, e.RequestID = a.method, a.requestID
+
+	err := a.cfg.Audit.Record(e)
+	if err != nil {
+		a.log.Error("Could not record audit event", "type", e.Type,
+			"error", err)
+	}
+	return err
+}
+
+// issue signs the CSR of a user who logged in.  The certificate is not handed
+// out unless its issuance, or the renewal of a session, was recorded in the
+// audit log.
+func (s *Auth) issue(
+	ctx context.Context, a *attempt, csr string,
+) (resp *pb.LoginResponse, err error) {
+	_, span := trace.Start(ctx, "pki.SignCSR", trace.Internal)
+	certPEM, err := pki.SignIdentity(a.cfg.Key, a.cfg.CA, csr,
+		a.cfg.UserTTL, a.user, s.users.rolesOf(a.user))
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	cert, err := pki.PEMtoCert(certPEM)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	e := audit.Event{
+		Type:        audit.CertIssued,
+		Serial:      cert.SerialNumber.Text(16),
+		Subject:     cert.Subject.String(),
+		Fingerprint: pki.Fingerprint(cert),
+	}
+	if e.Renews = s.renewed(ctx, a.user, e.Fingerprint); e.Renews != "" {
+		e.Type = audit.CertRenewed
+	}
+	err = s.record(a, e)
+	if err != nil {
+		return nil, status.Error(codes.Internal,
+			"could not record the certificate")
+	}
+	s.sessions.add(a.user, e.Fingerprint, e.Serial, cert.NotAfter)
+	a.cfg.Metrics.Issued()
+
+	return &pb.LoginResponse{
+		Cert: certPEM, Anchors: a.cfg.AnchorsPEM,
+	}, nil
+}
+
+// renewed returns the serial of the session a login of the user renews, if
+// any: that of the session certificate the client presented, or else that of
+// a session still valid issued to the user for the key with the fingerprint.
+func (s *Auth) renewed(ctx context.Context, user, fingerprint string) string {
+	if prev, err := clientCert(ctx); err == nil {
+		id, err := pki.CertIdentity(prev)
+		if err == nil && id.User == user && time.Now().Before(prev.NotAfter) {
+			return id.Serial
+		}
+	}
+	return s.sessions.renewed(user, fingerprint)
+}
+
+func (c *AuthConfig) totpRequired(user string) bool {
+	return c.TOTP != nil && c.TOTP.Enrolled(user)
+}
+
+// allow checks the limiter for the key.  If the key is locked out, the error to
+// return is given and the retry-after trailer is set on the call.
+func allow(ctx context.Context, lim *throttle.Limiter, key string) error {
+	if lim == nil {
+		return nil
+	}
+
+	retryAfter, ok := lim.Allow(key)
+	if ok {
+		return nil
+	}
+
+	secs := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+	_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", secs))
+	return status.Errorf(codes.ResourceExhausted,
+		"too many failed logins, retry after %s seconds", secs)
+}
+
+func fail(log *logging.Logger, lim *throttle.Limiter, key string) {
+	if lim == nil {
+		return
+	}
+	if lockout := lim.Fail(key); lockout > 0 {
+		log.Warn("Locked out", "key", key, "lockout", lockout)
+	}
+}
+
+// peerIP returns the address the call came from without the port.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
 }
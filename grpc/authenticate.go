@@ -0,0 +1,172 @@
+package grpc
+
+import (
+	"io"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+// minPasswordLength is the shortest new password that is accepted.
+const minPasswordLength = 8
+
+// Authenticate logs a user in over several rounds.  After the client names the
+// user, the server challenges it for the password, a TOTP code if the user
+// enrolled, a new password if it must be changed, acceptance of the terms of
+// use if there are any, and finally a CSR carrying a nonce to sign.
+func (s *Auth) Authenticate(stream pb.Auth_AuthenticateServer) error {
+	ctx := stream.Context()
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	start := req.GetStart()
+	if start == nil || start.Username == "" {
+		return status.Error(codes.InvalidArgument,
+			"the first request must name the user")
+	}
+	user := start.Username
+
+	a, err := s.begin(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	pass, err := ask(stream, &pb.Challenge{
+		Type:   pb.Challenge_PASSWORD,
+		Prompt: "Enter Password: ",
+	})
+	if err != nil {
+		return err
+	}
+	err = s.checkPassword(ctx, a, pass)
+	if err != nil {
+		return err
+	}
+
+	if a.cfg.totpRequired(user) {
+		var code string
+		code, err = ask(stream, &pb.Challenge{
+			Type:   pb.Challenge_OTP,
+			Prompt: "Enter TOTP Code: ",
+		})
+		if err != nil {
+			return err
+		}
+		err = s.checkTOTP(ctx, a, code)
+		if err != nil {
+			return err
+		}
+	}
+	s.succeed(a)
+
+	if s.users.mustChangePassword(user) {
+		var newPass string
+		newPass, err = ask(stream, &pb.Challenge{
+			Type:   pb.Challenge_NEW_PASSWORD,
+			Prompt: "Enter New Password: ",
+			Text:   "Your password has expired and must be changed.",
+		})
+		if err != nil {
+			return err
+		}
+		if len(newPass) < minPasswordLength {
+			return status.Errorf(codes.InvalidArgument,
+				"the new password must be at least %d characters",
+				minPasswordLength)
+		}
+		if newPass == pass {
+			return status.Error(codes.InvalidArgument,
+				"the new password must differ from the old one")
+		}
+		s.users.setPassword(user, newPass)
+	}
+
+	if a.cfg.Terms != "" && !s.users.acceptedTerms(user) {
+		var answer string
+		answer, err = ask(stream, &pb.Challenge{
+			Type:   pb.Challenge_TERMS,
+			Prompt: "Do you accept these terms? [yes/no]: ",
+			Text:   a.cfg.Terms,
+		})
+		if err != nil {
+			return err
+		}
+		if !accepts(answer) {
+			return s.deny(a, status.Error(codes.PermissionDenied,
+				"the terms of use must be accepted to login"))
+		}
+		s.users.acceptTerms(user)
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	csr, err := ask(stream, &pb.Challenge{
+		Type:  pb.Challenge_CSR,
+		Nonce: nonce,
+	})
+	if err != nil {
+		return err
+	}
+	got, err := pki.CSRChallenge(csr)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "invalid CSR")
+	}
+	if got != nonce {
+		return s.deny(a, status.Error(codes.FailedPrecondition,
+			"the CSR must carry the challenge nonce"))
+	}
+
+	resp, err := s.issue(ctx, a, csr)
+	if err != nil {
+		return err
+	}
+
+	return stream.Send(&pb.AuthenticateResponse{
+		Step: &pb.AuthenticateResponse_Result{Result: resp},
+	})
+}
+
+// ask sends a challenge to the client and waits for the answer.
+func ask(
+	stream pb.Auth_AuthenticateServer, ch *pb.Challenge,
+) (answer string, err error) {
+	err = stream.Send(&pb.AuthenticateResponse{
+		Step: &pb.AuthenticateResponse_Challenge{Challenge: ch},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return "", status.Error(codes.Canceled,
+			"the client stopped answering challenges")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ans := req.GetAnswer()
+	if ans == nil {
+		return "", status.Errorf(codes.InvalidArgument,
+			"expected an answer to the %s challenge", ch.Type)
+	}
+
+	return ans.Value, nil
+}
+
+func accepts(answer string) bool {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes", "accept":
+		return true
+	}
+	return false
+}
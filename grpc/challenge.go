@@ -0,0 +1,127 @@
+package grpc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// challengeSize is the number of random bytes in a login challenge.
+const challengeSize = 32
+
+// Bounds on the challenges outstanding, since anyone can ask for them.  They
+// are counted by source address rather than by user, so that flooding the
+// challenges of a user does not stop that user from logging in.
+const (
+	MaxChallenges          = 16384
+	MaxChallengesPerSource = 256
+)
+
+// pruneInterval is how often expired challenges are removed, unless a bound
+// is reached first.
+const pruneInterval = time.Second
+
+// errTooManyChallenges refuses a challenge when a bound is reached.
+var errTooManyChallenges = errors.New("too many login challenges outstanding")
+
+type challenge struct {
+	username string
+	source   string
+	expires  time.Time
+}
+
+// challenges keeps track of the login challenges that were issued and not yet
+// used.  Each challenge can be taken only once.
+type challenges struct {
+	mu       sync.Mutex
+	issued   map[string]challenge
+	bySource map[string]int
+	pruned   time.Time
+}
+
+func newChallenges() *challenges {
+	return &challenges{
+		issued:   make(map[string]challenge),
+		bySource: make(map[string]int),
+	}
+}
+
+// issue creates a new challenge for the given user, asked for from the source
+// address, that is valid for ttl.  It returns errTooManyChallenges if too many
+// are outstanding, overall or from the source.
+func (c *challenges) issue(
+	username, source string, ttl time.Duration,
+) (nonce string, expires time.Time, err error) {
+	nonce, err = newNonce()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expires = now.Add(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	full := func() bool {
+		return len(c.issued) >= MaxChallenges ||
+			c.bySource[source] >= MaxChallengesPerSource
+	}
+	if full() || now.Sub(c.pruned) >= pruneInterval {
+		c.prune(now)
+	}
+	if full() {
+		return "", time.Time{}, errTooManyChallenges
+	}
+	c.issued[nonce] = challenge{
+		username: username, source: source, expires: expires,
+	}
+	c.bySource[source]++
+
+	return nonce, expires, nil
+}
+
+// take consumes a challenge.  It reports whether the challenge was issued for
+// the given user and has not expired.  A challenge cannot be taken twice.
+func (c *challenges) take(nonce, username string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.issued[nonce]
+	if !ok {
+		return false
+	}
+	c.remove(nonce, ch)
+
+	return ch.username == username && time.Now().Before(ch.expires)
+}
+
+// prune removes expired challenges.  The caller must hold the lock.
+func (c *challenges) prune(now time.Time) {
+	c.pruned = now
+	for nonce, ch := range c.issued {
+		if !now.Before(ch.expires) {
+			c.remove(nonce, ch)
+		}
+	}
+}
+
+// remove forgets a challenge.  The caller must hold the lock.
+func (c *challenges) remove(nonce string, ch challenge) {
+	delete(c.issued, nonce)
+	if c.bySource[ch.source]--; c.bySource[ch.source] <= 0 {
+		delete(c.bySource, ch.source)
+	}
+}
+
+// newNonce generates a random value for a challenge.
+func newNonce() (nonce string, err error) {
+	byt := make([]byte, challengeSize)
+	_, err = rand.Read(byt)
+	if err != nil {
+		return "", errors.Wrap(err, "generating nonce")
+	}
+	return base64.RawURLEncoding.EncodeToString(byt), nil
+}
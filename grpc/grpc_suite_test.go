@@ -0,0 +1,97 @@
+package grpc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	srv "github.com/KibaFox/tls-usr-sessions/grpc"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+func TestGRPC(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "gRPC Suite")
+}
+
+// fromAddr returns a context for a call from the given IP address.
+func fromAddr(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000},
+	})
+}
+
+// fromCert returns a context for a call from a client that presented the
+// verified certificate, or none if cert is nil.
+func fromCert(cert *x509.Certificate) context.Context {
+	var state tls.ConnectionState
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 50000},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
+
+// sessionCert returns a session certificate for the user, signed by a new CA.
+func sessionCert(user string, roles ...string) *x509.Certificate {
+	caKey, err := pki.GenerateKey()
+	Expect(err).ToNot(HaveOccurred())
+	caPEM, err := pki.SelfSign(caKey, "ca")
+	Expect(err).ToNot(HaveOccurred())
+	ca, err := pki.PEMtoCert(caPEM)
+	Expect(err).ToNot(HaveOccurred())
+
+	key, err := pki.GenerateKey()
+	Expect(err).ToNot(HaveOccurred())
+	csr, err := pki.NewCSR(key, "laptop")
+	Expect(err).ToNot(HaveOccurred())
+	certPEM, err := pki.SignIdentity(caKey, ca, csr, time.Hour, user, roles)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := pki.PEMtoCert(certPEM)
+	Expect(err).ToNot(HaveOccurred())
+	return cert
+}
+
+// loginWith logs the demo user in to auth with a CSR for the key, and returns
+// the certificate issued.
+func loginWith(
+	ctx context.Context, auth *srv.Auth, key *ecdsa.PrivateKey,
+) *x509.Certificate {
+	chal, err := auth.BeginLogin(ctx,
+		&pb.BeginLoginRequest{Username: "demo"})
+	Expect(err).ToNot(HaveOccurred())
+	csr, err := pki.NewChallengeCSR(key, "laptop", chal.Challenge)
+	Expect(err).ToNot(HaveOccurred())
+	resp, err := auth.Login(ctx, &pb.LoginRequest{
+		Username: "demo",
+		Password: "test123",
+		Csr:      csr,
+	})
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := pki.PEMtoCert(resp.Cert)
+	Expect(err).ToNot(HaveOccurred())
+	return cert
+}
+
+// contextStream is a server stream that only has a context.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
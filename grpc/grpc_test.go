@@ -0,0 +1,205 @@
+package grpc_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/audit"
+	srv "github.com/KibaFox/tls-usr-sessions/grpc"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+var _ = Describe("Auth", func() {
+	It("Bounds the challenges outstanding from a source", func() {
+		auth := srv.NewAuth(&srv.AuthConfig{ChallengeTTL: time.Minute})
+		req := &pb.BeginLoginRequest{Username: "demo"}
+
+		for i := 0; i < srv.MaxChallengesPerSource; i++ {
+			_, err := auth.BeginLogin(fromAddr("192.0.2.1"), req)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		_, err := auth.BeginLogin(fromAddr("192.0.2.1"), req)
+		Expect(status.Code(err)).Should(Equal(codes.ResourceExhausted))
+
+		By("Still issuing challenges for the user to other sources")
+		_, err = auth.BeginLogin(fromAddr("192.0.2.2"), req)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Issues challenges again once they expire", func() {
+		auth := srv.NewAuth(&srv.AuthConfig{
+			ChallengeTTL: 10 * time.Millisecond,
+		})
+		req := &pb.BeginLoginRequest{Username: "demo"}
+
+		for i := 0; i < srv.MaxChallengesPerSource; i++ {
+			_, err := auth.BeginLogin(fromAddr("192.0.2.1"), req)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		time.Sleep(20 * time.Millisecond)
+		_, err := auth.BeginLogin(fromAddr("192.0.2.1"), req)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Records logins that renew a session", func() {
+		dir, err := ioutil.TempDir("", "auth")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		logPath := filepath.Join(dir, "audit.log")
+		auditLog, err := audit.Open(logPath)
+		Expect(err).ToNot(HaveOccurred())
+		defer auditLog.Close()
+
+		caKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		caPEM, err := pki.SelfSign(caKey, "ca")
+		Expect(err).ToNot(HaveOccurred())
+		ca, err := pki.PEMtoCert(caPEM)
+		Expect(err).ToNot(HaveOccurred())
+		auth := srv.NewAuth(&srv.AuthConfig{
+			CA:           ca,
+			Key:          caKey,
+			UserTTL:      time.Hour,
+			ChallengeTTL: time.Minute,
+			Audit:        auditLog,
+		})
+
+		login := func(ctx context.Context) *x509.Certificate {
+			key, err := pki.GenerateKey()
+			Expect(err).ToNot(HaveOccurred())
+			return loginWith(ctx, auth, key)
+		}
+		lastEvent := func() audit.Event {
+			byt, err := ioutil.ReadFile(logPath)
+			Expect(err).ToNot(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(byt)), "\n")
+			var e audit.Event
+			Expect(json.Unmarshal([]byte(lines[len(lines)-1]), &e)).To(
+				Succeed())
+			return e
+		}
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		first := loginWith(fromAddr("192.0.2.1"), auth, key)
+		Expect(lastEvent().Type).Should(Equal(audit.CertIssued))
+
+		By("Renewing the session of the same key")
+		second := loginWith(fromAddr("192.0.2.1"), auth, key)
+		e := lastEvent()
+		Expect(e.Type).Should(Equal(audit.CertRenewed))
+		Expect(e.Serial).Should(Equal(second.SerialNumber.Text(16)))
+		Expect(e.Renews).Should(Equal(first.SerialNumber.Text(16)))
+
+		By("Renewing the session certificate presented")
+		third := login(fromCert(second))
+		e = lastEvent()
+		Expect(e.Type).Should(Equal(audit.CertRenewed))
+		Expect(e.Serial).Should(Equal(third.SerialNumber.Text(16)))
+		Expect(e.Renews).Should(Equal(second.SerialNumber.Text(16)))
+
+		By("Issuing a new session for a new key")
+		login(fromAddr("192.0.2.1"))
+		e = lastEvent()
+		Expect(e.Type).Should(Equal(audit.CertIssued))
+		Expect(e.Renews).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("Identity interceptors", func() {
+	var (
+		dir     string
+		revoked *revoke.List
+		unary   grpc.UnaryServerInterceptor
+		stream  grpc.StreamServerInterceptor
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "grpc")
+		Expect(err).ToNot(HaveOccurred())
+		revoked, err = revoke.Open(filepath.Join(dir, "revoked.txt"))
+		Expect(err).ToNot(HaveOccurred())
+
+		unary = srv.UnaryIdentityInterceptor(revoked)
+		stream = srv.StreamIdentityInterceptor(revoked)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	// call returns the identity the handlers of a unary and a streaming call
+	// from ctx find in their context, failing if they disagree.
+	call := func(ctx context.Context) (pki.Identity, error) {
+		var fromUnary, fromStream pki.Identity
+		_, unaryErr := unary(ctx, nil, &grpc.UnaryServerInfo{},
+			func(ctx context.Context, _ interface{}) (interface{}, error) {
+				var ok bool
+				fromUnary, ok = pki.FromContext(ctx)
+				Expect(ok).To(BeTrue())
+				return nil, nil
+			})
+		streamErr := stream(nil, &contextStream{ctx: ctx},
+			&grpc.StreamServerInfo{},
+			func(_ interface{}, ss grpc.ServerStream) error {
+				var ok bool
+				fromStream, ok = pki.FromContext(ss.Context())
+				Expect(ok).To(BeTrue())
+				return nil
+			})
+		Expect(status.Code(streamErr)).Should(Equal(status.Code(unaryErr)))
+		Expect(fromStream).Should(Equal(fromUnary))
+		return fromUnary, unaryErr
+	}
+
+	It("Puts the identity of the client in the context", func() {
+		cert := sessionCert("demo", "user", "admin")
+		id, err := call(fromCert(cert))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).Should(Equal(pki.Identity{
+			User:   "demo",
+			Roles:  []string{"user", "admin"},
+			Device: "laptop",
+			Serial: cert.SerialNumber.Text(16),
+		}))
+	})
+
+	It("Refuses calls without a peer", func() {
+		_, err := call(context.Background())
+		Expect(status.Code(err)).Should(Equal(codes.Unauthenticated))
+	})
+
+	It("Refuses calls without a verified certificate", func() {
+		By("Refusing connections without TLS")
+		_, err := call(fromAddr("192.0.2.1"))
+		Expect(status.Code(err)).Should(Equal(codes.Unauthenticated))
+
+		By("Refusing TLS connections without a verified chain")
+		_, err = call(fromCert(nil))
+		Expect(status.Code(err)).Should(Equal(codes.Unauthenticated))
+	})
+
+	It("Refuses revoked certificates", func() {
+		cert := sessionCert("demo", "user")
+		_, err := revoked.Revoke(cert.SerialNumber.Text(16))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = call(fromCert(cert))
+		Expect(status.Code(err)).Should(Equal(codes.PermissionDenied))
+	})
+})
@@ -0,0 +1,95 @@
+package grpc
+
+import (
+	"context"
+	"crypto/x509"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+// UnaryIdentityInterceptor returns an interceptor that puts the identity of the
+// client, taken from its session certificate, in the context of each call as
+// httpauth does for HTTP requests.  Calls from clients whose certificate was
+// revoked since the connection was established are refused.
+func UnaryIdentityInterceptor(
+	revoked *revoke.List,
+) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		ctx, err = identify(ctx, revoked)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamIdentityInterceptor is like UnaryIdentityInterceptor for streaming
+// calls.
+func StreamIdentityInterceptor(
+	revoked *revoke.List,
+) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := identify(ss.Context(), revoked)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// identify returns the context with the identity of the client.
+func identify(
+	ctx context.Context, revoked *revoke.List,
+) (context.Context, error) {
+	cert, err := clientCert(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := revoked.Check(cert); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	id, err := pki.CertIdentity(cert)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied,
+			"invalid client certificate")
+	}
+	return pki.NewContext(ctx, id), nil
+}
+
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// clientCert returns the verified certificate the client presented.
+func clientCert(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated,
+			"a client certificate is required")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return nil, status.Error(codes.Unauthenticated,
+			"a client certificate is required")
+	}
+	return info.State.VerifiedChains[0][0], nil
+}
@@ -2,19 +2,35 @@ package grpc
 
 import (
 	"context"
-	"log"
+	"crypto/ecdsa"
+	"strconv"
+	"time"
 
 	"github.com/golang/protobuf/ptypes/empty"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"github.com/KibaFox/tls-usr-sessions/audit"
+	"github.com/KibaFox/tls-usr-sessions/logging"
 	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
 )
 
 // Protected is used to implement pb.ProtectedServer
-type Protected struct{}
+type Protected struct {
+	// CAKey returns the key of the CA, which also signs the SSH certificates.
+	CAKey func() *ecdsa.PrivateKey
 
-// NewProtected creates a new gRPC server.
-func NewProtected() *Protected {
-	return &Protected{}
+	// Audit records the SSH certificates issued, which are not handed out
+	// unless they were recorded.  A nil Audit discards the events.
+	Audit *audit.Log
+}
+
+// NewProtected creates a new gRPC server, which signs SSH certificates with
+// the CA key returned by caKey at each request, so that it follows reloads.
+func NewProtected(caKey func() *ecdsa.PrivateKey) *Protected {
+	return &Protected{CAKey: caKey}
 }
 
 // MOTD will return a message-of-the-day bulletin.
@@ -24,6 +40,60 @@ func (s *Protected) MOTD(
 	resp = &pb.Bulletin{
 		Bulletin: "Hello and welcome!",
 	}
-	log.Println("Received: request for MOTD")
+	id, _ := pki.FromContext(ctx)
+	logging.FromContext(ctx).Debug("Sending MOTD", "user", id.User)
 	return resp, nil
 }
+
+// IssueSSHCert signs an OpenSSH user certificate for the public key, with the
+// user of the session as its principal, which expires with the session.
+func (s *Protected) IssueSSHCert(
+	ctx context.Context, req *pb.SSHCertRequest,
+) (*pb.SSHCert, error) {
+	id, ok := pki.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated,
+			"a client certificate is required")
+	}
+	session, err := clientCert(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	caKey := s.CAKey()
+	cert, err := pki.SignSSHCert(caKey, req.PublicKey, id, session.NotAfter)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	caPub, err := pki.SSHPublicKey(caKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	serial := strconv.FormatUint(cert.Serial, 10)
+	err = s.Audit.Record(audit.Event{
+		Type:        audit.SSHCertIssued,
+		User:        id.User,
+		Addr:        peerIP(ctx),
+		Method:      "/pb.Protected/IssueSSHCert",
+		RequestID:   logging.RequestID(ctx),
+		Serial:      serial,
+		Subject:     cert.KeyId,
+		Fingerprint: ssh.FingerprintSHA256(cert.Key),
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("Could not record audit event",
+			"type", audit.SSHCertIssued, "error", err)
+		return nil, status.Error(codes.Internal,
+			"could not record the SSH certificate")
+	}
+
+	expires := time.Unix(int64(cert.ValidBefore), 0)
+	logging.FromContext(ctx).Info("Issued SSH certificate", "user", id.User,
+		"serial", serial, "expires", expires)
+	return &pb.SSHCert{
+		Certificate: string(ssh.MarshalAuthorizedKey(cert)),
+		CaPublicKey: caPub,
+		Expires:     expires.Unix(),
+	}, nil
+}
@@ -0,0 +1,57 @@
+package grpc
+
+import (
+	"sync"
+	"time"
+)
+
+type session struct {
+	serial  string
+	expires time.Time
+}
+
+// sessions remembers the certificates issued to each user until they expire,
+// by the fingerprint of their key, so that a login with the key of a session
+// that is still valid is recorded as its renewal.
+type sessions struct {
+	mu     sync.Mutex
+	byUser map[string]map[string]session
+}
+
+func newSessions() *sessions {
+	return &sessions{byUser: make(map[string]map[string]session)}
+}
+
+// add remembers the session issued to the user for the key with the
+// fingerprint, replacing any before it, and forgets the user's sessions that
+// expired.
+func (s *sessions) add(user, fingerprint, serial string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, ok := s.byUser[user]
+	if !ok {
+		keys = make(map[string]session)
+		s.byUser[user] = keys
+	}
+	now := time.Now()
+	for fp, sess := range keys {
+		if !now.Before(sess.expires) {
+			delete(keys, fp)
+		}
+	}
+	keys[fingerprint] = session{serial: serial, expires: expires}
+}
+
+// renewed returns the serial of the user's session for the key with the
+// fingerprint, or "" if there is none or it expired.
+func (s *sessions) renewed(user, fingerprint string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.byUser[user][fingerprint]
+	if !ok || !time.Now().Before(sess.expires) {
+		return ""
+	}
+	return sess.serial
+}
@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"crypto/subtle"
+	"sync"
+)
+
+// Static username and password for demonstration.
+const (
+	username = "demo"
+	password = "test123" // in prod, store passwords with a password hash + salt
+)
+
+// roles are those of the demo account, which are written in the certificates
+// issued to it.
+var roles = []string{"user"}
+
+// users holds the demo accounts along with what they still have to do before
+// they can login, such as changing their password or accepting the terms.
+type users struct {
+	mu         sync.Mutex
+	passwords  map[string]string
+	roles      map[string][]string
+	mustChange map[string]bool
+	accepted   map[string]bool
+}
+
+func newUsers(mustChangePassword bool) *users {
+	return &users{
+		passwords:  map[string]string{username: password},
+		roles:      map[string][]string{username: roles},
+		mustChange: map[string]bool{username: mustChangePassword},
+		accepted:   make(map[string]bool),
+	}
+}
+
+// check reports whether the password is correct for the user.
+func (u *users) check(user, pass string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	want, ok := u.passwords[user]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(pass)) == 1
+}
+
+// rolesOf returns the roles of the user.
+func (u *users) rolesOf(user string) []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.roles[user]
+}
+
+// mustChangePassword reports whether the user has to choose a new password
+// before they can login.
+func (u *users) mustChangePassword(user string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.mustChange[user]
+}
+
+func (u *users) setPassword(user, pass string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.passwords[user] = pass
+	delete(u.mustChange, user)
+}
+
+// acceptedTerms reports whether the user accepted the terms of use.
+func (u *users) acceptedTerms(user string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.accepted[user]
+}
+
+func (u *users) acceptTerms(user string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.accepted[user] = true
+}
@@ -0,0 +1,149 @@
+// Package httpauth authenticates the clients of HTTP servers with the session
+// certificates issued at login, so that REST services can share sessions with
+// the gRPC ones.
+//
+// TLSConfig verifies the client certificates against the session CA during
+// the handshake.  Middleware then checks each request against the revocation
+// list and the roles required, and puts the identity of the client in the
+// request's context, where it is read with pki.FromContext as in the gRPC
+// servers.
+package httpauth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+// LoadAnchors loads the trust anchors of the session CA from a PEM file, such
+// as the root.pem saved at login or the server's CA certificate.
+func LoadAnchors(path string) (*x509.CertPool, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading anchors")
+	}
+	certs, err := pki.PEMtoCerts(string(raw))
+	if err != nil {
+		return nil, errors.Wrap(err, "loading anchors")
+	}
+
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// TLSConfig returns the configuration of an HTTPS server presenting cert that
+// requires client certificates signed by the anchors and not revoked.
+func TLSConfig(
+	cert tls.Certificate, anchors *x509.CertPool, revoked *revoke.List,
+) *tls.Config {
+	return &tls.Config{
+		Certificates:          []tls.Certificate{cert},
+		ClientAuth:            tls.RequireAndVerifyClientCert,
+		ClientCAs:             anchors,
+		VerifyPeerCertificate: revoked.VerifyPeerCertificate,
+		MinVersion:            tls.VersionTLS12,
+		NextProtos:            []string{"h2", "http/1.1"},
+	}
+}
+
+// Options are the checks Middleware applies to each request.
+type Options struct {
+	// Revoked lists the certificates to refuse.  None are if it is nil.
+	Revoked *revoke.List
+
+	// Roles are those of which users need at least one.  All users are
+	// allowed if it is empty.
+	Roles []string
+}
+
+// Middleware returns a wrapper for handlers that only lets through the
+// requests of clients with a verified session certificate that passes the
+// checks of opts.  Other requests are answered with 401 Unauthorized or 403
+// Forbidden.  The certificate must have been verified during the handshake,
+// as with TLSConfig.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logging.Default().With("method", r.Method,
+				"path", r.URL.Path, "client", r.RemoteAddr)
+
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				log.Warn("Request denied", "reason", "no client certificate")
+				http.Error(w, "a client certificate is required",
+					http.StatusUnauthorized)
+				return
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+
+			id, err := pki.CertIdentity(cert)
+			if err != nil {
+				log.Warn("Request denied", "reason", err)
+				http.Error(w, "invalid client certificate",
+					http.StatusForbidden)
+				return
+			}
+			log = log.With("user", id.User, "serial", id.Serial)
+
+			if err = opts.Revoked.Check(cert); err != nil {
+				log.Warn("Request denied", "reason", err)
+				http.Error(w, "the certificate is revoked",
+					http.StatusForbidden)
+				return
+			}
+			if !HasRole(id, opts.Roles...) {
+				log.Warn("Request denied", "reason", "missing role")
+				http.Error(w, "access denied", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(pki.NewContext(r.Context(), id)))
+		})
+	}
+}
+
+// RequireRoles returns a wrapper for handlers behind Middleware that only lets
+// through users with one of the roles, for routes that need more than the
+// rest of the server.
+func RequireRoles(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok := pki.FromContext(r.Context())
+			if !ok {
+				http.Error(w, "a client certificate is required",
+					http.StatusUnauthorized)
+				return
+			}
+			if !HasRole(id, roles...) {
+				http.Error(w, "access denied", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HasRole reports whether the user has one of the roles, or true if none are
+// given.
+func HasRole(id pki.Identity, roles ...string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	for _, want := range roles {
+		for _, role := range id.Roles {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
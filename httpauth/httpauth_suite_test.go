@@ -0,0 +1,26 @@
+package httpauth_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestHttpauth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HTTP Auth Suite")
+}
+
+func tmpDir() string {
+	dir, err := ioutil.TempDir("", "temp")
+	Expect(err).ToNot(HaveOccurred())
+	return dir
+}
+
+func rmDir(path string) {
+	err := os.RemoveAll(path)
+	Expect(err).ToNot(HaveOccurred())
+}
@@ -0,0 +1,152 @@
+package httpauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/httpauth"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+var _ = Describe("HTTP Auth", func() {
+	var (
+		dir     string
+		caKey   *ecdsa.PrivateKey
+		ca      *x509.Certificate
+		anchors *x509.CertPool
+		revoked *revoke.List
+	)
+
+	BeforeEach(func() {
+		dir = tmpDir()
+
+		var err error
+		caKey, err = pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		caPEM, err := pki.SelfSign(caKey, "tls-sess-demo")
+		Expect(err).ToNot(HaveOccurred())
+		ca, err = pki.PEMtoCert(caPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		caPath := filepath.Join(dir, "root.pem")
+		Expect(pki.SaveCert(caPEM, caPath)).To(Succeed())
+		anchors, err = httpauth.LoadAnchors(caPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		revoked, err = revoke.Open(filepath.Join(dir, "revoked.txt"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		rmDir(dir)
+	})
+
+	// issue returns a client certificate for the user signed by the CA.
+	issue := func(user string, roles ...string) tls.Certificate {
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		csr, err := pki.NewCSR(key, "laptop")
+		Expect(err).ToNot(HaveOccurred())
+		certPEM, err := pki.SignIdentity(caKey, ca, csr, time.Hour, user,
+			roles)
+		Expect(err).ToNot(HaveOccurred())
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		Expect(err).ToNot(HaveOccurred())
+		pair, err := tls.X509KeyPair([]byte(certPEM), pem.EncodeToMemory(
+			&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+		Expect(err).ToNot(HaveOccurred())
+		pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		return pair
+	}
+
+	// start serves the handler behind the middleware over HTTPS.
+	start := func(h http.Handler, opts httpauth.Options) *httptest.Server {
+		srv := httptest.NewUnstartedServer(httpauth.Middleware(opts)(h))
+		srv.TLS = httpauth.TLSConfig(tls.Certificate{
+			Certificate: [][]byte{ca.Raw},
+			PrivateKey:  caKey,
+		}, anchors, opts.Revoked)
+		srv.StartTLS()
+		return srv
+	}
+
+	get := func(srv *httptest.Server, cert tls.Certificate) (int, string) {
+		cli := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:   "tls-sess-demo",
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      anchors,
+			},
+		}}
+		res, err := cli.Get(srv.URL)
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		Expect(err).ToNot(HaveOccurred())
+		return res.StatusCode, string(body)
+	}
+
+	whoami := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pki.FromContext(r.Context())
+		Expect(ok).Should(BeTrue())
+		_, _ = w.Write([]byte(id.User + " " + id.Serial))
+	})
+
+	It("Should put the identity of the client in the context", func() {
+		srv := start(whoami, httpauth.Options{Revoked: revoked})
+		defer srv.Close()
+
+		cert := issue("demo", "user")
+		code, body := get(srv, cert)
+		Expect(code).Should(Equal(http.StatusOK))
+		Expect(body).Should(Equal(
+			"demo " + cert.Leaf.SerialNumber.Text(16)))
+	})
+
+	It("Should refuse revoked certificates", func() {
+		srv := start(whoami, httpauth.Options{Revoked: revoked})
+		defer srv.Close()
+
+		cert := issue("demo", "user")
+		_, err := revoked.Revoke(cert.Leaf.SerialNumber.Text(16))
+		Expect(err).ToNot(HaveOccurred())
+
+		code, _ := get(srv, cert)
+		Expect(code).Should(BeZero(), "the handshake should fail")
+	})
+
+	It("Should require one of the roles", func() {
+		admins := httpauth.RequireRoles("admin")(whoami)
+		srv := start(admins, httpauth.Options{Roles: []string{"user"}})
+		defer srv.Close()
+
+		code, _ := get(srv, issue("guest"))
+		Expect(code).Should(Equal(http.StatusForbidden))
+		code, _ = get(srv, issue("demo", "user"))
+		Expect(code).Should(Equal(http.StatusForbidden))
+		code, _ = get(srv, issue("root", "user", "admin"))
+		Expect(code).Should(Equal(http.StatusOK))
+	})
+
+	It("Should refuse requests without a certificate", func() {
+		w := httptest.NewRecorder()
+		httpauth.Middleware(httpauth.Options{})(whoami).ServeHTTP(w,
+			httptest.NewRequest("GET", "/", nil))
+		Expect(w.Code).Should(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,118 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedPEMtype is the type of the PEM blocks holding encrypted keys.
+const encryptedPEMtype = "TLS-SESS ENCRYPTED KEY"
+
+// Parameters of scrypt, as recommended for interactive logins in 2017, and the
+// size of the salt.
+const (
+	scryptN  = 1 << 15
+	scryptR  = 8
+	scryptP  = 1
+	saltSize = 16
+)
+
+// Encrypted stores each key in the file its name is the path of, like Files,
+// but encrypted with AES-256-GCM under a key derived from a passphrase with
+// scrypt.  The file is a PEM block holding the salt, the nonce and the
+// ciphertext.
+type Encrypted struct {
+	// Passphrase returns the passphrase of the keys.  Confirm is set when
+	// a key is stored, for the passphrase to be asked twice.
+	Passphrase func(confirm bool) ([]byte, error)
+}
+
+// Get reads and decrypts the key from the file at path.
+func (e *Encrypted) Get(path string) ([]byte, error) {
+	raw, err := Files{}.Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	blk, _ := pem.Decode(raw)
+	if blk == nil || blk.Type != encryptedPEMtype {
+		return nil, errors.Errorf("%s is not an encrypted key", path)
+	}
+	if len(blk.Bytes) < saltSize {
+		return nil, errors.Errorf("%s is truncated", path)
+	}
+	salt, sealed := blk.Bytes[:saltSize], blk.Bytes[saltSize:]
+
+	aead, err := e.cipher(salt, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.Errorf("%s is truncated", path)
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	key, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, errors.Errorf("cannot decrypt %s, wrong passphrase?", path)
+	}
+	return key, nil
+}
+
+// Put encrypts the key and writes it to the file at path.
+func (e *Encrypted) Put(path string, key []byte) error {
+	salt := make([]byte, saltSize)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return errors.Wrap(err, "generating salt")
+	}
+
+	aead, err := e.cipher(salt, true)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return errors.Wrap(err, "generating nonce")
+	}
+
+	data := append(salt, nonce...)
+	blk := &pem.Block{
+		Type:  encryptedPEMtype,
+		Bytes: aead.Seal(data, nonce, key, nil),
+	}
+	return Files{}.Put(path, pem.EncodeToMemory(blk))
+}
+
+// Delete removes the file at path.
+func (e *Encrypted) Delete(path string) error {
+	return Files{}.Delete(path)
+}
+
+// cipher derives the key of the cipher from the passphrase and the salt.
+func (e *Encrypted) cipher(salt []byte, confirm bool) (cipher.AEAD, error) {
+	pass, err := e.Passphrase(confirm)
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, errors.New("a passphrase is required for encrypted keys")
+	}
+
+	key, err := scrypt.Key(pass, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key from passphrase")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher")
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,180 @@
+// Package keystore reads and writes the files holding keys and certificates,
+// so that they are never left half written nor open to other users.
+//
+// Files are replaced at once by writing a temporary file next to them, which
+// is synced and renamed over the old one.  They are only accessible by their
+// owner, as are the directories created for them, and private files with
+// looser permissions are refused.  Lock keeps processes from writing the same
+// files at the same time, and restricts the directory it locks to its owner.
+//
+// A Store keeps private keys under names, in files with Files, in files
+// encrypted with a passphrase with Encrypted, or in the keyring of the desktop
+// with SecretService, so that they never need to be written unencrypted.
+package keystore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Permissions of the files written and the directories created.
+const (
+	FileMode os.FileMode = 0600
+	DirMode  os.FileMode = 0700
+)
+
+// lockName is the name of the file locked in a directory by Lock.
+const lockName = ".lock"
+
+// PermissionError is returned when a private file is accessible by users
+// other than its owner.
+type PermissionError struct {
+	Path string
+	Mode os.FileMode
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("permissions %04o for %s are too open, it must only "+
+		"be accessible by its owner (chmod %04o)", e.Mode.Perm(), e.Path,
+		FileMode)
+}
+
+// WriteFile replaces the file at path with data.  The directories leading to
+// it are created if they do not exist.
+func WriteFile(path string, data []byte) (err error) {
+	dir := filepath.Dir(path)
+	err = os.MkdirAll(dir, DirMode)
+	if err != nil {
+		return errors.Wrap(err, "creating directory")
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// TempFile already creates the file with FileMode, but the umask could
+	// have been looser.
+	err = tmp.Chmod(FileMode)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "writing %s", path)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrapf(err, "replacing %s", path)
+	}
+	return syncDir(dir)
+}
+
+// syncDir syncs a directory so that the files renamed into it are kept after
+// a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "opening directory")
+	}
+	defer d.Close()
+
+	// Some file systems cannot sync directories, which is left to them.
+	err = d.Sync()
+	if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.EINVAL {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "syncing directory")
+	}
+	return nil
+}
+
+// ReadPrivate reads a private file, such as a key, refusing it with a
+// *PermissionError if users other than its owner can access it.
+func ReadPrivate(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, &PermissionError{Path: path, Mode: info.Mode()}
+	}
+	return ioutil.ReadAll(f)
+}
+
+// Lock takes an exclusive lock on the directory, creating it if needed.  If
+// another process holds the lock, busy is called, if not nil, before waiting
+// for it.  The lock is released by calling unlock, or when the process exits.
+// Since the directory holds private files, it is made accessible only by its
+// owner if it was not already.  Platforms other than Unix and Windows cannot
+// lock files, so Lock does not keep other processes out there.
+func Lock(dir string, busy func()) (unlock func() error, err error) {
+	err = privateDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, lockName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, FileMode)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening lock file")
+	}
+
+	err = lockFile(f, busy)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "locking %s", dir)
+	}
+
+	return func() error {
+		err := unlockFile(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
+
+// privateDir creates the directory if it does not exist, and otherwise
+// removes the permissions of other users from it, which directories created
+// by older releases could have.
+func privateDir(dir string) error {
+	err := os.MkdirAll(dir, DirMode)
+	if err != nil {
+		return errors.Wrap(err, "creating directory")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return errors.Wrap(err, "checking directory")
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		err = os.Chmod(dir, DirMode)
+		if err != nil {
+			return errors.Wrap(err, "restricting directory")
+		}
+	}
+	return nil
+}
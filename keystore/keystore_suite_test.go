@@ -0,0 +1,284 @@
+package keystore_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestKeystore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Keystore Suite")
+}
+
+func tmpDir() string {
+	dir, err := ioutil.TempDir("", "temp")
+	Expect(err).ToNot(HaveOccurred())
+	return dir
+}
+
+func rmDir(path string) {
+	err := os.RemoveAll(path)
+	Expect(err).ToNot(HaveOccurred())
+}
+
+// busConfig is the configuration of a private bus that lets its clients own
+// any name and call any other.
+const busConfig = `<!DOCTYPE busconfig PUBLIC
+ "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// startBus starts a private bus with its socket in dir, skipping the spec if
+// dbus-daemon is not installed.  It returns the address of the bus.
+func startBus(dir string) (address string, stop func()) {
+	exe, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		Skip("dbus-daemon is not installed")
+	}
+
+	cfg := filepath.Join(dir, "bus.conf")
+	err = ioutil.WriteFile(cfg,
+		[]byte(fmt.Sprintf(busConfig, filepath.Join(dir, "bus"))), 0600)
+	Expect(err).ToNot(HaveOccurred())
+
+	cmd := exec.Command(exe, "--config-file="+cfg, "--nofork",
+		"--print-address")
+	out, err := cmd.StdoutPipe()
+	Expect(err).ToNot(HaveOccurred())
+	cmd.Stderr = GinkgoWriter
+	Expect(cmd.Start()).To(Succeed())
+
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	Expect(err).ToNot(HaveOccurred())
+
+	return strings.TrimSpace(line), func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+// Paths of the objects of the fake Secret Service.
+const (
+	secretsPath    = dbus.ObjectPath("/org/freedesktop/secrets")
+	collectionPath = secretsPath + "/aliases/default"
+	sessionPath    = secretsPath + "/session/1"
+)
+
+// itemIface is the interface of the items of the Secret Service.
+const itemIface = "org.freedesktop.Secret.Item"
+
+// secret is a secret as sent over the bus by the Secret Service.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// fakeItem is an item kept by the fake Secret Service.
+type fakeItem struct {
+	attrs  map[string]string
+	value  []byte
+	locked bool
+}
+
+// fakeSecrets stands in for the Secret Service on a private bus, keeping the
+// items of its default collection in memory.
+type fakeSecrets struct {
+	conn *dbus.Conn
+
+	mu    sync.Mutex
+	items map[dbus.ObjectPath]*fakeItem
+	next  int
+
+	// lock makes the items locked when created, so that getting them needs
+	// them to be unlocked through a prompt.
+	lock bool
+
+	// prompts is the number of prompts shown.
+	prompts int
+}
+
+// startSecrets serves a fake Secret Service on the bus at address.
+func startSecrets(address string) *fakeSecrets {
+	conn, err := dbus.Connect(address)
+	Expect(err).ToNot(HaveOccurred())
+
+	s := &fakeSecrets{conn: conn, items: make(map[dbus.ObjectPath]*fakeItem)}
+	Expect(conn.ExportMethodTable(map[string]interface{}{
+		"OpenSession": s.openSession,
+		"SearchItems": s.searchItems,
+		"Unlock":      s.unlock,
+	}, secretsPath, "org.freedesktop.Secret.Service")).To(Succeed())
+	Expect(conn.ExportMethodTable(map[string]interface{}{
+		"CreateItem": s.createItem,
+	}, collectionPath, "org.freedesktop.Secret.Collection")).To(Succeed())
+
+	reply, err := conn.RequestName("org.freedesktop.secrets",
+		dbus.NameFlagDoNotQueue)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(reply).Should(Equal(dbus.RequestNameReplyPrimaryOwner))
+	return s
+}
+
+// values returns the values of the items held.
+func (s *fakeSecrets) values() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var values []string
+	for _, item := range s.items {
+		values = append(values, string(item.value))
+	}
+	return values
+}
+
+func (s *fakeSecrets) openSession(
+	algorithm string, _ dbus.Variant,
+) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.Variant{}, "", dbus.NewError(
+			"org.freedesktop.DBus.Error.NotSupported", nil)
+	}
+	return dbus.MakeVariant(""), sessionPath, nil
+}
+
+func (s *fakeSecrets) searchItems(
+	attrs map[string]string,
+) (unlocked, locked []dbus.ObjectPath, _ *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range s.find(attrs) {
+		if s.items[path].locked {
+			locked = append(locked, path)
+		} else {
+			unlocked = append(unlocked, path)
+		}
+	}
+	return unlocked, locked, nil
+}
+
+// find returns the items with all of the attributes.
+func (s *fakeSecrets) find(attrs map[string]string) []dbus.ObjectPath {
+	var paths []dbus.ObjectPath
+	for path, item := range s.items {
+		match := true
+		for k, v := range attrs {
+			match = match && item.attrs[k] == v
+		}
+		if match {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (s *fakeSecrets) unlock(
+	objects []dbus.ObjectPath,
+) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var unlocked, locked []dbus.ObjectPath
+	for _, path := range objects {
+		if item, ok := s.items[path]; ok && item.locked {
+			locked = append(locked, path)
+		} else {
+			unlocked = append(unlocked, path)
+		}
+	}
+	if len(locked) == 0 {
+		return unlocked, "/", nil
+	}
+
+	s.next++
+	prompt := dbus.ObjectPath(fmt.Sprintf("%s/prompt/%d", secretsPath, s.next))
+	err := s.conn.ExportMethodTable(map[string]interface{}{
+		"Prompt": func(string) *dbus.Error {
+			s.mu.Lock()
+			s.prompts++
+			for _, path := range locked {
+				s.items[path].locked = false
+			}
+			s.mu.Unlock()
+			go s.conn.Emit(prompt, "org.freedesktop.Secret.Prompt.Completed",
+				false, dbus.MakeVariant(locked))
+			return nil
+		},
+	}, prompt, "org.freedesktop.Secret.Prompt")
+	if err != nil {
+		return nil, "", dbus.MakeFailedError(err)
+	}
+	return unlocked, prompt, nil
+}
+
+func (s *fakeSecrets) createItem(
+	props map[string]dbus.Variant, sec secret, replace bool,
+) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	attrs, ok := props[itemIface+".Attributes"].Value().(map[string]string)
+	if !ok || sec.Session != sessionPath {
+		return "", "", dbus.NewError(
+			"org.freedesktop.DBus.Error.InvalidArgs", nil)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := &fakeItem{attrs: attrs, value: sec.Value, locked: s.lock}
+	if paths := s.find(attrs); replace && len(paths) > 0 {
+		s.items[paths[0]] = item
+		return paths[0], "/", nil
+	}
+
+	s.next++
+	path := dbus.ObjectPath(fmt.Sprintf("%s/%d", collectionPath, s.next))
+	err := s.conn.ExportMethodTable(map[string]interface{}{
+		"GetSecret": func(session dbus.ObjectPath) (secret, *dbus.Error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			item, ok := s.items[path]
+			if !ok || item.locked || session != sessionPath {
+				return secret{}, dbus.NewError(
+					"org.freedesktop.Secret.Error.IsLocked", nil)
+			}
+			return secret{Session: session, Value: item.value}, nil
+		},
+		"Delete": func() (dbus.ObjectPath, *dbus.Error) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.items, path)
+			return "/", nil
+		},
+	}, path, itemIface)
+	if err != nil {
+		return "", "", dbus.MakeFailedError(err)
+	}
+	s.items[path] = item
+	return path, "/", nil
+}
@@ -0,0 +1,216 @@
+package keystore_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+)
+
+var _ = Describe("Keystore", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = tmpDir()
+	})
+
+	AfterEach(func() {
+		rmDir(dir)
+	})
+
+	It("Should replace files at once with private permissions", func() {
+		path := filepath.Join(dir, "profiles", "default", "key.pem")
+		Expect(keystore.WriteFile(path, []byte("a much longer key\n"))).
+			To(Succeed())
+		Expect(keystore.WriteFile(path, []byte("short\n"))).To(Succeed())
+
+		raw, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).Should(Equal("short\n"))
+
+		info, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).Should(Equal(keystore.FileMode))
+		info, err = os.Stat(filepath.Dir(path))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).Should(Equal(keystore.DirMode))
+
+		files, err := ioutil.ReadDir(filepath.Dir(path))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).Should(HaveLen(1), "temporary files were left behind")
+	})
+
+	It("Should refuse private files open to other users", func() {
+		path := filepath.Join(dir, "key.pem")
+		Expect(keystore.WriteFile(path, []byte("key"))).To(Succeed())
+
+		raw, err := keystore.ReadPrivate(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).Should(Equal("key"))
+
+		Expect(os.Chmod(path, 0644)).To(Succeed())
+		_, err = keystore.ReadPrivate(path)
+		Expect(err).Should(BeAssignableToTypeOf(&keystore.PermissionError{}))
+		Expect(err.Error()).Should(ContainSubstring("0644"))
+	})
+
+	It("Should let one process at a time hold the lock", func() {
+		unlock, err := keystore.Lock(dir, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		busy := make(chan struct{})
+		locked := make(chan func() error)
+		go func() {
+			defer GinkgoRecover()
+			unlock, err := keystore.Lock(dir, func() { close(busy) })
+			Expect(err).ToNot(HaveOccurred())
+			locked <- unlock
+		}()
+
+		Eventually(busy).Should(BeClosed())
+		Consistently(locked, 100*time.Millisecond).ShouldNot(Receive())
+
+		Expect(unlock()).To(Succeed())
+		var second func() error
+		Eventually(locked).Should(Receive(&second))
+		Expect(second()).To(Succeed())
+	})
+
+	It("Should make the locked directory private", func() {
+		Expect(os.Chmod(dir, 0755)).To(Succeed())
+		unlock, err := keystore.Lock(dir, nil)
+		Expect(err).ToNot(HaveOccurred())
+		defer unlock()
+
+		info, err := os.Stat(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).Should(Equal(keystore.DirMode))
+	})
+
+	It("Should store keys in files", func() {
+		path := filepath.Join(dir, "key.pem")
+		store := keystore.Files{}
+
+		_, err := store.Get(path)
+		Expect(err).Should(Equal(keystore.ErrNotFound))
+
+		Expect(store.Put(path, []byte("key"))).To(Succeed())
+		key, err := store.Get(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(key)).Should(Equal("key"))
+
+		Expect(store.Delete(path)).To(Succeed())
+		_, err = store.Get(path)
+		Expect(err).Should(Equal(keystore.ErrNotFound))
+		Expect(store.Delete(path)).To(Succeed())
+	})
+
+	It("Should store keys encrypted with a passphrase", func() {
+		path := filepath.Join(dir, "key.pem")
+		pass := "correct horse"
+		var confirmed bool
+		store := &keystore.Encrypted{
+			Passphrase: func(confirm bool) ([]byte, error) {
+				confirmed = confirmed || confirm
+				return []byte(pass), nil
+			},
+		}
+
+		_, err := store.Get(path)
+		Expect(err).Should(Equal(keystore.ErrNotFound))
+
+		Expect(store.Put(path, []byte("secret key"))).To(Succeed())
+		Expect(confirmed).Should(BeTrue())
+		raw, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(raw)).ShouldNot(ContainSubstring("secret key"))
+		Expect(string(raw)).Should(HavePrefix("-----BEGIN "))
+
+		key, err := store.Get(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(key)).Should(Equal("secret key"))
+
+		pass = "wrong horse"
+		_, err = store.Get(path)
+		Expect(err).Should(MatchError(ContainSubstring("wrong passphrase")))
+
+		Expect(ioutil.WriteFile(path, []byte("key"), 0600)).To(Succeed())
+		_, err = store.Get(path)
+		Expect(err).Should(MatchError(ContainSubstring("not an encrypted key")))
+	})
+
+	Context("With the Secret Service", func() {
+		var (
+			address string
+			stopBus func()
+			secrets *fakeSecrets
+			store   *keystore.SecretService
+		)
+
+		BeforeEach(func() {
+			address, stopBus = startBus(dir)
+			secrets = startSecrets(address)
+
+			var err error
+			store, err = keystore.OpenSecretService(address, "test")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			if store != nil {
+				Expect(store.Close()).To(Succeed())
+			}
+			if secrets != nil {
+				secrets.conn.Close()
+			}
+			if stopBus != nil {
+				stopBus()
+			}
+			store, secrets, stopBus = nil, nil, nil
+		})
+
+		It("Should store keys in the default collection", func() {
+			_, err := store.Get("default")
+			Expect(err).Should(Equal(keystore.ErrNotFound))
+
+			Expect(store.Put("default", []byte("first"))).To(Succeed())
+			Expect(store.Put("other", []byte("other"))).To(Succeed())
+			Expect(store.Put("default", []byte("second"))).To(Succeed())
+			Expect(secrets.values()).Should(ConsistOf("second", "other"))
+
+			key, err := store.Get("default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(key)).Should(Equal("second"))
+
+			Expect(store.Delete("default")).To(Succeed())
+			_, err = store.Get("default")
+			Expect(err).Should(Equal(keystore.ErrNotFound))
+			Expect(secrets.values()).Should(ConsistOf("other"))
+		})
+
+		It("Should unlock keys through a prompt", func() {
+			secrets.lock = true
+			Expect(store.Put("default", []byte("key"))).To(Succeed())
+
+			key, err := store.Get("default")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(key)).Should(Equal("key"))
+			Expect(secrets.prompts).Should(Equal(1))
+		})
+
+		It("Should not find the keys of other applications", func() {
+			Expect(store.Put("default", []byte("key"))).To(Succeed())
+
+			other, err := keystore.OpenSecretService(address, "other")
+			Expect(err).ToNot(HaveOccurred())
+			defer other.Close()
+			_, err = other.Get("default")
+			Expect(err).Should(Equal(keystore.ErrNotFound))
+		})
+	})
+})
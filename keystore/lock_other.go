@@ -0,0 +1,16 @@
+//go:build aix || (!unix && !windows)
+
+package keystore
+
+import "os"
+
+// lockFile does nothing, since the platform has no advisory file locks that
+// are held per open file as on the others.
+func lockFile(f *os.File, busy func()) error {
+	return nil
+}
+
+// unlockFile does nothing, as lockFile.
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build unix && !aix
+
+package keystore
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an exclusive lock on the file, calling busy, if not nil,
+// before waiting for another process to release it.
+func lockFile(f *os.File, busy func()) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		if busy != nil {
+			busy()
+		}
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
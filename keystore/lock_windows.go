@@ -0,0 +1,32 @@
+//go:build windows
+
+package keystore
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on the file, calling busy, if not nil,
+// before waiting for another process to release it.
+func lockFile(f *os.File, busy func()) error {
+	h := windows.Handle(f.Fd())
+	err := windows.LockFileEx(h,
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, new(windows.Overlapped))
+	if err == windows.ERROR_LOCK_VIOLATION {
+		if busy != nil {
+			busy()
+		}
+		err = windows.LockFileEx(h, windows.LOCKFILE_EXCLUSIVE_LOCK,
+			0, 1, 0, new(windows.Overlapped))
+	}
+	return err
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0,
+		new(windows.Overlapped))
+}
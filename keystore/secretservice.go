@@ -0,0 +1,242 @@
+package keystore
+
+import (
+	"github.com/godbus/dbus/v5"
+	"github.com/pkg/errors"
+)
+
+// Names of the Secret Service API, as specified by freedesktop.org.
+const (
+	secretsName       = "org.freedesktop.secrets"
+	secretsPath       = dbus.ObjectPath("/org/freedesktop/secrets")
+	defaultCollection = secretsPath + "/aliases/default"
+	serviceIface      = "org.freedesktop.Secret.Service"
+	collectionIface   = "org.freedesktop.Secret.Collection"
+	itemIface         = "org.freedesktop.Secret.Item"
+	promptIface       = "org.freedesktop.Secret.Prompt"
+
+	// noPrompt is returned in place of a prompt when none is needed.
+	noPrompt = dbus.ObjectPath("/")
+)
+
+// dbusSecret is a secret as sent over the bus by the Secret Service.
+type dbusSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// SecretService stores keys in the default collection of the Secret Service
+// of the desktop, such as GNOME Keyring or KWallet, which keeps them
+// encrypted and unlocks them when the user logs in.  The keys are found by
+// the name and the application they are stored with.
+//
+// Secrets are sent over the bus in plain, which only the user can connect to.
+type SecretService struct {
+	// Application is stored with the keys, to tell them from the secrets of
+	// other applications.
+	Application string
+
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+// OpenSecretService connects to the Secret Service on the bus at address, or
+// on the session bus if address is empty.
+func OpenSecretService(address, application string) (*SecretService, error) {
+	var conn *dbus.Conn
+	var err error
+	if address == "" {
+		conn, err = dbus.ConnectSessionBus()
+	} else {
+		conn, err = dbus.Connect(address)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to the session bus")
+	}
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = conn.Object(secretsName, secretsPath).Call(
+		serviceIface+".OpenSession", 0, "plain", dbus.MakeVariant(""),
+	).Store(&output, &session)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "opening a Secret Service session")
+	}
+
+	return &SecretService{
+		Application: application,
+		conn:        conn,
+		session:     session,
+	}, nil
+}
+
+// Close closes the session and the connection to the bus.
+func (s *SecretService) Close() error {
+	s.conn.Object(secretsName, s.session).Call(
+		"org.freedesktop.Secret.Session.Close", 0)
+	return s.conn.Close()
+}
+
+// Get returns the key stored under name, unlocking it if needed.
+func (s *SecretService) Get(name string) ([]byte, error) {
+	items, err := s.search(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var secret dbusSecret
+	err = s.conn.Object(secretsName, items[0]).Call(
+		itemIface+".GetSecret", 0, s.session,
+	).Store(&secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting key from the Secret Service")
+	}
+	return secret.Value, nil
+}
+
+// Put stores the key under name in the default collection, unlocking it if
+// needed.
+func (s *SecretService) Put(name string, key []byte) error {
+	err := s.unlock([]dbus.ObjectPath{defaultCollection})
+	if err != nil {
+		return err
+	}
+
+	props := map[string]dbus.Variant{
+		itemIface + ".Label":      dbus.MakeVariant(s.Application + " " + name),
+		itemIface + ".Attributes": dbus.MakeVariant(s.attributes(name)),
+	}
+	secret := dbusSecret{
+		Session:     s.session,
+		Value:       key,
+		ContentType: "application/x-pem-file",
+	}
+	var item, prompt dbus.ObjectPath
+	err = s.conn.Object(secretsName, defaultCollection).Call(
+		collectionIface+".CreateItem", 0, props, secret, true,
+	).Store(&item, &prompt)
+	if err == nil {
+		_, err = s.prompt(prompt)
+	}
+	if err != nil {
+		return errors.Wrap(err, "storing key in the Secret Service")
+	}
+	return nil
+}
+
+// Delete removes the keys stored under name.
+func (s *SecretService) Delete(name string) error {
+	items, err := s.search(name)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		err = s.conn.Object(secretsName, item).Call(
+			itemIface+".Delete", 0,
+		).Store(&prompt)
+		if err == nil {
+			_, err = s.prompt(prompt)
+		}
+		if err != nil {
+			return errors.Wrap(err, "deleting key from the Secret Service")
+		}
+	}
+	return nil
+}
+
+func (s *SecretService) attributes(name string) map[string]string {
+	return map[string]string{
+		"application": s.Application,
+		"name":        name,
+	}
+}
+
+// search returns the items stored under name, unlocking those that are
+// locked.
+func (s *SecretService) search(name string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := s.conn.Object(secretsName, secretsPath).Call(
+		serviceIface+".SearchItems", 0, s.attributes(name),
+	).Store(&unlocked, &locked)
+	if err != nil {
+		return nil, errors.Wrap(err, "searching the Secret Service")
+	}
+
+	if len(locked) > 0 {
+		err = s.unlock(locked)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(unlocked, locked...), nil
+}
+
+// unlock unlocks the items or collections, which may prompt the user.
+func (s *SecretService) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := s.conn.Object(secretsName, secretsPath).Call(
+		serviceIface+".Unlock", 0, objects,
+	).Store(&unlocked, &prompt)
+	if err != nil {
+		return errors.Wrap(err, "unlocking the Secret Service")
+	}
+
+	dismissed, err := s.prompt(prompt)
+	if err != nil {
+		return errors.Wrap(err, "unlocking the Secret Service")
+	}
+	if dismissed {
+		return errors.New("unlocking the Secret Service was dismissed")
+	}
+	return nil
+}
+
+// prompt shows the prompt to the user, if any, and waits for it to complete.
+func (s *SecretService) prompt(
+	prompt dbus.ObjectPath,
+) (dismissed bool, err error) {
+	if prompt == noPrompt || prompt == "" {
+		return false, nil
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(promptIface),
+		dbus.WithMatchMember("Completed"),
+	}
+	err = s.conn.AddMatchSignal(match...)
+	if err != nil {
+		return false, err
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+
+	signals := make(chan *dbus.Signal, 1)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	err = s.conn.Object(secretsName, prompt).Call(
+		promptIface+".Prompt", 0, "").Err
+	if err != nil {
+		return false, err
+	}
+
+	for sig := range signals {
+		if sig.Path != prompt || sig.Name != promptIface+".Completed" {
+			continue
+		}
+		if len(sig.Body) > 0 {
+			dismissed, _ = sig.Body[0].(bool)
+		}
+		return dismissed, nil
+	}
+	return false, errors.New("the connection to the bus was closed")
+}
@@ -0,0 +1,50 @@
+package keystore
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by stores for the keys they do not hold.
+var ErrNotFound = errors.New("key not found")
+
+// Store keeps private keys under names.  What a name stands for depends on
+// the store, such as the path of a file.
+type Store interface {
+	// Get returns the key stored under name, or ErrNotFound.
+	Get(name string) ([]byte, error)
+
+	// Put stores the key under name, replacing any key stored before.
+	Put(name string, key []byte) error
+
+	// Delete removes the key stored under name, if any.
+	Delete(name string) error
+}
+
+// Files stores each key in the file its name is the path of, with WriteFile
+// and ReadPrivate.
+type Files struct{}
+
+// Get reads the key from the file at path.
+func (Files) Get(path string) ([]byte, error) {
+	key, err := ReadPrivate(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+// Put writes the key to the file at path.
+func (Files) Put(path string, key []byte) error {
+	return WriteFile(path, key)
+}
+
+// Delete removes the file at path.
+func (Files) Delete(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing key")
+	}
+	return nil
+}
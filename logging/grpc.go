@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDKey is the metadata key that carries request IDs.  A request ID
+// given by the client is kept, otherwise a new one is generated.  Either way,
+// the ID is sent back in the response header.
+const RequestIDKey = "x-request-id"
+
+// UnaryServerInterceptor returns an interceptor that gives each call a request
+// ID and a logger carrying it, and logs each call when it completes.
+func UnaryServerInterceptor(l *Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		ctx, log := begin(ctx, l, info.FullMethod)
+		log.Debug("Received request", "request", req)
+
+		start := time.Now()
+		resp, err = handler(ctx, req)
+		finish(log, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor for streaming calls.
+func StreamServerInterceptor(l *Logger) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, log := begin(ss.Context(), l, info.FullMethod)
+
+		start := time.Now()
+		err := handler(srv, &stream{ServerStream: ss, ctx: ctx, log: log})
+		finish(log, start, err)
+
+		return err
+	}
+}
+
+// RequestID returns the ID of the request in the context.
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+type requestIDKey struct{}
+
+func begin(
+	ctx context.Context, l *Logger, method string,
+) (context.Context, *Logger) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDKey); len(ids) > 0 && len(ids[0]) <= 64 {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, id))
+
+	log := l.With("request_id", id, "method", method)
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return NewContext(ctx, log), log
+}
+
+func finish(log *Logger, start time.Time, err error) {
+	kv := []interface{}{
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	}
+	if err != nil {
+		kv = append(kv, "error", status.Convert(err).Message())
+		log.Warn("Finished call", kv...)
+		return
+	}
+	log.Info("Finished call", kv...)
+}
+
+func newRequestID() string {
+	byt := make([]byte, 8)
+	_, _ = rand.Read(byt)
+	return hex.EncodeToString(byt)
+}
+
+// stream replaces the context of a server stream and logs the messages it
+// receives.
+type stream struct {
+	grpc.ServerStream
+	ctx context.Context
+	log *Logger
+}
+
+func (s *stream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.log.Debug("Received message", "message", m)
+	}
+	return err
+}
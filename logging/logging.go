@@ -0,0 +1,228 @@
+// Package logging provides a small structured logger with levels, text or JSON
+// output, and redaction of sensitive protobuf fields.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Levels of log entries, from least to most severe.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name such as "info", ignoring case.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+	return Info, errors.Errorf("unknown log level: %s", name)
+}
+
+// Format is how log entries are written.
+type Format int
+
+// Formats of log entries.
+const (
+	Text Format = iota
+	JSON
+)
+
+// ParseFormat parses a format name, either "text" or "json".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+	return Text, errors.Errorf("unknown log format: %s", name)
+}
+
+// output is shared by a logger and the loggers derived from it.
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format Format
+}
+
+// Logger writes structured log entries.  Each entry has a message and a list
+// of alternating keys and values.  Values that are protobuf messages are
+// redacted before they are written.  It is safe for concurrent use.
+type Logger struct {
+	out    *output
+	fields []interface{}
+}
+
+// New creates a logger writing entries at level or above to w.
+func New(w io.Writer, level Level, format Format) *Logger {
+	return &Logger{out: &output{w: w, level: level, format: format}}
+}
+
+var std = New(os.Stderr, Info, Text)
+
+// Default returns the logger used when none is given in a context.
+func Default() *Logger {
+	return std
+}
+
+// SetDefault replaces the default logger.
+func SetDefault(l *Logger) {
+	std = l
+}
+
+type ctxKey struct{}
+
+// NewContext returns a context that carries the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the logger in the context or the default logger.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// With returns a logger that adds the given keys and values to every entry.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{out: l.out, fields: fields}
+}
+
+// Enabled reports whether entries at the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+// Debug logs a message at the debug level.
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(Debug, msg, kv) }
+
+// Info logs a message at the info level.
+func (l *Logger) Info(msg string, kv ...interface{}) { l.log(Info, msg, kv) }
+
+// Warn logs a message at the warn level.
+func (l *Logger) Warn(msg string, kv ...interface{}) { l.log(Warn, msg, kv) }
+
+// Error logs a message at the error level.
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(Error, msg, kv) }
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(MISSING)")
+	}
+
+	var buf bytes.Buffer
+	now := time.Now()
+	if l.out.format == JSON {
+		writeJSON(&buf, now, level, msg, fields)
+	} else {
+		writeText(&buf, now, level, msg, fields)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(buf.Bytes())
+}
+
+func writeText(
+	buf *bytes.Buffer, t time.Time, level Level, msg string,
+	fields []interface{},
+) {
+	fmt.Fprintf(buf, "%s %-5s %s", t.Format(time.RFC3339), level, msg)
+	for i := 0; i < len(fields); i += 2 {
+		val := fmt.Sprint(value(fields[i+1]))
+		if val == "" || strings.ContainsAny(val, " \t\n\"=") {
+			val = fmt.Sprintf("%q", val)
+		}
+		fmt.Fprintf(buf, " %v=%s", fields[i], val)
+	}
+	buf.WriteByte('\n')
+}
+
+func writeJSON(
+	buf *bytes.Buffer, t time.Time, level Level, msg string,
+	fields []interface{},
+) {
+	entry := map[string]interface{}{
+		"time":  t.Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+	for i := 0; i < len(fields); i += 2 {
+		val := value(fields[i+1])
+		if _, ok := val.(json.Marshaler); !ok {
+			switch val.(type) {
+			case string, bool, int, int32, int64, uint, uint32, uint64,
+				float32, float64, nil:
+			default:
+				val = fmt.Sprint(val)
+			}
+		}
+		entry[fmt.Sprint(fields[i])] = val
+	}
+
+	byt, err := json.Marshal(entry)
+	if err != nil {
+		byt, _ = json.Marshal(map[string]string{
+			"time":  t.Format(time.RFC3339Nano),
+			"level": Error.String(),
+			"msg":   "could not encode log entry: " + err.Error(),
+		})
+	}
+	buf.Write(byt)
+	buf.WriteByte('\n')
+}
+
+// value prepares a value to be written, redacting protobuf messages.
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case proto.Message:
+		return proto.CompactTextString(Redact(v))
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+	return v
+}
@@ -0,0 +1,109 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+)
+
+var _ = Describe("Logging", func() {
+	It("Should only write entries at or above the level", func() {
+		var buf bytes.Buffer
+		log := logging.New(&buf, logging.Warn, logging.Text)
+
+		log.Info("not written")
+		log.Warn("written", "key", "some value")
+
+		Expect(buf.String()).ShouldNot(ContainSubstring("not written"))
+		Expect(buf.String()).Should(MatchRegexp(
+			`^\S+ WARN  written key="some value"\n$`))
+	})
+
+	It("Should write entries as JSON", func() {
+		var buf bytes.Buffer
+		log := logging.New(&buf, logging.Debug, logging.JSON).
+			With("request_id", "abc")
+
+		log.Debug("hello", "count", 3)
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+		Expect(entry).Should(HaveKeyWithValue("level", "DEBUG"))
+		Expect(entry).Should(HaveKeyWithValue("msg", "hello"))
+		Expect(entry).Should(HaveKeyWithValue("request_id", "abc"))
+		Expect(entry).Should(HaveKeyWithValue("count", 3.0))
+		Expect(entry).Should(HaveKey("time"))
+	})
+
+	It("Should parse levels and formats", func() {
+		Expect(logging.ParseLevel("debug")).Should(Equal(logging.Debug))
+		Expect(logging.ParseLevel("ERROR")).Should(Equal(logging.Error))
+		_, err := logging.ParseLevel("loud")
+		Expect(err).To(HaveOccurred())
+
+		Expect(logging.ParseFormat("json")).Should(Equal(logging.JSON))
+		_, err = logging.ParseFormat("xml")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Redact", func() {
+	It("Should redact sensitive fields", func() {
+		req := &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+			Csr:      "csr",
+			Totp:     "123456",
+		}
+
+		redacted := logging.Redact(req).(*pb.LoginRequest)
+		Expect(redacted.Username).Should(Equal("demo"))
+		Expect(redacted.Csr).Should(Equal("csr"))
+		Expect(redacted.Password).Should(Equal(logging.Redacted))
+		Expect(redacted.Totp).Should(Equal(logging.Redacted))
+
+		By("Leaving the original alone")
+		Expect(req.Password).Should(Equal("test123"))
+	})
+
+	It("Should redact sensitive fields in a oneof", func() {
+		req := &pb.AuthenticateRequest{
+			Step: &pb.AuthenticateRequest_Answer{
+				Answer: &pb.ChallengeAnswer{Value: "test123"},
+			},
+		}
+
+		redacted := logging.Redact(req).(*pb.AuthenticateRequest)
+		Expect(redacted.GetAnswer().Value).Should(Equal(logging.Redacted))
+	})
+
+	It("Should redact repeated fields", func() {
+		enr := &pb.TOTPEnrollment{
+			Secret:        "JBSWY3DPEHPK3PXP",
+			RecoveryCodes: []string{"a", "b"},
+		}
+
+		redacted := logging.Redact(enr).(*pb.TOTPEnrollment)
+		Expect(redacted.Secret).Should(Equal(logging.Redacted))
+		Expect(redacted.RecoveryCodes).Should(Equal(
+			[]string{logging.Redacted, logging.Redacted}))
+	})
+
+	It("Should never log a password", func() {
+		var buf bytes.Buffer
+		log := logging.New(&buf, logging.Debug, logging.JSON)
+
+		log.Info("Received", "request", &pb.LoginRequest{
+			Username: "demo",
+			Password: "test123",
+		})
+
+		Expect(buf.String()).Should(ContainSubstring("demo"))
+		Expect(buf.String()).ShouldNot(ContainSubstring("test123"))
+	})
+})
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"reflect"
+
+	"github.com/golang/protobuf/descriptor"
+	"github.com/golang/protobuf/proto"
+	pbdesc "github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/KibaFox/tls-usr-sessions/pb"
+)
+
+// Redacted replaces the value of sensitive string fields.
+const Redacted = "REDACTED"
+
+// Redact returns a copy of the message where every field marked with the
+// (pb.sensitive) option, including those of nested messages, is redacted.
+// Sensitive strings are replaced with Redacted and other sensitive fields are
+// cleared.
+func Redact(msg proto.Message) proto.Message {
+	if msg == nil || reflect.ValueOf(msg).IsNil() {
+		return msg
+	}
+
+	clone := proto.Clone(msg)
+	redact(reflect.ValueOf(clone))
+	return clone
+}
+
+// redact redacts the message that v points to in place.
+func redact(v reflect.Value) {
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return
+	}
+	msg, ok := v.Interface().(descriptor.Message)
+	if !ok {
+		return
+	}
+
+	_, md := descriptor.ForMessage(msg)
+	st := v.Elem()
+	props := proto.GetProperties(st.Type())
+
+	for _, fd := range md.Field {
+		f, ok := field(st, props, fd)
+		if !ok {
+			continue
+		}
+
+		if sensitive(fd) {
+			scrub(f)
+			continue
+		}
+
+		if fd.GetType() != pbdesc.FieldDescriptorProto_TYPE_MESSAGE {
+			continue
+		}
+		if f.Kind() == reflect.Slice {
+			for i := 0; i < f.Len(); i++ {
+				redact(f.Index(i))
+			}
+		} else {
+			redact(f)
+		}
+	}
+}
+
+// field finds the struct field in st for the field descriptor.  Fields in a
+// oneof are only found if they are the one that is set.
+func field(
+	st reflect.Value, props *proto.StructProperties,
+	fd *pbdesc.FieldDescriptorProto,
+) (f reflect.Value, ok bool) {
+	if fd.OneofIndex != nil {
+		op, ok := props.OneofTypes[fd.GetName()]
+		if !ok {
+			return reflect.Value{}, false
+		}
+		iface := st.Field(op.Field)
+		if iface.IsNil() || iface.Elem().Type() != op.Type {
+			return reflect.Value{}, false
+		}
+		return iface.Elem().Elem().Field(0), true
+	}
+
+	for i, p := range props.Prop {
+		if p.OrigName == fd.GetName() {
+			return st.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func sensitive(fd *pbdesc.FieldDescriptorProto) bool {
+	if fd.Options == nil {
+		return false
+	}
+	ext, err := proto.GetExtension(fd.Options, pb.E_Sensitive)
+	if err != nil {
+		return false
+	}
+	b, ok := ext.(*bool)
+	return ok && *b
+}
+
+func scrub(f reflect.Value) {
+	switch {
+	case f.Kind() == reflect.String:
+		if f.Len() > 0 {
+			f.SetString(Redacted)
+		}
+	case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+		for i := 0; i < f.Len(); i++ {
+			f.Index(i).SetString(Redacted)
+		}
+	default:
+		f.Set(reflect.Zero(f.Type()))
+	}
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns an interceptor that records the latency of
+// each call.
+func UnaryServerInterceptor(m *Server) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		start := time.Now()
+		resp, err = handler(ctx, req)
+		m.RPC(info.FullMethod, status.Code(err).String(), time.Since(start))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor for streaming calls.
+func StreamServerInterceptor(m *Server) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.RPC(info.FullMethod, status.Code(err).String(), time.Since(start))
+		return err
+	}
+}
+
+// ServerCredentials wraps transport credentials to count the handshakes with
+// clients that fail.
+func ServerCredentials(
+	creds credentials.TransportCredentials, m *Server,
+) credentials.TransportCredentials {
+	return &serverCreds{TransportCredentials: creds, m: m}
+}
+
+type serverCreds struct {
+	credentials.TransportCredentials
+	m *Server
+}
+
+func (c *serverCreds) ServerHandshake(
+	conn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	out, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		c.m.HandshakeFailed(err)
+	}
+	return out, info, err
+}
+
+func (c *serverCreds) Clone() credentials.TransportCredentials {
+	return ServerCredentials(c.TransportCredentials.Clone(), c.m)
+}
@@ -0,0 +1,267 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets, in seconds, suited
+// to the latency of RPCs.
+var DefaultBuckets = []float64{
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// Registry holds a set of metrics.  It is an http.Handler serving them in the
+// Prometheus text format.  It is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*vec
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Write writes all metrics to w in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]*vec(nil), r.metrics...)
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+func (r *Registry) register(v *vec) *vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range r.metrics {
+		if m.name == v.name {
+			panic("metrics: duplicate metric " + v.name)
+		}
+	}
+	r.metrics = append(r.metrics, v)
+
+	if len(v.labels) == 0 {
+		v.get(nil)
+	}
+	return v
+}
+
+// CounterVec is a counter for each combination of label values.
+type CounterVec struct{ v *vec }
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(newVec(name, help, "counter", labels))}
+}
+
+// Inc adds one to the counter for the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta, which must not be negative, to the counter for the label
+// values.  Adding zero makes the series appear before anything is counted.
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.v.update(values, func(s *series) { s.value += delta })
+}
+
+// GaugeVec is a gauge for each combination of label values.
+type GaugeVec struct{ v *vec }
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(newVec(name, help, "gauge", labels))}
+}
+
+// Set sets the gauge for the label values.
+func (g *GaugeVec) Set(value float64, values ...string) {
+	g.v.update(values, func(s *series) { s.value = value })
+}
+
+// HistogramVec is a histogram for each combination of label values.
+type HistogramVec struct{ v *vec }
+
+// Histogram registers a histogram with the given bucket upper bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(
+	name, help string, buckets []float64, labels ...string,
+) *HistogramVec {
+	v := newVec(name, help, "histogram", labels)
+	v.buckets = buckets
+	return &HistogramVec{r.register(v)}
+}
+
+// Observe adds a value to the histogram for the label values.
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	h.v.update(values, func(s *series) {
+		for i, le := range h.v.buckets {
+			if value <= le {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+// vec is a metric family: a metric with a series for each combination of
+// label values.
+type vec struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series holds the value of a counter or gauge, or the bucket counts, count
+// and sum (in value) of a histogram.
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+func (v *vec) update(values []string, f func(s *series)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	f(v.get(values))
+}
+
+// get returns the series for the label values, creating it if needed.  The
+// caller must hold the lock unless the vec is not shared yet.
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values",
+			v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{
+			values: append([]string(nil), values...),
+			counts: make([]uint64, len(v.buckets)),
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escape(v.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.series[k]
+		if v.typ != "histogram" {
+			writeSample(w, v.name, v.labels, s.values, s.value)
+			continue
+		}
+
+		names := append(append([]string(nil), v.labels...), "le")
+		for i, le := range v.buckets {
+			values := append(append([]string(nil), s.values...), number(le))
+			writeSample(w, v.name+"_bucket", names, values,
+				float64(s.counts[i]))
+		}
+		values := append(append([]string(nil), s.values...), "+Inf")
+		writeSample(w, v.name+"_bucket", names, values, float64(s.count))
+		writeSample(w, v.name+"_sum", v.labels, s.values, s.value)
+		writeSample(w, v.name+"_count", v.labels, s.values, float64(s.count))
+	}
+}
+
+func writeSample(
+	w *bufio.Writer, name string, labels, values []string, value float64,
+) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, escape(values[i], true))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(number(value))
+	w.WriteByte('\n')
+}
+
+func number(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// escape escapes help text, or label values if quoted is set.
+func escape(s string, quoted bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quoted {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
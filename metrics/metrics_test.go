@@ -0,0 +1,123 @@
+package metrics_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/metrics"
+)
+
+var _ = Describe("Metrics", func() {
+	write := func(r *metrics.Registry) string {
+		var buf bytes.Buffer
+		Expect(r.Write(&buf)).To(Succeed())
+		return buf.String()
+	}
+
+	It("Should write counters and gauges sorted by name", func() {
+		r := metrics.NewRegistry()
+		g := r.Gauge("b_gauge", "A gauge.", "name")
+		c := r.Counter("a_total", "A counter.")
+
+		c.Inc()
+		c.Add(2)
+		g.Set(1.5, `say "hi"`)
+
+		Expect(write(r)).Should(Equal(`# HELP a_total A counter.
+# TYPE a_total counter
+a_total 3
+# HELP b_gauge A gauge.
+# TYPE b_gauge gauge
+b_gauge{name="say \"hi\""} 1.5
+`))
+	})
+
+	It("Should write cumulative histogram buckets", func() {
+		r := metrics.NewRegistry()
+		h := r.Histogram("latency_seconds", "Latency.", []float64{.1, 1}, "op")
+
+		h.Observe(.05, "get")
+		h.Observe(.5, "get")
+		h.Observe(2, "get")
+
+		Expect(write(r)).Should(Equal(`# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="get",le="0.1"} 1
+latency_seconds_bucket{op="get",le="1"} 2
+latency_seconds_bucket{op="get",le="+Inf"} 3
+latency_seconds_sum{op="get"} 2.55
+latency_seconds_count{op="get"} 3
+`))
+	})
+
+	It("Should reject the wrong number of label values", func() {
+		r := metrics.NewRegistry()
+		c := r.Counter("a_total", "A counter.", "outcome")
+
+		Expect(func() { c.Inc() }).Should(Panic())
+		Expect(func() { r.Counter("a_total", "Again.") }).Should(Panic())
+	})
+
+	It("Should export the server metrics", func() {
+		m := metrics.NewServer()
+		m.Login(metrics.LoginSucceeded)
+		m.Issued()
+		m.RPC("/pb.Auth/Login", "OK", 30*time.Millisecond)
+		m.CertExpiry("ca", &x509.Certificate{NotAfter: time.Unix(1700000000, 0)})
+
+		out := write(m.Registry)
+		Expect(out).Should(ContainSubstring(
+			`tls_sess_logins_total{outcome="success"} 1`))
+		Expect(out).Should(ContainSubstring(
+			`tls_sess_logins_total{outcome="failure"} 0`))
+		Expect(out).Should(ContainSubstring(
+			"tls_sess_certificates_issued_total 1"))
+		Expect(out).Should(ContainSubstring(
+			"tls_sess_certificates_revoked_total 0"))
+		Expect(out).Should(ContainSubstring(
+			`tls_sess_rpc_duration_seconds_count{service="pb.Auth",` +
+				`method="Login",code="OK"} 1`))
+		Expect(out).Should(ContainSubstring(
+			`tls_sess_certificate_expiry_timestamp_seconds{cert="ca"} 1.7e+09`))
+	})
+
+	It("Should do nothing when nil", func() {
+		var m *metrics.Server
+		m.Login(metrics.LoginFailed)
+		m.Issued()
+		m.HandshakeFailed(errors.New("boom"))
+	})
+
+	It("Should classify handshake failures", func() {
+		wrap := func(err error) error {
+			return fmt.Errorf("tls: failed to verify certificate: %w", err)
+		}
+
+		Expect(metrics.HandshakeReason(wrap(x509.UnknownAuthorityError{}))).
+			Should(Equal(metrics.HandshakeUnknownCA))
+		Expect(metrics.HandshakeReason(wrap(x509.CertificateInvalidError{
+			Reason: x509.Expired,
+		}))).Should(Equal(metrics.HandshakeExpired))
+		Expect(metrics.HandshakeReason(wrap(x509.CertificateInvalidError{
+			Reason: x509.IncompatibleUsage,
+		}))).Should(Equal(metrics.HandshakeInvalid))
+		Expect(metrics.HandshakeReason(wrap(revoked{}))).
+			Should(Equal(metrics.HandshakeRevoked))
+		Expect(metrics.HandshakeReason(errors.New(
+			"tls: client didn't provide a certificate"))).
+			Should(Equal(metrics.HandshakeNoCertificate))
+		Expect(metrics.HandshakeReason(errors.New("EOF"))).
+			Should(Equal(metrics.HandshakeOther))
+	})
+})
+
+type revoked struct{}
+
+func (revoked) Error() string { return "certificate is revoked" }
+func (revoked) Revoked() bool { return true }
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"crypto/x509"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Outcomes of login attempts.
+const (
+	LoginSucceeded = "success"
+	LoginFailed    = "failure"
+	LoginLocked    = "locked_out"
+	LoginDenied    = "denied"
+)
+
+// Reasons that a TLS handshake with a client failed.
+const (
+	HandshakeUnknownCA     = "unknown_ca"
+	HandshakeExpired       = "expired"
+	HandshakeRevoked       = "revoked"
+	HandshakeNoCertificate = "no_certificate"
+	HandshakeInvalid       = "invalid"
+	HandshakeOther         = "other"
+)
+
+// Server holds the metrics of the demo server.  The methods of a nil Server do
+// nothing, so that metrics can be turned off.
+type Server struct {
+	*Registry
+
+	logins            *CounterVec
+	issued            *CounterVec
+	revoked           *CounterVec
+	handshakeFailures *CounterVec
+	rpcDuration       *HistogramVec
+	certExpiry        *GaugeVec
+}
+
+// NewServer creates a registry with the metrics of the demo server.
+func NewServer() *Server {
+	r := NewRegistry()
+	m := &Server{
+		Registry: r,
+		logins: r.Counter("tls_sess_logins_total",
+			"Login attempts by outcome.", "outcome"),
+		issued: r.Counter("tls_sess_certificates_issued_total",
+			"Client certificates issued."),
+		revoked: r.Counter("tls_sess_certificates_revoked_total",
+			"Client certificates revoked."),
+		handshakeFailures: r.Counter("tls_sess_handshake_failures_total",
+			"Failed TLS handshakes with clients by reason.", "reason"),
+		rpcDuration: r.Histogram("tls_sess_rpc_duration_seconds",
+			"Time taken to handle RPCs.", DefaultBuckets,
+			"service", "method", "code"),
+		certExpiry: r.Gauge("tls_sess_certificate_expiry_timestamp_seconds",
+			"When the server's certificates expire, in seconds since the epoch.",
+			"cert"),
+	}
+
+	for _, o := range []string{
+		LoginSucceeded, LoginFailed, LoginLocked, LoginDenied,
+	} {
+		m.logins.Add(0, o)
+	}
+	for _, r := range []string{
+		HandshakeUnknownCA, HandshakeExpired, HandshakeRevoked,
+		HandshakeNoCertificate, HandshakeInvalid, HandshakeOther,
+	} {
+		m.handshakeFailures.Add(0, r)
+	}
+
+	return m
+}
+
+// Login counts a login attempt with the given outcome.
+func (m *Server) Login(outcome string) {
+	if m != nil {
+		m.logins.Inc(outcome)
+	}
+}
+
+// Issued counts an issued certificate.
+func (m *Server) Issued() {
+	if m != nil {
+		m.issued.Inc()
+	}
+}
+
+// Revoked counts a revoked certificate.
+func (m *Server) Revoked() {
+	if m != nil {
+		m.revoked.Inc()
+	}
+}
+
+// HandshakeFailed counts a failed handshake, classifying the error.
+func (m *Server) HandshakeFailed(err error) {
+	if m != nil {
+		m.handshakeFailures.Inc(HandshakeReason(err))
+	}
+}
+
+// RPC records how long a call to a method, given as "/service/method", took
+// and the status code it returned.
+func (m *Server) RPC(fullMethod, code string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	service, method := splitMethod(fullMethod)
+	m.rpcDuration.Observe(d.Seconds(), service, method, code)
+}
+
+// CertExpiry sets when the named certificate expires.
+func (m *Server) CertExpiry(name string, cert *x509.Certificate) {
+	if m != nil {
+		m.certExpiry.Set(float64(cert.NotAfter.Unix()), name)
+	}
+}
+
+// RevokedError is implemented by errors that reject a revoked certificate, so
+// that handshakes failing because of them are counted as revoked.
+type RevokedError interface {
+	error
+	Revoked() bool
+}
+
+// HandshakeReason classifies the error of a failed TLS handshake.
+func HandshakeReason(err error) string {
+	var unknown x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var revoked RevokedError
+
+	switch {
+	case errors.As(err, &unknown):
+		return HandshakeUnknownCA
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return HandshakeExpired
+	case errors.As(err, &revoked) && revoked.Revoked():
+		return HandshakeRevoked
+	case errors.As(err, &invalid):
+		return HandshakeInvalid
+	case strings.Contains(err.Error(), "didn't provide a certificate"):
+		return HandshakeNoCertificate
+	}
+	return HandshakeOther
+}
+
+func splitMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
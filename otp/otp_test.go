@@ -0,0 +1,133 @@
+package otp_test
+
+import (
+	"encoding/base32"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/otp"
+)
+
+var _ = Describe("TOTP", func() {
+	// The SHA-1 secret from the test vectors in RFC 6238, appendix B.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	It("Should match the RFC 6238 test vectors", func() {
+		vectors := map[int64]string{
+			59:          "287082",
+			1111111109:  "081804",
+			1111111111:  "050471",
+			1234567890:  "005924",
+			2000000000:  "279037",
+			20000000000: "353130",
+		}
+		for unix, want := range vectors {
+			code, err := otp.Code(secret, time.Unix(unix, 0))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(code).Should(Equal(want), "code at %d", unix)
+		}
+	})
+
+	It("Should accept codes from adjacent periods only", func() {
+		now := time.Unix(1234567890, 0)
+		prev, err := otp.Code(secret, now.Add(-otp.Period))
+		Expect(err).ToNot(HaveOccurred())
+		old, err := otp.Code(secret, now.Add(-3*otp.Period))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, ok, err := otp.Validate(secret, prev, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).Should(BeTrue())
+
+		_, ok, err = otp.Validate(secret, old, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).Should(BeFalse())
+	})
+
+	It("Should generate an otpauth URI", func() {
+		uri := otp.URI("tls-sess-demo", "demo", "JBSWY3DPEHPK3PXP")
+
+		u, err := url.Parse(uri)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(u.Scheme).Should(Equal("otpauth"))
+		Expect(u.Host).Should(Equal("totp"))
+		Expect(u.Path).Should(Equal("/tls-sess-demo:demo"))
+		Expect(u.Query().Get("secret")).Should(Equal("JBSWY3DPEHPK3PXP"))
+		Expect(u.Query().Get("issuer")).Should(Equal("tls-sess-demo"))
+	})
+})
+
+var _ = Describe("Store", func() {
+	It("Should enroll a user and verify codes once", func() {
+		dir := tmpDir()
+		defer rmDir(dir)
+		path := filepath.Join(dir, "totp.json")
+
+		store, err := otp.OpenStore(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Enrolled("demo")).Should(BeFalse())
+
+		secret, codes, err := store.Enroll("demo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(codes).Should(HaveLen(otp.RecoveryCodes))
+		Expect(store.Enrolled("demo")).Should(BeTrue())
+
+		info, err := os.Stat(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Mode().Perm()).Should(Equal(os.FileMode(0600)))
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).Should(HaveLen(1), "no temporary file should be left")
+
+		code, err := otp.Code(secret, time.Now())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(store.Verify("demo", code)).Should(BeTrue())
+		Expect(store.Verify("demo", code)).Should(BeFalse(),
+			"a code should not be accepted twice")
+		Expect(store.Verify("demo", "000000")).Should(BeFalse())
+
+		By("Reopening the store")
+		store, err = otp.OpenStore(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Enrolled("demo")).Should(BeTrue())
+		Expect(store.Verify("demo", code)).Should(BeFalse())
+
+		By("Using a recovery code")
+		Expect(store.Verify("demo", codes[3])).Should(BeTrue())
+		Expect(store.Verify("demo", codes[3])).Should(BeFalse())
+		Expect(store.Verify("demo", codes[4])).Should(BeTrue())
+	})
+
+	It("Should not verify users who are not enrolled", func() {
+		dir := tmpDir()
+		defer rmDir(dir)
+
+		store, err := otp.OpenStore(filepath.Join(dir, "totp.json"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Verify("nobody", "123456")).Should(BeFalse())
+	})
+
+	It("Should report when the store is unavailable", func() {
+		dir := tmpDir()
+		defer rmDir(dir)
+		path := filepath.Join(dir, "totp.json")
+
+		store, err := otp.OpenStore(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Check()).To(Succeed())
+
+		_, _, err = store.Enroll("demo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(store.Check()).To(Succeed())
+
+		rmDir(dir)
+		Expect(store.Check()).ToNot(Succeed())
+	})
+})
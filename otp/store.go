@@ -0,0 +1,191 @@
+package otp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+)
+
+// RecoveryCodes is the number of recovery codes generated at enrollment.
+const RecoveryCodes = 10
+
+// Enrollment is what is stored for a user who enrolled in TOTP.
+type Enrollment struct {
+	Secret string `json:"secret"`
+
+	// RecoveryCodes holds the SHA-256 hashes of the unused recovery codes.
+	RecoveryCodes []string `json:"recovery_codes"`
+
+	// LastStep is the time step of the last accepted code, so that a code
+	// cannot be used twice.
+	LastStep int64 `json:"last_step"`
+}
+
+// Store keeps the TOTP enrollments of users in a JSON file.  It is safe for
+// concurrent use.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	users map[string]*Enrollment
+}
+
+// OpenStore loads the enrollments from the file at path.  The file is created
+// when the first user enrolls if it does not exist.
+func OpenStore(path string) (store *Store, err error) {
+	store = &Store{path: path, users: make(map[string]*Enrollment)}
+
+	byt, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading TOTP store")
+	}
+
+	err = json.Unmarshal(byt, &store.users)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing TOTP store")
+	}
+
+	return store, nil
+}
+
+// Enrolled reports whether the user requires a TOTP code to login.
+func (s *Store) Enrolled(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[user]
+	return ok
+}
+
+// Enroll generates a new secret and recovery codes for the user, replacing any
+// previous enrollment.  The recovery codes are only returned here; the store
+// keeps their hashes.
+func (s *Store) Enroll(user string) (secret string, codes []string, err error) {
+	secret, err = GenerateSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	enr := &Enrollment{Secret: secret}
+	for i := 0; i < RecoveryCodes; i++ {
+		var code string
+		code, err = newRecoveryCode()
+		if err != nil {
+			return "", nil, err
+		}
+		codes = append(codes, code)
+		enr.RecoveryCodes = append(enr.RecoveryCodes, hashCode(code))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user] = enr
+	err = s.save()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return secret, codes, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code for the user.  Either
+// kind of code is accepted only once.
+func (s *Store) Verify(user, code string) (ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enr, found := s.users[user]
+	if !found {
+		return false, nil
+	}
+
+	codeStep, ok, err := Validate(enr.Secret, code, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		if codeStep <= enr.LastStep {
+			return false, nil
+		}
+		enr.LastStep = codeStep
+		return true, s.save()
+	}
+
+	hash := hashCode(code)
+	for i, rc := range enr.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(rc), []byte(hash)) == 1 {
+			enr.RecoveryCodes = append(
+				enr.RecoveryCodes[:i], enr.RecoveryCodes[i+1:]...)
+			return true, s.save()
+		}
+	}
+
+	return false, nil
+}
+
+// Check reports whether the store's file can be read and written, or if it
+// was not created yet, whether its directory exists.
+func (s *Store) Check() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		var info os.FileInfo
+		info, err = os.Stat(filepath.Dir(s.path))
+		if err != nil {
+			return errors.Wrap(err, "TOTP store unavailable")
+		}
+		if !info.IsDir() {
+			return errors.New("TOTP store unavailable: not in a directory")
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "TOTP store unavailable")
+	}
+	return f.Close()
+}
+
+// save writes the store to its file.  The caller must hold the lock.
+func (s *Store) save() error {
+	byt, err := json.MarshalIndent(s.users, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding TOTP store")
+	}
+
+	err = keystore.WriteFile(s.path, byt)
+	if err != nil {
+		return errors.Wrap(err, "saving TOTP store")
+	}
+	return nil
+}
+
+func newRecoveryCode() (code string, err error) {
+	byt := make([]byte, 5)
+	_, err = rand.Read(byt)
+	if err != nil {
+		return "", errors.Wrap(err, "generating recovery code")
+	}
+	code = hex.EncodeToString(byt)
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
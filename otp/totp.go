@@ -0,0 +1,126 @@
+// Package otp implements time-based one-time passwords (TOTP) as described in
+// RFC 6238, which are used as an optional second factor at login.
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // nolint: gosec
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Digits is the number of digits in a code.
+	Digits = 6
+
+	// Period is how long each code is valid for.
+	Period = 30 * time.Second
+
+	// Skew is the number of periods before and after the current one that
+	// are also accepted to allow for clock drift.
+	Skew = 1
+
+	secretSize = 20
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret creates a new random secret encoded in base32.
+func GenerateSecret() (secret string, err error) {
+	byt := make([]byte, secretSize)
+	_, err = rand.Read(byt)
+	if err != nil {
+		return "", errors.Wrap(err, "generating secret")
+	}
+	return b32.EncodeToString(byt), nil
+}
+
+// Code returns the code for the secret at the given time.
+func Code(secret string, t time.Time) (code string, err error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, step(t)), nil
+}
+
+// Validate checks the code against the secret at the given time.  If the code
+// is valid, the time step it belongs to is returned so that callers can refuse
+// to accept the same code twice.
+func Validate(
+	secret, code string, t time.Time,
+) (codeStep int64, ok bool, err error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	now := step(t)
+	for s := now - Skew; s <= now+Skew; s++ {
+		want := hotp(key, s)
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return s, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// URI returns an otpauth:// URI for the secret that authenticator apps can
+// import, typically by scanning it as a QR code.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+func decodeSecret(secret string) (key []byte, err error) {
+	key, err = b32.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding secret")
+	}
+	return key, nil
+}
+
+func step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// hotp computes an HOTP value as described in RFC 4226.
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:]) // nolint: errcheck
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	val := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, val%mod)
+}
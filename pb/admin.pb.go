@@ -0,0 +1,611 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: admin.proto
+
+package pb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type Lockout struct {
+	// Key identifies what is locked out, such as "user:demo" or "ip:127.0.0.1".
+	Key string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	// Failures is the number of failed logins that led to the lockout.
+	Failures int32 `protobuf:"varint,2,opt,name=failures,proto3" json:"failures,omitempty"`
+	// Until is when the lockout ends, in seconds since the Unix epoch.
+	Until                int64    `protobuf:"varint,3,opt,name=until,proto3" json:"until,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Lockout) Reset()         { *m = Lockout{} }
+func (m *Lockout) String() string { return proto.CompactTextString(m) }
+func (*Lockout) ProtoMessage()    {}
+func (*Lockout) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{0}
+}
+
+func (m *Lockout) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Lockout.Unmarshal(m, b)
+}
+func (m *Lockout) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Lockout.Marshal(b, m, deterministic)
+}
+func (m *Lockout) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Lockout.Merge(m, src)
+}
+func (m *Lockout) XXX_Size() int {
+	return xxx_messageInfo_Lockout.Size(m)
+}
+func (m *Lockout) XXX_DiscardUnknown() {
+	xxx_messageInfo_Lockout.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Lockout proto.InternalMessageInfo
+
+func (m *Lockout) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *Lockout) GetFailures() int32 {
+	if m != nil {
+		return m.Failures
+	}
+	return 0
+}
+
+func (m *Lockout) GetUntil() int64 {
+	if m != nil {
+		return m.Until
+	}
+	return 0
+}
+
+type Lockouts struct {
+	Lockouts             []*Lockout `protobuf:"bytes,1,rep,name=lockouts,proto3" json:"lockouts,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *Lockouts) Reset()         { *m = Lockouts{} }
+func (m *Lockouts) String() string { return proto.CompactTextString(m) }
+func (*Lockouts) ProtoMessage()    {}
+func (*Lockouts) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{1}
+}
+
+func (m *Lockouts) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Lockouts.Unmarshal(m, b)
+}
+func (m *Lockouts) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Lockouts.Marshal(b, m, deterministic)
+}
+func (m *Lockouts) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Lockouts.Merge(m, src)
+}
+func (m *Lockouts) XXX_Size() int {
+	return xxx_messageInfo_Lockouts.Size(m)
+}
+func (m *Lockouts) XXX_DiscardUnknown() {
+	xxx_messageInfo_Lockouts.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Lockouts proto.InternalMessageInfo
+
+func (m *Lockouts) GetLockouts() []*Lockout {
+	if m != nil {
+		return m.Lockouts
+	}
+	return nil
+}
+
+type ClearLockoutRequest struct {
+	Key                  string   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ClearLockoutRequest) Reset()         { *m = ClearLockoutRequest{} }
+func (m *ClearLockoutRequest) String() string { return proto.CompactTextString(m) }
+func (*ClearLockoutRequest) ProtoMessage()    {}
+func (*ClearLockoutRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{2}
+}
+
+func (m *ClearLockoutRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ClearLockoutRequest.Unmarshal(m, b)
+}
+func (m *ClearLockoutRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ClearLockoutRequest.Marshal(b, m, deterministic)
+}
+func (m *ClearLockoutRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ClearLockoutRequest.Merge(m, src)
+}
+func (m *ClearLockoutRequest) XXX_Size() int {
+	return xxx_messageInfo_ClearLockoutRequest.Size(m)
+}
+func (m *ClearLockoutRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ClearLockoutRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ClearLockoutRequest proto.InternalMessageInfo
+
+func (m *ClearLockoutRequest) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+type ClearLockoutResponse struct {
+	// Cleared is true if any failures were recorded for the key.
+	Cleared              bool     `protobuf:"varint,1,opt,name=cleared,proto3" json:"cleared,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ClearLockoutResponse) Reset()         { *m = ClearLockoutResponse{} }
+func (m *ClearLockoutResponse) String() string { return proto.CompactTextString(m) }
+func (*ClearLockoutResponse) ProtoMessage()    {}
+func (*ClearLockoutResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{3}
+}
+
+func (m *ClearLockoutResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ClearLockoutResponse.Unmarshal(m, b)
+}
+func (m *ClearLockoutResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ClearLockoutResponse.Marshal(b, m, deterministic)
+}
+func (m *ClearLockoutResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ClearLockoutResponse.Merge(m, src)
+}
+func (m *ClearLockoutResponse) XXX_Size() int {
+	return xxx_messageInfo_ClearLockoutResponse.Size(m)
+}
+func (m *ClearLockoutResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ClearLockoutResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ClearLockoutResponse proto.InternalMessageInfo
+
+func (m *ClearLockoutResponse) GetCleared() bool {
+	if m != nil {
+		return m.Cleared
+	}
+	return false
+}
+
+type EnrollTOTPRequest struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EnrollTOTPRequest) Reset()         { *m = EnrollTOTPRequest{} }
+func (m *EnrollTOTPRequest) String() string { return proto.CompactTextString(m) }
+func (*EnrollTOTPRequest) ProtoMessage()    {}
+func (*EnrollTOTPRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{4}
+}
+
+func (m *EnrollTOTPRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EnrollTOTPRequest.Unmarshal(m, b)
+}
+func (m *EnrollTOTPRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EnrollTOTPRequest.Marshal(b, m, deterministic)
+}
+func (m *EnrollTOTPRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EnrollTOTPRequest.Merge(m, src)
+}
+func (m *EnrollTOTPRequest) XXX_Size() int {
+	return xxx_messageInfo_EnrollTOTPRequest.Size(m)
+}
+func (m *EnrollTOTPRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_EnrollTOTPRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EnrollTOTPRequest proto.InternalMessageInfo
+
+func (m *EnrollTOTPRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type TOTPEnrollment struct {
+	// Secret is the shared secret encoded in base32.
+	Secret string `protobuf:"bytes,1,opt,name=secret,proto3" json:"secret,omitempty"`
+	// URI is the otpauth:// URI of the secret for authenticator apps.
+	Uri string `protobuf:"bytes,2,opt,name=uri,proto3" json:"uri,omitempty"`
+	// RecoveryCodes can each be used once in place of a TOTP code.
+	RecoveryCodes        []string `protobuf:"bytes,3,rep,name=recovery_codes,json=recoveryCodes,proto3" json:"recovery_codes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TOTPEnrollment) Reset()         { *m = TOTPEnrollment{} }
+func (m *TOTPEnrollment) String() string { return proto.CompactTextString(m) }
+func (*TOTPEnrollment) ProtoMessage()    {}
+func (*TOTPEnrollment) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{5}
+}
+
+func (m *TOTPEnrollment) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TOTPEnrollment.Unmarshal(m, b)
+}
+func (m *TOTPEnrollment) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TOTPEnrollment.Marshal(b, m, deterministic)
+}
+func (m *TOTPEnrollment) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TOTPEnrollment.Merge(m, src)
+}
+func (m *TOTPEnrollment) XXX_Size() int {
+	return xxx_messageInfo_TOTPEnrollment.Size(m)
+}
+func (m *TOTPEnrollment) XXX_DiscardUnknown() {
+	xxx_messageInfo_TOTPEnrollment.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TOTPEnrollment proto.InternalMessageInfo
+
+func (m *TOTPEnrollment) GetSecret() string {
+	if m != nil {
+		return m.Secret
+	}
+	return ""
+}
+
+func (m *TOTPEnrollment) GetUri() string {
+	if m != nil {
+		return m.Uri
+	}
+	return ""
+}
+
+func (m *TOTPEnrollment) GetRecoveryCodes() []string {
+	if m != nil {
+		return m.RecoveryCodes
+	}
+	return nil
+}
+
+type RevokeCertRequest struct {
+	// Serial is the serial number of the certificate in hexadecimal.
+	Serial               string   `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RevokeCertRequest) Reset()         { *m = RevokeCertRequest{} }
+func (m *RevokeCertRequest) String() string { return proto.CompactTextString(m) }
+func (*RevokeCertRequest) ProtoMessage()    {}
+func (*RevokeCertRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{6}
+}
+
+func (m *RevokeCertRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RevokeCertRequest.Unmarshal(m, b)
+}
+func (m *RevokeCertRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RevokeCertRequest.Marshal(b, m, deterministic)
+}
+func (m *RevokeCertRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RevokeCertRequest.Merge(m, src)
+}
+func (m *RevokeCertRequest) XXX_Size() int {
+	return xxx_messageInfo_RevokeCertRequest.Size(m)
+}
+func (m *RevokeCertRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RevokeCertRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RevokeCertRequest proto.InternalMessageInfo
+
+func (m *RevokeCertRequest) GetSerial() string {
+	if m != nil {
+		return m.Serial
+	}
+	return ""
+}
+
+type RevokeCertResponse struct {
+	// Revoked is false if the certificate was already revoked.
+	Revoked              bool     `protobuf:"varint,1,opt,name=revoked,proto3" json:"revoked,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RevokeCertResponse) Reset()         { *m = RevokeCertResponse{} }
+func (m *RevokeCertResponse) String() string { return proto.CompactTextString(m) }
+func (*RevokeCertResponse) ProtoMessage()    {}
+func (*RevokeCertResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_73a7fc70dcc2027c, []int{7}
+}
+
+func (m *RevokeCertResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RevokeCertResponse.Unmarshal(m, b)
+}
+func (m *RevokeCertResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RevokeCertResponse.Marshal(b, m, deterministic)
+}
+func (m *RevokeCertResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RevokeCertResponse.Merge(m, src)
+}
+func (m *RevokeCertResponse) XXX_Size() int {
+	return xxx_messageInfo_RevokeCertResponse.Size(m)
+}
+func (m *RevokeCertResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RevokeCertResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RevokeCertResponse proto.InternalMessageInfo
+
+func (m *RevokeCertResponse) GetRevoked() bool {
+	if m != nil {
+		return m.Revoked
+	}
+	return false
+}
+
+func init() {
+	proto.RegisterType((*Lockout)(nil), "pb.Lockout")
+	proto.RegisterType((*Lockouts)(nil), "pb.Lockouts")
+	proto.RegisterType((*ClearLockoutRequest)(nil), "pb.ClearLockoutRequest")
+	proto.RegisterType((*ClearLockoutResponse)(nil), "pb.ClearLockoutResponse")
+	proto.RegisterType((*EnrollTOTPRequest)(nil), "pb.EnrollTOTPRequest")
+	proto.RegisterType((*TOTPEnrollment)(nil), "pb.TOTPEnrollment")
+	proto.RegisterType((*RevokeCertRequest)(nil), "pb.RevokeCertRequest")
+	proto.RegisterType((*RevokeCertResponse)(nil), "pb.RevokeCertResponse")
+}
+
+func init() { proto.RegisterFile("admin.proto", fileDescriptor_73a7fc70dcc2027c) }
+
+var fileDescriptor_73a7fc70dcc2027c = []byte{
+	// 429 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x52, 0x4f, 0x6f, 0xd3, 0x30,
+	0x14, 0x6f, 0x1a, 0xba, 0x85, 0xd7, 0x6e, 0x62, 0x8f, 0x51, 0xac, 0xc0, 0x21, 0xf2, 0x65, 0x95,
+	0x26, 0xa5, 0x68, 0x93, 0x90, 0x38, 0x70, 0x80, 0x6a, 0xb7, 0x21, 0x50, 0xb4, 0x3b, 0x4a, 0xd3,
+	0xb7, 0x29, 0xaa, 0x1b, 0x07, 0xdb, 0x99, 0xd4, 0x1b, 0x5f, 0x8a, 0xaf, 0x87, 0x90, 0x6b, 0x27,
+	0xcd, 0xe8, 0x6e, 0xfe, 0xfd, 0xb1, 0xfd, 0xde, 0xfb, 0x3d, 0x18, 0xe7, 0xab, 0x4d, 0x59, 0xa5,
+	0xb5, 0x92, 0x46, 0xe2, 0xb0, 0x5e, 0xc6, 0xef, 0x1e, 0xa4, 0x7c, 0x10, 0x34, 0xdf, 0x31, 0xcb,
+	0xe6, 0x7e, 0x4e, 0x9b, 0xda, 0x6c, 0x9d, 0x21, 0x3e, 0x91, 0xb5, 0x29, 0x65, 0xa5, 0x1d, 0xe4,
+	0xdf, 0xe0, 0xf8, 0x56, 0x16, 0x6b, 0xd9, 0x18, 0x7c, 0x05, 0xe1, 0x9a, 0xb6, 0x2c, 0x48, 0x82,
+	0xd9, 0xcb, 0xcc, 0x1e, 0x31, 0x86, 0xe8, 0x3e, 0x2f, 0x45, 0xa3, 0x48, 0xb3, 0x61, 0x12, 0xcc,
+	0x46, 0x59, 0x87, 0xf1, 0x1c, 0x46, 0x4d, 0x65, 0x4a, 0xc1, 0xc2, 0x24, 0x98, 0x85, 0x99, 0x03,
+	0xfc, 0x1a, 0x22, 0xff, 0x9c, 0xc6, 0x0b, 0x88, 0x84, 0x3f, 0xb3, 0x20, 0x09, 0x67, 0xe3, 0xab,
+	0x71, 0x5a, 0x2f, 0x53, 0xaf, 0x67, 0x9d, 0xc8, 0x2f, 0xe0, 0xf5, 0x42, 0x50, 0xae, 0x5a, 0x85,
+	0x7e, 0x35, 0xa4, 0x9f, 0xa9, 0x87, 0x7f, 0x80, 0xf3, 0xa7, 0x46, 0x5d, 0xcb, 0x4a, 0x13, 0x32,
+	0x38, 0x2e, 0x2c, 0x4f, 0xab, 0x9d, 0x3b, 0xca, 0x5a, 0xc8, 0xe7, 0x70, 0x76, 0x53, 0x29, 0x29,
+	0xc4, 0xdd, 0xf7, 0xbb, 0x1f, 0xed, 0xc3, 0x31, 0x44, 0x8d, 0x26, 0x55, 0xe5, 0x1b, 0xf2, 0xaf,
+	0x77, 0x98, 0x6b, 0x38, 0xb5, 0x56, 0x77, 0x69, 0x43, 0x95, 0xc1, 0xf7, 0x70, 0xa4, 0xa9, 0x50,
+	0x64, 0x9c, 0xf7, 0xeb, 0x8b, 0xdf, 0x7f, 0x58, 0x90, 0x79, 0x0e, 0xa7, 0x10, 0x36, 0xaa, 0x64,
+	0xc3, 0x9e, 0x64, 0x09, 0xbc, 0x84, 0x53, 0x45, 0x85, 0x7c, 0x24, 0xb5, 0xfd, 0x59, 0xc8, 0x15,
+	0x69, 0x16, 0x26, 0x61, 0x67, 0x39, 0x69, 0xb5, 0x85, 0x95, 0xf8, 0x25, 0x9c, 0x65, 0xf4, 0x28,
+	0xd7, 0xb4, 0x20, 0xd5, 0xb5, 0x3f, 0xb5, 0xff, 0xaa, 0x32, 0x17, 0xbe, 0x46, 0x8f, 0x78, 0x0a,
+	0xd8, 0x37, 0xef, 0x47, 0xa0, 0x76, 0x6c, 0x37, 0x02, 0x0f, 0xaf, 0xfe, 0x06, 0x30, 0xfa, 0x62,
+	0x37, 0x04, 0x3f, 0xc2, 0xe4, 0xb6, 0xd4, 0xa6, 0x0b, 0x68, 0x9a, 0xba, 0x45, 0x49, 0xdb, 0x45,
+	0x49, 0x6f, 0xec, 0xa2, 0xc4, 0x93, 0x5e, 0x4c, 0x9a, 0x0f, 0x70, 0x01, 0x93, 0xfe, 0xd8, 0xf1,
+	0xad, 0xd5, 0x9f, 0x49, 0x2c, 0x66, 0x87, 0x82, 0x2b, 0x8f, 0x0f, 0xf0, 0x13, 0xc0, 0x3e, 0x09,
+	0x7c, 0x63, 0x9d, 0x07, 0xc9, 0xc4, 0x68, 0xe9, 0xa7, 0xf3, 0xe7, 0x03, 0xfc, 0x0c, 0xb0, 0xef,
+	0xd8, 0x5d, 0x3d, 0x18, 0x57, 0x3c, 0xfd, 0x9f, 0x6e, 0x7f, 0x5e, 0x1e, 0xed, 0xda, 0xbb, 0xfe,
+	0x17, 0x00, 0x00, 0xff, 0xff, 0xea, 0x5e, 0x2f, 0x87, 0x28, 0x03, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AdminClient interface {
+	ListLockouts(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*Lockouts, error)
+	ClearLockout(ctx context.Context, in *ClearLockoutRequest, opts ...grpc.CallOption) (*ClearLockoutResponse, error)
+	EnrollTOTP(ctx context.Context, in *EnrollTOTPRequest, opts ...grpc.CallOption) (*TOTPEnrollment, error)
+	RevokeCert(ctx context.Context, in *RevokeCertRequest, opts ...grpc.CallOption) (*RevokeCertResponse, error)
+}
+
+type adminClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAdminClient(cc *grpc.ClientConn) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListLockouts(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*Lockouts, error) {
+	out := new(Lockouts)
+	err := c.cc.Invoke(ctx, "/pb.Admin/ListLockouts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ClearLockout(ctx context.Context, in *ClearLockoutRequest, opts ...grpc.CallOption) (*ClearLockoutResponse, error) {
+	out := new(ClearLockoutResponse)
+	err := c.cc.Invoke(ctx, "/pb.Admin/ClearLockout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) EnrollTOTP(ctx context.Context, in *EnrollTOTPRequest, opts ...grpc.CallOption) (*TOTPEnrollment, error) {
+	out := new(TOTPEnrollment)
+	err := c.cc.Invoke(ctx, "/pb.Admin/EnrollTOTP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RevokeCert(ctx context.Context, in *RevokeCertRequest, opts ...grpc.CallOption) (*RevokeCertResponse, error) {
+	out := new(RevokeCertResponse)
+	err := c.cc.Invoke(ctx, "/pb.Admin/RevokeCert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+type AdminServer interface {
+	ListLockouts(context.Context, *empty.Empty) (*Lockouts, error)
+	ClearLockout(context.Context, *ClearLockoutRequest) (*ClearLockoutResponse, error)
+	EnrollTOTP(context.Context, *EnrollTOTPRequest) (*TOTPEnrollment, error)
+	RevokeCert(context.Context, *RevokeCertRequest) (*RevokeCertResponse, error)
+}
+
+// UnimplementedAdminServer can be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (*UnimplementedAdminServer) ListLockouts(ctx context.Context, req *empty.Empty) (*Lockouts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLockouts not implemented")
+}
+func (*UnimplementedAdminServer) ClearLockout(ctx context.Context, req *ClearLockoutRequest) (*ClearLockoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ClearLockout not implemented")
+}
+func (*UnimplementedAdminServer) EnrollTOTP(ctx context.Context, req *EnrollTOTPRequest) (*TOTPEnrollment, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnrollTOTP not implemented")
+}
+func (*UnimplementedAdminServer) RevokeCert(ctx context.Context, req *RevokeCertRequest) (*RevokeCertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeCert not implemented")
+}
+
+func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
+	s.RegisterService(&_Admin_serviceDesc, srv)
+}
+
+func _Admin_ListLockouts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListLockouts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Admin/ListLockouts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListLockouts(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ClearLockout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearLockoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ClearLockout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Admin/ClearLockout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ClearLockout(ctx, req.(*ClearLockoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_EnrollTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollTOTPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).EnrollTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Admin/EnrollTOTP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).EnrollTOTP(ctx, req.(*EnrollTOTPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RevokeCert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeCertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RevokeCert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Admin/RevokeCert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RevokeCert(ctx, req.(*RevokeCertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Admin_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListLockouts",
+			Handler:    _Admin_ListLockouts_Handler,
+		},
+		{
+			MethodName: "ClearLockout",
+			Handler:    _Admin_ClearLockout_Handler,
+		},
+		{
+			MethodName: "EnrollTOTP",
+			Handler:    _Admin_EnrollTOTP_Handler,
+		},
+		{
+			MethodName: "RevokeCert",
+			Handler:    _Admin_RevokeCert_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "admin.proto",
+}
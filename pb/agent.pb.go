@@ -0,0 +1,787 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: agent.proto
+
+package pb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type AddKeyRequest struct {
+	Profile string `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	// Key is the private key in PEM format.
+	Key []byte `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	// Cert is the certificate of the key and Anchors the trust anchors, in PEM
+	// format.
+	Cert    string `protobuf:"bytes,3,opt,name=cert,proto3" json:"cert,omitempty"`
+	Anchors string `protobuf:"bytes,4,opt,name=anchors,proto3" json:"anchors,omitempty"`
+	// Lifetime is how many seconds the key is held, or 0 for the agent's
+	// default.
+	Lifetime             int64    `protobuf:"varint,5,opt,name=lifetime,proto3" json:"lifetime,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AddKeyRequest) Reset()         { *m = AddKeyRequest{} }
+func (m *AddKeyRequest) String() string { return proto.CompactTextString(m) }
+func (*AddKeyRequest) ProtoMessage()    {}
+func (*AddKeyRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{0}
+}
+
+func (m *AddKeyRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AddKeyRequest.Unmarshal(m, b)
+}
+func (m *AddKeyRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AddKeyRequest.Marshal(b, m, deterministic)
+}
+func (m *AddKeyRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AddKeyRequest.Merge(m, src)
+}
+func (m *AddKeyRequest) XXX_Size() int {
+	return xxx_messageInfo_AddKeyRequest.Size(m)
+}
+func (m *AddKeyRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AddKeyRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AddKeyRequest proto.InternalMessageInfo
+
+func (m *AddKeyRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *AddKeyRequest) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *AddKeyRequest) GetCert() string {
+	if m != nil {
+		return m.Cert
+	}
+	return ""
+}
+
+func (m *AddKeyRequest) GetAnchors() string {
+	if m != nil {
+		return m.Anchors
+	}
+	return ""
+}
+
+func (m *AddKeyRequest) GetLifetime() int64 {
+	if m != nil {
+		return m.Lifetime
+	}
+	return 0
+}
+
+type RemoveKeyRequest struct {
+	// Profile is that of the key to remove, or empty to remove them all.
+	Profile              string   `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RemoveKeyRequest) Reset()         { *m = RemoveKeyRequest{} }
+func (m *RemoveKeyRequest) String() string { return proto.CompactTextString(m) }
+func (*RemoveKeyRequest) ProtoMessage()    {}
+func (*RemoveKeyRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{1}
+}
+
+func (m *RemoveKeyRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RemoveKeyRequest.Unmarshal(m, b)
+}
+func (m *RemoveKeyRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RemoveKeyRequest.Marshal(b, m, deterministic)
+}
+func (m *RemoveKeyRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RemoveKeyRequest.Merge(m, src)
+}
+func (m *RemoveKeyRequest) XXX_Size() int {
+	return xxx_messageInfo_RemoveKeyRequest.Size(m)
+}
+func (m *RemoveKeyRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RemoveKeyRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RemoveKeyRequest proto.InternalMessageInfo
+
+func (m *RemoveKeyRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+type AgentKey struct {
+	Profile string `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	Cert    string `protobuf:"bytes,2,opt,name=cert,proto3" json:"cert,omitempty"`
+	Anchors string `protobuf:"bytes,3,opt,name=anchors,proto3" json:"anchors,omitempty"`
+	// Expires is when the agent forgets the key, in seconds since the Unix
+	// epoch, or 0 if it is held until removed.
+	Expires              int64    `protobuf:"varint,4,opt,name=expires,proto3" json:"expires,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AgentKey) Reset()         { *m = AgentKey{} }
+func (m *AgentKey) String() string { return proto.CompactTextString(m) }
+func (*AgentKey) ProtoMessage()    {}
+func (*AgentKey) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{2}
+}
+
+func (m *AgentKey) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AgentKey.Unmarshal(m, b)
+}
+func (m *AgentKey) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AgentKey.Marshal(b, m, deterministic)
+}
+func (m *AgentKey) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AgentKey.Merge(m, src)
+}
+func (m *AgentKey) XXX_Size() int {
+	return xxx_messageInfo_AgentKey.Size(m)
+}
+func (m *AgentKey) XXX_DiscardUnknown() {
+	xxx_messageInfo_AgentKey.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AgentKey proto.InternalMessageInfo
+
+func (m *AgentKey) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *AgentKey) GetCert() string {
+	if m != nil {
+		return m.Cert
+	}
+	return ""
+}
+
+func (m *AgentKey) GetAnchors() string {
+	if m != nil {
+		return m.Anchors
+	}
+	return ""
+}
+
+func (m *AgentKey) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
+type AgentKeys struct {
+	Keys                 []*AgentKey `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *AgentKeys) Reset()         { *m = AgentKeys{} }
+func (m *AgentKeys) String() string { return proto.CompactTextString(m) }
+func (*AgentKeys) ProtoMessage()    {}
+func (*AgentKeys) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{3}
+}
+
+func (m *AgentKeys) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AgentKeys.Unmarshal(m, b)
+}
+func (m *AgentKeys) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AgentKeys.Marshal(b, m, deterministic)
+}
+func (m *AgentKeys) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AgentKeys.Merge(m, src)
+}
+func (m *AgentKeys) XXX_Size() int {
+	return xxx_messageInfo_AgentKeys.Size(m)
+}
+func (m *AgentKeys) XXX_DiscardUnknown() {
+	xxx_messageInfo_AgentKeys.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AgentKeys proto.InternalMessageInfo
+
+func (m *AgentKeys) GetKeys() []*AgentKey {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
+type SignRequest struct {
+	Profile string `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	Digest  []byte `protobuf:"bytes,2,opt,name=digest,proto3" json:"digest,omitempty"`
+	// Hash is the crypto.Hash the digest was made with.
+	Hash                 uint32   `protobuf:"varint,3,opt,name=hash,proto3" json:"hash,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SignRequest) Reset()         { *m = SignRequest{} }
+func (m *SignRequest) String() string { return proto.CompactTextString(m) }
+func (*SignRequest) ProtoMessage()    {}
+func (*SignRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{4}
+}
+
+func (m *SignRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SignRequest.Unmarshal(m, b)
+}
+func (m *SignRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SignRequest.Marshal(b, m, deterministic)
+}
+func (m *SignRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SignRequest.Merge(m, src)
+}
+func (m *SignRequest) XXX_Size() int {
+	return xxx_messageInfo_SignRequest.Size(m)
+}
+func (m *SignRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SignRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SignRequest proto.InternalMessageInfo
+
+func (m *SignRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *SignRequest) GetDigest() []byte {
+	if m != nil {
+		return m.Digest
+	}
+	return nil
+}
+
+func (m *SignRequest) GetHash() uint32 {
+	if m != nil {
+		return m.Hash
+	}
+	return 0
+}
+
+type SignResponse struct {
+	Signature            []byte   `protobuf:"bytes,1,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SignResponse) Reset()         { *m = SignResponse{} }
+func (m *SignResponse) String() string { return proto.CompactTextString(m) }
+func (*SignResponse) ProtoMessage()    {}
+func (*SignResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{5}
+}
+
+func (m *SignResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SignResponse.Unmarshal(m, b)
+}
+func (m *SignResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SignResponse.Marshal(b, m, deterministic)
+}
+func (m *SignResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SignResponse.Merge(m, src)
+}
+func (m *SignResponse) XXX_Size() int {
+	return xxx_messageInfo_SignResponse.Size(m)
+}
+func (m *SignResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_SignResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SignResponse proto.InternalMessageInfo
+
+func (m *SignResponse) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+type RenewRequest struct {
+	Profile              string   `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	Cert                 string   `protobuf:"bytes,2,opt,name=cert,proto3" json:"cert,omitempty"`
+	Anchors              string   `protobuf:"bytes,3,opt,name=anchors,proto3" json:"anchors,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenewRequest) Reset()         { *m = RenewRequest{} }
+func (m *RenewRequest) String() string { return proto.CompactTextString(m) }
+func (*RenewRequest) ProtoMessage()    {}
+func (*RenewRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{6}
+}
+
+func (m *RenewRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenewRequest.Unmarshal(m, b)
+}
+func (m *RenewRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenewRequest.Marshal(b, m, deterministic)
+}
+func (m *RenewRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenewRequest.Merge(m, src)
+}
+func (m *RenewRequest) XXX_Size() int {
+	return xxx_messageInfo_RenewRequest.Size(m)
+}
+func (m *RenewRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenewRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenewRequest proto.InternalMessageInfo
+
+func (m *RenewRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *RenewRequest) GetCert() string {
+	if m != nil {
+		return m.Cert
+	}
+	return ""
+}
+
+func (m *RenewRequest) GetAnchors() string {
+	if m != nil {
+		return m.Anchors
+	}
+	return ""
+}
+
+type LockRequest struct {
+	Passphrase           string   `protobuf:"bytes,1,opt,name=passphrase,proto3" json:"passphrase,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LockRequest) Reset()         { *m = LockRequest{} }
+func (m *LockRequest) String() string { return proto.CompactTextString(m) }
+func (*LockRequest) ProtoMessage()    {}
+func (*LockRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_56ede974c0020f77, []int{7}
+}
+
+func (m *LockRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LockRequest.Unmarshal(m, b)
+}
+func (m *LockRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LockRequest.Marshal(b, m, deterministic)
+}
+func (m *LockRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LockRequest.Merge(m, src)
+}
+func (m *LockRequest) XXX_Size() int {
+	return xxx_messageInfo_LockRequest.Size(m)
+}
+func (m *LockRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LockRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LockRequest proto.InternalMessageInfo
+
+func (m *LockRequest) GetPassphrase() string {
+	if m != nil {
+		return m.Passphrase
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*AddKeyRequest)(nil), "pb.AddKeyRequest")
+	proto.RegisterType((*RemoveKeyRequest)(nil), "pb.RemoveKeyRequest")
+	proto.RegisterType((*AgentKey)(nil), "pb.AgentKey")
+	proto.RegisterType((*AgentKeys)(nil), "pb.AgentKeys")
+	proto.RegisterType((*SignRequest)(nil), "pb.SignRequest")
+	proto.RegisterType((*SignResponse)(nil), "pb.SignResponse")
+	proto.RegisterType((*RenewRequest)(nil), "pb.RenewRequest")
+	proto.RegisterType((*LockRequest)(nil), "pb.LockRequest")
+}
+
+func init() { proto.RegisterFile("agent.proto", fileDescriptor_56ede974c0020f77) }
+
+var fileDescriptor_56ede974c0020f77 = []byte{
+	// 462 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x92, 0xc1, 0x6f, 0xd3, 0x30,
+	0x14, 0xc6, 0x97, 0x26, 0xed, 0xda, 0xd7, 0x54, 0x14, 0x0b, 0x4d, 0x56, 0xe0, 0x10, 0x59, 0x1c,
+	0x2a, 0x31, 0x32, 0xb1, 0x8a, 0x13, 0xa7, 0x21, 0x71, 0xda, 0x4e, 0x9e, 0xe0, 0x9e, 0xb6, 0xaf,
+	0xa9, 0xd5, 0x34, 0x36, 0x71, 0x0a, 0xe4, 0xc6, 0x9d, 0xbf, 0x87, 0x7f, 0x8e, 0x13, 0xb2, 0xdd,
+	0xac, 0x01, 0x41, 0xb6, 0xdd, 0xfc, 0x9e, 0xed, 0xcf, 0x3f, 0x7f, 0xef, 0x83, 0x71, 0x9a, 0x61,
+	0x51, 0x25, 0xaa, 0x94, 0x95, 0x24, 0x3d, 0xb5, 0x88, 0x9e, 0x67, 0x52, 0x66, 0x39, 0x5e, 0xd8,
+	0xce, 0x62, 0xbf, 0xbe, 0xc0, 0x9d, 0xaa, 0x6a, 0x77, 0x20, 0x9a, 0x48, 0x55, 0x09, 0x59, 0x68,
+	0x57, 0xb2, 0x1f, 0x1e, 0x4c, 0xae, 0x56, 0xab, 0x6b, 0xac, 0x39, 0x7e, 0xde, 0xa3, 0xae, 0x08,
+	0x85, 0x53, 0x55, 0xca, 0xb5, 0xc8, 0x91, 0x7a, 0xb1, 0x37, 0x1b, 0xf1, 0xa6, 0x24, 0x67, 0xe0,
+	0x6f, 0xb1, 0xa6, 0xbd, 0xd8, 0x9b, 0x85, 0xef, 0x83, 0xef, 0x3f, 0xa9, 0xc7, 0x4d, 0x83, 0x10,
+	0x08, 0x96, 0x58, 0x56, 0xd4, 0xb7, 0xc7, 0xed, 0xda, 0xa8, 0xa4, 0xc5, 0x72, 0x23, 0x4b, 0x4d,
+	0x03, 0xa7, 0x72, 0x28, 0x49, 0x04, 0xc3, 0x5c, 0xac, 0xb1, 0x12, 0x3b, 0xa4, 0xfd, 0xd8, 0x9b,
+	0xf9, 0xfc, 0xae, 0x66, 0xe7, 0x30, 0xe5, 0xb8, 0x93, 0x5f, 0xf0, 0x21, 0x3c, 0x2c, 0x87, 0xe1,
+	0x95, 0xf9, 0xfa, 0x35, 0xd6, 0x1d, 0xd4, 0x0d, 0x5d, 0xef, 0xdf, 0x74, 0xfe, 0x9f, 0x74, 0x14,
+	0x4e, 0xf1, 0x9b, 0x12, 0x25, 0x3a, 0x6e, 0x9f, 0x37, 0x25, 0x7b, 0x0d, 0xa3, 0xe6, 0x35, 0x4d,
+	0x62, 0x08, 0xb6, 0x58, 0x6b, 0xea, 0xc5, 0xfe, 0x6c, 0x7c, 0x19, 0x26, 0x6a, 0x91, 0x34, 0x9b,
+	0xdc, 0xee, 0xb0, 0x5b, 0x18, 0xdf, 0x8a, 0xac, 0x78, 0x88, 0xab, 0x83, 0x95, 0xc8, 0x50, 0x3b,
+	0xc2, 0x90, 0x1f, 0x2a, 0xc3, 0xbd, 0x49, 0xf5, 0xc6, 0x02, 0x4e, 0xb8, 0x5d, 0xb3, 0x73, 0x08,
+	0x9d, 0xa8, 0x56, 0xb2, 0xd0, 0x48, 0x5e, 0xc0, 0x48, 0x8b, 0xac, 0x48, 0xab, 0x7d, 0xe9, 0x74,
+	0x43, 0x7e, 0x6c, 0xb0, 0x4f, 0x10, 0x72, 0x2c, 0xf0, 0xeb, 0xfd, 0x0c, 0x8f, 0xf2, 0x88, 0xcd,
+	0x61, 0x7c, 0x23, 0x97, 0xdb, 0x46, 0xf6, 0x25, 0x80, 0x4a, 0xb5, 0x56, 0x9b, 0x32, 0xd5, 0x07,
+	0xe5, 0x43, 0x3a, 0x5a, 0xfd, 0xcb, 0x5f, 0x3d, 0xe8, 0x5b, 0x8b, 0xc8, 0x5b, 0x18, 0xb8, 0xc4,
+	0x91, 0xa7, 0xd6, 0xb7, 0x76, 0xfa, 0xa2, 0xb3, 0xc4, 0x85, 0x37, 0x69, 0xc2, 0x9b, 0x7c, 0x30,
+	0xe1, 0x65, 0x27, 0xe4, 0x1d, 0x8c, 0xee, 0xb2, 0x41, 0x9e, 0x99, 0x9b, 0x7f, 0x47, 0xa5, 0xe3,
+	0xf2, 0x1c, 0x86, 0x37, 0x42, 0xbb, 0xd9, 0xfd, 0xe7, 0x54, 0x34, 0x69, 0x4f, 0x51, 0xb3, 0x13,
+	0xf2, 0x0a, 0x02, 0xe3, 0x36, 0x79, 0x62, 0x36, 0x5a, 0xc3, 0x8c, 0xa6, 0xc7, 0x86, 0x1b, 0x84,
+	0x7d, 0xa1, 0x6f, 0xcd, 0x26, 0x53, 0x87, 0x76, 0xf4, 0xbd, 0x03, 0xeb, 0x0d, 0x04, 0xc6, 0x49,
+	0xf7, 0x42, 0xcb, 0xd3, 0xce, 0x9f, 0x0c, 0x3e, 0x16, 0xf9, 0xe3, 0x2e, 0x2d, 0x06, 0xb6, 0x33,
+	0xff, 0x1d, 0x00, 0x00, 0xff, 0xff, 0x26, 0x42, 0xa8, 0xdd, 0x2b, 0x04, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// AgentClient is the client API for Agent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AgentClient interface {
+	AddKey(ctx context.Context, in *AddKeyRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	RemoveKey(ctx context.Context, in *RemoveKeyRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// ListKeys lists the keys held, which are none while the agent is locked.
+	ListKeys(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*AgentKeys, error)
+	Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error)
+	// Renew replaces the certificate of a key, after a login renewed the
+	// session, so that the client's commands use it from then on.
+	Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Lock refuses to list or use the keys until Unlock is given the same
+	// passphrase.
+	Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	Unlock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+}
+
+type agentClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAgentClient(cc *grpc.ClientConn) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) AddKey(ctx context.Context, in *AddKeyRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/pb.Agent/AddKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) RemoveKey(ctx context.Context, in *RemoveKeyRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/pb.Agent/RemoveKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) ListKeys(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*AgentKeys, error) {
+	out := new(AgentKeys)
+	err := c.cc.Invoke(ctx, "/pb.Agent/ListKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Sign(ctx context.Context, in *SignRequest, opts ...grpc.CallOption) (*SignResponse, error) {
+	out := new(SignResponse)
+	err := c.cc.Invoke(ctx, "/pb.Agent/Sign", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/pb.Agent/Renew", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/pb.Agent/Lock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Unlock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/pb.Agent/Unlock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AgentServer is the server API for Agent service.
+type AgentServer interface {
+	AddKey(context.Context, *AddKeyRequest) (*empty.Empty, error)
+	RemoveKey(context.Context, *RemoveKeyRequest) (*empty.Empty, error)
+	// ListKeys lists the keys held, which are none while the agent is locked.
+	ListKeys(context.Context, *empty.Empty) (*AgentKeys, error)
+	Sign(context.Context, *SignRequest) (*SignResponse, error)
+	// Renew replaces the certificate of a key, after a login renewed the
+	// session, so that the client's commands use it from then on.
+	Renew(context.Context, *RenewRequest) (*empty.Empty, error)
+	// Lock refuses to list or use the keys until Unlock is given the same
+	// passphrase.
+	Lock(context.Context, *LockRequest) (*empty.Empty, error)
+	Unlock(context.Context, *LockRequest) (*empty.Empty, error)
+}
+
+// UnimplementedAgentServer can be embedded to have forward compatible implementations.
+type UnimplementedAgentServer struct {
+}
+
+func (*UnimplementedAgentServer) AddKey(ctx context.Context, req *AddKeyRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddKey not implemented")
+}
+func (*UnimplementedAgentServer) RemoveKey(ctx context.Context, req *RemoveKeyRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveKey not implemented")
+}
+func (*UnimplementedAgentServer) ListKeys(ctx context.Context, req *empty.Empty) (*AgentKeys, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListKeys not implemented")
+}
+func (*UnimplementedAgentServer) Sign(ctx context.Context, req *SignRequest) (*SignResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sign not implemented")
+}
+func (*UnimplementedAgentServer) Renew(ctx context.Context, req *RenewRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Renew not implemented")
+}
+func (*UnimplementedAgentServer) Lock(ctx context.Context, req *LockRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lock not implemented")
+}
+func (*UnimplementedAgentServer) Unlock(ctx context.Context, req *LockRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unlock not implemented")
+}
+
+func RegisterAgentServer(s *grpc.Server, srv AgentServer) {
+	s.RegisterService(&_Agent_serviceDesc, srv)
+}
+
+func _Agent_AddKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).AddKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Agent/AddKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).AddKey(ctx, req.(*AddKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_RemoveKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).RemoveKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Agent/RemoveKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).RemoveKey(ctx, req.(*RemoveKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_ListKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).ListKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Agent/ListKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).ListKeys(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Sign_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Sign(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Agent/Sign",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Sign(ctx, req.(*SignRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Renew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Agent/Renew",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Renew(ctx, req.(*RenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Lock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Lock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Agent/Lock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Lock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Unlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Unlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Agent/Unlock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Unlock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Agent_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddKey",
+			Handler:    _Agent_AddKey_Handler,
+		},
+		{
+			MethodName: "RemoveKey",
+			Handler:    _Agent_RemoveKey_Handler,
+		},
+		{
+			MethodName: "ListKeys",
+			Handler:    _Agent_ListKeys_Handler,
+		},
+		{
+			MethodName: "Sign",
+			Handler:    _Agent_Sign_Handler,
+		},
+		{
+			MethodName: "Renew",
+			Handler:    _Agent_Renew_Handler,
+		},
+		{
+			MethodName: "Lock",
+			Handler:    _Agent_Lock_Handler,
+		},
+		{
+			MethodName: "Unlock",
+			Handler:    _Agent_Unlock_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "agent.proto",
+}
@@ -24,12 +24,142 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type Challenge_Type int32
+
+const (
+	Challenge_UNKNOWN      Challenge_Type = 0
+	Challenge_PASSWORD     Challenge_Type = 1
+	Challenge_OTP          Challenge_Type = 2
+	Challenge_NEW_PASSWORD Challenge_Type = 3
+	Challenge_TERMS        Challenge_Type = 4
+	Challenge_CSR          Challenge_Type = 5
+)
+
+var Challenge_Type_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "PASSWORD",
+	2: "OTP",
+	3: "NEW_PASSWORD",
+	4: "TERMS",
+	5: "CSR",
+}
+
+var Challenge_Type_value = map[string]int32{
+	"UNKNOWN":      0,
+	"PASSWORD":     1,
+	"OTP":          2,
+	"NEW_PASSWORD": 3,
+	"TERMS":        4,
+	"CSR":          5,
+}
+
+func (x Challenge_Type) String() string {
+	return proto.EnumName(Challenge_Type_name, int32(x))
+}
+
+func (Challenge_Type) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{8, 0}
+}
+
+type BeginLoginRequest struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BeginLoginRequest) Reset()         { *m = BeginLoginRequest{} }
+func (m *BeginLoginRequest) String() string { return proto.CompactTextString(m) }
+func (*BeginLoginRequest) ProtoMessage()    {}
+func (*BeginLoginRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{0}
+}
+
+func (m *BeginLoginRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BeginLoginRequest.Unmarshal(m, b)
+}
+func (m *BeginLoginRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BeginLoginRequest.Marshal(b, m, deterministic)
+}
+func (m *BeginLoginRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BeginLoginRequest.Merge(m, src)
+}
+func (m *BeginLoginRequest) XXX_Size() int {
+	return xxx_messageInfo_BeginLoginRequest.Size(m)
+}
+func (m *BeginLoginRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BeginLoginRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BeginLoginRequest proto.InternalMessageInfo
+
+func (m *BeginLoginRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type LoginChallenge struct {
+	// Challenge is a single-use value that the client must embed in the CSR it
+	// presents at login.
+	Challenge string `protobuf:"bytes,1,opt,name=challenge,proto3" json:"challenge,omitempty"`
+	// Expires is when the challenge stops being accepted, in seconds since the
+	// Unix epoch.
+	Expires              int64    `protobuf:"varint,2,opt,name=expires,proto3" json:"expires,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LoginChallenge) Reset()         { *m = LoginChallenge{} }
+func (m *LoginChallenge) String() string { return proto.CompactTextString(m) }
+func (*LoginChallenge) ProtoMessage()    {}
+func (*LoginChallenge) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{1}
+}
+
+func (m *LoginChallenge) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LoginChallenge.Unmarshal(m, b)
+}
+func (m *LoginChallenge) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LoginChallenge.Marshal(b, m, deterministic)
+}
+func (m *LoginChallenge) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LoginChallenge.Merge(m, src)
+}
+func (m *LoginChallenge) XXX_Size() int {
+	return xxx_messageInfo_LoginChallenge.Size(m)
+}
+func (m *LoginChallenge) XXX_DiscardUnknown() {
+	xxx_messageInfo_LoginChallenge.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LoginChallenge proto.InternalMessageInfo
+
+func (m *LoginChallenge) GetChallenge() string {
+	if m != nil {
+		return m.Challenge
+	}
+	return ""
+}
+
+func (m *LoginChallenge) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
 type LoginRequest struct {
 	Username string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
 	Password string `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
 	// CSR is the certificate signing request presented by the client to sign if
-	// the login succeds.
-	Csr                  string   `protobuf:"bytes,3,opt,name=csr,proto3" json:"csr,omitempty"`
+	// the login succeds.  It must carry the challenge issued by BeginLogin.
+	Csr string `protobuf:"bytes,3,opt,name=csr,proto3" json:"csr,omitempty"`
+	// TOTP is the current code from the user's authenticator app, or one of
+	// their recovery codes.  It is required if the user enrolled in TOTP.
+	Totp                 string   `protobuf:"bytes,4,opt,name=totp,proto3" json:"totp,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -39,7 +169,7 @@ func (m *LoginRequest) Reset()         { *m = LoginRequest{} }
 func (m *LoginRequest) String() string { return proto.CompactTextString(m) }
 func (*LoginRequest) ProtoMessage()    {}
 func (*LoginRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_8bbd6f3875b0e874, []int{0}
+	return fileDescriptor_8bbd6f3875b0e874, []int{2}
 }
 
 func (m *LoginRequest) XXX_Unmarshal(b []byte) error {
@@ -81,6 +211,13 @@ func (m *LoginRequest) GetCsr() string {
 	return ""
 }
 
+func (m *LoginRequest) GetTotp() string {
+	if m != nil {
+		return m.Totp
+	}
+	return ""
+}
+
 type LoginResponse struct {
 	// Cert is the signed certificate that the client must use for the
 	// authenticated user session in PEM format.
@@ -97,7 +234,7 @@ func (m *LoginResponse) Reset()         { *m = LoginResponse{} }
 func (m *LoginResponse) String() string { return proto.CompactTextString(m) }
 func (*LoginResponse) ProtoMessage()    {}
 func (*LoginResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_8bbd6f3875b0e874, []int{1}
+	return fileDescriptor_8bbd6f3875b0e874, []int{3}
 }
 
 func (m *LoginResponse) XXX_Unmarshal(b []byte) error {
@@ -132,27 +269,363 @@ func (m *LoginResponse) GetAnchors() string {
 	return ""
 }
 
+type AuthenticateRequest struct {
+	// Types that are valid to be assigned to Step:
+	//	*AuthenticateRequest_Start
+	//	*AuthenticateRequest_Answer
+	Step                 isAuthenticateRequest_Step `protobuf_oneof:"step"`
+	XXX_NoUnkeyedLiteral struct{}                   `json:"-"`
+	XXX_unrecognized     []byte                     `json:"-"`
+	XXX_sizecache        int32                      `json:"-"`
+}
+
+func (m *AuthenticateRequest) Reset()         { *m = AuthenticateRequest{} }
+func (m *AuthenticateRequest) String() string { return proto.CompactTextString(m) }
+func (*AuthenticateRequest) ProtoMessage()    {}
+func (*AuthenticateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{4}
+}
+
+func (m *AuthenticateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AuthenticateRequest.Unmarshal(m, b)
+}
+func (m *AuthenticateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AuthenticateRequest.Marshal(b, m, deterministic)
+}
+func (m *AuthenticateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AuthenticateRequest.Merge(m, src)
+}
+func (m *AuthenticateRequest) XXX_Size() int {
+	return xxx_messageInfo_AuthenticateRequest.Size(m)
+}
+func (m *AuthenticateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AuthenticateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AuthenticateRequest proto.InternalMessageInfo
+
+type isAuthenticateRequest_Step interface {
+	isAuthenticateRequest_Step()
+}
+
+type AuthenticateRequest_Start struct {
+	Start *AuthenticateStart `protobuf:"bytes,1,opt,name=start,proto3,oneof"`
+}
+
+type AuthenticateRequest_Answer struct {
+	Answer *ChallengeAnswer `protobuf:"bytes,2,opt,name=answer,proto3,oneof"`
+}
+
+func (*AuthenticateRequest_Start) isAuthenticateRequest_Step() {}
+
+func (*AuthenticateRequest_Answer) isAuthenticateRequest_Step() {}
+
+func (m *AuthenticateRequest) GetStep() isAuthenticateRequest_Step {
+	if m != nil {
+		return m.Step
+	}
+	return nil
+}
+
+func (m *AuthenticateRequest) GetStart() *AuthenticateStart {
+	if x, ok := m.GetStep().(*AuthenticateRequest_Start); ok {
+		return x.Start
+	}
+	return nil
+}
+
+func (m *AuthenticateRequest) GetAnswer() *ChallengeAnswer {
+	if x, ok := m.GetStep().(*AuthenticateRequest_Answer); ok {
+		return x.Answer
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*AuthenticateRequest) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*AuthenticateRequest_Start)(nil),
+		(*AuthenticateRequest_Answer)(nil),
+	}
+}
+
+type AuthenticateStart struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AuthenticateStart) Reset()         { *m = AuthenticateStart{} }
+func (m *AuthenticateStart) String() string { return proto.CompactTextString(m) }
+func (*AuthenticateStart) ProtoMessage()    {}
+func (*AuthenticateStart) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{5}
+}
+
+func (m *AuthenticateStart) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AuthenticateStart.Unmarshal(m, b)
+}
+func (m *AuthenticateStart) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AuthenticateStart.Marshal(b, m, deterministic)
+}
+func (m *AuthenticateStart) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AuthenticateStart.Merge(m, src)
+}
+func (m *AuthenticateStart) XXX_Size() int {
+	return xxx_messageInfo_AuthenticateStart.Size(m)
+}
+func (m *AuthenticateStart) XXX_DiscardUnknown() {
+	xxx_messageInfo_AuthenticateStart.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AuthenticateStart proto.InternalMessageInfo
+
+func (m *AuthenticateStart) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+type ChallengeAnswer struct {
+	// Value answers the last challenge.  For a CSR challenge, it is the CSR in
+	// PEM format carrying the challenge's nonce.
+	Value                string   `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ChallengeAnswer) Reset()         { *m = ChallengeAnswer{} }
+func (m *ChallengeAnswer) String() string { return proto.CompactTextString(m) }
+func (*ChallengeAnswer) ProtoMessage()    {}
+func (*ChallengeAnswer) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{6}
+}
+
+func (m *ChallengeAnswer) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ChallengeAnswer.Unmarshal(m, b)
+}
+func (m *ChallengeAnswer) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ChallengeAnswer.Marshal(b, m, deterministic)
+}
+func (m *ChallengeAnswer) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ChallengeAnswer.Merge(m, src)
+}
+func (m *ChallengeAnswer) XXX_Size() int {
+	return xxx_messageInfo_ChallengeAnswer.Size(m)
+}
+func (m *ChallengeAnswer) XXX_DiscardUnknown() {
+	xxx_messageInfo_ChallengeAnswer.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ChallengeAnswer proto.InternalMessageInfo
+
+func (m *ChallengeAnswer) GetValue() string {
+	if m != nil {
+		return m.Value
+	}
+	return ""
+}
+
+type AuthenticateResponse struct {
+	// Types that are valid to be assigned to Step:
+	//	*AuthenticateResponse_Challenge
+	//	*AuthenticateResponse_Result
+	Step                 isAuthenticateResponse_Step `protobuf_oneof:"step"`
+	XXX_NoUnkeyedLiteral struct{}                    `json:"-"`
+	XXX_unrecognized     []byte                      `json:"-"`
+	XXX_sizecache        int32                       `json:"-"`
+}
+
+func (m *AuthenticateResponse) Reset()         { *m = AuthenticateResponse{} }
+func (m *AuthenticateResponse) String() string { return proto.CompactTextString(m) }
+func (*AuthenticateResponse) ProtoMessage()    {}
+func (*AuthenticateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{7}
+}
+
+func (m *AuthenticateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AuthenticateResponse.Unmarshal(m, b)
+}
+func (m *AuthenticateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AuthenticateResponse.Marshal(b, m, deterministic)
+}
+func (m *AuthenticateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AuthenticateResponse.Merge(m, src)
+}
+func (m *AuthenticateResponse) XXX_Size() int {
+	return xxx_messageInfo_AuthenticateResponse.Size(m)
+}
+func (m *AuthenticateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AuthenticateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AuthenticateResponse proto.InternalMessageInfo
+
+type isAuthenticateResponse_Step interface {
+	isAuthenticateResponse_Step()
+}
+
+type AuthenticateResponse_Challenge struct {
+	Challenge *Challenge `protobuf:"bytes,1,opt,name=challenge,proto3,oneof"`
+}
+
+type AuthenticateResponse_Result struct {
+	Result *LoginResponse `protobuf:"bytes,2,opt,name=result,proto3,oneof"`
+}
+
+func (*AuthenticateResponse_Challenge) isAuthenticateResponse_Step() {}
+
+func (*AuthenticateResponse_Result) isAuthenticateResponse_Step() {}
+
+func (m *AuthenticateResponse) GetStep() isAuthenticateResponse_Step {
+	if m != nil {
+		return m.Step
+	}
+	return nil
+}
+
+func (m *AuthenticateResponse) GetChallenge() *Challenge {
+	if x, ok := m.GetStep().(*AuthenticateResponse_Challenge); ok {
+		return x.Challenge
+	}
+	return nil
+}
+
+func (m *AuthenticateResponse) GetResult() *LoginResponse {
+	if x, ok := m.GetStep().(*AuthenticateResponse_Result); ok {
+		return x.Result
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*AuthenticateResponse) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*AuthenticateResponse_Challenge)(nil),
+		(*AuthenticateResponse_Result)(nil),
+	}
+}
+
+type Challenge struct {
+	Type Challenge_Type `protobuf:"varint,1,opt,name=type,proto3,enum=pb.Challenge_Type" json:"type,omitempty"`
+	// Prompt is a short question to ask the user.
+	Prompt string `protobuf:"bytes,2,opt,name=prompt,proto3" json:"prompt,omitempty"`
+	// Text is shown to the user before the prompt, such as terms to accept.
+	Text string `protobuf:"bytes,3,opt,name=text,proto3" json:"text,omitempty"`
+	// Nonce must be embedded in the CSR answering a CSR challenge.
+	Nonce                string   `protobuf:"bytes,4,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Challenge) Reset()         { *m = Challenge{} }
+func (m *Challenge) String() string { return proto.CompactTextString(m) }
+func (*Challenge) ProtoMessage()    {}
+func (*Challenge) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8bbd6f3875b0e874, []int{8}
+}
+
+func (m *Challenge) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Challenge.Unmarshal(m, b)
+}
+func (m *Challenge) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Challenge.Marshal(b, m, deterministic)
+}
+func (m *Challenge) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Challenge.Merge(m, src)
+}
+func (m *Challenge) XXX_Size() int {
+	return xxx_messageInfo_Challenge.Size(m)
+}
+func (m *Challenge) XXX_DiscardUnknown() {
+	xxx_messageInfo_Challenge.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Challenge proto.InternalMessageInfo
+
+func (m *Challenge) GetType() Challenge_Type {
+	if m != nil {
+		return m.Type
+	}
+	return Challenge_UNKNOWN
+}
+
+func (m *Challenge) GetPrompt() string {
+	if m != nil {
+		return m.Prompt
+	}
+	return ""
+}
+
+func (m *Challenge) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+func (m *Challenge) GetNonce() string {
+	if m != nil {
+		return m.Nonce
+	}
+	return ""
+}
+
 func init() {
+	proto.RegisterEnum("pb.Challenge_Type", Challenge_Type_name, Challenge_Type_value)
+	proto.RegisterType((*BeginLoginRequest)(nil), "pb.BeginLoginRequest")
+	proto.RegisterType((*LoginChallenge)(nil), "pb.LoginChallenge")
 	proto.RegisterType((*LoginRequest)(nil), "pb.LoginRequest")
 	proto.RegisterType((*LoginResponse)(nil), "pb.LoginResponse")
+	proto.RegisterType((*AuthenticateRequest)(nil), "pb.AuthenticateRequest")
+	proto.RegisterType((*AuthenticateStart)(nil), "pb.AuthenticateStart")
+	proto.RegisterType((*ChallengeAnswer)(nil), "pb.ChallengeAnswer")
+	proto.RegisterType((*AuthenticateResponse)(nil), "pb.AuthenticateResponse")
+	proto.RegisterType((*Challenge)(nil), "pb.Challenge")
 }
 
 func init() { proto.RegisterFile("auth.proto", fileDescriptor_8bbd6f3875b0e874) }
 
 var fileDescriptor_8bbd6f3875b0e874 = []byte{
-	// 177 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x4c, 0x8f, 0x3b, 0x0f, 0xc2, 0x20,
-	0x14, 0x85, 0xed, 0xc3, 0xd7, 0x8d, 0x26, 0xf5, 0x4e, 0xa4, 0x93, 0xe9, 0xe4, 0xc4, 0xa0, 0x89,
-	0x9b, 0x83, 0xbb, 0x53, 0x27, 0x57, 0x5a, 0x89, 0x75, 0x10, 0x90, 0x0b, 0xf1, 0xef, 0x9b, 0x52,
-	0x6a, 0xba, 0x9d, 0xef, 0x9c, 0xe4, 0x83, 0x0b, 0x20, 0xbc, 0xeb, 0xb8, 0xb1, 0xda, 0x69, 0x4c,
-	0x4d, 0x53, 0xdd, 0x61, 0x73, 0xd3, 0xcf, 0x97, 0xaa, 0xe5, 0xc7, 0x4b, 0x72, 0x58, 0xc2, 0xca,
-	0x93, 0xb4, 0x4a, 0xbc, 0x25, 0x4b, 0xf6, 0xc9, 0x61, 0x5d, 0xff, 0xb9, 0xdf, 0x8c, 0x20, 0xfa,
-	0x6a, 0xfb, 0x60, 0xe9, 0xb0, 0x8d, 0x8c, 0x05, 0x64, 0x2d, 0x59, 0x96, 0x85, 0xba, 0x8f, 0xd5,
-	0x05, 0xb6, 0xd1, 0x4c, 0x46, 0x2b, 0x92, 0x88, 0x90, 0xb7, 0xd2, 0xba, 0xa8, 0x0d, 0x19, 0x19,
-	0x2c, 0x85, 0x6a, 0x3b, 0x6d, 0x29, 0x1a, 0x47, 0x3c, 0x9e, 0x21, 0xbf, 0x7a, 0xd7, 0x21, 0x87,
-	0x79, 0xd0, 0x60, 0xc1, 0x4d, 0xc3, 0xa7, 0x7f, 0x2d, 0x77, 0x93, 0x66, 0x78, 0xa3, 0x9a, 0x35,
-	0x8b, 0x70, 0xdb, 0xe9, 0x17, 0x00, 0x00, 0xff, 0xff, 0x67, 0x05, 0x15, 0xb7, 0xe9, 0x00, 0x00,
-	0x00,
+	// 542 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0x4d, 0x8f, 0xd2, 0x5c,
+	0x14, 0x6e, 0xa1, 0x05, 0x7a, 0x80, 0x79, 0x3b, 0x67, 0xe6, 0xd5, 0x86, 0xb8, 0x20, 0x5d, 0x18,
+	0x12, 0x03, 0x1a, 0x5c, 0xb9, 0x70, 0x01, 0xe3, 0x44, 0x12, 0x15, 0xc8, 0x05, 0xc3, 0xd2, 0x94,
+	0x7a, 0x03, 0x24, 0x4c, 0x7b, 0xed, 0xbd, 0x75, 0x18, 0xdd, 0xf8, 0xa7, 0xdc, 0xf8, 0x07, 0xfc,
+	0x5b, 0xe6, 0xde, 0xde, 0x96, 0xaf, 0xc4, 0xb8, 0xbb, 0xe7, 0x9c, 0xe7, 0x7c, 0x3d, 0xcf, 0x69,
+	0x01, 0x82, 0x54, 0xac, 0x7b, 0x2c, 0x89, 0x45, 0x8c, 0x25, 0xb6, 0x6c, 0x35, 0x63, 0x26, 0x36,
+	0x71, 0xc4, 0x33, 0x97, 0xff, 0x1c, 0x2e, 0x87, 0x74, 0xb5, 0x89, 0xde, 0xc7, 0xab, 0x4d, 0x44,
+	0xe8, 0x97, 0x94, 0x72, 0x81, 0x2d, 0xa8, 0xa5, 0x9c, 0x26, 0x51, 0x70, 0x47, 0x3d, 0xb3, 0x6d,
+	0x76, 0x1c, 0x52, 0xd8, 0xfe, 0x08, 0x2e, 0x14, 0xf6, 0x66, 0x1d, 0x6c, 0xb7, 0x34, 0x5a, 0x51,
+	0x7c, 0x02, 0x4e, 0x98, 0x1b, 0x1a, 0xbe, 0x77, 0xa0, 0x07, 0x55, 0xba, 0x63, 0x9b, 0x84, 0x72,
+	0xaf, 0xd4, 0x36, 0x3b, 0x65, 0x92, 0x9b, 0xfe, 0x0e, 0x1a, 0xff, 0xda, 0x15, 0xdb, 0x50, 0x63,
+	0x01, 0xe7, 0xf7, 0x71, 0xf2, 0x59, 0x95, 0x71, 0x86, 0xd6, 0x8f, 0x9f, 0x9e, 0x49, 0x0a, 0x2f,
+	0xba, 0x50, 0x0e, 0x79, 0xe2, 0x95, 0x55, 0xa2, 0x7c, 0xa2, 0x07, 0x96, 0x88, 0x05, 0xf3, 0xac,
+	0x03, 0xbc, 0xf2, 0xf8, 0xaf, 0xa1, 0xa9, 0x3b, 0x73, 0x16, 0x47, 0x9c, 0x22, 0x82, 0x15, 0xd2,
+	0x44, 0xe8, 0xb6, 0xea, 0x2d, 0x07, 0x0f, 0xa2, 0x70, 0x1d, 0x27, 0xd9, 0xe0, 0x0e, 0xc9, 0x4d,
+	0xff, 0x3b, 0x5c, 0x0d, 0x52, 0xb1, 0xa6, 0x91, 0xd8, 0x84, 0x81, 0xa0, 0xf9, 0xfc, 0x5d, 0xb0,
+	0xb9, 0x08, 0x74, 0x95, 0x7a, 0xff, 0xff, 0x1e, 0x5b, 0xf6, 0x0e, 0x71, 0x33, 0x19, 0x1c, 0x19,
+	0x24, 0x43, 0x61, 0x17, 0x2a, 0x41, 0xc4, 0xef, 0x69, 0xa2, 0xca, 0xd7, 0xfb, 0x57, 0x12, 0x5f,
+	0xb0, 0x3a, 0x50, 0xa1, 0x91, 0x41, 0x34, 0x68, 0x58, 0x01, 0x8b, 0x0b, 0xca, 0xa4, 0x60, 0x67,
+	0x45, 0xff, 0x2a, 0x58, 0x17, 0xfe, 0x3b, 0xa9, 0x8a, 0x2d, 0xb0, 0xbf, 0x06, 0xdb, 0x54, 0x63,
+	0x35, 0x35, 0x99, 0xcb, 0xff, 0x06, 0xd7, 0xc7, 0xcb, 0x69, 0x8a, 0xba, 0xa7, 0x2a, 0xd7, 0xfb,
+	0xcd, 0xa3, 0x89, 0x47, 0xc6, 0xa1, 0xec, 0xcf, 0xa0, 0x92, 0x50, 0x9e, 0x6e, 0x85, 0xde, 0xee,
+	0x52, 0x62, 0x8f, 0x48, 0x97, 0xbb, 0x65, 0x90, 0x62, 0xb7, 0xdf, 0x26, 0x38, 0xfb, 0xbb, 0x7a,
+	0x0a, 0x96, 0x78, 0x60, 0x59, 0xb3, 0x8b, 0x3e, 0x1e, 0x35, 0xeb, 0xcd, 0x1f, 0x18, 0x25, 0x2a,
+	0x8e, 0x8f, 0xa0, 0xc2, 0x92, 0xf8, 0x8e, 0x09, 0xad, 0x93, 0xb6, 0xa4, 0xa8, 0x82, 0xee, 0x84,
+	0x3e, 0x09, 0xf5, 0xc6, 0x6b, 0xb0, 0xa3, 0x38, 0x0a, 0x69, 0x76, 0x14, 0x24, 0x33, 0xfc, 0x29,
+	0x58, 0xb2, 0x1e, 0xd6, 0xa1, 0xfa, 0x71, 0xfc, 0x6e, 0x3c, 0x59, 0x8c, 0x5d, 0x03, 0x1b, 0x50,
+	0x9b, 0x0e, 0x66, 0xb3, 0xc5, 0x84, 0xbc, 0x71, 0x4d, 0xac, 0x42, 0x79, 0x32, 0x9f, 0xba, 0x25,
+	0x74, 0xa1, 0x31, 0xbe, 0x5d, 0x7c, 0x2a, 0x42, 0x65, 0x74, 0xc0, 0x9e, 0xdf, 0x92, 0x0f, 0x33,
+	0xd7, 0x92, 0xa8, 0x9b, 0x19, 0x71, 0xed, 0xfe, 0x2f, 0x13, 0x2c, 0x49, 0x23, 0xbe, 0x02, 0xd8,
+	0x7f, 0x5f, 0xa8, 0x6e, 0xe2, 0xec, 0x7b, 0x6b, 0x61, 0x41, 0x4e, 0xb1, 0xa0, 0x6f, 0x60, 0x0f,
+	0xec, 0x2c, 0xcb, 0x3d, 0xe0, 0x2e, 0x4b, 0x38, 0x67, 0xd3, 0x37, 0xf0, 0x2d, 0x34, 0x0e, 0x95,
+	0xc3, 0xc7, 0xa7, 0x07, 0x98, 0x67, 0x7b, 0xe7, 0x81, 0xbc, 0x48, 0xc7, 0x7c, 0x61, 0x2e, 0x2b,
+	0xea, 0xd7, 0xf0, 0xf2, 0xcf, 0x00, 0xc2, 0x51, 0xbc, 0x8f, 0x3b, 0x04, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -167,7 +640,12 @@ const _ = grpc.SupportPackageIsVersion4
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type AuthClient interface {
+	BeginLogin(ctx context.Context, in *BeginLoginRequest, opts ...grpc.CallOption) (*LoginChallenge, error)
 	Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error)
+	// Authenticate logs in over several rounds.  The client starts with the
+	// username, and the server answers with challenges until it either fails
+	// the stream or sends the signed certificate.
+	Authenticate(ctx context.Context, opts ...grpc.CallOption) (Auth_AuthenticateClient, error)
 }
 
 type authClient struct {
@@ -178,6 +656,15 @@ func NewAuthClient(cc *grpc.ClientConn) AuthClient {
 	return &authClient{cc}
 }
 
+func (c *authClient) BeginLogin(ctx context.Context, in *BeginLoginRequest, opts ...grpc.CallOption) (*LoginChallenge, error) {
+	out := new(LoginChallenge)
+	err := c.cc.Invoke(ctx, "/pb.Auth/BeginLogin", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *authClient) Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error) {
 	out := new(LoginResponse)
 	err := c.cc.Invoke(ctx, "/pb.Auth/Login", in, out, opts...)
@@ -187,23 +674,83 @@ func (c *authClient) Login(ctx context.Context, in *LoginRequest, opts ...grpc.C
 	return out, nil
 }
 
+func (c *authClient) Authenticate(ctx context.Context, opts ...grpc.CallOption) (Auth_AuthenticateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Auth_serviceDesc.Streams[0], "/pb.Auth/Authenticate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &authAuthenticateClient{stream}
+	return x, nil
+}
+
+type Auth_AuthenticateClient interface {
+	Send(*AuthenticateRequest) error
+	Recv() (*AuthenticateResponse, error)
+	grpc.ClientStream
+}
+
+type authAuthenticateClient struct {
+	grpc.ClientStream
+}
+
+func (x *authAuthenticateClient) Send(m *AuthenticateRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *authAuthenticateClient) Recv() (*AuthenticateResponse, error) {
+	m := new(AuthenticateResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // AuthServer is the server API for Auth service.
 type AuthServer interface {
+	BeginLogin(context.Context, *BeginLoginRequest) (*LoginChallenge, error)
 	Login(context.Context, *LoginRequest) (*LoginResponse, error)
+	// Authenticate logs in over several rounds.  The client starts with the
+	// username, and the server answers with challenges until it either fails
+	// the stream or sends the signed certificate.
+	Authenticate(Auth_AuthenticateServer) error
 }
 
 // UnimplementedAuthServer can be embedded to have forward compatible implementations.
 type UnimplementedAuthServer struct {
 }
 
+func (*UnimplementedAuthServer) BeginLogin(ctx context.Context, req *BeginLoginRequest) (*LoginChallenge, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeginLogin not implemented")
+}
 func (*UnimplementedAuthServer) Login(ctx context.Context, req *LoginRequest) (*LoginResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Login not implemented")
 }
+func (*UnimplementedAuthServer) Authenticate(srv Auth_AuthenticateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
 
 func RegisterAuthServer(s *grpc.Server, srv AuthServer) {
 	s.RegisterService(&_Auth_serviceDesc, srv)
 }
 
+func _Auth_BeginLogin_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginLoginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).BeginLogin(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Auth/BeginLogin",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).BeginLogin(ctx, req.(*BeginLoginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Auth_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(LoginRequest)
 	if err := dec(in); err != nil {
@@ -222,15 +769,52 @@ func _Auth_Login_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Auth_Authenticate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AuthServer).Authenticate(&authAuthenticateServer{stream})
+}
+
+type Auth_AuthenticateServer interface {
+	Send(*AuthenticateResponse) error
+	Recv() (*AuthenticateRequest, error)
+	grpc.ServerStream
+}
+
+type authAuthenticateServer struct {
+	grpc.ServerStream
+}
+
+func (x *authAuthenticateServer) Send(m *AuthenticateResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *authAuthenticateServer) Recv() (*AuthenticateRequest, error) {
+	m := new(AuthenticateRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Auth_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.Auth",
 	HandlerType: (*AuthServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "BeginLogin",
+			Handler:    _Auth_BeginLogin_Handler,
+		},
 		{
 			MethodName: "Login",
 			Handler:    _Auth_Login_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Authenticate",
+			Handler:       _Auth_Authenticate_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "auth.proto",
 }
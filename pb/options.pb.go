@@ -0,0 +1,49 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: options.proto
+
+package pb
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	descriptor "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+var E_Sensitive = &proto.ExtensionDesc{
+	ExtendedType:  (*descriptor.FieldOptions)(nil),
+	ExtensionType: (*bool)(nil),
+	Field:         50000,
+	Name:          "pb.sensitive",
+	Tag:           "varint,50000,opt,name=sensitive",
+	Filename:      "options.proto",
+}
+
+func init() {
+	proto.RegisterExtension(E_Sensitive)
+}
+
+func init() { proto.RegisterFile("options.proto", fileDescriptor_110d40819f1994f9) }
+
+var fileDescriptor_110d40819f1994f9 = []byte{
+	// 116 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xcd, 0x2f, 0x28, 0xc9,
+	0xcc, 0xcf, 0x2b, 0xd6, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2a, 0x48, 0x92, 0x52, 0x48,
+	0xcf, 0xcf, 0x4f, 0xcf, 0x49, 0xd5, 0x07, 0x8b, 0x24, 0x95, 0xa6, 0xe9, 0xa7, 0xa4, 0x16, 0x27,
+	0x17, 0x65, 0x16, 0x94, 0xe4, 0x17, 0x41, 0x54, 0x59, 0xd9, 0x72, 0x71, 0x16, 0xa7, 0xe6, 0x15,
+	0x67, 0x96, 0x64, 0x96, 0xa5, 0x0a, 0xc9, 0xea, 0x41, 0xd4, 0xeb, 0xc1, 0xd4, 0xeb, 0xb9, 0x65,
+	0xa6, 0xe6, 0xa4, 0xf8, 0x43, 0xcc, 0x95, 0xb8, 0xd0, 0xc6, 0xac, 0xc0, 0xa8, 0xc1, 0x11, 0x84,
+	0xd0, 0x91, 0xc4, 0x06, 0x56, 0x69, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff, 0x06, 0x04, 0xeb, 0x57,
+	0x7c, 0x00, 0x00, 0x00,
+}
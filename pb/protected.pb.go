@@ -64,23 +64,130 @@ func (m *Bulletin) GetBulletin() string {
 	return ""
 }
 
+type SSHCertRequest struct {
+	// PublicKey is the key to certify, in the authorized_keys format.
+	PublicKey            string   `protobuf:"bytes,1,opt,name=public_key,json=publicKey,proto3" json:"public_key,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SSHCertRequest) Reset()         { *m = SSHCertRequest{} }
+func (m *SSHCertRequest) String() string { return proto.CompactTextString(m) }
+func (*SSHCertRequest) ProtoMessage()    {}
+func (*SSHCertRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5b99d8d2ac383f6c, []int{1}
+}
+
+func (m *SSHCertRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SSHCertRequest.Unmarshal(m, b)
+}
+func (m *SSHCertRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SSHCertRequest.Marshal(b, m, deterministic)
+}
+func (m *SSHCertRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SSHCertRequest.Merge(m, src)
+}
+func (m *SSHCertRequest) XXX_Size() int {
+	return xxx_messageInfo_SSHCertRequest.Size(m)
+}
+func (m *SSHCertRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SSHCertRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SSHCertRequest proto.InternalMessageInfo
+
+func (m *SSHCertRequest) GetPublicKey() string {
+	if m != nil {
+		return m.PublicKey
+	}
+	return ""
+}
+
+type SSHCert struct {
+	// Certificate is the signed certificate, and CAPublicKey the key of the CA
+	// that signed it, both in the authorized_keys format.
+	Certificate string `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	CaPublicKey string `protobuf:"bytes,2,opt,name=ca_public_key,json=caPublicKey,proto3" json:"ca_public_key,omitempty"`
+	// Expires is when the certificate expires, in seconds since the Unix epoch.
+	Expires              int64    `protobuf:"varint,3,opt,name=expires,proto3" json:"expires,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SSHCert) Reset()         { *m = SSHCert{} }
+func (m *SSHCert) String() string { return proto.CompactTextString(m) }
+func (*SSHCert) ProtoMessage()    {}
+func (*SSHCert) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5b99d8d2ac383f6c, []int{2}
+}
+
+func (m *SSHCert) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SSHCert.Unmarshal(m, b)
+}
+func (m *SSHCert) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SSHCert.Marshal(b, m, deterministic)
+}
+func (m *SSHCert) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SSHCert.Merge(m, src)
+}
+func (m *SSHCert) XXX_Size() int {
+	return xxx_messageInfo_SSHCert.Size(m)
+}
+func (m *SSHCert) XXX_DiscardUnknown() {
+	xxx_messageInfo_SSHCert.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SSHCert proto.InternalMessageInfo
+
+func (m *SSHCert) GetCertificate() string {
+	if m != nil {
+		return m.Certificate
+	}
+	return ""
+}
+
+func (m *SSHCert) GetCaPublicKey() string {
+	if m != nil {
+		return m.CaPublicKey
+	}
+	return ""
+}
+
+func (m *SSHCert) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Bulletin)(nil), "pb.Bulletin")
+	proto.RegisterType((*SSHCertRequest)(nil), "pb.SSHCertRequest")
+	proto.RegisterType((*SSHCert)(nil), "pb.SSHCert")
 }
 
 func init() { proto.RegisterFile("protected.proto", fileDescriptor_5b99d8d2ac383f6c) }
 
 var fileDescriptor_5b99d8d2ac383f6c = []byte{
-	// 135 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2f, 0x28, 0xca, 0x2f,
-	0x49, 0x4d, 0x2e, 0x49, 0x4d, 0xd1, 0x03, 0xb1, 0xf2, 0x85, 0x98, 0x0a, 0x92, 0xa4, 0xa4, 0xd3,
-	0xf3, 0xf3, 0xd3, 0x73, 0x52, 0xf5, 0xc1, 0x22, 0x49, 0xa5, 0x69, 0xfa, 0xa9, 0xb9, 0x05, 0x25,
-	0x95, 0x10, 0x05, 0x4a, 0x6a, 0x5c, 0x1c, 0x4e, 0xa5, 0x39, 0x39, 0xa9, 0x25, 0x99, 0x79, 0x42,
-	0x52, 0x5c, 0x1c, 0x49, 0x50, 0xb6, 0x04, 0xa3, 0x02, 0xa3, 0x06, 0x67, 0x10, 0x9c, 0x6f, 0x64,
-	0xcd, 0xc5, 0x19, 0x00, 0x33, 0x5b, 0x48, 0x8f, 0x8b, 0xc5, 0xd7, 0x3f, 0xc4, 0x45, 0x48, 0x4c,
-	0x0f, 0x62, 0xb4, 0x1e, 0xcc, 0x68, 0x3d, 0x57, 0x90, 0xd1, 0x52, 0x3c, 0x7a, 0x05, 0x49, 0x7a,
-	0x30, 0x63, 0x95, 0x18, 0x92, 0xd8, 0xc0, 0xf2, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0xc4,
-	0x5d, 0xaf, 0xed, 0x9f, 0x00, 0x00, 0x00,
+	// 250 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x8f, 0x5d, 0x4b, 0xc3, 0x30,
+	0x14, 0x86, 0xd7, 0x4d, 0xdc, 0x7a, 0x36, 0x15, 0xce, 0x85, 0x94, 0x8a, 0x50, 0x72, 0x21, 0xbb,
+	0x4a, 0x51, 0xff, 0x81, 0x1f, 0xa0, 0x88, 0x38, 0x3a, 0xef, 0x47, 0x13, 0xcf, 0x46, 0xb0, 0xae,
+	0xb1, 0x49, 0xc0, 0xfe, 0x7b, 0xe9, 0x9a, 0x48, 0xbd, 0x3b, 0xe7, 0xcd, 0x93, 0x97, 0xf3, 0xc0,
+	0x99, 0x6e, 0x6a, 0x4b, 0xd2, 0xd2, 0x07, 0xef, 0xa6, 0x1a, 0xc7, 0x5a, 0xa4, 0x17, 0xbb, 0xba,
+	0xde, 0x55, 0x94, 0x1f, 0x12, 0xe1, 0xb6, 0x39, 0x7d, 0x69, 0xdb, 0xf6, 0x00, 0xbb, 0x82, 0xd9,
+	0x9d, 0xab, 0x2a, 0xb2, 0x6a, 0x8f, 0x29, 0xcc, 0x84, 0x9f, 0x93, 0x28, 0x8b, 0x96, 0x71, 0xf1,
+	0xb7, 0xb3, 0x1c, 0x4e, 0xd7, 0xeb, 0xa7, 0x7b, 0x6a, 0x6c, 0x41, 0xdf, 0x8e, 0x8c, 0xc5, 0x4b,
+	0x00, 0xed, 0x44, 0xa5, 0xe4, 0xe6, 0x93, 0x5a, 0xcf, 0xc7, 0x7d, 0xf2, 0x42, 0x2d, 0x53, 0x30,
+	0xf5, 0x1f, 0x30, 0x83, 0xb9, 0xa4, 0xc6, 0xaa, 0xad, 0x92, 0xa5, 0x25, 0x8f, 0x0e, 0x23, 0x64,
+	0x70, 0x22, 0xcb, 0xcd, 0xa0, 0x6e, 0xec, 0x99, 0x72, 0x15, 0x0a, 0x31, 0x81, 0x29, 0xfd, 0x68,
+	0xd5, 0x90, 0x49, 0x26, 0x59, 0xb4, 0x9c, 0x14, 0x61, 0xbd, 0xd9, 0x43, 0xbc, 0x0a, 0xde, 0xc8,
+	0xe1, 0xe8, 0xf5, 0xed, 0xfd, 0x01, 0xcf, 0x79, 0xaf, 0xcd, 0x83, 0x36, 0x7f, 0xec, 0xb4, 0xd3,
+	0x05, 0xd7, 0x82, 0x07, 0x65, 0x36, 0xc2, 0x6b, 0x58, 0x3c, 0x1b, 0xe3, 0x28, 0x1c, 0x8b, 0xdd,
+	0xfb, 0x7f, 0xd5, 0x74, 0x3e, 0xc8, 0xd8, 0x48, 0x1c, 0x1f, 0x2a, 0x6f, 0x7f, 0x03, 0x00, 0x00,
+	0xff, 0xff, 0x81, 0x64, 0x75, 0xfc, 0x6e, 0x01, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -96,6 +203,9 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type ProtectedClient interface {
 	MOTD(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*Bulletin, error)
+	// IssueSSHCert signs an OpenSSH user certificate for the public key, for
+	// the user of the session and until the session expires.
+	IssueSSHCert(ctx context.Context, in *SSHCertRequest, opts ...grpc.CallOption) (*SSHCert, error)
 }
 
 type protectedClient struct {
@@ -115,9 +225,21 @@ func (c *protectedClient) MOTD(ctx context.Context, in *empty.Empty, opts ...grp
 	return out, nil
 }
 
+func (c *protectedClient) IssueSSHCert(ctx context.Context, in *SSHCertRequest, opts ...grpc.CallOption) (*SSHCert, error) {
+	out := new(SSHCert)
+	err := c.cc.Invoke(ctx, "/pb.Protected/IssueSSHCert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ProtectedServer is the server API for Protected service.
 type ProtectedServer interface {
 	MOTD(context.Context, *empty.Empty) (*Bulletin, error)
+	// IssueSSHCert signs an OpenSSH user certificate for the public key, for
+	// the user of the session and until the session expires.
+	IssueSSHCert(context.Context, *SSHCertRequest) (*SSHCert, error)
 }
 
 // UnimplementedProtectedServer can be embedded to have forward compatible implementations.
@@ -127,6 +249,9 @@ type UnimplementedProtectedServer struct {
 func (*UnimplementedProtectedServer) MOTD(ctx context.Context, req *empty.Empty) (*Bulletin, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method MOTD not implemented")
 }
+func (*UnimplementedProtectedServer) IssueSSHCert(ctx context.Context, req *SSHCertRequest) (*SSHCert, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IssueSSHCert not implemented")
+}
 
 func RegisterProtectedServer(s *grpc.Server, srv ProtectedServer) {
 	s.RegisterService(&_Protected_serviceDesc, srv)
@@ -150,6 +275,24 @@ func _Protected_MOTD_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Protected_IssueSSHCert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SSHCertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ProtectedServer).IssueSSHCert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Protected/IssueSSHCert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProtectedServer).IssueSSHCert(ctx, req.(*SSHCertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Protected_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.Protected",
 	HandlerType: (*ProtectedServer)(nil),
@@ -158,6 +301,10 @@ var _Protected_serviceDesc = grpc.ServiceDesc{
 			MethodName: "MOTD",
 			Handler:    _Protected_MOTD_Handler,
 		},
+		{
+			MethodName: "IssueSSHCert",
+			Handler:    _Protected_IssueSSHCert_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "protected.proto",
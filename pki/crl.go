@@ -0,0 +1,74 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const crlPEMtype = "X509 CRL"
+
+// CreateCRL signs a certificate revocation list of the serial numbers, in
+// hexadecimal, with the CA's key, for servers that check client certificates
+// against a CRL rather than the list of revoked serials.  The list is issued
+// at now and must be issued again before next, with a greater number.  Since
+// the time of each revocation is not kept, they are all given as now.
+func CreateCRL(
+	ca *x509.Certificate, key *ecdsa.PrivateKey, serials []string,
+	number *big.Int, now, next time.Time,
+) (crlPEM string, err error) {
+	if ca.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return "", errors.New("the CA is not allowed to sign CRLs, " +
+			"rotate it to issue them")
+	}
+
+	tmpl := &x509.RevocationList{
+		Number:     number,
+		ThisUpdate: now,
+		NextUpdate: next,
+	}
+	for _, serial := range serials {
+		n, ok := new(big.Int).SetString(serial, 16)
+		if !ok {
+			return "", errors.Errorf("invalid serial number %q", serial)
+		}
+		tmpl.RevokedCertificateEntries = append(
+			tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+				SerialNumber:   n,
+				RevocationTime: now,
+			})
+	}
+
+	byt, err := x509.CreateRevocationList(rand.Reader, tmpl, ca, key)
+	if err != nil {
+		return "", errors.Wrap(err, "creating CRL")
+	}
+
+	blk := &pem.Block{
+		Type:  crlPEMtype,
+		Bytes: byt,
+	}
+	return string(pem.EncodeToMemory(blk)), nil
+}
+
+// NextCRLNumber returns the number of a CRL issued at now to replace the one
+// in PEM format, which may be empty.  Numbers are the time in nanoseconds, or
+// the previous number plus one if that is not greater, so that they increase
+// even for lists issued within the same second or after the clock went back.
+func NextCRLNumber(prevPEM string, now time.Time) *big.Int {
+	number := big.NewInt(now.UnixNano())
+	blk, _ := pem.Decode([]byte(prevPEM))
+	if blk == nil || blk.Type != crlPEMtype {
+		return number
+	}
+	prev, err := x509.ParseRevocationList(blk.Bytes)
+	if err != nil || prev.Number == nil || prev.Number.Cmp(number) < 0 {
+		return number
+	}
+	return number.Add(prev.Number, big.NewInt(1))
+}
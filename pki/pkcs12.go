@@ -0,0 +1,82 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// PKCS12Encryption is how a PKCS#12 bundle is encrypted.
+type PKCS12Encryption int
+
+const (
+	// PKCS12Modern encrypts with AES-256 and PBKDF2, with a SHA-256 MAC.  It
+	// is read by current browsers, operating systems and OpenSSL 3.
+	PKCS12Modern PKCS12Encryption = iota
+
+	// PKCS12Legacy encrypts with 3DES, with a SHA-1 MAC, for older systems
+	// that cannot read modern bundles, such as Java 8 or Windows before
+	// Server 2019.
+	PKCS12Legacy
+)
+
+// ParsePKCS12Encryption returns the encryption named "modern" or "legacy".
+func ParsePKCS12Encryption(name string) (PKCS12Encryption, error) {
+	switch name {
+	case "modern":
+		return PKCS12Modern, nil
+	case "legacy":
+		return PKCS12Legacy, nil
+	}
+	return 0, errors.Errorf("unknown PKCS#12 encryption %q, "+
+		"must be modern or legacy", name)
+}
+
+// EncodePKCS12 bundles the key, its certificate and the certificates of the
+// chain into a PKCS#12 file protected by the password, for browsers and the
+// certificate stores of operating systems.
+func EncodePKCS12(
+	key *ecdsa.PrivateKey, cert *x509.Certificate, chain []*x509.Certificate,
+	password string, enc PKCS12Encryption,
+) (pfx []byte, err error) {
+	if password == "" {
+		return nil, errors.New("a password is required for PKCS#12 bundles")
+	}
+
+	encoder := pkcs12.Modern
+	if enc == PKCS12Legacy {
+		encoder = pkcs12.Legacy
+	}
+	pfx, err = encoder.Encode(key, cert, chain, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding PKCS#12 bundle")
+	}
+	return pfx, nil
+}
+
+// DecodePKCS12 returns the key, its certificate and the certificates of the
+// chain from a PKCS#12 file protected by the password.  The key must be an
+// ECDSA key matching the certificate.
+func DecodePKCS12(
+	pfx []byte, password string,
+) (key *ecdsa.PrivateKey, cert *x509.Certificate, chain []*x509.Certificate,
+	err error) {
+	priv, cert, chain, err := pkcs12.DecodeChain(pfx, password)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "decoding PKCS#12 bundle")
+	}
+
+	key, ok := priv.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, nil, errors.Errorf(
+			"unsupported key type %T in PKCS#12 bundle", priv)
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		return nil, nil, nil, errors.New(
+			"the certificate in the PKCS#12 bundle does not match the key")
+	}
+	return key, cert, chain, nil
+}
@@ -1,19 +1,26 @@
 package pki
 
 import (
+	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"math/big"
-	"os"
+	"net"
 	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/KibaFox/tls-usr-sessions/keystore"
 )
 
 const (
@@ -22,6 +29,15 @@ const (
 	certPEMtype = "CERTIFICATE"
 )
 
+// ChallengeOID identifies the CSR extension that carries the server-issued
+// login challenge.  Since the extension is covered by the CSR signature, it
+// proves that the holder of the key answered this particular challenge.
+var ChallengeOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1, 1}
+
+// DeviceOID identifies the certificate extension that names the device a user
+// logged in from, as given by the common name of the CSR.
+var DeviceOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1, 2}
+
 // GenerateKey will generate a new ECDSA private key.
 func GenerateKey() (key *ecdsa.PrivateKey, err error) {
 	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -32,41 +48,52 @@ func GenerateKey() (key *ecdsa.PrivateKey, err error) {
 	return key, nil
 }
 
-// SaveKey saves an ECDSA private key to a file in PEM format.
+// SaveKey saves an ECDSA private key to a file in PEM format, readable only by
+// its owner.  An existing file is replaced at once.
 func SaveKey(key *ecdsa.PrivateKey, path string) (err error) {
-	byt, err := x509.MarshalECPrivateKey(key)
+	byt, err := KeyToPEM(key)
 	if err != nil {
-		return errors.Wrap(err, "marshalling key to save")
+		return err
 	}
 
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	err = keystore.WriteFile(path, byt)
 	if err != nil {
-		return errors.Wrap(err, "opening file to save key")
+		return errors.Wrap(err, "saving key")
 	}
-	defer f.Close()
 
-	blk := &pem.Block{
-		Type:  keyPEMtype,
-		Bytes: byt,
-	}
+	return nil
+}
 
-	err = pem.Encode(f, blk)
+// KeyToPEM encodes an ECDSA private key in PEM format, such as for a
+// keystore.Store.
+func KeyToPEM(key *ecdsa.PrivateKey) (keyPEM []byte, err error) {
+	byt, err := x509.MarshalECPrivateKey(key)
 	if err != nil {
-		return errors.Wrap(err, "encoding key to PEM while saving")
+		return nil, errors.Wrap(err, "marshalling key to save")
 	}
 
-	return nil
+	blk := &pem.Block{
+		Type:  keyPEMtype,
+		Bytes: byt,
+	}
+	return pem.EncodeToMemory(blk), nil
 }
 
 // LoadKey will load an ECDSA private key file that's encoded in PEM format.
-// Encrypted key files are not supported.
+// Encrypted key files are not supported, and neither are files that users
+// other than their owner can access.
 func LoadKey(path string) (key *ecdsa.PrivateKey, err error) {
-	byt, err := ioutil.ReadFile(path)
+	byt, err := keystore.ReadPrivate(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "reading the key file")
 	}
 
-	blk, _ := pem.Decode(byt)
+	return PEMtoKey(byt)
+}
+
+// PEMtoKey parses an ECDSA private key encoded in PEM format.
+func PEMtoKey(keyPEM []byte) (key *ecdsa.PrivateKey, err error) {
+	blk, _ := pem.Decode(keyPEM)
 	if blk == nil {
 		return nil, errors.New("could not find PEM")
 	}
@@ -92,6 +119,59 @@ func NewCSR(key *ecdsa.PrivateKey, cn string) (csrPEM string, err error) {
 		},
 	}
 
+	return createCSR(key, tmpl)
+}
+
+// NewChallengeCSR creates a new CSR like NewCSR, but also embeds the given
+// login challenge as a signed extension.  The key may be held elsewhere, such
+// as by an agent.
+func NewChallengeCSR(
+	key crypto.Signer, cn, challenge string,
+) (csrPEM string, err error) {
+	val, err := asn1.Marshal(challenge)
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling challenge")
+	}
+
+	tmpl := &x509.CertificateRequest{
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+		Subject: pkix.Name{
+			CommonName: cn,
+		},
+		ExtraExtensions: []pkix.Extension{
+			{Id: ChallengeOID, Value: val},
+		},
+	}
+
+	return createCSR(key, tmpl)
+}
+
+// CSRChallenge returns the login challenge embedded in the given CSR after
+// verifying the CSR's signature.  An empty challenge is returned if the CSR
+// does not carry one.
+func CSRChallenge(csrPEM string) (challenge string, err error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return "", err
+	}
+
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(ChallengeOID) {
+			continue
+		}
+		_, err = asn1.Unmarshal(ext.Value, &challenge)
+		if err != nil {
+			return "", errors.Wrap(err, "parsing challenge")
+		}
+		return challenge, nil
+	}
+
+	return "", nil
+}
+
+func createCSR(
+	key crypto.Signer, tmpl *x509.CertificateRequest,
+) (csrPEM string, err error) {
 	byt, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
 	if err != nil {
 		return "", errors.Wrap(err, "creating CSR")
@@ -104,62 +184,193 @@ func NewCSR(key *ecdsa.PrivateKey, cn string) (csrPEM string, err error) {
 	return string(pem.EncodeToMemory(blk)), nil
 }
 
+// SignCSR signs the CSR with the given CA key, keeping the subject that the
+// CSR asked for.
 func SignCSR(
 	key *ecdsa.PrivateKey,
 	parent *x509.Certificate,
 	csrPEM string,
 	ttl time.Duration,
 ) (certPEM string, err error) {
-	blk, _ := pem.Decode([]byte(csrPEM))
-	if blk == nil {
-		return "", errors.New("could not find PEM")
-	}
-	if blk.Type != csrPEMtype {
-		return "", errors.New("PEM is not a certificate request")
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return "", err
 	}
 
-	csr, err := x509.ParseCertificateRequest(blk.Bytes)
+	return sign(key, parent, csr, csr.Subject, nil, ttl)
+}
+
+// SignIdentity signs the CSR for the identity of a user who logged in.  The
+// subject holds the user as common name and the roles as organizational
+// units, whatever the CSR asked for, and the device is the CSR's common name.
+func SignIdentity(
+	key *ecdsa.PrivateKey,
+	parent *x509.Certificate,
+	csrPEM string,
+	ttl time.Duration,
+	user string,
+	roles []string,
+) (certPEM string, err error) {
+	csr, err := parseCSR(csrPEM)
 	if err != nil {
-		return "", errors.Wrap(err, "parsing certificate request")
+		return "", err
 	}
 
-	err = csr.CheckSignature()
-	if err != nil {
-		return "", errors.Wrap(err, "checking CSR signature")
+	subject := pkix.Name{CommonName: user, OrganizationalUnit: roles}
+	var exts []pkix.Extension
+	if device := csr.Subject.CommonName; device != "" {
+		val, err := asn1.Marshal(device)
+		if err != nil {
+			return "", errors.Wrap(err, "marshalling device")
+		}
+		exts = append(exts, pkix.Extension{Id: DeviceOID, Value: val})
+	}
+
+	return sign(key, parent, csr, subject, exts, ttl)
+}
+
+// SignServer signs a certificate for a server named name, such as a gateway
+// or a VPN server, so that it presents a certificate clients trust without
+// holding the CA's key.  The name may be a host name or an IP address.
+func SignServer(
+	key *ecdsa.PrivateKey,
+	parent *x509.Certificate,
+	pub *ecdsa.PublicKey,
+	name string,
+	ttl time.Duration,
+) (certPEM string, err error) {
+	if name == "" {
+		return "", errors.New("a name is required for a server certificate")
+	}
+
+	tmpl := &x509.Certificate{
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+		Subject:            pkix.Name{CommonName: name},
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	return issue(key, parent, tmpl, pub, ttl)
+}
+
+func sign(
+	key *ecdsa.PrivateKey,
+	parent *x509.Certificate,
+	csr *x509.CertificateRequest,
+	subject pkix.Name,
+	exts []pkix.Extension,
+	ttl time.Duration,
+) (certPEM string, err error) {
+	tmpl := &x509.Certificate{
+		SignatureAlgorithm: csr.SignatureAlgorithm,
+		Subject:            subject,
+		ExtraExtensions:    exts,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
+	return issue(key, parent, tmpl, csr.PublicKey, ttl)
+}
 
+// issue signs a certificate of the template for the public key, valid from now
+// for ttl.
+func issue(
+	key *ecdsa.PrivateKey,
+	parent *x509.Certificate,
+	tmpl *x509.Certificate,
+	pub interface{},
+	ttl time.Duration,
+) (certPEM string, err error) {
 	serialNumber, err := newSerial()
 	if err != nil {
 		return "", errors.Wrap(err, "generating serial number")
 	}
 
-	tmpl := &x509.Certificate{
-		SerialNumber:          serialNumber,
-		SignatureAlgorithm:    csr.SignatureAlgorithm,
-		Subject:               csr.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().Add(ttl),
-		IsCA:                  false,
-		MaxPathLen:            0,
-		MaxPathLenZero:        true,
-		BasicConstraintsValid: true,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
-		KeyUsage: x509.KeyUsageKeyEncipherment |
-			x509.KeyUsageDigitalSignature,
-	}
-	byt, err := x509.CreateCertificate(
-		rand.Reader, tmpl, parent, csr.PublicKey, key)
+	tmpl.SerialNumber = serialNumber
+	tmpl.NotBefore = time.Now()
+	tmpl.NotAfter = time.Now().Add(ttl)
+	tmpl.IsCA = false
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageKeyEncipherment |
+		x509.KeyUsageDigitalSignature
+	byt, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, key)
 	if err != nil {
 		return "", errors.Wrap(err, "creating certificate")
 	}
 
-	blk = &pem.Block{
+	blk := &pem.Block{
 		Type:  certPEMtype,
 		Bytes: byt,
 	}
 	return string(pem.EncodeToMemory(blk)), nil
 }
 
+// Identity is who a certificate was issued to by SignIdentity, along with the
+// serial number of the certificate in hexadecimal.
+type Identity struct {
+	User   string
+	Roles  []string
+	Device string
+	Serial string
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx that carries the identity of the client a
+// request came from.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the client a request came from, if the
+// server authenticated it.
+func FromContext(ctx context.Context) (id Identity, ok bool) {
+	id, ok = ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// CertIdentity returns the identity of the user a certificate was issued to.
+func CertIdentity(cert *x509.Certificate) (id Identity, err error) {
+	id.User = cert.Subject.CommonName
+	id.Roles = cert.Subject.OrganizationalUnit
+	id.Serial = cert.SerialNumber.Text(16)
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(DeviceOID) {
+			continue
+		}
+		_, err = asn1.Unmarshal(ext.Value, &id.Device)
+		if err != nil {
+			return id, errors.Wrap(err, "parsing device")
+		}
+	}
+	return id, nil
+}
+
+// parseCSR decodes a CSR in PEM format and checks its signature.
+func parseCSR(csrPEM string) (csr *x509.CertificateRequest, err error) {
+	blk, _ := pem.Decode([]byte(csrPEM))
+	if blk == nil {
+		return nil, errors.New("could not find PEM")
+	}
+	if blk.Type != csrPEMtype {
+		return nil, errors.New("PEM is not a certificate request")
+	}
+
+	csr, err = x509.ParseCertificateRequest(blk.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing certificate request")
+	}
+
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, errors.Wrap(err, "checking CSR signature")
+	}
+
+	return csr, nil
+}
+
 // SelfSign will create a new self signed CA certificate with the given key and
 // common name (CN).
 func SelfSign(key *ecdsa.PrivateKey, cn string) (certPEM string, err error) {
@@ -176,6 +387,7 @@ func SelfSign(key *ecdsa.PrivateKey, cn string) (certPEM string, err error) {
 		Subject: pkix.Name{
 			CommonName: cn,
 		},
+		DNSNames:       []string{cn},
 		NotBefore:      time.Now(),
 		NotAfter:       time.Now().AddDate(5, 0, 0), // years
 		IsCA:           true,
@@ -183,7 +395,8 @@ func SelfSign(key *ecdsa.PrivateKey, cn string) (certPEM string, err error) {
 		MaxPathLenZero: true,
 		KeyUsage: x509.KeyUsageKeyEncipherment |
 			x509.KeyUsageDigitalSignature |
-			x509.KeyUsageCertSign,
+			x509.KeyUsageCertSign |
+			x509.KeyUsageCRLSign,
 		ExtKeyUsage: []x509.ExtKeyUsage{
 			x509.ExtKeyUsageClientAuth,
 			x509.ExtKeyUsageServerAuth,
@@ -204,9 +417,10 @@ func SelfSign(key *ecdsa.PrivateKey, cn string) (certPEM string, err error) {
 	return string(pem.EncodeToMemory(blk)), nil
 }
 
-// SaveCert saves a certificate in PEM format to a file.
+// SaveCert saves a certificate in PEM format to a file.  An existing file is
+// replaced at once.
 func SaveCert(certPEM string, path string) (err error) {
-	err = ioutil.WriteFile(path, []byte(certPEM), 0666)
+	err = keystore.WriteFile(path, []byte(certPEM))
 	if err != nil {
 		return errors.Wrap(err, "saving certificate")
 	}
@@ -248,6 +462,41 @@ func PEMtoCert(certPEM string) (cert *x509.Certificate, err error) {
 	return cert, nil
 }
 
+// PEMtoCerts parses every certificate in PEM format, in order, such as the
+// trust anchors in a file.  Blocks of other types are skipped.
+func PEMtoCerts(certsPEM string) (certs []*x509.Certificate, err error) {
+	rest := []byte(certsPEM)
+	for {
+		var blk *pem.Block
+		blk, rest = pem.Decode(rest)
+		if blk == nil {
+			break
+		}
+		if blk.Type != certPEMtype {
+			continue
+		}
+
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing x509 certificate")
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("could not find PEM")
+	}
+	return certs, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate's public key
+// in the form "SHA256:<base64>", as OpenSSH prints them.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
 func newSerial() (serial *big.Int, err error) {
 	var serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
 	return rand.Int(rand.Reader, serialNumberLimit)
@@ -3,11 +3,13 @@ package pki_test
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"math/big"
 	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/KibaFox/tls-usr-sessions/pki"
 )
@@ -56,6 +58,26 @@ var _ = Describe("PKI", func() {
 		Expect(csr.PublicKey).Should(Equal(&key.PublicKey))
 	})
 
+	It("Can embed a login challenge in a CSR", func() {
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+
+		csrPEM, err := pki.NewChallengeCSR(key, "client", "nonce123")
+		Expect(err).ToNot(HaveOccurred())
+
+		challenge, err := pki.CSRChallenge(csrPEM)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(challenge).Should(Equal("nonce123"))
+
+		By("Reading a CSR without a challenge")
+		csrPEM, err = pki.NewCSR(key, "client")
+		Expect(err).ToNot(HaveOccurred())
+
+		challenge, err = pki.CSRChallenge(csrPEM)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(challenge).Should(BeEmpty())
+	})
+
 	It("Can create a self-signed certificate", func() {
 		key, err := pki.GenerateKey()
 		Expect(err).ToNot(HaveOccurred())
@@ -78,7 +100,8 @@ var _ = Describe("PKI", func() {
 		Expect(cert.KeyUsage).Should(Equal(
 			x509.KeyUsageKeyEncipherment |
 				x509.KeyUsageDigitalSignature |
-				x509.KeyUsageCertSign))
+				x509.KeyUsageCertSign |
+				x509.KeyUsageCRLSign))
 		Expect(cert.ExtKeyUsage).Should(Equal([]x509.ExtKeyUsage{
 			x509.ExtKeyUsageClientAuth,
 			x509.ExtKeyUsageServerAuth,
@@ -123,8 +146,9 @@ var _ = Describe("PKI", func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(cliCert.Subject.CommonName).Should(Equal("client"))
 		Expect(cliCert.IsCA).Should(BeFalse(), "client cert should not be CA")
-		Expect(cliCert.MaxPathLen).Should(BeZero())
-		Expect(cliCert.MaxPathLenZero).Should(BeTrue())
+		Expect(cliCert.MaxPathLen).Should(Equal(-1),
+			"only CAs may specify a path length")
+		Expect(cliCert.MaxPathLenZero).Should(BeFalse())
 		Expect(cliCert.KeyUsage).Should(Equal(
 			x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature))
 		Expect(cliCert.ExtKeyUsage).Should(Equal([]x509.ExtKeyUsage{
@@ -134,6 +158,209 @@ var _ = Describe("PKI", func() {
 			BeTemporally("~", time.Now(), time.Second))
 		Expect(cliCert.NotAfter).Should(
 			BeTemporally("~", time.Now().AddDate(0, 0, 5), time.Second))
+
+		By("Fingerprinting the client's key")
+		fp := pki.Fingerprint(cliCert)
+		Expect(fp).Should(HavePrefix("SHA256:"))
+		Expect(fp).ShouldNot(Equal(pki.Fingerprint(srvCert)))
+	})
+
+	It("Can sign a CSR for a user's identity", func() {
+		srvKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		srvCertPEM, err := pki.SelfSign(srvKey, "server")
+		Expect(err).ToNot(HaveOccurred())
+		srvCert, err := pki.PEMtoCert(srvCertPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		csrPEM, err := pki.NewCSR(cliKey, "laptop")
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Signing the CSR for the user")
+		cliCertPEM, err := pki.SignIdentity(srvKey, srvCert, csrPEM,
+			time.Hour, "demo", []string{"user", "admin"})
+		Expect(err).ToNot(HaveOccurred())
+		cliCert, err := pki.PEMtoCert(cliCertPEM)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cliCert.Subject.CommonName).Should(Equal("demo"))
+
+		By("Reading the identity back")
+		id, err := pki.CertIdentity(cliCert)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).Should(Equal(pki.Identity{
+			User:   "demo",
+			Roles:  []string{"user", "admin"},
+			Device: "laptop",
+			Serial: cliCert.SerialNumber.Text(16),
+		}))
+	})
+
+	It("Can sign a certificate for a server", func() {
+		caKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		caCertPEM, err := pki.SelfSign(caKey, "server")
+		Expect(err).ToNot(HaveOccurred())
+		caCert, err := pki.PEMtoCert(caCertPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		certPEM, err := pki.SignServer(caKey, caCert, &key.PublicKey,
+			"gateway.example", time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := pki.PEMtoCert(certPEM)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.IsCA).Should(BeFalse())
+		Expect(cert.ExtKeyUsage).Should(Equal(
+			[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}))
+
+		pool := x509.NewCertPool()
+		pool.AddCert(caCert)
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName: "gateway.example",
+			Roots:   pool,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Naming a server by its IP address")
+		certPEM, err = pki.SignServer(caKey, caCert, &key.PublicKey,
+			"192.0.2.1", time.Hour)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err = pki.PEMtoCert(certPEM)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.VerifyHostname("192.0.2.1")).To(Succeed())
+	})
+
+	It("Can bundle a session into a PKCS#12 file", func() {
+		srvKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		srvCertPEM, err := pki.SelfSign(srvKey, "server")
+		Expect(err).ToNot(HaveOccurred())
+		srvCert, err := pki.PEMtoCert(srvCertPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		cliKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		csrPEM, err := pki.NewCSR(cliKey, "laptop")
+		Expect(err).ToNot(HaveOccurred())
+		cliCertPEM, err := pki.SignIdentity(srvKey, srvCert, csrPEM,
+			time.Hour, "demo", []string{"user"})
+		Expect(err).ToNot(HaveOccurred())
+		cliCert, err := pki.PEMtoCert(cliCertPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, name := range []string{"modern", "legacy"} {
+			By("Encoding with " + name + " encryption")
+			enc, err := pki.ParsePKCS12Encryption(name)
+			Expect(err).ToNot(HaveOccurred())
+			pfx, err := pki.EncodePKCS12(cliKey, cliCert,
+				[]*x509.Certificate{srvCert}, "secret", enc)
+			Expect(err).ToNot(HaveOccurred())
+
+			key, cert, chain, err := pki.DecodePKCS12(pfx, "secret")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key.D).Should(Equal(cliKey.D))
+			Expect(cert.Raw).Should(Equal(cliCert.Raw))
+			Expect(chain).Should(HaveLen(1))
+			Expect(chain[0].Raw).Should(Equal(srvCert.Raw))
+
+			_, _, _, err = pki.DecodePKCS12(pfx, "wrong")
+			Expect(err).To(HaveOccurred())
+		}
+
+		_, err = pki.EncodePKCS12(cliKey, cliCert, nil, "", pki.PKCS12Modern)
+		Expect(err).To(HaveOccurred(), "a password should be required")
+		_, err = pki.ParsePKCS12Encryption("rot13")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Can sign an SSH certificate for a user's session", func() {
+		caKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		userKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		pub, err := ssh.NewPublicKey(&userKey.PublicKey)
+		Expect(err).ToNot(HaveOccurred())
+		authorized := string(ssh.MarshalAuthorizedKey(pub))
+
+		id := pki.Identity{User: "demo", Device: "laptop", Serial: "1f"}
+		notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+		cert, err := pki.SignSSHCert(caKey, authorized, id, notAfter)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.CertType).Should(Equal(uint32(ssh.UserCert)))
+		Expect(cert.ValidPrincipals).Should(Equal([]string{"demo"}))
+		Expect(cert.ValidBefore).Should(Equal(uint64(notAfter.Unix())))
+		Expect(cert.ValidAfter).Should(BeNumerically("<=",
+			time.Now().Add(-time.Minute).Unix()),
+			"SSH servers with slow clocks would refuse the certificate")
+		Expect(cert.KeyId).Should(Equal(
+			"user=demo session=1f device=laptop"))
+
+		caPub, err := pki.SSHPublicKey(caKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(ssh.MarshalAuthorizedKey(cert.SignatureKey))).
+			Should(Equal(caPub))
+		Expect((&ssh.CertChecker{}).CheckCert("demo", cert)).To(Succeed())
+
+		By("Refusing an expired session")
+		_, err = pki.SignSSHCert(caKey, authorized, id,
+			time.Now().Add(-time.Minute))
+		Expect(err).To(HaveOccurred())
+
+		By("Refusing what is not an SSH public key")
+		_, err = pki.SignSSHCert(caKey, "not a key", id, notAfter)
+		Expect(err).To(HaveOccurred())
+
+		By("Refusing to certify a certificate")
+		_, err = pki.SignSSHCert(caKey,
+			string(ssh.MarshalAuthorizedKey(cert)), id, notAfter)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Can sign a CRL of revoked serials", func() {
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		caPEM, err := pki.SelfSign(key, "server")
+		Expect(err).ToNot(HaveOccurred())
+		ca, err := pki.PEMtoCert(caPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		now := time.Now().Truncate(time.Second)
+		crlPEM, err := pki.CreateCRL(ca, key, []string{"1f", "a0"},
+			big.NewInt(42), now, now.Add(time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+
+		blk, _ := pem.Decode([]byte(crlPEM))
+		Expect(blk).ToNot(BeNil())
+		Expect(blk.Type).Should(Equal("X509 CRL"))
+		crl, err := x509.ParseRevocationList(blk.Bytes)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(crl.CheckSignatureFrom(ca)).To(Succeed())
+		Expect(crl.NextUpdate).Should(BeTemporally("==", now.Add(time.Hour)))
+		Expect(crl.Number).Should(Equal(big.NewInt(42)))
+		var serials []string
+		for _, entry := range crl.RevokedCertificateEntries {
+			serials = append(serials, entry.SerialNumber.Text(16))
+		}
+		Expect(serials).Should(Equal([]string{"1f", "a0"}))
+
+		By("Refusing invalid serials")
+		_, err = pki.CreateCRL(ca, key, []string{"xyz"}, big.NewInt(43),
+			now, now.Add(time.Hour))
+		Expect(err).To(HaveOccurred())
+
+		By("Numbering the next CRL after it, even within the same second")
+		Expect(pki.NextCRLNumber(crlPEM, now)).Should(Equal(big.NewInt(
+			now.UnixNano())))
+		Expect(pki.NextCRLNumber("", now)).Should(Equal(big.NewInt(
+			now.UnixNano())))
+		crlPEM, err = pki.CreateCRL(ca, key, nil, pki.NextCRLNumber(crlPEM,
+			now), now, now.Add(time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pki.NextCRLNumber(crlPEM, now)).Should(Equal(big.NewInt(
+			now.UnixNano()+1)))
 	})
 
 	It("can save + load a certificate", func() {
@@ -162,4 +389,25 @@ var _ = Describe("PKI", func() {
 		Expect(err).ToNot(HaveOccurred())
 		Expect(loadedCert).Should(Equal(cert))
 	})
+
+	It("can parse several certificates", func() {
+		oldKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		oldPEM, err := pki.SelfSign(oldKey, "old")
+		Expect(err).ToNot(HaveOccurred())
+
+		newKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		newPEM, err := pki.SelfSign(newKey, "new")
+		Expect(err).ToNot(HaveOccurred())
+
+		certs, err := pki.PEMtoCerts(newPEM + oldPEM)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(certs).Should(HaveLen(2))
+		Expect(certs[0].Subject.CommonName).Should(Equal("new"))
+		Expect(certs[1].Subject.CommonName).Should(Equal("old"))
+
+		_, err = pki.PEMtoCerts("not a certificate")
+		Expect(err).To(HaveOccurred())
+	})
 })
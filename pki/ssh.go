@@ -0,0 +1,98 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshExtensions are the permissions of the OpenSSH user certificates, which
+// are those ssh-keygen grants by default.
+var sshExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// sshClockSkew is how long before they are signed SSH certificates are valid
+// from, so that SSH servers whose clocks are behind accept them at once.
+const sshClockSkew = 2 * time.Minute
+
+// SignSSHCert signs an OpenSSH user certificate for the public key, in the
+// authorized_keys format, with the CA's key.  The certificate is issued to
+// the user of the identity as its only principal, and is valid until
+// notAfter, which is that of the user's session.
+func SignSSHCert(
+	caKey *ecdsa.PrivateKey, publicKey string, id Identity, notAfter time.Time,
+) (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing SSH public key")
+	}
+	if _, ok := pub.(*ssh.Certificate); ok {
+		return nil, errors.New("SSH public key is already a certificate")
+	}
+	if id.User == "" {
+		return nil, errors.New("a user is required for an SSH certificate")
+	}
+	now := time.Now()
+	if !notAfter.After(now) {
+		return nil, errors.New("the session has expired")
+	}
+
+	signer, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading SSH CA key")
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating serial number")
+	}
+
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          serial.Uint64(),
+		CertType:        ssh.UserCert,
+		KeyId:           sshKeyID(id),
+		ValidPrincipals: []string{id.User},
+		ValidAfter:      uint64(now.Add(-sshClockSkew).Unix()),
+		ValidBefore:     uint64(notAfter.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: make(map[string]string, len(sshExtensions)),
+		},
+	}
+	for ext, v := range sshExtensions {
+		cert.Permissions.Extensions[ext] = v
+	}
+	err = cert.SignCert(rand.Reader, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing SSH certificate")
+	}
+	return cert, nil
+}
+
+// SSHPublicKey returns the public key of the CA's key in the authorized_keys
+// format, for the TrustedUserCAKeys of SSH servers.
+func SSHPublicKey(caKey *ecdsa.PrivateKey) (string, error) {
+	pub, err := ssh.NewPublicKey(&caKey.PublicKey)
+	if err != nil {
+		return "", errors.Wrap(err, "loading SSH CA key")
+	}
+	return string(ssh.MarshalAuthorizedKey(pub)), nil
+}
+
+// sshKeyID names the session an SSH certificate was issued from, so that the
+// logs of SSH servers can be matched with the audit log.
+func sshKeyID(id Identity) string {
+	keyID := fmt.Sprintf("user=%s session=%s", id.User, id.Serial)
+	if id.Device != "" {
+		keyID += " device=" + id.Device
+	}
+	return keyID
+}
@@ -0,0 +1,290 @@
+// Package profile keeps the settings for each server a client logs into as a
+// named profile, in the style of kubeconfig, so that logging into one server
+// does not overwrite the credentials for another.  The profiles are listed in
+// profiles.yaml in the client's configuration directory, and each keeps its
+// key and certificates in a directory of its own.
+package profile
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/KibaFox/tls-usr-sessions/keystore"
+	"github.com/KibaFox/tls-usr-sessions/pki"
+)
+
+// DefaultName is the profile used when none is given or selected.
+const DefaultName = "default"
+
+// Default addresses of the servers of a new profile.
+const (
+	DefaultAuth      = "127.0.0.1:4443"
+	DefaultProtected = "127.0.0.1:4444"
+)
+
+// Dir returns the client's configuration directory, which follows the XDG
+// base directory specification.
+func Dir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "tls-sess-demo"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "finding the configuration directory")
+	}
+	return filepath.Join(home, ".config", "tls-sess-demo"), nil
+}
+
+// Profile holds what a client needs to connect to a server.
+type Profile struct {
+	// Auth and Protected are the addresses of the servers.
+	Auth      string `yaml:"auth"`
+	Protected string `yaml:"protected"`
+
+	// Key, Cert and Root are the paths of the client's key and certificate
+	// and of the trust anchors.  They are kept in the profile's directory if
+	// empty.
+	Key  string `yaml:"key,omitempty"`
+	Cert string `yaml:"cert,omitempty"`
+	Root string `yaml:"root,omitempty"`
+
+	// KeyStore is where the client's key is kept: "file", the default, for
+	// a file at Key, "encrypted" for a file at Key encrypted with a
+	// passphrase, or "secret-service" for the keyring of the desktop.
+	KeyStore string `yaml:"keystore,omitempty"`
+
+	// Fingerprint is that of the server's CA, as given by pki.Fingerprint.
+	// It is pinned at the first login and checked from then on.
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+
+	name string
+	dir  string
+}
+
+// Name returns the name of the profile.
+func (p *Profile) Name() string {
+	return p.name
+}
+
+// Dir returns the directory where the profile keeps its files by default.
+func (p *Profile) Dir() string {
+	return filepath.Join(p.dir, "profiles", p.name)
+}
+
+// KeyPath returns the path of the client's key.
+func (p *Profile) KeyPath() string {
+	return p.path(p.Key, "key.pem")
+}
+
+// CertPath returns the path of the client's certificate.
+func (p *Profile) CertPath() string {
+	return p.path(p.Cert, "cert.pem")
+}
+
+// RootPath returns the path of the trust anchors.
+func (p *Profile) RootPath() string {
+	return p.path(p.Root, "root.pem")
+}
+
+func (p *Profile) path(set, name string) string {
+	if set != "" {
+		return set
+	}
+	return filepath.Join(p.Dir(), name)
+}
+
+// PinError is returned when a server's CA does not match the fingerprint
+// pinned for a profile.
+type PinError struct {
+	Profile string
+	Pinned  string
+	Got     string
+}
+
+func (e *PinError) Error() string {
+	return "the server's CA " + e.Got + " does not match " + e.Pinned +
+		", pinned for profile " + e.Profile
+}
+
+// Pin checks the server's CA against the pinned fingerprint, pinning it if
+// none is yet.
+func (p *Profile) Pin(ca *x509.Certificate) error {
+	fp := pki.Fingerprint(ca)
+	if p.Fingerprint == "" {
+		p.Fingerprint = fp
+		return nil
+	}
+	if fp != p.Fingerprint {
+		return &PinError{Profile: p.name, Pinned: p.Fingerprint, Got: fp}
+	}
+	return nil
+}
+
+// VerifyPeerCertificate checks that the server's certificate chains to the
+// pinned CA, for use in a tls.Config.  Any server is accepted if no
+// fingerprint is pinned.
+func (p *Profile) VerifyPeerCertificate(
+	_ [][]byte, verifiedChains [][]*x509.Certificate,
+) error {
+	if p.Fingerprint == "" {
+		return nil
+	}
+
+	var got string
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			got = pki.Fingerprint(cert)
+			if got == p.Fingerprint {
+				return nil
+			}
+		}
+	}
+	return &PinError{Profile: p.name, Pinned: p.Fingerprint, Got: got}
+}
+
+// Config is the list of profiles and which is current.
+type Config struct {
+	Current  string              `yaml:"current,omitempty"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+
+	dir string
+}
+
+var nameRx = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Load reads the profiles in the configuration directory.  There are none if
+// the directory does not exist yet.
+func Load(dir string) (*Config, error) {
+	c := &Config{Profiles: make(map[string]*Profile), dir: dir}
+
+	raw, err := ioutil.ReadFile(c.path())
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading profiles")
+	}
+
+	err = yaml.Unmarshal(raw, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", c.path())
+	}
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]*Profile)
+	}
+	for name, p := range c.Profiles {
+		if !nameRx.MatchString(name) || p == nil {
+			return nil, errors.Errorf("invalid profile %q in %s", name,
+				c.path())
+		}
+		p.name, p.dir = name, dir
+	}
+
+	return c, nil
+}
+
+func (c *Config) path() string {
+	return filepath.Join(c.dir, "profiles.yaml")
+}
+
+// Save writes the profiles.  The file is replaced at once, so that it is never
+// left half written.
+func (c *Config) Save() error {
+	raw, err := yaml.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "encoding profiles")
+	}
+
+	err = keystore.WriteFile(c.path(), raw)
+	if err != nil {
+		return errors.Wrap(err, "saving profiles")
+	}
+	return nil
+}
+
+// Names returns the names of the profiles in order.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the named profile, or the current one if name is empty, or
+// else the default one.  A profile that does not exist yet is returned with
+// the default addresses, but is not added until it is given to Put.
+func (c *Config) Select(name string) (*Profile, error) {
+	if name == "" {
+		name = c.Current
+	}
+	if name == "" {
+		name = DefaultName
+	}
+	if !nameRx.MatchString(name) {
+		return nil, errors.Errorf("invalid profile name %q", name)
+	}
+
+	if p, ok := c.Profiles[name]; ok {
+		return p, nil
+	}
+	return &Profile{
+		Auth:      DefaultAuth,
+		Protected: DefaultProtected,
+		name:      name,
+		dir:       c.dir,
+	}, nil
+}
+
+// Exists reports whether the named profile exists.
+func (c *Config) Exists(name string) bool {
+	_, ok := c.Profiles[name]
+	return ok
+}
+
+// Put adds or replaces a profile from Select, making it current if no profile
+// is.
+func (c *Config) Put(p *Profile) {
+	c.Profiles[p.name] = p
+	if c.Current == "" {
+		c.Current = p.name
+	}
+}
+
+// Use makes the named profile current.
+func (c *Config) Use(name string) error {
+	if !c.Exists(name) {
+		return errors.Errorf("no profile named %q", name)
+	}
+	c.Current = name
+	return nil
+}
+
+// Delete removes the named profile along with its directory.  Files the
+// profile was given paths for elsewhere are left alone.
+func (c *Config) Delete(name string) error {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return errors.Errorf("no profile named %q", name)
+	}
+
+	err := os.RemoveAll(p.Dir())
+	if err != nil {
+		return errors.Wrap(err, "removing profile directory")
+	}
+
+	delete(c.Profiles, name)
+	if c.Current == name {
+		c.Current = ""
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package profile_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/pki"
+	"github.com/KibaFox/tls-usr-sessions/profile"
+)
+
+var _ = Describe("Profile", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = tmpDir()
+	})
+
+	AfterEach(func() {
+		rmDir(dir)
+	})
+
+	It("follows XDG_CONFIG_HOME", func() {
+		defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+		Expect(os.Setenv("XDG_CONFIG_HOME", dir)).To(Succeed())
+
+		got, err := profile.Dir()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).Should(Equal(filepath.Join(dir, "tls-sess-demo")))
+	})
+
+	It("selects the default profile when there are none", func() {
+		c, err := profile.Load(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Names()).Should(BeEmpty())
+
+		p, err := c.Select("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.Name()).Should(Equal(profile.DefaultName))
+		Expect(p.Auth).Should(Equal(profile.DefaultAuth))
+		Expect(p.KeyPath()).Should(Equal(
+			filepath.Join(dir, "profiles", "default", "key.pem")))
+		Expect(c.Exists(profile.DefaultName)).To(BeFalse())
+	})
+
+	It("saves, switches and deletes profiles", func() {
+		c, err := profile.Load(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		work, err := c.Select("work")
+		Expect(err).ToNot(HaveOccurred())
+		work.Auth = "work.example.com:4443"
+		c.Put(work)
+		home, err := c.Select("home")
+		Expect(err).ToNot(HaveOccurred())
+		home.Cert = "/elsewhere/cert.pem"
+		c.Put(home)
+		Expect(c.Current).Should(Equal("work"))
+		Expect(c.Save()).To(Succeed())
+
+		Expect(os.MkdirAll(work.Dir(), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(work.KeyPath(), nil, 0600)).To(Succeed())
+
+		By("Loading them again")
+		c, err = profile.Load(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Names()).Should(Equal([]string{"home", "work"}))
+		p, err := c.Select("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.Name()).Should(Equal("work"))
+		Expect(p.Auth).Should(Equal("work.example.com:4443"))
+		p, err = c.Select("home")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.CertPath()).Should(Equal("/elsewhere/cert.pem"))
+
+		By("Switching profiles")
+		Expect(c.Use("home")).To(Succeed())
+		Expect(c.Use("play")).ToNot(Succeed())
+		Expect(c.Current).Should(Equal("home"))
+
+		By("Deleting a profile with its files")
+		Expect(c.Delete("work")).To(Succeed())
+		Expect(c.Delete("work")).ToNot(Succeed())
+		Expect(work.Dir()).ShouldNot(BeADirectory())
+		Expect(c.Names()).Should(Equal([]string{"home"}))
+	})
+
+	It("refuses invalid names", func() {
+		c, err := profile.Load(dir)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.Select("../etc")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("pins the CA at first use", func() {
+		c, err := profile.Load(dir)
+		Expect(err).ToNot(HaveOccurred())
+		p, err := c.Select("")
+		Expect(err).ToNot(HaveOccurred())
+
+		key, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		caPEM, err := pki.SelfSign(key, "server")
+		Expect(err).ToNot(HaveOccurred())
+		ca, err := pki.PEMtoCert(caPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(p.Pin(ca)).To(Succeed())
+		Expect(p.Fingerprint).Should(Equal(pki.Fingerprint(ca)))
+		Expect(p.Pin(ca)).To(Succeed())
+
+		otherKey, err := pki.GenerateKey()
+		Expect(err).ToNot(HaveOccurred())
+		otherPEM, err := pki.SelfSign(otherKey, "server")
+		Expect(err).ToNot(HaveOccurred())
+		other, err := pki.PEMtoCert(otherPEM)
+		Expect(err).ToNot(HaveOccurred())
+
+		err = p.Pin(other)
+		Expect(err).Should(BeAssignableToTypeOf(&profile.PinError{}))
+		Expect(p.VerifyPeerCertificate(nil, [][]*x509.Certificate{{other}})).
+			ToNot(Succeed())
+		Expect(p.VerifyPeerCertificate(nil, [][]*x509.Certificate{{ca}})).
+			To(Succeed())
+	})
+})
@@ -0,0 +1,292 @@
+// Package rest serves the Auth and Protected services as JSON over HTTPS, for
+// browsers and scripts that do not speak gRPC.
+//
+// Each route is mapped by hand to a method of the gRPC services, which is
+// called in the same process through the interceptors of the gRPC servers, so
+// that the calls are logged, traced, limited and audited the same way.
+// Messages are encoded with the JSON mapping of protocol buffers, and errors
+// are answered with the HTTP status matching their gRPC code.
+//
+// The login routes are open to anyone, while the protected ones require a
+// session certificate, checked by httpauth.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/httpauth"
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+	"github.com/KibaFox/tls-usr-sessions/trace"
+)
+
+// Routes of the services.  The login routes take a POST with the request
+// message of the method they map to, and the others a GET.
+const (
+	// BeginLoginPath maps to Auth.BeginLogin.
+	BeginLoginPath = "/v1/login/begin"
+
+	// LoginPath maps to Auth.Login.  The response carries the signed
+	// certificate and the trust anchors in PEM format.
+	LoginPath = "/v1/login"
+
+	// MOTDPath maps to Protected.MOTD.
+	MOTDPath = "/v1/motd"
+)
+
+// maxBodySize bounds the size of request bodies, which only need to hold a
+// CSR and a few short strings.
+const maxBodySize = 64 << 10
+
+// Config is the configuration of the handler returned by New.
+type Config struct {
+	Auth      pb.AuthServer
+	Protected pb.ProtectedServer
+
+	// AuthInterceptor and ProtectedInterceptor wrap the calls to each
+	// service, as on their gRPC servers.  The calls are made directly if they
+	// are nil.
+	AuthInterceptor      grpc.UnaryServerInterceptor
+	ProtectedInterceptor grpc.UnaryServerInterceptor
+
+	// Revoked lists the certificates refused on the protected routes.  None
+	// are if it is nil.
+	Revoked *revoke.List
+}
+
+// New returns an http.Handler serving the routes of the services.  It must be
+// served over TLS with client certificates verified against the session CA if
+// given, so that clients can login without one.
+func New(cfg Config) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle(BeginLoginPath, &route{
+		verb:      http.MethodPost,
+		method:    "/pb.Auth/BeginLogin",
+		intercept: cfg.AuthInterceptor,
+		request:   func() proto.Message { return &pb.BeginLoginRequest{} },
+		call: func(ctx context.Context, req interface{}) (interface{}, error) {
+			return cfg.Auth.BeginLogin(ctx, req.(*pb.BeginLoginRequest))
+		},
+	})
+	mux.Handle(LoginPath, &route{
+		verb:      http.MethodPost,
+		method:    "/pb.Auth/Login",
+		intercept: cfg.AuthInterceptor,
+		request:   func() proto.Message { return &pb.LoginRequest{} },
+		call: func(ctx context.Context, req interface{}) (interface{}, error) {
+			return cfg.Auth.Login(ctx, req.(*pb.LoginRequest))
+		},
+	})
+
+	protected := httpauth.Middleware(httpauth.Options{Revoked: cfg.Revoked})
+	mux.Handle(MOTDPath, protected(&route{
+		verb:      http.MethodGet,
+		method:    "/pb.Protected/MOTD",
+		intercept: cfg.ProtectedInterceptor,
+		request:   func() proto.Message { return &empty.Empty{} },
+		call: func(ctx context.Context, req interface{}) (interface{}, error) {
+			return cfg.Protected.MOTD(ctx, req.(*empty.Empty))
+		},
+	}))
+
+	return mux
+}
+
+// route maps an HTTP route to a unary gRPC method.
+type route struct {
+	verb      string
+	method    string
+	intercept grpc.UnaryServerInterceptor
+	request   func() proto.Message
+	call      grpc.UnaryHandler
+}
+
+func (rt *route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != rt.verb {
+		w.Header().Set("Allow", rt.verb)
+		writeError(w, http.StatusMethodNotAllowed, status.Errorf(
+			codes.Unimplemented, "%s is not allowed", r.Method))
+		return
+	}
+
+	req := rt.request()
+	if rt.verb == http.MethodPost {
+		body := io.LimitReader(r.Body, maxBodySize)
+		if err := jsonpb.Unmarshal(body, req); err != nil {
+			writeError(w, http.StatusBadRequest, status.Error(
+				codes.InvalidArgument, "invalid request: "+err.Error()))
+			return
+		}
+	}
+
+	st := &transportStream{method: rt.method}
+	ctx := grpc.NewContextWithServerTransportStream(
+		incomingContext(r), st)
+
+	var resp interface{}
+	var err error
+	if rt.intercept != nil {
+		resp, err = rt.intercept(ctx, req, &grpc.UnaryServerInfo{
+			FullMethod: rt.method,
+		}, rt.call)
+	} else {
+		resp, err = rt.call(ctx, req)
+	}
+
+	st.copyTo(w.Header())
+	if err != nil {
+		writeError(w, HTTPStatus(status.Code(err)), err)
+		return
+	}
+	writeMessage(w, http.StatusOK, resp.(proto.Message))
+}
+
+// incomingContext returns the context of the request as a gRPC server would
+// give it to a call: with the address and TLS state of the client and the
+// request ID and traceparent it sent as metadata.
+func incomingContext(r *http.Request) context.Context {
+	ctx := r.Context()
+
+	p := &peer.Peer{Addr: remoteAddr(r.RemoteAddr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+	ctx = peer.NewContext(ctx, p)
+
+	md := metadata.MD{}
+	for _, key := range []string{logging.RequestIDKey, trace.TraceparentKey} {
+		if v := r.Header.Get(key); v != "" {
+			md.Set(key, v)
+		}
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// remoteAddr parses the address of the client for the peer of a call.
+func remoteAddr(addr string) net.Addr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	n, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: n}
+}
+
+// HTTPStatus returns the HTTP status for a gRPC code, as grpc-gateway maps
+// them.
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return http.StatusRequestTimeout
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is the body of the responses to failed requests.
+type Error struct {
+	// Code is the gRPC status code of the error.
+	Code codes.Code `json:"code"`
+
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, httpStatus int, err error) {
+	st := status.Convert(err)
+	body, _ := json.Marshal(Error{Code: st.Code(), Message: st.Message()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_, _ = w.Write(append(body, '\n'))
+}
+
+func writeMessage(w http.ResponseWriter, httpStatus int, msg proto.Message) {
+	var buf bytes.Buffer
+	m := &jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+	if err := m.Marshal(&buf, msg); err != nil {
+		writeError(w, http.StatusInternalServerError,
+			status.Error(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_, _ = w.Write(append(buf.Bytes(), '\n'))
+}
+
+// transportStream collects the headers and trailers set by a call, which are
+// sent back as HTTP headers.
+type transportStream struct {
+	method string
+
+	mu sync.Mutex
+	md metadata.MD
+}
+
+func (s *transportStream) Method() string {
+	return s.method
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.md = metadata.Join(s.md, md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+// copyTo sets the headers and trailers of the call on h, such as the request
+// ID and retry-after.
+func (s *transportStream) copyTo(h http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, values := range s.md {
+		for _, v := range values {
+			h.Add(http.CanonicalHeaderKey(key), v)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package rest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/KibaFox/tls-usr-sessions/logging"
+	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/rest"
+)
+
+// stubAuth answers logins with a fixed certificate for the password "right"
+// and locks out the others.
+type stubAuth struct {
+	pb.AuthServer
+	method string
+}
+
+func (s *stubAuth) Login(
+	ctx context.Context, req *pb.LoginRequest,
+) (*pb.LoginResponse, error) {
+	s.method, _ = grpc.Method(ctx)
+	if req.Password != "right" {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", "30"))
+		return nil, status.Error(codes.ResourceExhausted, "locked out")
+	}
+	return &pb.LoginResponse{
+		Cert: "cert for " + req.Csr, Anchors: "anchors",
+	}, nil
+}
+
+type stubProtected struct{}
+
+func (stubProtected) MOTD(
+	context.Context, *empty.Empty,
+) (*pb.Bulletin, error) {
+	return &pb.Bulletin{Bulletin: "Hello"}, nil
+}
+
+func (stubProtected) IssueSSHCert(
+	context.Context, *pb.SSHCertRequest,
+) (*pb.SSHCert, error) {
+	return nil, status.Error(codes.Unimplemented, "not in the REST API")
+}
+
+var _ = Describe("REST", func() {
+	var (
+		auth *stubAuth
+		h    http.Handler
+	)
+
+	BeforeEach(func() {
+		auth = &stubAuth{}
+		h = rest.New(rest.Config{
+			Auth:            auth,
+			Protected:       stubProtected{},
+			AuthInterceptor: logging.UnaryServerInterceptor(logging.Default()),
+		})
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path,
+			strings.NewReader(body)))
+		return w
+	}
+
+	It("Should map JSON requests to the gRPC methods", func() {
+		w := do("POST", rest.LoginPath,
+			`{"username": "demo", "password": "right", "csr": "CSR"}`)
+		Expect(w.Code).Should(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).Should(
+			Equal("application/json"))
+		Expect(w.Header().Get("X-Request-Id")).ShouldNot(BeEmpty())
+		Expect(auth.method).Should(Equal("/pb.Auth/Login"))
+
+		var resp map[string]string
+		Expect(json.Unmarshal(w.Body.Bytes(), &resp)).To(Succeed())
+		Expect(resp).Should(Equal(map[string]string{
+			"cert": "cert for CSR", "anchors": "anchors",
+		}))
+	})
+
+	It("Should answer errors with the matching HTTP status", func() {
+		w := do("POST", rest.LoginPath,
+			`{"username": "demo", "password": "wrong", "csr": "CSR"}`)
+		Expect(w.Code).Should(Equal(http.StatusTooManyRequests))
+		Expect(w.Header().Get("Retry-After")).Should(Equal("30"))
+
+		var e rest.Error
+		Expect(json.Unmarshal(w.Body.Bytes(), &e)).To(Succeed())
+		Expect(e).Should(Equal(rest.Error{
+			Code: codes.ResourceExhausted, Message: "locked out",
+		}))
+
+		w = do("POST", rest.LoginPath, `{"username": `)
+		Expect(w.Code).Should(Equal(http.StatusBadRequest))
+		w = do("GET", rest.LoginPath, "")
+		Expect(w.Code).Should(Equal(http.StatusMethodNotAllowed))
+		Expect(w.Header().Get("Allow")).Should(Equal("POST"))
+	})
+
+	It("Should require a certificate on the protected routes", func() {
+		w := do("GET", rest.MOTDPath, "")
+		Expect(w.Code).Should(Equal(http.StatusUnauthorized))
+	})
+})
@@ -0,0 +1,224 @@
+// Package revoke keeps the serial numbers of the client certificates that were
+// revoked before they expire, so that servers can refuse them.
+//
+// The list is a text file with a serial number in hexadecimal on each line.
+// Blank lines and lines starting with "#" are ignored.  Revocations are
+// appended to the file, and a List picks up those made by other processes
+// sharing the file within RefreshInterval.
+package revoke
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RefreshInterval is how often a List checks whether its file changed.
+const RefreshInterval = time.Second
+
+// Error rejects a revoked certificate.  It implements metrics.RevokedError.
+type Error struct {
+	Serial string
+}
+
+func (e *Error) Error() string {
+	return "certificate " + e.Serial + " is revoked"
+}
+
+// Revoked reports that the certificate was rejected for being revoked.
+func (e *Error) Revoked() bool {
+	return true
+}
+
+// List is the list of revoked serial numbers kept in a file.  It is safe for
+// concurrent use.  A nil List revokes nothing.
+type List struct {
+	path string
+
+	mu      sync.Mutex
+	serials map[string]bool
+	modTime time.Time
+	checked time.Time
+}
+
+// Open loads the list from the file at path.  The file is created at the first
+// revocation if it does not exist.
+func Open(path string) (*List, error) {
+	l := &List{path: path, serials: make(map[string]bool)}
+
+	err := l.load()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// load reads the file again if it changed since it was last read.  It must be
+// called with the lock held.
+func (l *List) load() error {
+	l.checked = time.Now()
+
+	info, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "reading revocation list")
+	}
+	if info.ModTime().Equal(l.modTime) {
+		return nil
+	}
+
+	byt, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return errors.Wrap(err, "reading revocation list")
+	}
+
+	serials := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(byt))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		serial, err := Normalize(line)
+		if err != nil {
+			return errors.Wrapf(err, "%s:%d", l.path, n)
+		}
+		serials[serial] = true
+	}
+
+	l.serials, l.modTime = serials, info.ModTime()
+	return nil
+}
+
+// refresh reloads the file if it was not checked for RefreshInterval.  The
+// list last loaded is kept if the file cannot be read.  It must be called
+// with the lock held.
+func (l *List) refresh() {
+	if time.Since(l.checked) < RefreshInterval {
+		return
+	}
+	_ = l.load()
+}
+
+// Normalize returns the serial number in lowercase hexadecimal without leading
+// zeros, as it is written in the list, or an error if it is not hexadecimal.
+func Normalize(serial string) (string, error) {
+	s := strings.TrimPrefix(strings.ToLower(serial), "0x")
+	s = strings.Replace(s, ":", "", -1)
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok || n.Sign() < 0 {
+		return "", errors.Errorf("invalid serial number %q", serial)
+	}
+	return n.Text(16), nil
+}
+
+// Revoke adds the serial number to the list.  It reports false if it was
+// already revoked.
+func (l *List) Revoke(serial string) (added bool, err error) {
+	serial, err = Normalize(serial)
+	if err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err = l.load()
+	if err != nil {
+		return false, err
+	}
+	if l.serials[serial] {
+		return false, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(l.path), 0700)
+	if err != nil {
+		return false, errors.Wrap(err, "creating directory for revocations")
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return false, errors.Wrap(err, "opening revocation list")
+	}
+	_, err = f.WriteString(serial + "\n")
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "writing revocation list")
+	}
+
+	l.serials[serial] = true
+	return true, nil
+}
+
+// Revoked reports whether the serial number was revoked.
+func (l *List) Revoked(serial string) bool {
+	if l == nil {
+		return false
+	}
+	serial, err := Normalize(serial)
+	if err != nil {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refresh()
+	return l.serials[serial]
+}
+
+// Serials returns the revoked serial numbers in order.
+func (l *List) Serials() []string {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refresh()
+	serials := make([]string, 0, len(l.serials))
+	for serial := range l.serials {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+	return serials
+}
+
+// Check returns an *Error if the certificate was revoked.
+func (l *List) Check(cert *x509.Certificate) error {
+	serial := cert.SerialNumber.Text(16)
+	if l.Revoked(serial) {
+		return &Error{Serial: serial}
+	}
+	return nil
+}
+
+// VerifyPeerCertificate refuses a client certificate that was revoked, for use
+// in a tls.Config.  The certificate must already have been verified.
+func (l *List) VerifyPeerCertificate(
+	_ [][]byte, verifiedChains [][]*x509.Certificate,
+) error {
+	for _, chain := range verifiedChains {
+		if len(chain) > 0 {
+			return l.Check(chain[0])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package revoke_test
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/metrics"
+	"github.com/KibaFox/tls-usr-sessions/revoke"
+)
+
+var _ = Describe("Revoke", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		dir = tmpDir()
+		path = filepath.Join(dir, "certs", "revoked.txt")
+	})
+
+	AfterEach(func() {
+		rmDir(dir)
+	})
+
+	It("Should keep the revoked serial numbers", func() {
+		l, err := revoke.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Serials()).Should(BeEmpty())
+
+		added, err := l.Revoke("0A:BC")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).Should(BeTrue())
+		added, err = l.Revoke("abc")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(added).Should(BeFalse(), "already revoked")
+		_, err = l.Revoke("xyz")
+		Expect(err).Should(MatchError(ContainSubstring("invalid serial")))
+
+		Expect(l.Revoked("0abc")).Should(BeTrue())
+		Expect(l.Revoked("abd")).Should(BeFalse())
+
+		By("Loading the list again")
+		l, err = revoke.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Serials()).Should(Equal([]string{"abc"}))
+	})
+
+	It("Should refuse revoked certificates", func() {
+		l, err := revoke.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = l.Revoke("2a")
+		Expect(err).ToNot(HaveOccurred())
+
+		revoked := &x509.Certificate{SerialNumber: big.NewInt(42)}
+		valid := &x509.Certificate{SerialNumber: big.NewInt(43)}
+
+		err = l.VerifyPeerCertificate(nil,
+			[][]*x509.Certificate{{revoked}})
+		Expect(err).Should(MatchError("certificate 2a is revoked"))
+		Expect(metrics.HandshakeReason(err)).
+			Should(Equal(metrics.HandshakeRevoked))
+
+		Expect(l.Check(valid)).To(Succeed())
+
+		var none *revoke.List
+		Expect(none.Check(revoked)).To(Succeed())
+	})
+
+	It("Should pick up revocations by other processes", func() {
+		l, err := revoke.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Revoked("2a")).Should(BeFalse())
+
+		Expect(os.MkdirAll(filepath.Dir(path), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(path,
+			[]byte("# revoked by hand\n\n2a\n"), 0600)).To(Succeed())
+
+		Eventually(func() bool { return l.Revoked("2a") },
+			2*revoke.RefreshInterval).Should(BeTrue())
+	})
+})
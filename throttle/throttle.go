@@ -0,0 +1,192 @@
+// Package throttle limits repeated failures, such as failed logins, by locking
+// out a key (a username or a source address) with an exponential backoff.
+package throttle
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config holds the thresholds for a Limiter.
+type Config struct {
+	// MaxFailures is the number of failures allowed before a key is locked out.
+	MaxFailures int
+
+	// Lockout is how long a key is locked out after reaching MaxFailures.  The
+	// lockout doubles with every further failure.
+	Lockout time.Duration
+
+	// MaxLockout caps how long a key can be locked out.
+	MaxLockout time.Duration
+
+	// Window is how long failures are remembered after the last failure.
+	Window time.Duration
+}
+
+// DefaultConfig is a reasonable configuration for per-user limits.
+var DefaultConfig = Config{
+	MaxFailures: 5,
+	Lockout:     time.Minute,
+	MaxLockout:  time.Hour,
+	Window:      15 * time.Minute,
+}
+
+// Lockout describes a key that is locked out.
+type Lockout struct {
+	Key      string
+	Failures int
+	Until    time.Time
+}
+
+type entry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// Limiter tracks failures per key.  It is safe for concurrent use.
+type Limiter struct {
+	cfg Config
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	swept   time.Time
+}
+
+// New creates a new Limiter with the given configuration.
+func New(cfg Config) *Limiter {
+	return &Limiter{cfg: cfg, entries: make(map[string]*entry)}
+}
+
+// Allow reports whether the key may make another attempt.  If not, it returns
+// how long the key must wait before trying again.
+func (l *Limiter) Allow(key string) (retryAfter time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := l.get(key, time.Now())
+	if e == nil {
+		return 0, true
+	}
+
+	wait := time.Until(e.until)
+	if wait > 0 {
+		return wait, false
+	}
+	return 0, true
+}
+
+// Fail records a failure for the key and returns how long the key is now
+// locked out for, which is zero if it is not locked out.
+func (l *Limiter) Fail(key string) (lockout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	e := l.get(key, now)
+	if e == nil {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+
+	if e.failures < l.cfg.MaxFailures {
+		return 0
+	}
+
+	lockout = l.cfg.Lockout
+	for i := l.cfg.MaxFailures; i < e.failures; i++ {
+		lockout *= 2
+		if l.cfg.MaxLockout > 0 && lockout >= l.cfg.MaxLockout {
+			break
+		}
+	}
+	if l.cfg.MaxLockout > 0 && lockout > l.cfg.MaxLockout {
+		lockout = l.cfg.MaxLockout
+	}
+	e.until = now.Add(lockout)
+
+	return lockout
+}
+
+// Succeed forgets the failures recorded for the key.
+func (l *Limiter) Succeed(key string) {
+	l.Clear(key)
+}
+
+// Clear forgets the failures recorded for the key, lifting any lockout.  It
+// reports whether anything was recorded for the key.
+func (l *Limiter) Clear(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.entries[key]
+	delete(l.entries, key)
+	return ok
+}
+
+// Lockouts lists the keys that are currently locked out, sorted by key.
+func (l *Limiter) Lockouts() []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var locked []Lockout
+	for key := range l.entries {
+		e := l.get(key, now)
+		if e == nil || !now.Before(e.until) {
+			continue
+		}
+		locked = append(locked, Lockout{
+			Key:      key,
+			Failures: e.failures,
+			Until:    e.until,
+		})
+	}
+
+	sort.Slice(locked, func(i, j int) bool {
+		return locked[i].Key < locked[j].Key
+	})
+	return locked
+}
+
+// Len returns the number of keys with failures recorded.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(time.Now())
+	return len(l.entries)
+}
+
+// sweep drops the entries that have gone stale, at most once per window, so
+// that failures for keys that are never seen again are forgotten too.  The
+// caller must hold the lock.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.cfg.Window {
+		return
+	}
+	l.swept = now
+	for key := range l.entries {
+		l.get(key, now)
+	}
+}
+
+// get returns the entry for key, dropping it if it has gone stale.  The caller
+// must hold the lock.
+func (l *Limiter) get(key string, now time.Time) *entry {
+	e, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+
+	if now.Before(e.until) || now.Sub(e.last) < l.cfg.Window {
+		return e
+	}
+
+	delete(l.entries, key)
+	return nil
+}
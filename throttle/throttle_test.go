@@ -0,0 +1,116 @@
+package throttle_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/throttle"
+)
+
+var _ = Describe("Throttle", func() {
+	cfg := throttle.Config{
+		MaxFailures: 3,
+		Lockout:     time.Minute,
+		MaxLockout:  5 * time.Minute,
+		Window:      time.Hour,
+	}
+
+	It("Should allow attempts below the threshold", func() {
+		lim := throttle.New(cfg)
+
+		Expect(lim.Fail("bob")).Should(BeZero())
+		Expect(lim.Fail("bob")).Should(BeZero())
+
+		_, ok := lim.Allow("bob")
+		Expect(ok).Should(BeTrue())
+		Expect(lim.Lockouts()).Should(BeEmpty())
+	})
+
+	It("Should lock out with an exponential backoff", func() {
+		lim := throttle.New(cfg)
+
+		lim.Fail("bob")
+		lim.Fail("bob")
+		Expect(lim.Fail("bob")).Should(Equal(time.Minute))
+
+		retryAfter, ok := lim.Allow("bob")
+		Expect(ok).Should(BeFalse())
+		Expect(retryAfter).Should(BeNumerically("~", time.Minute, time.Second))
+
+		Expect(lim.Fail("bob")).Should(Equal(2 * time.Minute))
+		Expect(lim.Fail("bob")).Should(Equal(4 * time.Minute))
+		Expect(lim.Fail("bob")).Should(Equal(5*time.Minute),
+			"lockout should be capped")
+
+		By("Leaving other keys alone")
+		_, ok = lim.Allow("alice")
+		Expect(ok).Should(BeTrue())
+	})
+
+	It("Should list and clear lockouts", func() {
+		lim := throttle.New(cfg)
+
+		for i := 0; i < 3; i++ {
+			lim.Fail("user:bob")
+			lim.Fail("ip:10.0.0.1")
+		}
+		lim.Fail("user:alice")
+
+		locked := lim.Lockouts()
+		Expect(locked).Should(HaveLen(2))
+		Expect(locked[0].Key).Should(Equal("ip:10.0.0.1"))
+		Expect(locked[1].Key).Should(Equal("user:bob"))
+		Expect(locked[1].Failures).Should(Equal(3))
+		Expect(locked[1].Until).Should(
+			BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+
+		Expect(lim.Clear("user:bob")).Should(BeTrue())
+		Expect(lim.Clear("user:bob")).Should(BeFalse())
+
+		_, ok := lim.Allow("user:bob")
+		Expect(ok).Should(BeTrue())
+		Expect(lim.Lockouts()).Should(HaveLen(1))
+	})
+
+	It("Should forget failures after a successful attempt", func() {
+		lim := throttle.New(cfg)
+
+		lim.Fail("bob")
+		lim.Fail("bob")
+		lim.Succeed("bob")
+
+		Expect(lim.Fail("bob")).Should(BeZero())
+	})
+
+	It("Should forget failures outside of the window", func() {
+		lim := throttle.New(throttle.Config{
+			MaxFailures: 2,
+			Lockout:     time.Minute,
+			Window:      10 * time.Millisecond,
+		})
+
+		lim.Fail("bob")
+		time.Sleep(20 * time.Millisecond)
+
+		Expect(lim.Fail("bob")).Should(BeZero())
+	})
+
+	It("Should drop keys that are not seen again", func() {
+		lim := throttle.New(throttle.Config{
+			MaxFailures: 2,
+			Lockout:     10 * time.Millisecond,
+			Window:      10 * time.Millisecond,
+		})
+
+		lim.Fail("bob")
+		lim.Fail("mallory")
+		lim.Fail("mallory")
+		Expect(lim.Len()).Should(Equal(2))
+
+		time.Sleep(20 * time.Millisecond)
+		lim.Fail("alice")
+		Expect(lim.Len()).Should(Equal(1))
+	})
+})
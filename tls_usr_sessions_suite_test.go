@@ -1,22 +1,29 @@
 package tls_usr_sessions_test
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/types"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
 	"github.com/KibaFox/tls-usr-sessions/pb"
+	"github.com/KibaFox/tls-usr-sessions/pki"
 )
 
 func TestTLSUsrSessions(t *testing.T) {
@@ -24,65 +31,112 @@ func TestTLSUsrSessions(t *testing.T) {
 	RunSpecs(t, "TLS User Sessions Suite")
 }
 
+// server is an instance of the demo server running in its own directory.
+type server struct {
+	session *gexec.Session
+	dir     string
+	auth    string
+	addr    string
+	admin   string
+	metrics string
+	rest    string
+}
+
 var (
-	service *gexec.Session
+	exe     string
+	service *server
 	auth    string
 	addr    string
+	admin   string
 )
 
 var _ = BeforeSuite(func() {
-	service, auth, addr = startService()
+	var err error
+	exe, err = gexec.Build(
+		"github.com/KibaFox/tls-usr-sessions/cmd/tls-sess-demo")
+	Expect(err).ToNot(HaveOccurred(), "problem building service")
+
+	service = startService()
+	auth, addr, admin = service.auth, service.addr, service.admin
 })
 
 var _ = AfterSuite(func() {
 	if service != nil {
-		service.Kill()
+		service.stop()
 	}
+	gexec.CleanupBuildArtifacts()
 })
 
-const listenPattern = `(\w+) server listening at: (.*)`
+const listenPattern = `(\w+) server listening addr=(\S+)`
 
 var listenRx = regexp.MustCompile(listenPattern)
 
-func startService() (session *gexec.Session, auth, addr string) {
-	exe, err := gexec.Build(
-		"github.com/KibaFox/tls-usr-sessions/cmd/tls-sess-demo")
-	Expect(err).ToNot(HaveOccurred(), "problem building service")
+// startService starts a server in a new temporary directory, which is where it
+// keeps its certificates and stores.
+func startService(args ...string) *server {
+	dir, err := ioutil.TempDir("", "tls-sess-demo")
+	Expect(err).ToNot(HaveOccurred())
 
-	cmd := exec.Command(exe, "serv",
+	args = append([]string{"serv",
 		"-auth", "127.0.0.1:0",
 		"-listen", "127.0.0.1:0",
-	)
+	}, args...)
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
 
-	session, err = gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
+	session, err := gexec.Start(cmd, GinkgoWriter, GinkgoWriter)
 	Expect(err).ToNot(HaveOccurred(), "problem starting service")
 
-	for i := 1; i <= 2; i++ {
-		Eventually(session.Err, 3).Should(gbytes.Say(listenPattern),
-			"service did not start in time #%d", i)
+	listening := func() map[string]string {
+		servers := make(map[string]string)
+		out := session.Err.Contents()
+		for _, match := range listenRx.FindAllSubmatch(out, -1) {
+			servers[string(match[1])] = string(match[2])
+		}
+		return servers
 	}
-
-	matches := listenRx.FindAllSubmatch(session.Err.Contents(), 2)
-
-	for _, match := range matches {
-		Expect(match).Should(HaveLen(3))
-		srv, lis := string(match[1]), string(match[2])
-		if srv == "Protected" {
-			addr = lis
-		} else if srv == "Auth" {
-			auth = lis
+	started := []types.GomegaMatcher{
+		HaveKey("Auth"), HaveKey("Protected"), HaveKey("Admin"),
+	}
+	for _, arg := range args {
+		switch arg {
+		case "-metrics":
+			started = append(started, HaveKey("Metrics"))
+		case "-rest":
+			started = append(started, HaveKey("REST"))
 		}
 	}
+	Eventually(listening, 3).Should(And(started...),
+		"service did not start in time")
 
-	Expect(auth).ShouldNot(BeEmpty())
-	Expect(addr).ShouldNot(BeEmpty())
+	servers := listening()
+	srv := &server{
+		session: session,
+		dir:     dir,
+		auth:    servers["Auth"],
+		addr:    servers["Protected"],
+		admin:   filepath.Join(dir, servers["Admin"]),
+		metrics: servers["Metrics"],
+		rest:    servers["REST"],
+	}
+
+	Expect(srv.auth).ShouldNot(BeEmpty())
+	Expect(srv.addr).ShouldNot(BeEmpty())
 
-	return session, auth, addr
+	return srv
+}
+
+func (s *server) stop() {
+	s.session.Kill().Wait()
+	Expect(os.RemoveAll(s.dir)).To(Succeed())
 }
 
 func authCli() (cli pb.AuthClient, conn *grpc.ClientConn) {
 	Expect(auth).ShouldNot(BeEmpty())
+	return dialAuth(auth)
+}
 
+func dialAuth(auth string) (cli pb.AuthClient, conn *grpc.ClientConn) {
 	var err error
 	conn, err = grpc.Dial(auth, grpc.WithInsecure())
 	Expect(err).ToNot(HaveOccurred(), "could not connect to: %s", auth)
@@ -92,11 +146,94 @@ func authCli() (cli pb.AuthClient, conn *grpc.ClientConn) {
 	return cli, conn
 }
 
+// challengeCSR begins a login for the given user and returns a CSR for key that
+// carries the issued challenge.
+func challengeCSR(
+	ctx context.Context, cli pb.AuthClient, key *ecdsa.PrivateKey, usr string,
+) (csrPEM string) {
+	chal, err := cli.BeginLogin(ctx, &pb.BeginLoginRequest{Username: usr})
+	Expect(err).ToNot(HaveOccurred(), "problem beginning login")
+	Expect(chal.Challenge).ShouldNot(BeEmpty())
+
+	csrPEM, err = pki.NewChallengeCSR(key, "client", chal.Challenge)
+	Expect(err).ToNot(HaveOccurred())
+
+	return csrPEM
+}
+
+// authenticate logs in over the Authenticate stream, answering challenges
+// from answers by type and CSR challenges with a CSR for key.  The types of the
+// challenges that were asked are returned.
+func authenticate(
+	ctx context.Context, cli pb.AuthClient, key *ecdsa.PrivateKey, usr string,
+	answers map[pb.Challenge_Type]string,
+) (resp *pb.LoginResponse, asked []pb.Challenge_Type, err error) {
+	stream, err := cli.Authenticate(ctx)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = stream.Send(&pb.AuthenticateRequest{
+		Step: &pb.AuthenticateRequest_Start{
+			Start: &pb.AuthenticateStart{Username: usr},
+		},
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	for {
+		var step *pb.AuthenticateResponse
+		step, err = stream.Recv()
+		if err != nil {
+			return nil, asked, err
+		}
+		if resp = step.GetResult(); resp != nil {
+			return resp, asked, nil
+		}
+
+		ch := step.GetChallenge()
+		Expect(ch).ToNot(BeNil())
+		asked = append(asked, ch.Type)
+
+		answer := answers[ch.Type]
+		if ch.Type == pb.Challenge_CSR {
+			answer, err = pki.NewChallengeCSR(key, "client", ch.Nonce)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		err = stream.Send(&pb.AuthenticateRequest{
+			Step: &pb.AuthenticateRequest_Answer{
+				Answer: &pb.ChallengeAnswer{Value: answer},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	}
+}
+
+func adminCli() (cli pb.AdminClient, conn *grpc.ClientConn) {
+	return dialAdmin(admin)
+}
+
+func dialAdmin(admin string) (cli pb.AdminClient, conn *grpc.ClientConn) {
+	var err error
+	conn, err = grpc.Dial(admin, grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}))
+	Expect(err).ToNot(HaveOccurred(), "could not connect to: %s", admin)
+
+	cli = pb.NewAdminClient(conn)
+
+	return cli, conn
+}
+
 func protectedCli(
 	key *ecdsa.PrivateKey, certPEM, anchorPEM string,
 ) (cli pb.ProtectedClient, conn *grpc.ClientConn) {
 	Expect(addr).ShouldNot(BeEmpty())
+	return dialProtected(addr, key, certPEM, anchorPEM)
+}
 
+func dialProtected(
+	addr string, key *ecdsa.PrivateKey, certPEM, anchorPEM string,
+) (cli pb.ProtectedClient, conn *grpc.ClientConn) {
 	byt, err := x509.MarshalECPrivateKey(key)
 	Expect(err).ToNot(HaveOccurred())
 	blk := &pem.Block{
@@ -125,3 +262,13 @@ func protectedCli(
 
 	return cli, conn
 }
+
+// sshConnMeta is the metadata of an SSH connection logging in as user.
+type sshConnMeta string
+
+func (m sshConnMeta) User() string          { return string(m) }
+func (m sshConnMeta) SessionID() []byte     { return nil }
+func (m sshConnMeta) ClientVersion() []byte { return nil }
+func (m sshConnMeta) ServerVersion() []byte { return nil }
+func (m sshConnMeta) RemoteAddr() net.Addr  { return nil }
+func (m sshConnMeta) LocalAddr() net.Addr   { return nil }
@@ -0,0 +1,143 @@
+package trace
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TraceparentKey is the metadata key that carries the W3C traceparent header.
+const TraceparentKey = "traceparent"
+
+// UnaryServerInterceptor returns an interceptor that starts a server span for
+// each call, continuing the trace of the client if it sent a traceparent.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (resp interface{}, err error) {
+		ctx, span := startServer(ctx, info.FullMethod)
+		resp, err = handler(ctx, req)
+		finish(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is like UnaryServerInterceptor for streaming calls.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := startServer(ss.Context(), info.FullMethod)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		finish(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor that starts a client span for
+// each call and sends its traceparent to the server.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, span := startClient(ctx, method)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		finish(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor is like UnaryClientInterceptor for streaming calls.
+// The span ends when the stream does, which the client sees as an error from
+// RecvMsg, io.EOF included.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, span := startClient(ctx, method)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finish(span, err)
+			return nil, err
+		}
+		return &clientStream{ClientStream: cs, span: span}, nil
+	}
+}
+
+func startServer(ctx context.Context, fullMethod string) (
+	context.Context, *Span,
+) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(TraceparentKey); len(vals) > 0 {
+			if sc, ok := ParseTraceparent(vals[0]); ok {
+				ctx = ContextWithRemote(ctx, sc)
+			}
+		}
+	}
+
+	ctx, span := Start(ctx, strings.TrimPrefix(fullMethod, "/"), Server)
+	setRPC(span, fullMethod)
+	return ctx, span
+}
+
+func startClient(ctx context.Context, fullMethod string) (
+	context.Context, *Span,
+) {
+	ctx, span := Start(ctx, strings.TrimPrefix(fullMethod, "/"), Client)
+	if span != nil {
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			TraceparentKey, span.Context().Traceparent())
+	}
+	setRPC(span, fullMethod)
+	return ctx, span
+}
+
+// setRPC sets the OpenTelemetry attributes of an RPC span.
+func setRPC(span *Span, fullMethod string) {
+	service, method := "", strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(method, "/"); i >= 0 {
+		service, method = method[:i], method[i+1:]
+	}
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.service", service)
+	span.SetAttribute("rpc.method", method)
+}
+
+func finish(span *Span, err error) {
+	span.SetAttribute("rpc.grpc.status_code", int(status.Code(err)))
+	span.SetError(err)
+	span.End()
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	span *Span
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		finish(s.span, nil)
+	} else if err != nil {
+		finish(s.span, err)
+	}
+	return err
+}
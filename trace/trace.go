@@ -0,0 +1,329 @@
+// Package trace records spans that follow a request from the CLI through the
+// servers.  Spans use the OpenTelemetry data model and are propagated between
+// processes with the W3C traceparent header, so the traces can be read by
+// OpenTelemetry tools.  Finished spans are written as JSON lines, which needs
+// no collector.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// SpanContext is the part of a span that is propagated to other processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header.
+func ParseTraceparent(header string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	tid, err := hex.DecodeString(parts[1])
+	if err != nil || len(tid) != len(sc.TraceID) {
+		return SpanContext{}, false
+	}
+	sid, err := hex.DecodeString(parts[2])
+	if err != nil || len(sid) != len(sc.SpanID) {
+		return SpanContext{}, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return SpanContext{}, false
+	}
+
+	copy(sc.TraceID[:], tid)
+	copy(sc.SpanID[:], sid)
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// Kind is the role of a span in a trace.
+type Kind int
+
+// Kinds of spans.
+const (
+	Internal Kind = iota
+	Server
+	Client
+)
+
+var kindNames = []string{
+	"SPAN_KIND_INTERNAL", "SPAN_KIND_SERVER", "SPAN_KIND_CLIENT",
+}
+
+func (k Kind) String() string {
+	if k < Internal || k > Client {
+		return "SPAN_KIND_UNSPECIFIED"
+	}
+	return kindNames[k]
+}
+
+// Tracer writes finished spans to an io.Writer.  It is safe for concurrent
+// use.
+type Tracer struct {
+	service string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// New creates a tracer for the named service writing spans to w.
+func New(w io.Writer, service string) *Tracer {
+	return &Tracer{service: service, w: w}
+}
+
+var std *Tracer
+
+// Default returns the tracer used by Start, which is nil if tracing is off.
+func Default() *Tracer {
+	return std
+}
+
+// SetDefault replaces the tracer used by Start.  A nil tracer turns tracing
+// off.
+func SetDefault(t *Tracer) {
+	std = t
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithRemote returns a context carrying a span context received from
+// another process, which becomes the parent of the next span started.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// FromContext returns the current span in the context, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a span with the default tracer as a child of the current or
+// remote span in the context.  If tracing is off, the span is nil, which is
+// safe to use.
+func Start(
+	ctx context.Context, name string, kind Kind,
+) (context.Context, *Span) {
+	return Default().Start(ctx, name, kind)
+}
+
+// Start starts a span as a child of the current or remote span in the context.
+func (t *Tracer) Start(
+	ctx context.Context, name string, kind Kind,
+) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+
+	if parent := FromContext(ctx); parent != nil {
+		s.sc.TraceID, s.sc.Sampled = parent.sc.TraceID, parent.sc.Sampled
+		s.parent = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		s.sc.TraceID, s.sc.Sampled = remote.TraceID, remote.Sampled
+		s.parent = remote.SpanID
+	} else {
+		_, _ = rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = true
+	}
+	_, _ = rand.Read(s.sc.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Span is an operation within a trace.  The methods of a nil span do nothing.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   Kind
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu      sync.Mutex
+	attrs   []attribute
+	err     string
+	ended   bool
+	endTime time.Time
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Context returns the span context to propagate.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute sets an attribute of the span.  Values should be strings,
+// booleans or integers.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attribute{key, value})
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err.Error()
+}
+
+// End finishes the span and exports it.  Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.endTime = true, time.Now()
+	s.mu.Unlock()
+
+	if s.sc.Sampled {
+		s.tracer.export(s)
+	}
+}
+
+func (t *Tracer) export(s *Span) {
+	byt, err := json.Marshal(s.data(t.service))
+	if err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = t.w.Write(append(byt, '\n'))
+}
+
+// spanData is how a span is written, following the field names of the
+// OpenTelemetry protocol's JSON encoding.
+type spanData struct {
+	Resource          map[string]string `json:"resource"`
+	TraceID           string            `json:"traceId"`
+	SpanID            string            `json:"spanId"`
+	ParentSpanID      string            `json:"parentSpanId,omitempty"`
+	Name              string            `json:"name"`
+	Kind              string            `json:"kind"`
+	StartTimeUnixNano string            `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string            `json:"endTimeUnixNano"`
+	Attributes        []keyValue        `json:"attributes,omitempty"`
+	Status            spanStatus        `json:"status"`
+}
+
+type keyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type spanStatus struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s *Span) data(service string) spanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := spanData{
+		Resource:          map[string]string{"service.name": service},
+		TraceID:           s.sc.TraceID.String(),
+		SpanID:            s.sc.SpanID.String(),
+		Name:              s.name,
+		Kind:              s.kind.String(),
+		StartTimeUnixNano: fmt.Sprint(s.start.UnixNano()),
+		EndTimeUnixNano:   fmt.Sprint(s.endTime.UnixNano()),
+		Status:            spanStatus{Code: "STATUS_CODE_OK"},
+	}
+	if s.parent != (SpanID{}) {
+		d.ParentSpanID = s.parent.String()
+	}
+	if s.err != "" {
+		d.Status = spanStatus{Code: "STATUS_CODE_ERROR", Message: s.err}
+	}
+
+	for _, a := range s.attrs {
+		var v map[string]interface{}
+		switch val := a.value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": val}
+		case int:
+			v = map[string]interface{}{"intValue": fmt.Sprint(val)}
+		case int64:
+			v = map[string]interface{}{"intValue": fmt.Sprint(val)}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(val)}
+		}
+		d.Attributes = append(d.Attributes, keyValue{Key: a.key, Value: v})
+	}
+
+	return d
+}
@@ -0,0 +1,153 @@
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/KibaFox/tls-usr-sessions/trace"
+)
+
+type span struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         string `json:"kind"`
+	Attributes   []struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+	Resource map[string]string `json:"resource"`
+}
+
+func spans(buf *bytes.Buffer) (out []span) {
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var s span
+		Expect(json.Unmarshal([]byte(line), &s)).To(Succeed())
+		out = append(out, s)
+	}
+	return out
+}
+
+var _ = Describe("Trace", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		trace.SetDefault(trace.New(buf, "test"))
+	})
+
+	AfterEach(func() {
+		trace.SetDefault(nil)
+	})
+
+	It("Should format and parse traceparent headers", func() {
+		const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+		sc, ok := trace.ParseTraceparent(header)
+		Expect(ok).Should(BeTrue())
+		Expect(sc.TraceID.String()).Should(
+			Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(sc.SpanID.String()).Should(Equal("00f067aa0ba902b7"))
+		Expect(sc.Sampled).Should(BeTrue())
+		Expect(sc.Traceparent()).Should(Equal(header))
+
+		for _, bad := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xx",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		} {
+			_, ok = trace.ParseTraceparent(bad)
+			Expect(ok).Should(BeFalse(), bad)
+		}
+	})
+
+	It("Should write child spans in the same trace", func() {
+		ctx, root := trace.Start(context.Background(), "root", trace.Internal)
+		_, child := trace.Start(ctx, "child", trace.Internal)
+		child.SetAttribute("answer", 42)
+		child.SetError(errors.New("boom"))
+		child.End()
+		child.End()
+		root.End()
+
+		out := spans(buf)
+		Expect(out).Should(HaveLen(2))
+		Expect(out[0].Name).Should(Equal("child"))
+		Expect(out[0].TraceID).Should(Equal(out[1].TraceID))
+		Expect(out[0].ParentSpanID).Should(Equal(out[1].SpanID))
+		Expect(out[0].Status.Code).Should(Equal("STATUS_CODE_ERROR"))
+		Expect(out[0].Status.Message).Should(Equal("boom"))
+		Expect(out[0].Attributes[0].Key).Should(Equal("answer"))
+		Expect(out[0].Attributes[0].Value).Should(
+			HaveKeyWithValue("intValue", "42"))
+		Expect(out[1].ParentSpanID).Should(BeEmpty())
+		Expect(out[1].Resource).Should(
+			HaveKeyWithValue("service.name", "test"))
+	})
+
+	It("Should do nothing when off", func() {
+		trace.SetDefault(nil)
+
+		ctx, s := trace.Start(context.Background(), "root", trace.Internal)
+		Expect(s).Should(BeNil())
+		Expect(trace.FromContext(ctx)).Should(BeNil())
+		s.SetAttribute("key", "value")
+		s.End()
+		Expect(buf.Len()).Should(BeZero())
+	})
+
+	It("Should propagate the trace from client to server", func() {
+		ctx, root := trace.Start(context.Background(), "root", trace.Internal)
+
+		var sent metadata.MD
+		client := trace.UnaryClientInterceptor()
+		err := client(ctx, "/pb.Auth/Login", nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{},
+				cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				sent, _ = metadata.FromOutgoingContext(ctx)
+				return nil
+			})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sent.Get(trace.TraceparentKey)).Should(HaveLen(1))
+
+		server := trace.UnaryServerInterceptor()
+		_, err = server(metadata.NewIncomingContext(context.Background(), sent),
+			nil, &grpc.UnaryServerInfo{FullMethod: "/pb.Auth/Login"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				_, s := trace.Start(ctx, "pki.SignCSR", trace.Internal)
+				s.End()
+				return nil, nil
+			})
+		Expect(err).ToNot(HaveOccurred())
+		root.End()
+
+		out := spans(buf)
+		Expect(out).Should(HaveLen(4))
+		clientSpan, signSpan, serverSpan, rootSpan := out[0], out[1], out[2],
+			out[3]
+		Expect(clientSpan.Kind).Should(Equal("SPAN_KIND_CLIENT"))
+		Expect(clientSpan.ParentSpanID).Should(Equal(rootSpan.SpanID))
+		Expect(serverSpan.Kind).Should(Equal("SPAN_KIND_SERVER"))
+		Expect(serverSpan.Name).Should(Equal("pb.Auth/Login"))
+		Expect(serverSpan.ParentSpanID).Should(Equal(clientSpan.SpanID))
+		Expect(signSpan.ParentSpanID).Should(Equal(serverSpan.SpanID))
+		for _, s := range out {
+			Expect(s.TraceID).Should(Equal(rootSpan.TraceID))
+		}
+	})
+})
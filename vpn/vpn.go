@@ -0,0 +1,275 @@
+// Package vpn writes the configuration of VPN clients that authenticate with
+// their session certificate, and the snippets that make VPN servers accept
+// them, for OpenVPN and strongSwan.
+//
+// The client configurations carry the session certificate and the trust
+// anchors, and refer to the key where it is kept, so they must be written
+// again after each login.  OpenVPN configurations embed the certificates,
+// while strongSwan ones refer to the files of the profile, since swanctl.conf
+// cannot embed them.  The server snippets refer to a certificate the CA issued
+// to the VPN server, and its key, so that the CA's key stays on the server of
+// the sessions, and to the CA and a CRL of the revoked certificates it signed.
+package vpn
+
+import (
+	"io"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Type is the VPN software a configuration is written for.
+type Type string
+
+// The types of VPN supported.
+const (
+	OpenVPN    Type = "openvpn"
+	StrongSwan Type = "strongswan"
+)
+
+// ParseType returns the type of VPN named "openvpn" or "strongswan".
+func ParseType(name string) (Type, error) {
+	switch t := Type(name); t {
+	case OpenVPN, StrongSwan:
+		return t, nil
+	}
+	return "", errors.Errorf("unknown VPN type %q, must be %s or %s",
+		name, OpenVPN, StrongSwan)
+}
+
+// DefaultOpenVPNPort is the port of OpenVPN servers when the remote does not
+// give one.  strongSwan uses the IKE port by default.
+const DefaultOpenVPNPort = "1194"
+
+// Client is the configuration of a VPN client for a session.
+type Client struct {
+	// Name names the connection, such as after the profile.
+	Name string
+
+	// Remote is the address of the VPN server, as host or host:port.
+	Remote string
+
+	// ServerName is the name the VPN server's certificate is checked for, the
+	// host of Remote if empty.
+	ServerName string
+
+	// Cert is the session certificate and Anchors the trust anchors, in PEM
+	// format, which are embedded in OpenVPN configurations.
+	Cert    string
+	Anchors string
+
+	// CertPath, AnchorsPath and KeyPath are the absolute paths of the files
+	// of the session, which strongSwan configurations refer to.  OpenVPN ones
+	// only refer to the key.
+	CertPath    string
+	AnchorsPath string
+	KeyPath     string
+
+	// Expires is when the session certificate expires.
+	Expires time.Time
+}
+
+// Server is the configuration a VPN server needs to accept the sessions.
+type Server struct {
+	// Name names the connection.
+	Name string
+
+	// ServerName is the name in the VPN server's certificate, which clients
+	// check.
+	ServerName string
+
+	// CertPath is the absolute path of the certificate the CA issued to the
+	// VPN server, and KeyPath that of its key.
+	CertPath string
+	KeyPath  string
+
+	// CAPath is the absolute path of the trust anchors, of which the first
+	// is the CA.
+	CAPath string
+
+	// CRLPath is the absolute path of the CRL signed by the CA.
+	CRLPath string
+}
+
+// WriteClient writes the configuration of the client for the type of VPN.
+func WriteClient(w io.Writer, t Type, c Client) error {
+	host, port, err := splitRemote(c.Remote)
+	if err != nil {
+		return err
+	}
+	if t == OpenVPN && port == "" {
+		port = DefaultOpenVPNPort
+	}
+	if c.ServerName == "" {
+		c.ServerName = host
+	}
+
+	data := struct {
+		Client
+		Host, Port string
+	}{c, host, port}
+	return execute(w, clientTemplates, t, data)
+}
+
+// WriteServer writes the snippet of the server's configuration for the type of
+// VPN.
+func WriteServer(w io.Writer, t Type, s Server) error {
+	return execute(w, serverTemplates, t, s)
+}
+
+func execute(
+	w io.Writer, templates map[Type]*template.Template, t Type,
+	data interface{},
+) error {
+	tmpl, ok := templates[t]
+	if !ok {
+		_, err := ParseType(string(t))
+		return err
+	}
+	err := tmpl.Execute(w, data)
+	if err != nil {
+		return errors.Wrapf(err, "writing %s configuration", t)
+	}
+	return nil
+}
+
+// splitRemote splits the address of a VPN server into its host and port, which
+// is empty if not given.
+func splitRemote(remote string) (host, port string, err error) {
+	if remote == "" {
+		return "", "", errors.New("the address of the VPN server is required")
+	}
+	host, port, err = net.SplitHostPort(remote)
+	if err != nil {
+		return strings.Trim(remote, "[]"), "", nil
+	}
+	return host, port, nil
+}
+
+var funcs = template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	// pem trims the trailing newline of PEM blocks placed between tags.
+	"pem": func(s string) string { return strings.TrimRight(s, "\n") },
+}
+
+func parse(name, text string) *template.Template {
+	return template.Must(template.New(name).Funcs(funcs).Parse(text))
+}
+
+var clientTemplates = map[Type]*template.Template{
+	OpenVPN:    parse("openvpn-client", openVPNClient),
+	StrongSwan: parse("strongswan-client", strongSwanClient),
+}
+
+var serverTemplates = map[Type]*template.Template{
+	OpenVPN:    parse("openvpn-server", openVPNServer),
+	StrongSwan: parse("strongswan-server", strongSwanServer),
+}
+
+const openVPNClient = `# OpenVPN configuration for the session of {{.Name}}.
+# The session expires at {{date .Expires}}: login and write it again then.
+client
+dev tun
+proto udp
+remote {{.Host}} {{.Port}}
+nobind
+persist-key
+persist-tun
+remote-cert-tls server
+verify-x509-name {{.ServerName}} name
+key {{.KeyPath}}
+<cert>
+{{pem .Cert}}
+</cert>
+<ca>
+{{pem .Anchors}}
+</ca>
+`
+
+const strongSwanClient = `# swanctl.conf for the session of {{.Name}}.
+# The session expires at {{date .Expires}}: login then load it again with
+# swanctl --load-all.
+connections {
+    {{.Name}} {
+        remote_addrs = {{.Host}}
+{{- if .Port}}
+        remote_port = {{.Port}}
+{{- end}}
+        vips = 0.0.0.0
+        local {
+            auth = pubkey
+            certs = {{.CertPath}}
+        }
+        remote {
+            auth = pubkey
+            id = {{.ServerName}}
+        }
+        children {
+            {{.Name}} {
+                remote_ts = 0.0.0.0/0
+                start_action = start
+            }
+        }
+    }
+}
+
+authorities {
+    {{.Name}} {
+        cacert = {{.AnchorsPath}}
+    }
+}
+
+secrets {
+    private-{{.Name}} {
+        file = {{.KeyPath}}
+    }
+}
+`
+
+const openVPNServer = `# OpenVPN snippet accepting the sessions.
+# Add it to a server, and keep the CRL up to date with storage.crl or by writing
+# it again after each revocation and before it expires.
+ca {{.CAPath}}
+cert {{.CertPath}}
+key {{.KeyPath}}
+dh none
+verify-client-cert require
+remote-cert-tls client
+crl-verify {{.CRLPath}}
+`
+
+const strongSwanServer = `# swanctl.conf snippet accepting the sessions.
+# Complete it with the pools and traffic selectors of the server, and keep the
+# CRL up to date with storage.crl or by writing it again after each revocation
+# and before it expires.
+connections {
+    {{.Name}} {
+        local {
+            auth = pubkey
+            certs = {{.CertPath}}
+            id = {{.ServerName}}
+        }
+        remote {
+            auth = pubkey
+            cacerts = {{.CAPath}}
+            revocation = strict
+        }
+    }
+}
+
+authorities {
+    {{.Name}} {
+        cacert = {{.CAPath}}
+        crl_uris = file://{{.CRLPath}}
+    }
+}
+
+secrets {
+    private-{{.Name}} {
+        file = {{.KeyPath}}
+    }
+}
+`
@@ -0,0 +1,32 @@
+package vpn_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var update = flag.Bool("update", false, "write the golden files")
+
+func TestVPN(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "VPN Suite")
+}
+
+// expectGolden compares the output with the golden file of that name in
+// testdata, or writes it there when run with -update.
+func expectGolden(name string, out *bytes.Buffer) {
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		err := ioutil.WriteFile(path, out.Bytes(), 0644)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	golden, err := ioutil.ReadFile(path)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(out.String()).Should(Equal(string(golden)))
+}
@@ -0,0 +1,96 @@
+package vpn_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/KibaFox/tls-usr-sessions/vpn"
+)
+
+const certPEM = `-----BEGIN CERTIFICATE-----
+MIIBsession
+-----END CERTIFICATE-----
+`
+
+const anchorsPEM = `-----BEGIN CERTIFICATE-----
+MIIBcurrentCA
+-----END CERTIFICATE-----
+-----BEGIN CERTIFICATE-----
+MIIBpreviousCA
+-----END CERTIFICATE-----
+`
+
+var client = vpn.Client{
+	Name:        "work",
+	ServerName:  "vpn.example.com",
+	Cert:        certPEM,
+	Anchors:     anchorsPEM,
+	CertPath:    "/home/demo/.config/tls-sess-demo/profiles/work/cert.pem",
+	AnchorsPath: "/home/demo/.config/tls-sess-demo/profiles/work/root.pem",
+	KeyPath:     "/home/demo/.config/tls-sess-demo/profiles/work/key.pem",
+	Expires:     time.Date(2026, 10, 18, 17, 30, 0, 0, time.UTC),
+}
+
+var server = vpn.Server{
+	Name:       "tls-sess-demo",
+	ServerName: "vpn.example.com",
+	CertPath:   "/etc/vpn/server_cert.pem",
+	KeyPath:    "/etc/vpn/server_key.pem",
+	CAPath:     "/etc/vpn/ca_cert.pem",
+	CRLPath:    "/srv/tls-sess-demo/certs/crl.pem",
+}
+
+var _ = Describe("VPN", func() {
+	DescribeTable("Writes the configuration of clients",
+		func(t vpn.Type, remote, golden string) {
+			c := client
+			c.Remote = remote
+			var out bytes.Buffer
+			err := vpn.WriteClient(&out, t, c)
+			Expect(err).ToNot(HaveOccurred())
+			expectGolden(golden, &out)
+		},
+		Entry("for OpenVPN", vpn.OpenVPN, "vpn.example.com",
+			"openvpn-client"),
+		Entry("for OpenVPN with a port", vpn.OpenVPN, "[2001:db8::1]:443",
+			"openvpn-client-port"),
+		Entry("for strongSwan", vpn.StrongSwan, "vpn.example.com",
+			"strongswan-client"),
+		Entry("for strongSwan with a port", vpn.StrongSwan,
+			"vpn.example.com:4500", "strongswan-client-port"),
+	)
+
+	DescribeTable("Writes the snippets of servers",
+		func(t vpn.Type, golden string) {
+			var out bytes.Buffer
+			err := vpn.WriteServer(&out, t, server)
+			Expect(err).ToNot(HaveOccurred())
+			expectGolden(golden, &out)
+		},
+		Entry("for OpenVPN", vpn.OpenVPN, "openvpn-server"),
+		Entry("for strongSwan", vpn.StrongSwan, "strongswan-server"),
+	)
+
+	It("Parses the types of VPN", func() {
+		t, err := vpn.ParseType("openvpn")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t).Should(Equal(vpn.OpenVPN))
+		t, err = vpn.ParseType("strongswan")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(t).Should(Equal(vpn.StrongSwan))
+
+		_, err = vpn.ParseType("wireguard")
+		Expect(err).Should(MatchError(ContainSubstring("unknown VPN type")))
+		err = vpn.WriteServer(&bytes.Buffer{}, "wireguard", server)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Requires the address of the VPN server", func() {
+		err := vpn.WriteClient(&bytes.Buffer{}, vpn.OpenVPN, client)
+		Expect(err).To(HaveOccurred())
+	})
+})